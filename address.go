@@ -0,0 +1,197 @@
+package bgapi
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// address types of a QualifiedMac
+const (
+	// AddrTypePublic IEEE assigned public address
+	AddrTypePublic byte = 0
+	// AddrTypeRandom random address, its subtype is given by the two most
+	// significant bits of the address
+	AddrTypeRandom byte = 1
+)
+
+// random address subtypes, the two most significant bits of the address
+const (
+	randomSubtypeMask          = 0xc0
+	randomNonResolvablePrivate = 0x00
+	randomResolvablePrivate    = 0x40
+	randomStatic               = 0xc0
+)
+
+// msb returns the most significant byte, addresses are stored LSB first
+func (m Mac) msb() byte {
+	return m[5]
+}
+
+// IsStaticRandom returns true when the address, if random, is static
+func (m Mac) IsStaticRandom() bool {
+	return m.msb()&randomSubtypeMask == randomStatic
+}
+
+// IsResolvablePrivate returns true when the address, if random, is a
+// resolvable private address (RPA)
+func (m Mac) IsResolvablePrivate() bool {
+	return m.msb()&randomSubtypeMask == randomResolvablePrivate
+}
+
+// IsNonResolvablePrivate returns true when the address, if random, is a
+// non-resolvable private address (NRPA)
+func (m Mac) IsNonResolvablePrivate() bool {
+	return m.msb()&randomSubtypeMask == randomNonResolvablePrivate
+}
+
+// randomPartUniform returns true when the bits below the subtype are all zeros or
+// all ones, which the specification forbids for static and non-resolvable
+// addresses
+func (m Mac) randomPartUniform() bool {
+	zeros, ones := true, true
+	for i, b := range m {
+		mask := byte(0xff)
+		if i == 5 {
+			mask = ^byte(randomSubtypeMask)
+		}
+		zeros = zeros && b&mask == 0
+		ones = ones && b&mask == mask
+	}
+	return zeros || ones
+}
+
+// ParseMac parse an address written most significant byte first, as
+// printed by Mac.String (e.g. "00:07:80:12:34:56")
+func ParseMac(s string) (Mac, error) {
+	var m Mac
+	var b [6]byte
+	n, err := fmt.Sscanf(s, "%02x:%02x:%02x:%02x:%02x:%02x", &b[0], &b[1], &b[2], &b[3], &b[4], &b[5])
+	if err != nil || n != 6 || len(s) != 17 {
+		return m, fmt.Errorf("invalid address %q", s)
+	}
+	for i := range b {
+		m[i] = b[5-i]
+	}
+	return m, nil
+}
+
+// IsPublic returns true for a public address
+func (qm *QualifiedMac) IsPublic() bool {
+	return qm.AddrType == AddrTypePublic
+}
+
+// IsStaticRandom returns true for a random static address
+func (qm *QualifiedMac) IsStaticRandom() bool {
+	return qm.AddrType == AddrTypeRandom && qm.Address.IsStaticRandom()
+}
+
+// IsResolvablePrivate returns true for a resolvable private address
+func (qm *QualifiedMac) IsResolvablePrivate() bool {
+	return qm.AddrType == AddrTypeRandom && qm.Address.IsResolvablePrivate()
+}
+
+// IsNonResolvablePrivate returns true for a non-resolvable private address
+func (qm *QualifiedMac) IsNonResolvablePrivate() bool {
+	return qm.AddrType == AddrTypeRandom && qm.Address.IsNonResolvablePrivate()
+}
+
+// Validate check the address type and, for random addresses, the subtype
+// rules of the specification
+func (qm *QualifiedMac) Validate() error {
+	switch qm.AddrType {
+	case AddrTypePublic:
+		return nil
+	case AddrTypeRandom:
+	default:
+		return fmt.Errorf("invalid address type %d", qm.AddrType)
+	}
+
+	switch qm.Address.msb() & randomSubtypeMask {
+	case randomStatic, randomNonResolvablePrivate:
+		if qm.Address.randomPartUniform() {
+			return fmt.Errorf("random address %s is all zeros or all ones", qm.Address)
+		}
+	case randomResolvablePrivate:
+	default:
+		return fmt.Errorf("random address %s uses the reserved subtype", qm.Address)
+	}
+	return nil
+}
+
+// NewQualifiedMac returns a qualified address after validating it
+func NewQualifiedMac(address Mac, addrType byte) (QualifiedMac, error) {
+	qm := QualifiedMac{Address: address, AddrType: addrType}
+	return qm, qm.Validate()
+}
+
+// DefaultAddressPSKey the first user PS key, where ProgramAddress stores the
+// address unless told otherwise
+const DefaultAddressPSKey uint16 = 0x8000
+
+// GenerateStaticAddress returns a random static address drawn from r, or
+// from crypto/rand when r is nil
+func GenerateStaticAddress(r io.Reader) (QualifiedMac, error) {
+	if r == nil {
+		r = rand.Reader
+	}
+
+	// retry the (unlikely) draws the specification forbids
+	for i := 0; i < 8; i++ {
+		var m Mac
+		if _, err := io.ReadFull(r, m[:]); err != nil {
+			return QualifiedMac{}, err
+		}
+		m[5] |= randomStatic
+
+		qm := QualifiedMac{Address: m, AddrType: AddrTypeRandom}
+		if qm.Validate() == nil {
+			return qm, nil
+		}
+	}
+	return QualifiedMac{}, errors.New("random source keeps producing invalid addresses")
+}
+
+// ProgramAddress store address in PS key and reset the module so the
+// firmware applies it at boot. The BLED112 stock firmware always uses its
+// factory address, this needs a firmware that reads its address from key.
+// The completion runs once the module booted again, or with the error
+// that stopped it: saving the key, writing the reset or ErrNoBoot.
+func (api *API) ProgramAddress(key uint16, address Mac, completion func(error)) error {
+	if !IsUserPSKey(key) {
+		return fmt.Errorf("PS key 0x%04x is not a user key", key)
+	}
+
+	return api.FlashPsSave(key, address[:], func(result uint16) {
+		if result != 0 {
+			completion(fmt.Errorf("saving the address failed with result 0x%04x", result))
+			return
+		}
+
+		// discard a stale boot event, the one awaited follows the reset
+		select {
+		case <-api.bootC:
+		default:
+		}
+		err := api.submitNoReply(0, 0, []byte{0}, func(_ *bytes.Buffer, err error) {
+			if err != nil {
+				completion(fmt.Errorf("resetting: %w", err))
+				return
+			}
+			// the boot event is parsed on the receive goroutine
+			go func() {
+				select {
+				case <-api.bootC:
+					completion(nil)
+				case <-api.clock.After(readyTimeout):
+					completion(ErrNoBoot)
+				}
+			}()
+		})
+		if err != nil {
+			completion(fmt.Errorf("resetting: %w", err))
+		}
+	})
+}
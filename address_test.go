@@ -0,0 +1,44 @@
+package bgapi_test
+
+import (
+	"testing"
+	"time"
+
+	bgapi "github.com/jsakwa/go_bgapi"
+	"github.com/jsakwa/go_bgapi/bgapitest"
+)
+
+func TestProgramAddress(t *testing.T) {
+	module := bgapitest.NewModule()
+	boot := bgapitest.SystemBoot(bgapi.SystemInfo{Major: 1, Minor: 3})
+	// the reset is not answered, the boot event is sent by the test
+	module.Handle(0, 0, func([]byte) ([]byte, []bgapitest.Event) { return nil, nil })
+	api := bgapi.NewAPI(&bgapi.LoggingDelegate{})
+	api.OpenTransport(module, nil)
+	defer api.Close()
+
+	done := make(chan error, 1)
+	address := bgapi.Mac{0x01, 0x02, 0x03, 0x04, 0x05, 0xc6}
+	if err := api.ProgramAddress(0x8000, address, func(err error) { done <- err }); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("completed before booting: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	module.Event(boot.Class, boot.Event, boot.Payload)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("not completed after the boot")
+	}
+
+	commands := module.Commands()
+	if len(commands) != 2 || commands[0].Class != 1 || commands[0].Command != 3 || commands[1].Class != 0 || commands[1].Command != 0 {
+		t.Fatalf("commands %+v", commands)
+	}
+}
@@ -0,0 +1,164 @@
+package bgapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// storeAliasPrefix store key prefix of the address book entries
+const storeAliasPrefix = "alias/"
+
+// ErrUnknownAlias the address book has no entry of that name
+var ErrUnknownAlias = errors.New("unknown alias")
+
+// AddressBookEntry a named address, as kept in the Store
+type AddressBookEntry struct {
+	Name string `json:"name"`
+	// Address as printed by Mac.String
+	Address string `json:"address"`
+	Public  bool   `json:"public,omitempty"`
+}
+
+// QualifiedMac returns the address of the entry
+func (e *AddressBookEntry) QualifiedMac() (QualifiedMac, error) {
+	mac, err := ParseMac(strings.ToLower(e.Address))
+	if err != nil {
+		return QualifiedMac{}, err
+	}
+	addrType := AddrTypeRandom
+	if e.Public {
+		addrType = AddrTypePublic
+	}
+	return NewQualifiedMac(mac, addrType)
+}
+
+// AddressBook names peripherals, e.g. "kitchen-sensor", so that they need
+// not be designated by address. Entries are kept in a Store under "alias/"
+// followed by the name.
+type AddressBook struct {
+	store Store
+}
+
+// NewAddressBook returns the address book kept in store
+func NewAddressBook(store Store) *AddressBook {
+	return &AddressBook{store: store}
+}
+
+// validateAlias check that name can designate a peripheral: not empty, and
+// not mistaken for an address
+func validateAlias(name string) error {
+	if name == "" {
+		return errors.New("empty alias")
+	}
+	if _, err := ParseMac(strings.ToLower(name)); err == nil {
+		return fmt.Errorf("alias %q is an address", name)
+	}
+	return nil
+}
+
+// Add name address, replacing the address the name had
+func (b *AddressBook) Add(name string, address QualifiedMac) error {
+	if err := validateAlias(name); err != nil {
+		return err
+	}
+	if err := address.Validate(); err != nil {
+		return err
+	}
+	return storePut(b.store, storeAliasPrefix+name, &AddressBookEntry{
+		Name:    name,
+		Address: address.Address.String(),
+		Public:  address.AddrType == AddrTypePublic,
+	})
+}
+
+// Remove forget name, removing a missing name is not an error
+func (b *AddressBook) Remove(name string) error {
+	return b.store.Delete(storeAliasPrefix + name)
+}
+
+// Lookup returns the address named name, ErrUnknownAlias when there is
+// none
+func (b *AddressBook) Lookup(name string) (QualifiedMac, error) {
+	var entry AddressBookEntry
+	if err := storeGet(b.store, storeAliasPrefix+name, &entry); err == ErrKeyNotFound {
+		return QualifiedMac{}, fmt.Errorf("%w %q", ErrUnknownAlias, name)
+	} else if err != nil {
+		return QualifiedMac{}, err
+	}
+	return entry.QualifiedMac()
+}
+
+// Name returns the name of address, false when it has none
+func (b *AddressBook) Name(address Mac) (string, bool, error) {
+	entries, err := b.List()
+	if err != nil {
+		return "", false, err
+	}
+	for _, entry := range entries {
+		if strings.EqualFold(entry.Address, address.String()) {
+			return entry.Name, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// List returns every entry, sorted by name
+func (b *AddressBook) List() ([]AddressBookEntry, error) {
+	keys, err := b.store.List(storeAliasPrefix)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]AddressBookEntry, 0, len(keys))
+	for _, key := range keys {
+		var entry AddressBookEntry
+		if err := storeGet(b.store, key, &entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Resolve returns the address designated by s: a name of the address
+// book, or an address as printed by Mac.String. Addresses outside the book
+// are looked up among the peripherals the central scanned, for their type.
+func (c *Central) Resolve(s string) (QualifiedMac, error) {
+	mac, err := ParseMac(strings.ToLower(s))
+	if err != nil {
+		if c.AddressBook == nil {
+			return QualifiedMac{}, fmt.Errorf("no address book to resolve %q", s)
+		}
+		return c.AddressBook.Lookup(s)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, resp := range c.knownPeripherals {
+		if resp.Address.Address == mac {
+			return resp.Address, nil
+		}
+	}
+	return QualifiedMac{}, fmt.Errorf("address type of %s unknown, scan first or name it in the address book", s)
+}
+
+// Connect open a connection to the peripheral designated by name, see
+// Resolve, with the default connection parameters
+func (c *Central) Connect(name string) (*Connection, error) {
+	return c.ConnectContext(context.Background(), name)
+}
+
+// ConnectContext open a connection to the peripheral designated by name,
+// ending the attempt when ctx is done
+func (c *Central) ConnectContext(ctx context.Context, name string) (*Connection, error) {
+	address, err := c.Resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	conn := c.NewConnection(&GapScanRespone{Address: address}, DefaultConnectionParameters())
+	if err := conn.OpenContext(ctx); err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
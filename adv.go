@@ -0,0 +1,74 @@
+package bgapi
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+)
+
+// advertising data types, see the Bluetooth assigned numbers
+const (
+	AdFlags            byte = 0x01
+	AdIncomplete16     byte = 0x02
+	AdComplete16       byte = 0x03
+	AdIncomplete32     byte = 0x04
+	AdComplete32       byte = 0x05
+	AdIncomplete128    byte = 0x06
+	AdComplete128      byte = 0x07
+	AdShortName        byte = 0x08
+	AdCompleteName     byte = 0x09
+	AdTxPower          byte = 0x0a
+	AdServiceData16    byte = 0x16
+	AdManufacturerData byte = 0xff
+)
+
+// UUIDString format a UUID held least significant byte first, as BGAPI
+// transfers them, in its usual textual form
+func UUIDString(uuid []byte) string {
+	b := make([]byte, len(uuid))
+	for i, v := range uuid {
+		b[len(uuid)-1-i] = v
+	}
+	s := hex.EncodeToString(b)
+	if len(b) == 16 {
+		s = s[0:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+	}
+	return s
+}
+
+// Flags returns the advertised flags
+func (adv AdvertisementData) Flags() (byte, bool) {
+	flags, ok := adv[AdFlags]
+	if !ok || len(flags) == 0 {
+		return 0, false
+	}
+	return flags[0], true
+}
+
+// TxPower returns the advertised transmit power in dBm
+func (adv AdvertisementData) TxPower() (int8, bool) {
+	power, ok := adv[AdTxPower]
+	if !ok || len(power) == 0 {
+		return 0, false
+	}
+	return int8(power[0]), true
+}
+
+// ManufacturerData returns the company identifier and the data of the
+// manufacturer specific field
+func (adv AdvertisementData) ManufacturerData() (uint16, []byte, bool) {
+	data, ok := adv[AdManufacturerData]
+	if !ok || len(data) < 2 {
+		return 0, nil, false
+	}
+	return binary.LittleEndian.Uint16(data), data[2:], true
+}
+
+// ServiceData returns the service data field, keyed by its 16-bit service
+// UUID
+func (adv AdvertisementData) ServiceData() (uint16, []byte, bool) {
+	data, ok := adv[AdServiceData16]
+	if !ok || len(data) < 2 {
+		return 0, nil, false
+	}
+	return binary.LittleEndian.Uint16(data), data[2:], true
+}
@@ -0,0 +1,69 @@
+package bgapi
+
+import (
+	"bytes"
+	"sort"
+)
+
+// AdvFieldChange an AD field of an advertisement that changed, tagged for
+// JSON. A field may be advertised with an empty value, Added and Removed
+// tell it from a field missing.
+type AdvFieldChange struct {
+	Type    byte     `json:"type"`
+	Old     HexBytes `json:"old,omitempty"`
+	New     HexBytes `json:"new,omitempty"`
+	Added   bool     `json:"added,omitempty"`
+	Removed bool     `json:"removed,omitempty"`
+}
+
+// DiffAdvertisementData returns the fields that differ between two
+// decoded advertisements, ordered by type
+func DiffAdvertisementData(old AdvertisementData, new AdvertisementData) []AdvFieldChange {
+	var changes []AdvFieldChange
+	for t, value := range old {
+		newValue, ok := new[t]
+		if !ok {
+			changes = append(changes, AdvFieldChange{Type: t, Old: append(HexBytes{}, value...), Removed: true})
+		} else if !bytes.Equal(value, newValue) {
+			changes = append(changes, AdvFieldChange{Type: t, Old: append(HexBytes{}, value...), New: append(HexBytes{}, newValue...)})
+		}
+	}
+	for t, value := range new {
+		if _, ok := old[t]; !ok {
+			changes = append(changes, AdvFieldChange{Type: t, New: append(HexBytes{}, value...), Added: true})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Type < changes[j].Type })
+	return changes
+}
+
+// payloadChanged record the data of a scan response, returning the data
+// it replaces when the peripheral sent different data in the last packet
+// of the same type. Advertisements and scan responses are compared
+// separately, an active scan receiving both in turn. The mutex must be
+// held.
+func (c *Central) payloadChanged(resp *GapScanRespone) ([]byte, bool) {
+	if c.knownPayloads == nil {
+		c.knownPayloads = make(map[string][]byte)
+	}
+	key := resp.Address.Hashable() + string(resp.PacketType)
+	previous, seen := c.knownPayloads[key]
+	c.knownPayloads[key] = resp.Data
+	return previous, seen && !bytes.Equal(previous, resp.Data)
+}
+
+// publishPayloadChanged report the fields of a peripheral's advertisement
+// that changed since its previous packet of the same type
+func (c *Central) publishPayloadChanged(resp *GapScanRespone, previous []byte) {
+	c.publish(func() *DeviceEvent {
+		old := ParseGapScanResponse(&GapScanRespone{Data: previous})
+		return &DeviceEvent{
+			Type:    DevicePayloadChanged,
+			Time:    c.api.clock.Now(),
+			Address: resp.Address.Address.String(),
+			RSSI:    resp.RSSI,
+			Name:    ParseGapScanResponse(resp).LocalName(),
+			Changes: DiffAdvertisementData(*old, *ParseGapScanResponse(resp)),
+		}
+	})
+}
@@ -0,0 +1,130 @@
+package bgapi
+
+import "fmt"
+
+// AttError an ATT error code, returned to clients by a local GATT server
+// answering user read and write requests
+type AttError byte
+
+// protocol errors, defined by the Bluetooth specification
+const (
+	// AttErrNone the request succeeded
+	AttErrNone AttError = 0x00
+	// AttErrInvalidHandle the handle is not valid on this server
+	AttErrInvalidHandle AttError = 0x01
+	// AttErrReadNotPermitted the attribute cannot be read
+	AttErrReadNotPermitted AttError = 0x02
+	// AttErrWriteNotPermitted the attribute cannot be written
+	AttErrWriteNotPermitted AttError = 0x03
+	// AttErrInvalidPDU the request was malformed
+	AttErrInvalidPDU AttError = 0x04
+	// AttErrInsufficientAuthentication the link must be authenticated
+	AttErrInsufficientAuthentication AttError = 0x05
+	// AttErrRequestNotSupported the server does not support the request
+	AttErrRequestNotSupported AttError = 0x06
+	// AttErrInvalidOffset the offset is past the end of the value
+	AttErrInvalidOffset AttError = 0x07
+	// AttErrInsufficientAuthorization the client is not authorized
+	AttErrInsufficientAuthorization AttError = 0x08
+	// AttErrPrepareQueueFull too many prepared writes are queued
+	AttErrPrepareQueueFull AttError = 0x09
+	// AttErrAttributeNotFound no attribute in the handle range
+	AttErrAttributeNotFound AttError = 0x0a
+	// AttErrAttributeNotLong the attribute cannot be read or written with
+	// blob requests
+	AttErrAttributeNotLong AttError = 0x0b
+	// AttErrInsufficientEncryptionKeySize the encryption key is too short
+	AttErrInsufficientEncryptionKeySize AttError = 0x0c
+	// AttErrInvalidAttributeValueLength the value has the wrong length
+	AttErrInvalidAttributeValueLength AttError = 0x0d
+	// AttErrUnlikely the request failed for an unlikely reason
+	AttErrUnlikely AttError = 0x0e
+	// AttErrInsufficientEncryption the link must be encrypted
+	AttErrInsufficientEncryption AttError = 0x0f
+	// AttErrUnsupportedGroupType the group type is not supported
+	AttErrUnsupportedGroupType AttError = 0x10
+	// AttErrInsufficientResources the server ran out of resources
+	AttErrInsufficientResources AttError = 0x11
+)
+
+// common profile errors, defined by the Core Specification Supplement
+const (
+	// AttErrWriteRequestRejected the write was rejected
+	AttErrWriteRequestRejected AttError = 0xfc
+	// AttErrCCCDImproperlyConfigured the client characteristic
+	// configuration descriptor is not configured as required
+	AttErrCCCDImproperlyConfigured AttError = 0xfd
+	// AttErrProcedureInProgress a procedure is already in progress
+	AttErrProcedureInProgress AttError = 0xfe
+	// AttErrOutOfRange the value is out of range
+	AttErrOutOfRange AttError = 0xff
+)
+
+// application errors, 0x80 to 0x9f, are defined by each profile
+const (
+	attErrApplicationFirst   AttError = 0x80
+	attErrApplicationLast    AttError = 0x9f
+	attErrProtocolLast       AttError = AttErrInsufficientResources
+	attErrCommonProfileFirst AttError = 0xe0
+)
+
+// attErrorNames names of the protocol errors
+var attErrorNames = []string{
+	"success", "invalid handle", "read not permitted", "write not permitted", "invalid PDU",
+	"insufficient authentication", "request not supported", "invalid offset",
+	"insufficient authorization", "prepare queue full", "attribute not found",
+	"attribute not long", "insufficient encryption key size", "invalid attribute value length",
+	"unlikely error", "insufficient encryption", "unsupported group type", "insufficient resources",
+}
+
+// attCommonProfileNames names of the common profile errors from 0xfc
+var attCommonProfileNames = []string{
+	"write request rejected", "CCCD improperly configured", "procedure already in progress", "out of range",
+}
+
+// AttApplicationError returns the application error code (0x00-0x1f) as an
+// AttError, 0x80 onwards
+func AttApplicationError(code byte) (AttError, error) {
+	if AttError(code) > attErrApplicationLast-attErrApplicationFirst {
+		return 0, fmt.Errorf("application error code 0x%02x outside 0x00-0x1f", code)
+	}
+	return attErrApplicationFirst + AttError(code), nil
+}
+
+// IsApplication returns true for the profile defined errors 0x80-0x9f
+func (e AttError) IsApplication() bool {
+	return e >= attErrApplicationFirst && e <= attErrApplicationLast
+}
+
+// Validate check that the code is not reserved by the specification
+func (e AttError) Validate() error {
+	if e <= attErrProtocolLast || e.IsApplication() || e >= attErrCommonProfileFirst {
+		return nil
+	}
+	return fmt.Errorf("ATT error code 0x%02x is reserved", byte(e))
+}
+
+// Error implements error
+func (e AttError) Error() string {
+	switch {
+	case e <= attErrProtocolLast:
+		return "ATT error: " + attErrorNames[e]
+	case e.IsApplication():
+		return fmt.Sprintf("ATT application error 0x%02x", byte(e))
+	case e >= AttErrWriteRequestRejected:
+		return "ATT error: " + attCommonProfileNames[e-AttErrWriteRequestRejected]
+	}
+	return fmt.Sprintf("ATT error 0x%02x", byte(e))
+}
+
+// attResultBase results in the 0x04xx range report ATT errors from the peer
+const attResultBase = 0x0400
+
+// AttError returns the ATT error reported by the peer, when the result is
+// one
+func (e *ProcedureError) AttError() (AttError, bool) {
+	if e.Result&0xff00 != attResultBase {
+		return 0, false
+	}
+	return AttError(e.Result & 0xff), true
+}
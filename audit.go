@@ -0,0 +1,141 @@
+package bgapi
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// SecurityEventType the kind of a SecurityEvent
+type SecurityEventType string
+
+// security event types
+const (
+	SecurityPairingStarted    SecurityEventType = "pairing_started"
+	SecurityPasskeyDisplayed  SecurityEventType = "passkey_displayed"
+	SecurityPasskeyRequested  SecurityEventType = "passkey_requested"
+	SecurityBondingSucceeded  SecurityEventType = "bonding_succeeded"
+	SecurityBondingFailed     SecurityEventType = "bonding_failed"
+	SecurityEncrypted         SecurityEventType = "encrypted"
+	SecurityEncryptionFailed  SecurityEventType = "encryption_failed"
+	SecurityBondDeleted       SecurityEventType = "bond_deleted"
+	SecurityConnectionRefused SecurityEventType = "connection_rejected"
+)
+
+// SecurityEvent an entry of the security audit trail, tagged for JSON.
+// Passkeys are never recorded.
+type SecurityEvent struct {
+	Type SecurityEventType `json:"type"`
+	Time time.Time         `json:"time"`
+	// Address of the peer as printed by Mac.String, empty for bonds
+	// deleted by handle
+	Address    string `json:"address,omitempty"`
+	Connection byte   `json:"connection"`
+
+	// Bond, KeySize, MITM and Keys of a bond
+	Bond    byte   `json:"bond,omitempty"`
+	KeySize byte   `json:"key_size,omitempty"`
+	MITM    bool   `json:"mitm,omitempty"`
+	Keys    SmKeys `json:"keys,omitempty"`
+
+	// Result and Reason of a failure
+	Result uint16 `json:"result,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// SecurityAuditor receives the security events of a Central, see
+// Central.Auditor. Audit runs on the goroutine of the event, possibly the
+// API's receive goroutine, and must not block.
+type SecurityAuditor interface {
+	Audit(event *SecurityEvent)
+}
+
+// JSONAuditor a SecurityAuditor writing each event to w as a line of JSON
+type JSONAuditor struct {
+	mutex sync.Mutex
+	enc   *json.Encoder
+	err   error // first write error, later events are dropped
+}
+
+// NewJSONAuditor returns an auditor writing to w
+func NewJSONAuditor(w io.Writer) *JSONAuditor {
+	return &JSONAuditor{enc: json.NewEncoder(w)}
+}
+
+// Audit implements SecurityAuditor
+func (a *JSONAuditor) Audit(event *SecurityEvent) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.err == nil {
+		a.err = a.enc.Encode(event)
+	}
+}
+
+// Err returns the first write error
+func (a *JSONAuditor) Err() error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	return a.err
+}
+
+// audit report a security event of the connection, err sets the reason of
+// a failure
+func (c *Connection) audit(kind SecurityEventType, bond *BondInfo, err error) {
+	auditor := c.central.Auditor
+	if auditor == nil {
+		return
+	}
+	event := &SecurityEvent{
+		Type:       kind,
+		Time:       c.central.api.clock.Now(),
+		Address:    c.resp.Address.Address.String(),
+		Connection: c.status.Connection,
+	}
+	if bond != nil {
+		event.Bond = bond.Bond
+		event.KeySize = bond.KeySize
+		event.MITM = bond.MITM
+		event.Keys = bond.Keys
+	}
+	if err != nil {
+		event.Reason = err.Error()
+		var procErr *ProcedureError
+		if errors.As(err, &procErr) {
+			event.Result = procErr.Result
+			event.Reason = ResultName(procErr.Result)
+		}
+	}
+	auditor.Audit(event)
+}
+
+// DeleteBonding delete a bond stored on the module, 0xff deletes them all,
+// along with those kept in the central's Store
+func (c *Central) DeleteBonding(bond byte) error {
+	err := c.api.deleteBonding(bond)
+	if err == nil && c.Auditor != nil {
+		c.Auditor.Audit(&SecurityEvent{Type: SecurityBondDeleted, Time: c.api.clock.Now(), Bond: bond})
+	}
+	if err == nil && c.Store != nil {
+		err = deleteStoredBonds(c.Store, bond)
+	}
+	return err
+}
+
+// deleteBonding delete a bond and check the result
+func (api *API) deleteBonding(bond byte) error {
+	buf, err := api.call(5, 2, []byte{bond})
+	if err != nil {
+		return err
+	}
+	d := newDecoder(buf)
+	if result := d.u16(); d.err != nil {
+		return d.err
+	} else if result != 0 {
+		return &ProcedureError{Result: result}
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package bgapi
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+const (
+	appleCompanyID  = 0x004c
+	iBeaconType     = 0x02
+	iBeaconLength   = 0x15
+	eddystoneUUID16 = 0xfeaa
+)
+
+// IBeacon an iBeacon advertisement
+type IBeacon struct {
+	// UUID the proximity UUID, least significant byte first like the other
+	// UUIDs of this package
+	UUID  []byte
+	Major uint16
+	Minor uint16
+	// MeasuredPower RSSI at 1m in dBm
+	MeasuredPower int8
+}
+
+// IBeacon returns the iBeacon carried by the advertisement, if any
+func (adv AdvertisementData) IBeacon() (*IBeacon, bool) {
+	company, data, ok := adv.ManufacturerData()
+	if !ok || company != appleCompanyID || len(data) != 2+iBeaconLength || data[0] != iBeaconType || data[1] != iBeaconLength {
+		return nil, false
+	}
+	uuid := make([]byte, 16)
+	for i := range uuid {
+		uuid[i] = data[17-i] // transmitted most significant byte first
+	}
+	return &IBeacon{
+		UUID:          uuid,
+		Major:         binary.BigEndian.Uint16(data[18:]),
+		Minor:         binary.BigEndian.Uint16(data[20:]),
+		MeasuredPower: int8(data[22]),
+	}, true
+}
+
+// EddystoneFrame the type of an Eddystone frame
+type EddystoneFrame byte
+
+// Eddystone frame types
+const (
+	EddystoneUID EddystoneFrame = 0x00
+	EddystoneURL EddystoneFrame = 0x10
+	EddystoneTLM EddystoneFrame = 0x20
+	EddystoneEID EddystoneFrame = 0x30
+)
+
+func (f EddystoneFrame) String() string {
+	switch f {
+	case EddystoneUID:
+		return "uid"
+	case EddystoneURL:
+		return "url"
+	case EddystoneTLM:
+		return "tlm"
+	case EddystoneEID:
+		return "eid"
+	}
+	return "unknown"
+}
+
+// Eddystone an Eddystone advertisement, the fields set depend on the frame
+type Eddystone struct {
+	Frame EddystoneFrame
+	// TxPower calibrated power at 0m in dBm (UID, URL and EID frames)
+	TxPower int8
+
+	// Namespace and Instance the beacon identifier (UID frames)
+	Namespace []byte
+	Instance  []byte
+
+	// URL the decoded URL (URL frames)
+	URL string
+
+	// telemetry (TLM frames)
+	BatteryMillivolts uint16
+	Temperature       float64 // degrees Celsius
+	AdvCount          uint32  // advertisements sent since boot
+	Uptime            time.Duration
+
+	// EID the ephemeral identifier (EID frames)
+	EID []byte
+}
+
+var eddystoneSchemes = []string{"http://www.", "https://www.", "http://", "https://"}
+
+var eddystoneExpansions = []string{
+	".com/", ".org/", ".edu/", ".net/", ".info/", ".biz/", ".gov/",
+	".com", ".org", ".edu", ".net", ".info", ".biz", ".gov",
+}
+
+// Eddystone returns the Eddystone frame carried by the advertisement, if any
+func (adv AdvertisementData) Eddystone() (*Eddystone, bool) {
+	uuid, data, ok := adv.ServiceData()
+	if !ok || uuid != eddystoneUUID16 || len(data) < 2 {
+		return nil, false
+	}
+
+	e := &Eddystone{Frame: EddystoneFrame(data[0]), TxPower: int8(data[1])}
+	switch e.Frame {
+	case EddystoneUID:
+		if len(data) < 18 {
+			return nil, false
+		}
+		e.Namespace = data[2:12]
+		e.Instance = data[12:18]
+	case EddystoneURL:
+		if len(data) < 3 || int(data[2]) >= len(eddystoneSchemes) {
+			return nil, false
+		}
+		url := eddystoneSchemes[data[2]]
+		for _, c := range data[3:] {
+			if int(c) < len(eddystoneExpansions) {
+				url += eddystoneExpansions[c]
+			} else {
+				url += string(rune(c))
+			}
+		}
+		e.URL = url
+	case EddystoneTLM:
+		if len(data) < 14 || data[1] != 0 {
+			// only unencrypted telemetry is understood
+			return nil, false
+		}
+		e.TxPower = 0
+		e.BatteryMillivolts = binary.BigEndian.Uint16(data[2:])
+		e.Temperature = float64(int16(binary.BigEndian.Uint16(data[4:]))) / 256
+		e.AdvCount = binary.BigEndian.Uint32(data[6:])
+		e.Uptime = time.Duration(binary.BigEndian.Uint32(data[10:])) * 100 * time.Millisecond
+	case EddystoneEID:
+		if len(data) < 10 {
+			return nil, false
+		}
+		e.EID = data[2:10]
+	default:
+		return nil, false
+	}
+	return e, true
+}
@@ -0,0 +1,247 @@
+package bgapi
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultBeaconTimeout absence after which a beacon is reported missing
+const defaultBeaconTimeout = time.Minute
+
+// BeaconID the identity of a beacon, independent of the address it
+// advertises from: "ibeacon:UUID:MAJOR:MINOR" or "eddystone:NAMESPACEINSTANCE"
+// with the UUID as printed by UUIDString and the Eddystone UID in hex
+type BeaconID string
+
+// IBeaconID returns the identity of an iBeacon, uuid least significant byte
+// first as in IBeacon
+func IBeaconID(uuid []byte, major uint16, minor uint16) BeaconID {
+	return BeaconID(fmt.Sprintf("ibeacon:%s:%d:%d", UUIDString(uuid), major, minor))
+}
+
+// EddystoneUIDID returns the identity of an Eddystone beacon broadcasting
+// UID frames
+func EddystoneUIDID(namespace []byte, instance []byte) BeaconID {
+	return BeaconID("eddystone:" + hex.EncodeToString(namespace) + hex.EncodeToString(instance))
+}
+
+// ID returns the identity of the iBeacon
+func (b *IBeacon) ID() BeaconID {
+	return IBeaconID(b.UUID, b.Major, b.Minor)
+}
+
+// BeaconStatus what a BeaconMonitor knows of a beacon
+type BeaconStatus struct {
+	ID BeaconID
+	// Address the beacon last advertised from, empty until seen
+	Address string
+	// LastSeen time of the last advertisement, zero until seen
+	LastSeen time.Time
+	RSSI     int8
+
+	// telemetry of Eddystone beacons interleaving TLM frames, zero until
+	// received
+	BatteryMillivolts uint16
+	Temperature       float64
+	TelemetryTime     time.Time
+
+	// Missing not seen within the timeout of the monitor
+	Missing bool
+}
+
+// BeaconMonitor tracks a fleet of beacons, e.g. tagged assets, and reports
+// those that stop advertising. Feed it the scan responses with Observe and
+// check for missing beacons with Check, or let Central.MonitorBeacons do
+// both.
+type BeaconMonitor struct {
+	// Timeout absence after which a beacon is missing, defaults to a
+	// minute; set it before monitoring
+	Timeout time.Duration
+	// OnMissing notified when a beacon goes missing, may be nil
+	OnMissing func(status BeaconStatus)
+	// OnFound notified when a missing beacon advertises again, may be nil
+	OnFound func(status BeaconStatus)
+
+	mutex     sync.Mutex
+	since     time.Time // start of the monitoring, for the beacons never seen
+	beacons   map[BeaconID]*BeaconStatus
+	byAddress map[string]BeaconID // Eddystone beacons by address, for their TLM frames
+}
+
+// NewBeaconMonitor returns a monitor of the beacons ids
+func NewBeaconMonitor(ids []BeaconID) *BeaconMonitor {
+	m := &BeaconMonitor{
+		beacons:   make(map[BeaconID]*BeaconStatus),
+		byAddress: make(map[string]BeaconID),
+	}
+	for _, id := range ids {
+		m.beacons[id] = &BeaconStatus{ID: id}
+	}
+	return m
+}
+
+// timeout returns the absence after which a beacon is missing
+func (m *BeaconMonitor) timeout() time.Duration {
+	if m.Timeout > 0 {
+		return m.Timeout
+	}
+	return defaultBeaconTimeout
+}
+
+// Start count the absence of the beacons never seen from t, by default
+// from the first Observe or Check
+func (m *BeaconMonitor) Start(t time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.since = t
+}
+
+// Observe record a scan response received at t, ignored unless it comes
+// from a monitored beacon
+func (m *BeaconMonitor) Observe(t time.Time, resp *GapScanRespone) {
+	adv := ParseGapScanResponse(resp)
+	address := resp.Address.Address.String()
+
+	m.mutex.Lock()
+	if m.since.IsZero() {
+		m.since = t
+	}
+
+	var id BeaconID
+	var telemetry *Eddystone
+	if b, ok := adv.IBeacon(); ok {
+		id = b.ID()
+	} else if e, ok := adv.Eddystone(); ok {
+		switch e.Frame {
+		case EddystoneUID:
+			id = EddystoneUIDID(e.Namespace, e.Instance)
+			if _, monitored := m.beacons[id]; monitored {
+				m.byAddress[address] = id
+			}
+		case EddystoneTLM:
+			id, telemetry = m.byAddress[address], e
+		}
+	}
+	status := m.beacons[id]
+	if status == nil {
+		m.mutex.Unlock()
+		return
+	}
+
+	status.Address = address
+	status.LastSeen = t
+	status.RSSI = resp.RSSI
+	if telemetry != nil {
+		status.BatteryMillivolts = telemetry.BatteryMillivolts
+		status.Temperature = telemetry.Temperature
+		status.TelemetryTime = t
+	}
+	found := status.Missing
+	status.Missing = false
+	snapshot := *status
+	m.mutex.Unlock()
+
+	if found && m.OnFound != nil {
+		m.OnFound(snapshot)
+	}
+}
+
+// Check mark the beacons not seen within the timeout missing at now,
+// notifying OnMissing once per absence
+func (m *BeaconMonitor) Check(now time.Time) {
+	m.mutex.Lock()
+	if m.since.IsZero() {
+		m.since = now
+	}
+	var missing []BeaconStatus
+	for _, status := range m.beacons {
+		last := status.LastSeen
+		if last.IsZero() {
+			last = m.since
+		}
+		if !status.Missing && now.Sub(last) >= m.timeout() {
+			status.Missing = true
+			missing = append(missing, *status)
+		}
+	}
+	m.mutex.Unlock()
+
+	sortBeacons(missing)
+	if m.OnMissing != nil {
+		for _, status := range missing {
+			m.OnMissing(status)
+		}
+	}
+}
+
+// Status returns the status of every monitored beacon, sorted by identity
+func (m *BeaconMonitor) Status() []BeaconStatus {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	statuses := make([]BeaconStatus, 0, len(m.beacons))
+	for _, status := range m.beacons {
+		statuses = append(statuses, *status)
+	}
+	sortBeacons(statuses)
+	return statuses
+}
+
+// Missing returns the status of the missing beacons, sorted by identity
+func (m *BeaconMonitor) Missing() []BeaconStatus {
+	var missing []BeaconStatus
+	for _, status := range m.Status() {
+		if status.Missing {
+			missing = append(missing, status)
+		}
+	}
+	return missing
+}
+
+func sortBeacons(statuses []BeaconStatus) {
+	sort.Slice(statuses, func(i, j int) bool { return strings.Compare(string(statuses[i].ID), string(statuses[j].ID)) < 0 })
+}
+
+// MonitorBeacons scan like Scan, feeding monitor with the scan responses
+// and checking for missing beacons a few times per timeout, until ctx is
+// done. A handler already set in OnScanResponse keeps being called.
+// Returns nil once ctx is done.
+func (c *Central) MonitorBeacons(ctx context.Context, mode byte, monitor *BeaconMonitor) error {
+	clock := c.api.clock
+	monitor.Start(clock.Now())
+
+	defer c.addScanHook(func(resp *GapScanRespone) {
+		monitor.Observe(clock.Now(), resp)
+	})()
+
+	checked := make(chan struct{})
+	checkCtx, cancel := context.WithCancel(ctx)
+	go func() {
+		defer close(checked)
+		timer := clock.NewTimer(monitor.timeout() / 4)
+		defer timer.Stop()
+		for {
+			select {
+			case <-timer.C():
+				monitor.Check(clock.Now())
+				timer.Reset(monitor.timeout() / 4)
+			case <-checkCtx.Done():
+				return
+			}
+		}
+	}()
+
+	err := c.Scan(ctx, mode)
+	cancel()
+	<-checked
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		return nil
+	}
+	return err
+}
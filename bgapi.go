@@ -10,18 +10,26 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
-
-	"github.com/tarm/serial"
 )
 
 const (
 	defaultTimeoutMs = 1000
+
+	// txQueueDepth number of commands that may be queued for transmission
+	txQueueDepth = 64
 )
 
 // Mac represents an IEEE MAC address
 type Mac [6]byte
 
+// String format the address most significant byte first, as it is usually
+// printed (BGAPI transfers addresses least significant byte first)
+func (m Mac) String() string {
+	return fmt.Sprintf("%02x:%02x:%02x:%02x:%02x:%02x", m[5], m[4], m[3], m[2], m[1], m[0])
+}
+
 // QualifiedMac represents an IEEE MAC address qualified by BLE MAC Type idenfier
 type QualifiedMac struct {
 	Address  Mac
@@ -36,11 +44,17 @@ func (qm *QualifiedMac) Hashable() string {
 // ConnectionParameters connection parameters
 type ConnectionParameters struct {
 	IntervalMin uint16
-	intervalMax uint16
+	IntervalMax uint16
 	Timeout     uint16
 	Latency     uint16
 }
 
+// DefaultConnectionParameters returns parameters suitable for most
+// peripherals (75-95ms interval, 1s supervision timeout, no latency)
+func DefaultConnectionParameters() *ConnectionParameters {
+	return &ConnectionParameters{IntervalMin: 60, IntervalMax: 76, Timeout: 100, Latency: 0}
+}
+
 // SystemCounters result of query for system diagnostic counters
 type SystemCounters struct {
 	Txok, Txretry, Rxok, Rxfail, Mbuf byte
@@ -93,13 +107,25 @@ type SpiConfig struct {
 type IoPortStatus struct {
 	Timestamp        uint32
 	Port, Irq, State byte
+	// Time host time of Timestamp while a TimeSync runs, zero otherwise
+	Time time.Time
 }
 
-// Delegate an API Delegate to be implemented by clients of this module
+// Delegate an API Delegate to be implemented by clients of this module.
+//
+// The slices and pointers handed to the delegate reference the API's receive
+// buffer, which is reused for the following frames: they are only valid until
+// the method returns and must be copied to be kept. Setting
+// TransportOptions.CopyPayloads hands out copies instead.
+//
+// Methods are invoked one at a time on the receive goroutine unless
+// TransportOptions.Dispatch selects DispatchPerConnection, in which case the
+// events of different connections may be delivered concurrently.
 type Delegate interface {
 	// OnSystemBoot invoked when the BLED112 boots
 	OnSystemBoot(info *SystemInfo)
-	// OnSystemDebug invoked when BLED112 generates debug reply
+	// OnSystemDebug invoked when BLED112 generates debug reply, unless a
+	// DebugLog is attached (see API.SetDebugLog)
 	OnSystemDebug(data []byte)
 	// OnSystemEndpointWatermarkRx inovked when receiveing Endpoint Watermark
 	OnSystemEndpointWatermarkRx(endpoint byte, data byte)
@@ -107,7 +133,8 @@ type Delegate interface {
 	OnSystemEndpointWatermarkTx(endpoint byte, data byte)
 	// OnSystemScriptFailure invoked on script failure
 	OnSystemScriptFailure(addr uint16, reason uint16)
-	// OnSystemNoLicenseKey invoked when no license key is found
+	// OnSystemNoLicenseKey invoked when no license key is found, see
+	// API.RestoreLicenseKey
 	OnSystemNoLicenseKey()
 	// OnFlashPsKey invoked when flash PS Key is updated
 	OnFlashPsKey(key uint16, value []byte)
@@ -178,7 +205,7 @@ type bgFrameHeader struct {
 }
 
 func (hdr *bgFrameHeader) frameLengthGet() int {
-	return int(hdr.length & 0x7fff)
+	return int(hdr.length & 0x7ff)
 }
 
 func (hdr *bgFrameHeader) messageTypeGet() int {
@@ -189,36 +216,79 @@ func (hdr *bgFrameHeader) technologyTypeGet() int {
 	return int((hdr.length >> 11) & 0xf)
 }
 
+// bgFrameReader splits the received byte stream into frames. Frames are
+// sliced out of a single buffer that is reused once they have been handled,
+// so a frame (and any payload slice decoded from it) is only valid until the
+// next call to append.
 type bgFrameReader struct {
-	buf     *bytes.Buffer
+	buf     []byte
+	off     int // start of the unread data in buf
 	header  bgFrameHeader
 	inFrame bool
+
+	// packetMode each frame is preceded by a length byte
+	packetMode bool
 }
 
-// append raw data
+// append raw data, invalidating the frames returned so far
 func (fr *bgFrameReader) append(data []byte) {
-	fr.buf.Write(data)
+	if fr.off > 0 {
+		// drop the frames already handed out
+		n := copy(fr.buf, fr.buf[fr.off:])
+		fr.buf = fr.buf[:n]
+		fr.off = 0
+	}
+	fr.buf = append(fr.buf, data...)
+}
+
+// reset drop the buffered data, to resynchronise on the frames that follow
+func (fr *bgFrameReader) reset() {
+	fr.buf = fr.buf[:0]
+	fr.off = 0
+	fr.inFrame = false
+}
+
+// buffered returns the number of unread bytes
+func (fr *bgFrameReader) buffered() int {
+	return len(fr.buf) - fr.off
 }
 
 // HasFrame true if at least one frame is ready to be extracted
 func (fr *bgFrameReader) hasFrame() bool {
-	if !fr.inFrame && (fr.buf.Len() >= 4) {
-		// extract the header
-		binary.Read(fr.buf, binary.LittleEndian, &fr.header)
+	headerLen := 4
+	if fr.packetMode {
+		headerLen++
+	}
+
+	if !fr.inFrame && (fr.buffered() >= headerLen) {
+		if fr.packetMode {
+			fr.off++ // skip the packet length, the header carries it too
+		}
+
+		// extract the header, the length word is big-endian on the wire
+		raw := fr.buf[fr.off : fr.off+4]
+		fr.header.length = binary.BigEndian.Uint16(raw[0:2])
+		fr.header.packetClass = raw[2]
+		fr.header.packetCommand = raw[3]
+		fr.off += 4
 		fr.inFrame = true
 	}
 
-	return fr.inFrame && (fr.buf.Len() >= fr.header.frameLengthGet())
+	return fr.inFrame && (fr.buffered() >= fr.header.frameLengthGet())
 }
 
-// Next read the next pending frame
+// Next read the next pending frame, the frame's capacity is clipped so
+// appending to it cannot overwrite the following frames
 func (fr *bgFrameReader) next() ([]byte, *bgFrameHeader) {
 	if !fr.inFrame {
 		return nil, nil
 	}
 	fr.inFrame = false
 
-	return fr.buf.Next(fr.header.frameLengthGet()), &fr.header
+	end := fr.off + fr.header.frameLengthGet()
+	frame := fr.buf[fr.off:end:end]
+	fr.off = end
+	return frame, &fr.header
 }
 
 type operation struct {
@@ -227,16 +297,111 @@ type operation struct {
 	completion func(*bytes.Buffer, error)
 	txData     []byte
 	timeout    time.Duration
+	queued     time.Time
+	sent       time.Time
+	noReply    bool // the device answers with an event, if at all
+	priority   bool // transmitted ahead of the queue, see SetCommandPriority
 }
 
-// API for low-level BLED112 access
+// API for low-level BLED112 access. Slices passed to completions follow the
+// same ownership rules as the Delegate's.
 type API struct {
-	ser       *serial.Port
+	ser       Transport
 	txC       chan *operation
+	txHighC   chan *operation // high priority commands, see SetCommandPriority
 	rxReplyC  chan error
 	pendingOp *operation
 	delegate  Delegate
 	framer    bgFrameReader
+	wake      WakeController
+	clock     Clock
+
+	onTransportError func(err *TransportError)
+	reopen           func() (Transport, error)
+	eofIsTimeout     bool
+	readBuffer       readBuffer
+
+	// per frame state reused by the receive loop, see Delegate
+	copyPayloads bool
+	resync       bool
+	rxBuf        bytes.Buffer
+	rxDecoder    decoder
+	scanResp     GapScanRespone
+	// serializes frame processing between the receive loop and InjectEvent
+	rxMutex sync.Mutex
+
+	// guards pendingOp, stats and connections, which are shared between the
+	// transmit and receive loops and the introspection methods
+	mutex         sync.Mutex
+	stats         Stats
+	connections   map[byte]ConnectionStatus
+	rssi          map[byte]int8
+	counters      *SystemCounters
+	history       history
+	latencies     map[uint16]*LatencyHistogram // keyed by class << 8 | command
+	timedOut      *operation                   // last command that timed out, see unexpectedResponse
+	traceHook     TraceHook
+	recorder      *SessionRecorder               // session being recorded, see StartRecording
+	eventHandlers map[uint16]EventHandler        // keyed by class << 8 | event, see HandleEvent
+	psDump        func(key uint16, value []byte) // collects the keys during PSDump
+	softTimerC    chan struct{}                  // signalled by the self-test's soft timer
+	gapMode       GapMode
+	firmware      *FirmwareVersion
+	fingerprint   *cachedFingerprint            // see Fingerprint, dropped on boot
+	capabilities  map[Capability]bool           // see ProbeCapabilities, dropped on boot
+	linkStats     map[byte]*ConnectionStats     // keyed by connection handle, see LinkStats
+	subscriptions map[byte]map[uint16]bool      // client configurations enabled, by connection handle
+	timeSync      *TimeSync                     // see StartTimeSync
+	pins          map[uint16]*PinSubscription   // keyed by port << 8 | pin, see SubscribePin
+	pinIrqs       map[byte]pinIrq               // interrupts configured by port
+	pinMutex      sync.Mutex                    // serializes the interrupt configuration
+	comparator    func(*AnalogComparatorStatus) // see SetAnalogComparatorHandler
+	config        *Config                       // last applied by ApplyConfig, see SupportBundle
+	closed        bool
+	err           error // fatal transport error
+
+	// boot events, for handshakes waiting for the device to restart
+	bootC    chan *SystemInfo
+	dfuBootC chan uint32
+
+	// license key reported missing since the last boot, see
+	// RestoreLicenseKey
+	noLicense  bool
+	noLicenseC chan struct{}
+
+	parserBuffered int
+	readBufferSize int
+
+	// connection slots of the module, 0 until learned by Ready
+	maxConnections int
+
+	// reassembles system debug events, see SetDebugLog
+	debugLog *DebugLog
+
+	// endpoint watermark statistics, see EndpointStats
+	endpoints       map[byte]*EndpointStats
+	pressureHandler EndpointPressureHandler
+
+	// command rate limits, see SetRateLimit
+	limiter rateLimiter
+	// high priority commands keyed by class << 8 | command, see
+	// SetCommandPriority
+	highPriority map[uint16]bool
+
+	// user request deadline enforcement, see SetUserRequestPolicy
+	userPolicy   *UserRequestPolicy
+	userRequests map[byte]*pendingUserRequest
+
+	// script failure handling, see SetScriptFailurePolicy
+	scriptPolicy *ScriptFailurePolicy
+	degraded     *ScriptFailure
+
+	// shutdown, see Shutdown
+	enqueueMutex sync.RWMutex
+	closing      bool
+	done         chan struct{}
+	txDone       chan struct{}
+	rxGoroutine  uint64 // runs readLoop, where Shutdown is rejected
 }
 
 func boolCast(boolean bool) byte {
@@ -249,63 +414,190 @@ func boolCast(boolean bool) byte {
 
 // NewAPI returns a new API structure
 func NewAPI(delegate Delegate) *API {
-	var api = API{delegate: delegate}
+	var api = API{
+		delegate:    delegate,
+		clock:       SystemClock,
+		txC:         make(chan *operation, txQueueDepth),
+		txHighC:     make(chan *operation, txQueueDepth),
+		rxReplyC:    make(chan error, 1),
+		connections: make(map[byte]ConnectionStatus),
+		rssi:        make(map[byte]int8),
+		bootC:       make(chan *SystemInfo, 1),
+		dfuBootC:    make(chan uint32, 1),
+		noLicenseC:  make(chan struct{}, 1),
+		done:        make(chan struct{}),
+		txDone:      make(chan struct{}),
+	}
+	api.highPriority = make(map[uint16]bool)
+	for _, key := range defaultHighPriority {
+		api.highPriority[key] = true
+	}
 	return &api
 }
 
 // OpenBLED112 open the conneciton to the BLED112
-func (api *API) OpenBLED112(port string) {
-	cfg := serial.Config{Name: port, Baud: 115200}
-	if ser, err := serial.OpenPort(&cfg); err == nil {
-		api.ser = ser
-
-		// handle receiving data
-		go func() {
-			var data = make([]byte, 128)
-			for true {
-				if n, err := api.ser.Read(data); err == nil {
-					api.onSerialPortData(data[:n])
-				}
+func (api *API) OpenBLED112(port string) error {
+	return api.OpenSerial(port, nil)
+}
+
+// start the receive and transmit loops
+func (api *API) start() {
+	// handle receiving data
+	go api.readLoop()
+
+	go func() {
+		defer close(api.txDone)
+		for {
+			// stop transmitting as soon as the API is shut down
+			select {
+			case <-api.done:
+				api.drainQueue()
+				return
+			default:
 			}
-		}()
-
-		go func() {
-			for true {
-				op := <-api.txC
-				// FIXME need to handle errors
-				api.ser.Write(op.txData)
-				api.ser.Flush()
-
-				select {
-				case _ = <-api.rxReplyC:
-					// reply received, continue
-				case <-time.After(op.timeout * time.Millisecond):
-					op.completion(nil, errors.New("operation timed-out"))
-				}
+
+			// high priority commands jump ahead of the queue
+			select {
+			case op := <-api.txHighC:
+				api.transmit(op)
+				continue
+			default:
+			}
+
+			select {
+			case op := <-api.txHighC:
+				api.transmit(op)
+			case op := <-api.txC:
+				api.transmit(op)
+			case <-api.done:
 			}
-		}()
+		}
+	}()
+}
+
+// transmit write a command and wait for its response
+func (api *API) transmit(op *operation) {
+	api.mutex.Lock()
+	op.sent = api.clock.Now()
+	api.pendingOp = op
+	api.stats.CommandsSent++
+	api.stats.QueueWait += op.sent.Sub(op.queued)
+	api.countLinkCommand(op)
+	api.recordHistory(HistoryCommand, op.class, op.cmd, op.txData[4:])
+	api.mutex.Unlock()
+
+	if api.wake != nil {
+		// FIXME need to handle errors
+		api.wake.Assert()
+	}
+
+	// trace first, the response may be handled before Write returns
+	if hook := api.tracer(); hook != nil {
+		hook.OnTransmit(traceCommand(op, api.clock.Now()))
+	}
+	data := op.txData
+	if api.framer.packetMode {
+		data = append([]byte{byte(len(op.txData))}, op.txData...)
+	}
+
+	if _, err := api.transport().Write(data); err != nil {
+		api.failWrite(op, err)
+	} else if op.noReply {
+		api.mutex.Lock()
+		api.pendingOp = nil
+		api.mutex.Unlock()
+		op.completion(new(bytes.Buffer), nil)
+	} else {
+		api.awaitReply(op)
+	}
+
+	if api.wake != nil {
+		api.wake.Release()
 	}
 }
 
-func (api *API) sendWithTimeout(class byte, cmd byte, data []byte, timeoutMs time.Duration, completion func(*bytes.Buffer)) error {
-	// encode the command
+// failWrite complete op with the error writing it, no response will come
+func (api *API) failWrite(op *operation, err error) {
+	api.mutex.Lock()
+	failed := api.pendingOp == op
+	if failed {
+		api.pendingOp = nil
+	}
+	api.mutex.Unlock()
 
-	buf := new(bytes.Buffer)
-	binary.Write(buf, binary.LittleEndian, class)
-	binary.Write(buf, binary.LittleEndian, cmd)
-	binary.Write(buf, binary.LittleEndian, data)
+	if !failed {
+		// completed meanwhile, e.g. by a reopen, consume its signal
+		<-api.rxReplyC
+		return
+	}
+	if hook := api.tracer(); hook != nil {
+		f := traceCommand(op, api.clock.Now())
+		f.Err = err
+		hook.OnTimeout(f)
+	}
+	api.historyError(CommandName(op.class, op.cmd) + " not written: " + err.Error())
+	op.completion(nil, err)
+}
+
+// errOperationTimedOut completes the commands left unanswered
+var errOperationTimedOut = errors.New("operation timed-out")
+
+// awaitReply wait for the response to op, or time it out
+func (api *API) awaitReply(op *operation) {
+	select {
+	case _ = <-api.rxReplyC:
+		// reply received, continue
+	case <-api.clock.After(op.timeout * time.Millisecond):
+		api.mutex.Lock()
+		timedOut := api.pendingOp == op
+		if timedOut {
+			api.pendingOp = nil
+			api.stats.Timeouts++
+			api.timedOut = op
+		}
+		api.mutex.Unlock()
 
-	var ret error
-	api.txC <- &operation{class: class, cmd: cmd, txData: data, timeout: timeoutMs,
-		completion: func(buf *bytes.Buffer, err error) {
-			if err == nil {
-				completion(buf)
+		if timedOut {
+			if hook := api.tracer(); hook != nil {
+				hook.OnTimeout(traceCommand(op, api.clock.Now()))
 			}
-			ret = err
-		},
+			api.historyError(CommandName(op.class, op.cmd) + " timed out")
+			op.completion(nil, errOperationTimedOut)
+		} else {
+			// the reply raced the timer, consume its signal
+			<-api.rxReplyC
+		}
+	}
+}
+
+// submit queue a command, the completion observes both replies and failures
+func (api *API) submit(class byte, cmd byte, data []byte, timeoutMs time.Duration, completion func(*bytes.Buffer, error)) error {
+	if err := api.checkFirmware(class, cmd); err != nil {
+		return err
 	}
 
-	return ret
+	return api.enqueue(&operation{class: class, cmd: cmd, txData: encodeCommand(class, cmd, data), timeout: timeoutMs, completion: completion})
+}
+
+// encodeCommand frame a command, the header carries an 11-bit big-endian
+// length
+func encodeCommand(class byte, cmd byte, data []byte) []byte {
+	frame := make([]byte, 0, 4+len(data))
+	frame = append(frame, byte(len(data)>>8)&0x07, byte(len(data)), class, cmd)
+	return append(frame, data...)
+}
+
+// submitNoReply queue a command the device does not respond to
+func (api *API) submitNoReply(class byte, cmd byte, data []byte, completion func(*bytes.Buffer, error)) error {
+	return api.enqueue(&operation{class: class, cmd: cmd, txData: encodeCommand(class, cmd, data), completion: completion, noReply: true})
+}
+
+func (api *API) sendWithTimeout(class byte, cmd byte, data []byte, timeoutMs time.Duration, completion func(*bytes.Buffer)) error {
+	return api.submit(class, cmd, data, timeoutMs, func(buf *bytes.Buffer, err error) {
+		if err == nil {
+			completion(buf)
+		}
+	})
 }
 
 func (api *API) send(class byte, cmd byte, data []byte, completion func(*bytes.Buffer)) error {
@@ -314,32 +606,114 @@ func (api *API) send(class byte, cmd byte, data []byte, completion func(*bytes.B
 
 // handle receiveing data from the serial port
 func (api *API) onSerialPortData(data []byte) {
+	api.rxMutex.Lock()
+	defer api.rxMutex.Unlock()
+
+	api.mutex.Lock()
+	api.stats.BytesReceived += uint64(len(data))
+	api.mutex.Unlock()
+
 	api.framer.append(data)
 	for api.framer.hasFrame() {
 		frame, hdr := api.framer.next()
-		buf := bytes.NewBuffer(frame)
+		buf := api.frameBuffer(frame)
+
+		api.mutex.Lock()
+		api.stats.Frames++
+		hook := api.traceHook
+		op := api.pendingOp
+		if hdr.messageTypeGet() == 0 {
+			api.pendingOp = nil
+			api.recordHistory(HistoryResponse, hdr.packetClass, hdr.packetCommand, frame)
+			if op != nil {
+				api.stats.Responses++
+				if op.class == hdr.packetClass && op.cmd == hdr.packetCommand {
+					api.recordLatency(op.class, op.cmd, api.clock.Now().Sub(op.sent))
+				}
+			} else {
+				api.stats.UnexpectedResponses++
+			}
+		} else {
+			api.stats.Events++
+			api.recordHistory(HistoryEvent, hdr.packetClass, hdr.packetCommand, frame)
+			api.countLinkEvent(hdr.packetClass, hdr.packetCommand, frame)
+		}
+		api.mutex.Unlock()
+
+		if hook != nil {
+			traceReceived(hook, hdr, op, frame, api.clock.Now())
+		}
+
 		switch hdr.messageTypeGet() {
 		case 0:
-			if api.pendingOp != nil {
+			if op != nil {
 				var err error
-				if (api.pendingOp.class != hdr.packetClass) || (api.pendingOp.cmd != hdr.packetCommand) {
+				if (op.class != hdr.packetClass) || (op.cmd != hdr.packetCommand) {
 					err = errors.New("received incorrect response type")
+					api.historyError(fmt.Sprintf("%s answered by %s", CommandName(op.class, op.cmd),
+						CommandName(hdr.packetClass, hdr.packetCommand)))
 				}
-				api.pendingOp.completion(buf, err)
+				// release the transmitter first so completions may queue commands
 				api.rxReplyC <- nil
+				op.completion(buf, err)
 			} else {
-				fmt.Println("FIXME received bad header!")
+				api.unexpectedResponse(hdr)
 			}
 		case 1:
 			api.parseEvent(hdr, buf)
 		}
 	}
+
+	api.mutex.Lock()
+	api.parserBuffered = api.framer.buffered()
+	api.mutex.Unlock()
+}
+
+// unexpectedResponse account for a response received while no command was
+// pending. A response to the last command that timed out is merely late;
+// anything else suggests the framer lost track of the frame boundaries.
+func (api *API) unexpectedResponse(hdr *bgFrameHeader) {
+	name := CommandName(hdr.packetClass, hdr.packetCommand)
+
+	api.mutex.Lock()
+	stale := api.timedOut != nil && api.timedOut.class == hdr.packetClass && api.timedOut.cmd == hdr.packetCommand
+	if stale {
+		api.timedOut = nil
+		api.stats.StaleResponses++
+	}
+	resync := !stale && api.resync
+	if resync {
+		api.stats.Resyncs++
+	}
+	api.mutex.Unlock()
+
+	switch {
+	case stale:
+		api.historyError("stale " + name + " response after its command timed out")
+	case resync:
+		api.historyError("spurious " + name + " response, flushing the receive buffer")
+		api.framer.reset()
+	default:
+		api.historyError("spurious " + name + " response")
+	}
+}
+
+// frameBuffer wrap a frame for decoding, reusing the receive loop's buffer
+// unless the payloads must be copied
+func (api *API) frameBuffer(frame []byte) *bytes.Buffer {
+	if api.copyPayloads {
+		return bytes.NewBuffer(append([]byte(nil), frame...))
+	}
+	api.rxBuf = *bytes.NewBuffer(frame)
+	return &api.rxBuf
 }
 
-// SystemReset perform module reset
+// SystemReset perform module reset, the module does not respond so the
+// completion runs once the command is written; a boot event follows (unless
+// booting into DFU)
 func (api *API) SystemReset(bootInDfu bool, completion func()) error {
 	data := []byte{boolCast(bootInDfu)}
-	return api.send(0, 0, data, func(*bytes.Buffer) {
+	return api.submitNoReply(0, 0, data, func(*bytes.Buffer, error) {
 		completion()
 	})
 }
@@ -354,9 +728,7 @@ func (api *API) SystemHello(completion func()) error {
 // SystemAddressGet get the address
 func (api *API) SystemAddressGet(completion func(Mac)) error {
 	return api.send(0, 2, []byte{}, func(buf *bytes.Buffer) {
-		var mac Mac
-		binary.Read(buf, binary.LittleEndian, mac)
-		completion(mac)
+		completion(newDecoder(buf).mac())
 	})
 }
 
@@ -366,9 +738,7 @@ func (api *API) SystemRegWrite(addr uint16, value uint8, completion func(uint16)
 	binary.Write(buf, binary.LittleEndian, addr)
 	binary.Write(buf, binary.LittleEndian, value)
 	return api.send(0, 3, buf.Bytes(), func(buf *bytes.Buffer) {
-		var value uint16
-		binary.Read(buf, binary.LittleEndian, &value)
-		completion(value)
+		completion(newDecoder(buf).u16())
 	})
 }
 
@@ -376,11 +746,10 @@ func (api *API) SystemRegWrite(addr uint16, value uint8, completion func(uint16)
 func (api *API) SystemRegRead(addr uint16, completion func(uint16, uint8)) error {
 	buf := new(bytes.Buffer)
 	binary.Write(buf, binary.LittleEndian, addr)
-	return api.send(0, 4, []byte{}, func(buf *bytes.Buffer) {
-		var rxAddr uint16
-		var value uint8
-		binary.Read(buf, binary.LittleEndian, &rxAddr)
-		binary.Read(buf, binary.LittleEndian, &value)
+	return api.send(0, 4, buf.Bytes(), func(buf *bytes.Buffer) {
+		d := newDecoder(buf)
+		rxAddr := d.u16()
+		value := d.u8()
 		completion(rxAddr, value)
 	})
 }
@@ -389,7 +758,10 @@ func (api *API) SystemRegRead(addr uint16, completion func(uint16, uint8)) error
 func (api *API) SystemCountersGet(completion func(*SystemCounters)) error {
 	return api.send(0, 5, []byte{}, func(buf *bytes.Buffer) {
 		var counters = SystemCounters{}
-		binary.Read(buf, binary.LittleEndian, &counters)
+		newDecoder(buf).read(&counters)
+		api.mutex.Lock()
+		api.counters = &counters
+		api.mutex.Unlock()
 		completion(&counters)
 	})
 }
@@ -397,23 +769,20 @@ func (api *API) SystemCountersGet(completion func(*SystemCounters)) error {
 // SystemConnectionsGet get the connections
 func (api *API) SystemConnectionsGet(completion func(uint8)) error {
 	return api.send(0, 6, []byte{}, func(buf *bytes.Buffer) {
-		var maxConn uint8
-		binary.Read(buf, binary.LittleEndian, &maxConn)
-		completion(maxConn)
+		completion(newDecoder(buf).u8())
 	})
 }
 
 // SystemMemoryRead read memory
-func (api *API) SystemMemoryRead(addr uint16, length uint8, completion func(uint32, []byte)) error {
+func (api *API) SystemMemoryRead(addr uint32, length uint8, completion func(uint32, []byte)) error {
 	buf := new(bytes.Buffer)
 	binary.Write(buf, binary.LittleEndian, addr)
 	binary.Write(buf, binary.LittleEndian, length)
 	return api.send(0, 7, buf.Bytes(), func(buf *bytes.Buffer) {
-		var rxAddr uint32
-		var dataLen uint8
-		binary.Read(buf, binary.LittleEndian, &rxAddr)
-		binary.Read(buf, binary.LittleEndian, &dataLen)
-		completion(rxAddr, buf.Bytes())
+		d := newDecoder(buf)
+		rxAddr := d.u32()
+		data := d.uint8array()
+		completion(rxAddr, data)
 	})
 }
 
@@ -421,21 +790,23 @@ func (api *API) SystemMemoryRead(addr uint16, length uint8, completion func(uint
 func (api *API) SystemInfoGet(completion func(*SystemInfo)) error {
 	return api.send(0, 8, []byte{}, func(buf *bytes.Buffer) {
 		var info SystemInfo
-		binary.Read(buf, binary.LittleEndian, &info)
+		d := newDecoder(buf)
+		d.read(&info)
+		if d.err == nil {
+			api.setFirmware(&info)
+		}
 		completion(&info)
 	})
 }
 
-// SystemEndpointTx transmit endpoint
+// SystemEndpointTx transmit endpoint, see EndpointStats for flow control
 func (api *API) SystemEndpointTx(endpoint byte, data []byte, completion func(uint16)) error {
-	args := []byte{endpoint, byte(len(data))}
-	buf := new(bytes.Buffer)
-	binary.Write(buf, binary.LittleEndian, args)
-	binary.Write(buf, binary.LittleEndian, buf)
-	return api.send(0, 9, buf.Bytes(), func(buf *bytes.Buffer) {
-		var endpoint uint16
-		binary.Read(buf, binary.LittleEndian, endpoint)
-		completion(endpoint)
+	enc := new(encoder).write(endpoint).uint8array(data)
+	size := len(data)
+	return api.send(0, 9, enc.bytes(), func(buf *bytes.Buffer) {
+		result := newDecoder(buf).u16()
+		api.endpointTx(endpoint, size, result)
+		completion(result)
 	})
 }
 
@@ -444,9 +815,7 @@ func (api *API) SystemWhitelistAppend(address QualifiedMac, completion func(uint
 	buf := new(bytes.Buffer)
 	binary.Write(buf, binary.LittleEndian, address)
 	return api.send(0, 10, buf.Bytes(), func(buf *bytes.Buffer) {
-		var result uint16
-		binary.Read(buf, binary.LittleEndian, result)
-		completion(result)
+		completion(newDecoder(buf).u16())
 	})
 }
 
@@ -487,20 +856,27 @@ func (api *API) FlashPsEraseAll() error {
 	return api.send(1, 2, []byte{}, func(buf *bytes.Buffer) {})
 }
 
-// FlashPsSave save key value pair
-func (api *API) FlashPsSave(key uint16, value []byte) error {
+// FlashPsSave save key value pair, the completion receives the result code
+func (api *API) FlashPsSave(key uint16, value []byte, completion func(uint16)) error {
 	buf := new(bytes.Buffer)
 	binary.Write(buf, binary.LittleEndian, key)
 	binary.Write(buf, binary.LittleEndian, byte(len(value)))
 	binary.Write(buf, binary.LittleEndian, value)
-	return api.send(1, 3, buf.Bytes(), func(buf *bytes.Buffer) {})
+	return api.send(1, 3, buf.Bytes(), func(buf *bytes.Buffer) {
+		completion(newDecoder(buf).u16())
+	})
 }
 
-// FlashPsLoad load key value pair
-func (api *API) FlashPsLoad(key uint16) error {
+// FlashPsLoad load key value pair, the completion receives the result code
+// and the value
+func (api *API) FlashPsLoad(key uint16, completion func(uint16, []byte)) error {
 	buf := new(bytes.Buffer)
 	binary.Write(buf, binary.LittleEndian, key)
-	return api.send(1, 4, buf.Bytes(), func(buf *bytes.Buffer) {})
+	return api.send(1, 4, buf.Bytes(), func(buf *bytes.Buffer) {
+		d := newDecoder(buf)
+		result := d.u16()
+		completion(result, d.uint8array())
+	})
 }
 
 // FlashPsErase erase key value pair
@@ -512,7 +888,7 @@ func (api *API) FlashPsErase(key uint16) error {
 
 // FlashErasePage erase page
 func (api *API) FlashErasePage(page byte) error {
-	return api.send(1, 5, []byte{page}, func(buf *bytes.Buffer) {})
+	return api.send(1, 6, []byte{page}, func(buf *bytes.Buffer) {})
 }
 
 // FlashWriteWords write words
@@ -549,8 +925,13 @@ func (api *API) AttributesReadType(handle uint16) error {
 	return api.send(2, 2, buf.Bytes(), func(buf *bytes.Buffer) {})
 }
 
-// AttributesUserReadResponse read user response
-func (api *API) AttributesUserReadResponse(connection byte, attError byte, value []byte) error {
+// AttributesUserReadResponse answer a user read request, with value on
+// success (AttErrNone)
+func (api *API) AttributesUserReadResponse(connection byte, attError AttError, value []byte) error {
+	if err := attError.Validate(); err != nil {
+		return err
+	}
+	api.userAnswered(connection)
 	buf := new(bytes.Buffer)
 	binary.Write(buf, binary.LittleEndian, connection)
 	binary.Write(buf, binary.LittleEndian, attError)
@@ -559,8 +940,12 @@ func (api *API) AttributesUserReadResponse(connection byte, attError byte, value
 	return api.send(2, 3, buf.Bytes(), func(buf *bytes.Buffer) {})
 }
 
-// AttributesUserWriteResponse write response
-func (api *API) AttributesUserWriteResponse(connection byte, attError byte) error {
+// AttributesUserWriteResponse answer a user write request
+func (api *API) AttributesUserWriteResponse(connection byte, attError AttError) error {
+	if err := attError.Validate(); err != nil {
+		return err
+	}
+	api.userAnswered(connection)
 	buf := new(bytes.Buffer)
 	binary.Write(buf, binary.LittleEndian, connection)
 	binary.Write(buf, binary.LittleEndian, attError)
@@ -572,9 +957,21 @@ func (api *API) ConnectionDisconnect(connection byte) error {
 	return api.send(3, 0, []byte{connection}, func(buf *bytes.Buffer) {})
 }
 
-// ConnectionGetRssi get the RSSI value
+// ConnectionGetRssi get the RSSI value, the result is reported by State
 func (api *API) ConnectionGetRssi(connection byte) error {
-	return api.send(3, 1, []byte{connection}, func(buf *bytes.Buffer) {})
+	return api.send(3, 1, []byte{connection}, func(buf *bytes.Buffer) {
+		d := newDecoder(buf)
+		handle := d.u8()
+		rssi := d.i8()
+		if d.err == nil {
+			api.mutex.Lock()
+			api.rssi[handle] = rssi
+			if stats := api.linkStats[handle]; stats != nil {
+				stats.RSSI, stats.RSSIKnown = rssi, true
+			}
+			api.mutex.Unlock()
+		}
+	})
 }
 
 // ConnectionUpdate update connection params
@@ -590,9 +987,14 @@ func (api *API) ConnectionUpdate(connection byte, params *ConnectionParameters)
 	return api.send(3, 2, buf.Bytes(), func(buf *bytes.Buffer) {})
 }
 
-// ConnectionVersionUpdate update version
-func (api *API) ConnectionVersionUpdate(connection byte) error {
-	return api.send(3, 3, []byte{connection}, func(buf *bytes.Buffer) {})
+// ConnectionVersionUpdate request the version of the peer, the completion
+// receives the result code and the version follows as a version indication
+func (api *API) ConnectionVersionUpdate(connection byte, completion func(uint16)) error {
+	return api.send(3, 3, []byte{connection}, func(buf *bytes.Buffer) {
+		d := newDecoder(buf)
+		d.u8()
+		completion(d.u16())
+	})
 }
 
 // ConnectionChannelMapGet get channel mapping
@@ -605,9 +1007,14 @@ func (api *API) ConnectionChannelMapSet(connection byte, connMap []byte) error {
 	return api.send(3, 5, append([]byte{connection, byte(len(connMap))}, connMap...), func(buf *bytes.Buffer) {})
 }
 
-// ConnectionFeaturesGet get connection features
-func (api *API) ConnectionFeaturesGet(connection byte) error {
-	return api.send(3, 6, []byte{connection}, func(buf *bytes.Buffer) {})
+// ConnectionFeaturesGet request the features of the peer, the completion
+// receives the result code and the features follow as a feature indication
+func (api *API) ConnectionFeaturesGet(connection byte, completion func(uint16)) error {
+	return api.send(3, 6, []byte{connection}, func(buf *bytes.Buffer) {
+		d := newDecoder(buf)
+		d.u8()
+		completion(d.u16())
+	})
 }
 
 // ConnectionStatusGet get connection status
@@ -622,65 +1029,21 @@ func (api *API) ConnectionRawTx(connection byte, data []byte) error {
 
 // AttclientFindByTypeValue find attribute client by type
 func (api *API) AttclientFindByTypeValue(connection byte, start uint16, end uint16, uuid uint16, value []byte) error {
-	data := struct {
-		connection byte
-		start      uint16
-		end        uint16
-		uuid       uint16
-		valueLen   byte
-		value      []byte
-	}{
-		connection,
-		start,
-		end,
-		uuid,
-		byte(len(value)),
-		value,
-	}
-	buf := new(bytes.Buffer)
-	binary.Write(buf, binary.LittleEndian, data)
-	return api.send(4, 0, buf.Bytes(), func(buf *bytes.Buffer) {})
+	enc := new(encoder).write(connection).write(start).write(end).write(uuid).uint8array(value)
+	return api.send(4, 0, enc.bytes(), func(buf *bytes.Buffer) {})
 }
 
 // AttclientReadByGroupType query for discovered services
 // NOTE: Discovered services are reported by OnAttrclientGroupFound
 func (api *API) AttclientReadByGroupType(connection byte, start uint16, end uint16, uuid []byte) error {
-	data := struct {
-		connection byte
-		start      uint16
-		end        uint16
-		uuidLen    byte
-		uuid       []byte
-	}{
-		connection,
-		start,
-		end,
-		byte(len(uuid)),
-		uuid,
-	}
-	buf := new(bytes.Buffer)
-	binary.Write(buf, binary.LittleEndian, data)
-	return api.send(4, 1, buf.Bytes(), func(buf *bytes.Buffer) {})
+	enc := new(encoder).write(connection).write(start).write(end).uint8array(uuid)
+	return api.send(4, 1, enc.bytes(), func(buf *bytes.Buffer) {})
 }
 
 // AttclientReadByType read by group type
 func (api *API) AttclientReadByType(connection byte, start uint16, end uint16, uuid []byte) error {
-	data := struct {
-		connection byte
-		start      uint16
-		end        uint16
-		uuidLen    byte
-		uuid       []byte
-	}{
-		connection,
-		start,
-		end,
-		byte(len(uuid)),
-		uuid,
-	}
-	buf := new(bytes.Buffer)
-	binary.Write(buf, binary.LittleEndian, data)
-	return api.send(4, 2, buf.Bytes(), func(buf *bytes.Buffer) {})
+	enc := new(encoder).write(connection).write(start).write(end).uint8array(uuid)
+	return api.send(4, 2, enc.bytes(), func(buf *bytes.Buffer) {})
 }
 
 // AttclientFindInformation find information
@@ -715,38 +1078,24 @@ func (api *API) AttclientReadByHandle(connection byte, handle uint16) error {
 
 // AttclientAttributeWrite write to an attribute
 func (api *API) AttclientAttributeWrite(connection byte, handle uint16, data []uint8) error {
-	toSend := struct {
-		connection byte
-		handle     uint16
-		dataLen    byte
-		data       []byte
-	}{
-		connection,
-		handle,
-		byte(len(data)),
-		data,
-	}
-	buf := new(bytes.Buffer)
-	binary.Write(buf, binary.LittleEndian, toSend)
-	return api.send(4, 5, buf.Bytes(), func(buf *bytes.Buffer) {})
+	enc := new(encoder).write(connection).write(handle).uint8array(data)
+	return api.send(4, 5, enc.bytes(), func(buf *bytes.Buffer) {})
 }
 
-// AttclientWriteCommand write command data
-func (api *API) AttclientWriteCommand(connection byte, handle uint16, data []uint8) error {
-	toSend := struct {
-		connection byte
-		handle     uint16
-		dataLen    byte
-		data       []byte
-	}{
-		connection,
-		handle,
-		byte(len(data)),
-		data,
+// AttclientWriteCommand write command data, the completion receives the
+// result code which is non-zero when the module could not queue the data.
+// Write commands cannot be split, data longer than MaxAttributePayload
+// fails with a PayloadSizeError.
+func (api *API) AttclientWriteCommand(connection byte, handle uint16, data []uint8, completion func(uint16)) error {
+	if err := api.PayloadLimits().CheckAttribute(data); err != nil {
+		return err
 	}
-	buf := new(bytes.Buffer)
-	binary.Write(buf, binary.LittleEndian, toSend)
-	return api.send(4, 6, buf.Bytes(), func(buf *bytes.Buffer) {})
+	enc := new(encoder).write(connection).write(handle).uint8array(data)
+	return api.send(4, 6, enc.bytes(), func(buf *bytes.Buffer) {
+		d := newDecoder(buf)
+		d.u8()
+		completion(d.u16())
+	})
 }
 
 // AttrclientIndicateConfirm confirm indication
@@ -770,27 +1119,13 @@ func (api *API) AttclientReadLong(connection byte, handle uint16) error {
 
 // AttclientPrepareWrite prepare to write
 func (api *API) AttclientPrepareWrite(connection byte, handle uint16, offset uint16, data []byte) error {
-	toSend := struct {
-		connection byte
-		handle     uint16
-		offset     uint16
-		dataLen    byte
-		data       []byte
-	}{
-		connection,
-		handle,
-		offset,
-		byte(len(data)),
-		data,
-	}
-	buf := new(bytes.Buffer)
-	binary.Write(buf, binary.LittleEndian, toSend)
-	return api.send(4, 9, buf.Bytes(), func(buf *bytes.Buffer) {})
+	enc := new(encoder).write(connection).write(handle).write(offset).uint8array(data)
+	return api.send(4, 9, enc.bytes(), func(buf *bytes.Buffer) {})
 }
 
 // AttrclientExecuteWrite execute write
 func (api *API) AttrclientExecuteWrite(connection byte, commit byte) error {
-	return api.send(4, 10, []byte{commit}, func(buf *bytes.Buffer) {})
+	return api.send(4, 10, []byte{connection, commit}, func(buf *bytes.Buffer) {})
 }
 
 // AttrclientReadMultiple read multiple handles (FIXME should it be uint16)
@@ -802,9 +1137,14 @@ func (api *API) AttrclientReadMultiple(connection byte, handles []byte) error {
 	return api.send(4, 11, buf.Bytes(), func(buf *bytes.Buffer) {})
 }
 
-// SmEncryptStart start encryption
-func (api *API) SmEncryptStart(handle byte, bonding byte) error {
-	return api.send(5, 0, []byte{handle, bonding}, func(buf *bytes.Buffer) {})
+// SmEncryptStart start encryption, bonding when bonding is 1; the
+// completion receives the result code
+func (api *API) SmEncryptStart(handle byte, bonding byte, completion func(uint16)) error {
+	return api.send(5, 0, []byte{handle, bonding}, func(buf *bytes.Buffer) {
+		d := newDecoder(buf)
+		d.u8() // connection handle
+		completion(d.u16())
+	})
 }
 
 // SmSetBondableMode set bondable mode
@@ -825,6 +1165,7 @@ func (api *API) SmSetParameters(mitm byte, minKeySize byte, ioCapabilities byte)
 // SmPasskeyEntry set security passkey
 func (api *API) SmPasskeyEntry(handle byte, passkey uint32) error {
 	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, handle)
 	binary.Write(buf, binary.LittleEndian, passkey)
 	return api.send(5, 4, buf.Bytes(), func(buf *bytes.Buffer) {})
 }
@@ -845,9 +1186,15 @@ func (api *API) GapSetPrivacyFlags(periphPrivacy byte, centralPrivacy byte) erro
 	return api.send(6, 0, []byte{periphPrivacy, centralPrivacy}, func(buf *bytes.Buffer) {})
 }
 
-// GapSetMode set GAP mode
-func (api *API) GapSetMode(discover byte, connect byte) error {
-	return api.send(6, 1, []byte{discover, connect}, func(buf *bytes.Buffer) {})
+// GapSetMode set GAP mode, the mode is tracked by CurrentGapMode once the
+// module accepts it
+func (api *API) GapSetMode(discover GapDiscoverableMode, connect GapConnectableMode) error {
+	return api.send(6, 1, []byte{byte(discover), byte(connect)}, func(buf *bytes.Buffer) {
+		d := newDecoder(buf)
+		if result := d.u16(); d.err == nil && result == 0 {
+			api.setGapMode(GapMode{Discoverable: discover, Connectable: connect})
+		}
+	})
 }
 
 // GapDiscover set GAP discovery mode
@@ -857,6 +1204,10 @@ func (api *API) GapDiscover(mode byte) error {
 
 // GapConnectDirect set GAP connection parameters for directed discovery
 func (api *API) GapConnectDirect(mac QualifiedMac, params *ConnectionParameters) error {
+	if err := mac.Validate(); err != nil {
+		return err
+	}
+
 	buf := new(bytes.Buffer)
 	binary.Write(buf, binary.LittleEndian, mac.Address)
 	binary.Write(buf, binary.LittleEndian, mac.AddrType)
@@ -864,9 +1215,12 @@ func (api *API) GapConnectDirect(mac QualifiedMac, params *ConnectionParameters)
 	return api.send(6, 3, buf.Bytes(), func(buf *bytes.Buffer) {})
 }
 
-// GapEndProcedure end GAP procedure
-func (api *API) GapEndProcedure() error {
-	return api.send(6, 4, []byte{}, func(buf *bytes.Buffer) {})
+// GapEndProcedure end the current GAP procedure, the completion receives the
+// result code
+func (api *API) GapEndProcedure(completion func(uint16)) error {
+	return api.send(6, 4, []byte{}, func(buf *bytes.Buffer) {
+		completion(newDecoder(buf).u16())
+	})
 }
 
 // GapConnectSelective set GAP connetion paramters for selective discovery
@@ -876,8 +1230,9 @@ func (api *API) GapConnectSelective(params *ConnectionParameters) error {
 	return api.send(6, 5, buf.Bytes(), func(buf *bytes.Buffer) {})
 }
 
-// GapSetFiltering set GAP filtering policy
-func (api *API) GapSetFiltering(scanPolicy byte, advPolicy byte, scanDuplicateFiltering byte) error {
+// GapSetFilteringRaw set GAP filtering from raw policy bytes, see
+// GapSetFiltering
+func (api *API) GapSetFilteringRaw(scanPolicy byte, advPolicy byte, scanDuplicateFiltering byte) error {
 	return api.send(6, 6, []byte{scanPolicy, advPolicy, scanDuplicateFiltering}, func(buf *bytes.Buffer) {})
 }
 
@@ -899,8 +1254,12 @@ func (api *API) GapSetAdvParameters(intervalMin uint16, intervalMax uint16, chan
 	return api.send(6, 8, buf.Bytes(), func(buf *bytes.Buffer) {})
 }
 
-// GapSetAdvData set GAP advertisement data
+// GapSetAdvData set GAP advertisement data, failing with a
+// PayloadSizeError when it does not fit an advertisement
 func (api *API) GapSetAdvData(setScanResp byte, advData []byte) error {
+	if err := api.PayloadLimits().CheckAdv(advData); err != nil {
+		return err
+	}
 	data := append([]byte{setScanResp, byte(len(advData))}, advData...)
 	return api.send(6, 9, data, func(buf *bytes.Buffer) {})
 }
@@ -1164,235 +1523,355 @@ func (dgt *LoggingDelegate) OnHardwareAdcResult(input byte, value int16) {}
 // event parser
 //
 
-func (api *API) parseSystemEvent(cmdType byte, buf *bytes.Buffer) {
+func (api *API) parseSystemEvent(cmdType byte, d *decoder) {
 	switch cmdType {
 	case 0:
+		if d.buf.Len() == dfuBootLength {
+			// the bootloader reports a dfu_boot event in the same slot
+			version := d.u32()
+			select {
+			case api.dfuBootC <- version:
+			default:
+			}
+			return
+		}
+
 		var info SystemInfo
-		binary.Read(buf, binary.LittleEndian, &info)
-		api.delegate.OnSystemBoot(&info)
+		d.read(&info)
+		if d.err == nil {
+			api.setFirmware(&info)
+			api.setGapMode(GapMode{})
+			api.dropFingerprint()
+			api.dropCapabilities()
+			api.restartTimeSync()
+			api.restorePinIrqs()
+			api.setLicenseKeyMissing(false)
+			select {
+			case api.bootC <- &info:
+			default:
+			}
+			api.delegate.OnSystemBoot(&info)
+		}
 	case 1:
-		buf.ReadByte() // skip length
-		api.delegate.OnSystemDebug(buf.Bytes())
+		data := d.uint8array()
+		if d.err == nil {
+			if log := api.debugLogger(); log != nil {
+				log.write(data)
+			} else {
+				api.delegate.OnSystemDebug(data)
+			}
+		}
 	case 2:
-		endpoint, _ := buf.ReadByte()
-		data, _ := buf.ReadByte()
-		api.delegate.OnSystemEndpointWatermarkRx(endpoint, data)
+		endpoint := d.u8()
+		data := d.u8()
+		if d.err == nil {
+			api.endpointWatermark(endpoint, false, data)
+			api.delegate.OnSystemEndpointWatermarkRx(endpoint, data)
+		}
 	case 3:
-		endpoint, _ := buf.ReadByte()
-		data, _ := buf.ReadByte()
-		api.delegate.OnSystemEndpointWatermarkTx(endpoint, data)
+		endpoint := d.u8()
+		data := d.u8()
+		if d.err == nil {
+			api.endpointWatermark(endpoint, true, data)
+			api.delegate.OnSystemEndpointWatermarkTx(endpoint, data)
+		}
 	case 4:
-		var addr uint16
-		var value uint16
-		binary.Read(buf, binary.LittleEndian, &addr)
-		binary.Read(buf, binary.LittleEndian, &value)
-		api.delegate.OnSystemScriptFailure(addr, value)
+		addr := d.u16()
+		reason := d.u16()
+		if d.err == nil {
+			api.scriptFailed(addr, reason)
+		}
 	case 5:
+		api.setLicenseKeyMissing(true)
 		api.delegate.OnSystemNoLicenseKey()
+	case 6:
+		// the parser rejected a command, e.g. one the firmware lacks
+		reason := d.u16()
+		if d.err == nil {
+			api.historyError("protocol error: " + ResultName(reason))
+		}
 	}
 }
 
-func (api *API) parseFlashPsEvent(cmdType byte, buf *bytes.Buffer) {
+func (api *API) parseFlashPsEvent(cmdType byte, d *decoder) {
 	if cmdType != 0 {
 		return
 	}
 
-	var key uint16
-	binary.Read(buf, binary.LittleEndian, &key)
-	buf.ReadByte() // skip length
-	api.delegate.OnFlashPsKey(key, buf.Bytes())
+	key := d.u16()
+	value := d.uint8array()
+	if d.err == nil {
+		api.psKey(key, value)
+		api.delegate.OnFlashPsKey(key, value)
+	}
 }
 
-func (api *API) parseAttributeEvent(cmdType byte, buf *bytes.Buffer) {
+func (api *API) parseAttributeEvent(cmdType byte, d *decoder) {
 	switch cmdType {
 	case 0:
-		var connection, reason byte
-		var handle, offset uint16
-		binary.Read(buf, binary.LittleEndian, &connection)
-		binary.Read(buf, binary.LittleEndian, &reason)
-		binary.Read(buf, binary.LittleEndian, &handle)
-		binary.Read(buf, binary.LittleEndian, &offset)
-		buf.ReadByte() // skip length
-		api.delegate.OnAttributeValue(connection, reason, handle, offset, buf.Bytes())
+		connection := d.u8()
+		reason := d.u8()
+		handle := d.u16()
+		offset := d.u16()
+		value := d.uint8array()
+		if d.err == nil {
+			if reason == attributeChangeUserWrite {
+				api.userRequested(UserWrite, connection, handle)
+			}
+			api.delegate.OnAttributeValue(connection, reason, handle, offset, value)
+		}
 	case 1:
-		var connection, maxSize byte
-		var handle, offset uint16
-		binary.Read(buf, binary.LittleEndian, &connection)
-		binary.Read(buf, binary.LittleEndian, &handle)
-		binary.Read(buf, binary.LittleEndian, &offset)
-		binary.Read(buf, binary.LittleEndian, &maxSize)
-		api.delegate.OnAttributeUserReadRequest(connection, handle, offset, maxSize)
+		connection := d.u8()
+		handle := d.u16()
+		offset := d.u16()
+		maxSize := d.u8()
+		if d.err == nil {
+			api.userRequested(UserRead, connection, handle)
+			api.delegate.OnAttributeUserReadRequest(connection, handle, offset, maxSize)
+		}
 	case 2:
-		var handle uint16
-		var flags byte
-		api.delegate.OnAttributeStatus(handle, flags)
+		handle := d.u16()
+		flags := d.u8()
+		if d.err == nil {
+			api.delegate.OnAttributeStatus(handle, flags)
+		}
 	}
 }
 
-func (api *API) parseConnectionEvent(cmdType byte, buf *bytes.Buffer) {
+func (api *API) parseConnectionEvent(cmdType byte, d *decoder) {
 	switch cmdType {
 	case 0:
 		var status ConnectionStatus
-		binary.Read(buf, binary.LittleEndian, &status)
-		api.delegate.OnConnectionStatus(&status)
+		d.read(&status)
+		if d.err == nil {
+			api.mutex.Lock()
+			if status.Flags&ConnectionStatusFlagConnected != 0 {
+				api.connections[status.Connection] = status
+				if status.Flags&ConnectionStatusFlagCompleted != 0 {
+					api.resetLinkStats(status.Connection)
+				}
+			} else {
+				delete(api.connections, status.Connection)
+				delete(api.subscriptions, status.Connection)
+			}
+			api.mutex.Unlock()
+			api.delegate.OnConnectionStatus(&status)
+		}
 	case 1:
 		var ind ConnectionVersionIndication
-		binary.Read(buf, binary.LittleEndian, &ind)
-		api.delegate.OnConnectionVersionIndication(&ind)
+		d.read(&ind)
+		if d.err == nil {
+			api.delegate.OnConnectionVersionIndication(&ind)
+		}
 	case 2:
-		var connection, featureLen byte
-		binary.Read(buf, binary.LittleEndian, &connection)
-		binary.Read(buf, binary.LittleEndian, &featureLen)
-		api.delegate.OnConnectionFeatureIndication(connection, buf.Bytes()[:featureLen])
+		connection := d.u8()
+		features := d.uint8array()
+		if d.err == nil {
+			api.delegate.OnConnectionFeatureIndication(connection, features)
+		}
 	case 3:
-		var connection, dataLen byte
-		binary.Read(buf, binary.LittleEndian, &connection)
-		binary.Read(buf, binary.LittleEndian, &dataLen)
-		api.delegate.OnConnectionRawRx(connection, buf.Bytes()[:dataLen])
+		connection := d.u8()
+		data := d.uint8array()
+		if d.err == nil {
+			api.delegate.OnConnectionRawRx(connection, data)
+		}
 	case 4:
-		var connection byte
-		var reason uint16
-		api.delegate.OnConnectionDisconnected(connection, reason)
+		connection := d.u8()
+		reason := d.u16()
+		if d.err == nil {
+			api.mutex.Lock()
+			delete(api.connections, connection)
+			delete(api.rssi, connection)
+			delete(api.subscriptions, connection)
+			api.mutex.Unlock()
+			api.userAnswered(connection)
+			api.delegate.OnConnectionDisconnected(connection, reason)
+		}
 	}
 }
 
-func (api *API) parseAttrclientEvent(cmdType byte, buf *bytes.Buffer) {
+func (api *API) parseAttrclientEvent(cmdType byte, d *decoder) {
 	if cmdType > 6 {
 		return
 	}
 
-	var connection byte
-	binary.Read(buf, binary.LittleEndian, &connection)
+	connection := d.u8()
 
 	switch cmdType {
 	case 0:
-		var attrHandle uint16
-		binary.Read(buf, binary.LittleEndian, &attrHandle)
-		api.delegate.OnAttrclientIndicated(connection, attrHandle)
+		attrHandle := d.u16()
+		if d.err == nil {
+			api.delegate.OnAttrclientIndicated(connection, attrHandle)
+		}
 	case 1:
-		var result, chrHandle uint16
-		binary.Read(buf, binary.LittleEndian, &result)
-		binary.Read(buf, binary.LittleEndian, &chrHandle)
-		api.delegate.OnAttrclientProcedureCompleted(connection, result, chrHandle)
+		result := d.u16()
+		chrHandle := d.u16()
+		if d.err == nil {
+			api.delegate.OnAttrclientProcedureCompleted(connection, result, chrHandle)
+		}
 	case 2:
-		var start, end uint16
-		var uuidLen byte
-		binary.Read(buf, binary.LittleEndian, &start)
-		binary.Read(buf, binary.LittleEndian, &end)
-		binary.Read(buf, binary.LittleEndian, &uuidLen)
-		api.delegate.OnAttrclientGroupFound(connection, start, end, buf.Bytes()[:uuidLen])
+		start := d.u16()
+		end := d.u16()
+		uuid := d.uint8array()
+		if d.err == nil {
+			api.delegate.OnAttrclientGroupFound(connection, start, end, uuid)
+		}
 	case 3:
-		var chrdecl, value uint16
-		var properties, uuidLen byte
-		binary.Read(buf, binary.LittleEndian, &chrdecl)
-		binary.Read(buf, binary.LittleEndian, &value)
-		binary.Read(buf, binary.LittleEndian, &properties)
-		binary.Read(buf, binary.LittleEndian, &uuidLen)
-		api.delegate.OnAttrclientAttributeFound(connection, chrdecl, value, properties, buf.Bytes()[:uuidLen])
+		chrdecl := d.u16()
+		value := d.u16()
+		properties := d.u8()
+		uuid := d.uint8array()
+		if d.err == nil {
+			api.delegate.OnAttrclientAttributeFound(connection, chrdecl, value, properties, uuid)
+		}
 	case 4:
-		var chrHandle uint16
-		var uuidLen byte
-		binary.Read(buf, binary.LittleEndian, &chrHandle)
-		binary.Read(buf, binary.LittleEndian, &uuidLen)
-		api.delegate.OnAttrclientFindInformationFound(connection, chrHandle, buf.Bytes()[:uuidLen])
+		chrHandle := d.u16()
+		uuid := d.uint8array()
+		if d.err == nil {
+			api.delegate.OnAttrclientFindInformationFound(connection, chrHandle, uuid)
+		}
 	case 5:
-		var attHandle uint16
-		var valueType, valueLen byte
-		binary.Read(buf, binary.LittleEndian, &attHandle)
-		binary.Read(buf, binary.LittleEndian, &valueType)
-		binary.Read(buf, binary.LittleEndian, &valueLen)
-		api.delegate.OnAttrclientAttributeValue(connection, attHandle, valueType, buf.Bytes()[:valueLen])
+		attHandle := d.u16()
+		valueType := d.u8()
+		value := d.uint8array()
+		if d.err == nil {
+			api.delegate.OnAttrclientAttributeValue(connection, attHandle, valueType, value)
+		}
 	case 6:
-		var handlesLen byte
-		binary.Read(buf, binary.LittleEndian, &handlesLen)
-		api.delegate.OnAttrclientReadMultipleResponse(connection, buf.Bytes()[:handlesLen])
+		handles := d.uint8array()
+		if d.err == nil {
+			api.delegate.OnAttrclientReadMultipleResponse(connection, handles)
+		}
 	}
 }
 
-func (api *API) parseSmEvent(cmdType byte, buf *bytes.Buffer) {
+func (api *API) parseSmEvent(cmdType byte, d *decoder) {
 	if cmdType == 4 {
 		// special case where there is no handle in command
 		var status SmBondStatus
-		binary.Read(buf, binary.LittleEndian, &status)
-		api.delegate.OnSmBondStatus(&status)
+		d.read(&status)
+		if d.err == nil {
+			api.delegate.OnSmBondStatus(&status)
+		}
 		return
 	} else if cmdType > 4 {
 		return
 	}
 
-	var handle byte
-	binary.Read(buf, binary.LittleEndian, &handle)
+	handle := d.u8()
 
 	switch cmdType {
 	case 0:
-		packet, _ := buf.ReadByte()
-		dataLen, _ := buf.ReadByte()
-		api.delegate.OnSmSmpData(handle, packet, buf.Bytes()[:dataLen])
+		packet := d.u8()
+		data := d.uint8array()
+		if d.err == nil {
+			api.delegate.OnSmSmpData(handle, packet, data)
+		}
 	case 1:
-		var result uint16
-		binary.Read(buf, binary.LittleEndian, &result)
-		api.delegate.OnSmBondingFail(handle, result)
+		result := d.u16()
+		if d.err == nil {
+			api.delegate.OnSmBondingFail(handle, result)
+		}
 	case 2:
-		var passkey uint32
-		binary.Read(buf, binary.LittleEndian, &passkey)
-		api.delegate.OnSmPasskeyDisplay(handle, passkey)
+		passkey := d.u32()
+		if d.err == nil {
+			api.delegate.OnSmPasskeyDisplay(handle, passkey)
+		}
 	case 3:
-		api.delegate.OnSmPasskeyRequest(handle)
+		if d.err == nil {
+			api.delegate.OnSmPasskeyRequest(handle)
+		}
 	}
 }
 
-func (api *API) parseGapEvent(cmdType byte, buf *bytes.Buffer) {
+func (api *API) parseGapEvent(cmdType byte, d *decoder) {
 	switch cmdType {
 	case 0:
-		var resp GapScanRespone
-		binary.Read(buf, binary.LittleEndian, &resp.RSSI)
-		binary.Read(buf, binary.LittleEndian, &resp.PacketType)
-		binary.Read(buf, binary.LittleEndian, &resp.Address)
-		binary.Read(buf, binary.LittleEndian, &resp.Bond)
-		resp.Data = buf.Bytes()
-		api.delegate.OnGapScanResponse(&resp)
+		// the busiest event while scanning, decoded into a reused value
+		resp := &api.scanResp
+		if api.copyPayloads {
+			resp = new(GapScanRespone)
+		}
+		resp.RSSI = d.i8()
+		resp.PacketType = d.u8()
+		resp.Address = d.qualifiedMac()
+		resp.Bond = d.u8()
+		resp.Data = d.uint8array()
+		if d.err == nil {
+			api.delegate.OnGapScanResponse(resp)
+		}
 	case 1:
-		var discover, connect byte
-		binary.Read(buf, binary.LittleEndian, &discover)
-		binary.Read(buf, binary.LittleEndian, &connect)
-		api.delegate.OnGapModeChanged(discover, connect)
+		discover := d.u8()
+		connect := d.u8()
+		if d.err == nil {
+			api.setGapMode(GapMode{Discoverable: GapDiscoverableMode(discover), Connectable: GapConnectableMode(connect)})
+			api.delegate.OnGapModeChanged(discover, connect)
+		}
 	}
 }
 
-func (api *API) parseHardwareEvent(cmdType byte, buf *bytes.Buffer) {
+func (api *API) parseHardwareEvent(cmdType byte, d *decoder) {
 	switch cmdType {
 	case 0:
-		var status IoPortStatus
-		binary.Read(buf, binary.LittleEndian, &status)
-		api.delegate.OnHardwareIoPortStatus(&status)
+		status := IoPortStatus{Timestamp: d.u32(), Port: d.u8(), Irq: d.u8(), State: d.u8()}
+		if d.err == nil {
+			if s := api.currentTimeSync(); s != nil {
+				status.Time = s.observe(status.Timestamp, api.clock.Now())
+			}
+			api.pinInterrupts(&status)
+			api.delegate.OnHardwareIoPortStatus(&status)
+		}
 	case 1:
-		var handle byte
-		binary.Read(buf, binary.LittleEndian, &handle)
-		api.delegate.OnHardwareSoftTimer(handle)
+		handle := d.u8()
+		if d.err == nil {
+			api.softTimer(handle)
+			api.timeSyncExpired(handle)
+			api.delegate.OnHardwareSoftTimer(handle)
+		}
 	case 2:
-		var input byte
-		var value int16
-		api.delegate.OnHardwareAdcResult(input, value)
+		input := d.u8()
+		value := d.i16()
+		if d.err == nil {
+			api.delegate.OnHardwareAdcResult(input, value)
+		}
+	case 3:
+		api.analogComparatorStatus(d)
 	}
 }
 
 func (api *API) parseEvent(hdr *bgFrameHeader, buf *bytes.Buffer) {
+	if handler := api.eventHandler(hdr.packetClass, hdr.packetCommand); handler != nil {
+		handler(buf.Bytes())
+		return
+	}
+
+	d := &api.rxDecoder
+	*d = decoder{buf: buf}
+	defer func() {
+		if d.err != nil {
+			api.mutex.Lock()
+			api.stats.MalformedEvents++
+			api.mutex.Unlock()
+			api.historyError(fmt.Sprintf("malformed %s: %v", EventName(hdr.packetClass, hdr.packetCommand), d.err))
+		}
+	}()
+
 	switch hdr.packetClass {
 	case 0:
-		api.parseSystemEvent(hdr.packetCommand, buf)
+		api.parseSystemEvent(hdr.packetCommand, d)
 	case 1:
-		api.parseFlashPsEvent(hdr.packetCommand, buf)
+		api.parseFlashPsEvent(hdr.packetCommand, d)
 	case 2:
-		api.parseAttributeEvent(hdr.packetCommand, buf)
+		api.parseAttributeEvent(hdr.packetCommand, d)
 	case 3:
-		api.parseConnectionEvent(hdr.packetCommand, buf)
+		api.parseConnectionEvent(hdr.packetCommand, d)
 	case 4:
-		api.parseAttrclientEvent(hdr.packetCommand, buf)
+		api.parseAttrclientEvent(hdr.packetCommand, d)
 	case 5:
-		api.parseSmEvent(hdr.packetCommand, buf)
+		api.parseSmEvent(hdr.packetCommand, d)
 	case 6:
-		api.parseGapEvent(hdr.packetCommand, buf)
+		api.parseGapEvent(hdr.packetCommand, d)
 	case 7:
-		api.parseHardwareEvent(hdr.packetCommand, buf)
+		api.parseHardwareEvent(hdr.packetCommand, d)
 	}
 }
@@ -0,0 +1,136 @@
+package bgapitest
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	bgapi "github.com/jsakwa/go_bgapi"
+)
+
+// Clock a fake bgapi.Clock whose time only moves when advanced, firing the
+// timers that fall due at once; hand it to the API through
+// TransportOptions.Clock to run timeouts and retry delays deterministically
+// and without waiting
+type Clock struct {
+	mutex   sync.Mutex
+	armed   *sync.Cond
+	now     time.Time
+	pending []*fakeTimer
+}
+
+// NewClock returns a clock set to now
+func NewClock(now time.Time) *Clock {
+	c := &Clock{now: now}
+	c.armed = sync.NewCond(&c.mutex)
+	return c
+}
+
+// Now implements bgapi.Clock
+func (c *Clock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.now
+}
+
+// After implements bgapi.Clock
+func (c *Clock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+// NewTimer implements bgapi.Clock
+func (c *Clock) NewTimer(d time.Duration) bgapi.Timer {
+	t := &fakeTimer{clock: c, c: make(chan time.Time, 1)}
+	t.Reset(d)
+	return t
+}
+
+// Advance move the time forward by d, firing the timers due in the order of
+// their deadlines
+func (c *Clock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.now = c.now.Add(d)
+	sort.SliceStable(c.pending, func(i, j int) bool { return c.pending[i].deadline.Before(c.pending[j].deadline) })
+	due := 0
+	for due < len(c.pending) && !c.pending[due].deadline.After(c.now) {
+		t := c.pending[due]
+		select {
+		case t.c <- t.deadline:
+		default:
+		}
+		due++
+	}
+	c.pending = c.pending[due:]
+}
+
+// Timers returns the number of timers armed and not fired or stopped yet.
+// Timers of After calls whose channel was abandoned stay armed until they
+// fire.
+func (c *Clock) Timers() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return len(c.pending)
+}
+
+// BlockUntil wait for n timers to be armed, e.g. for the code under test to
+// start waiting before advancing the clock past its timeout
+func (c *Clock) BlockUntil(n int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for len(c.pending) < n {
+		c.armed.Wait()
+	}
+}
+
+// remove disarm a timer, the mutex must be held
+func (c *Clock) remove(t *fakeTimer) bool {
+	for i, p := range c.pending {
+		if p == t {
+			c.pending = append(c.pending[:i], c.pending[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// fakeTimer a bgapi.Timer driven by a Clock
+type fakeTimer struct {
+	clock    *Clock
+	c        chan time.Time
+	deadline time.Time
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+
+	return t.clock.remove(t)
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	c := t.clock
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	active := c.remove(t)
+	t.deadline = c.now.Add(d)
+	if d <= 0 {
+		select {
+		case t.c <- t.deadline:
+		default:
+		}
+		return active
+	}
+	c.pending = append(c.pending, t)
+	c.armed.Broadcast()
+	return active
+}
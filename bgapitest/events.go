@@ -0,0 +1,147 @@
+package bgapitest
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	bgapi "github.com/jsakwa/go_bgapi"
+)
+
+// ReplayEvents deliver a scripted sequence of events to delegate, decoded
+// as the API decodes those of a module, to unit test a delegate without a
+// transport. The events are built with the functions of this file or given
+// as raw payloads; replay stops at the first that fails to inject.
+func ReplayEvents(delegate bgapi.Delegate, events []Event) error {
+	api := bgapi.NewAPI(delegate)
+	for _, e := range events {
+		if err := api.InjectEvent(e.Class, e.Event, e.Payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encode the fields of an event payload, least significant byte first
+func encode(fields ...interface{}) []byte {
+	var buf bytes.Buffer
+	for _, f := range fields {
+		binary.Write(&buf, binary.LittleEndian, f)
+	}
+	return buf.Bytes()
+}
+
+// array a uint8array field: its length then its bytes
+func array(data []byte) []byte {
+	return append([]byte{byte(len(data))}, data...)
+}
+
+// SystemBoot a system boot event
+func SystemBoot(info bgapi.SystemInfo) Event {
+	return Event{Class: 0, Event: 0, Payload: encode(info)}
+}
+
+// PSKey a flash ps_key event, as sent for each key by a PS dump
+func PSKey(key uint16, value []byte) Event {
+	return Event{Class: 1, Event: 0, Payload: encode(key, array(value))}
+}
+
+// LocalWrite an attributes value event: a peer wrote a local attribute
+func LocalWrite(connection byte, reason byte, handle uint16, offset uint16, value []byte) Event {
+	return Event{Class: 2, Event: 0, Payload: encode(connection, reason, handle, offset, array(value))}
+}
+
+// UserReadRequest an attributes user_read_request event
+func UserReadRequest(connection byte, handle uint16, offset uint16, maxSize byte) Event {
+	return Event{Class: 2, Event: 1, Payload: encode(connection, handle, offset, maxSize)}
+}
+
+// ConnectionStatus a connection status event
+func ConnectionStatus(status bgapi.ConnectionStatus) Event {
+	return Event{Class: 3, Event: 0, Payload: encode(status)}
+}
+
+// Connected the status event of a connection just established
+func Connected(connection byte, address bgapi.QualifiedMac) Event {
+	return ConnectionStatus(bgapi.ConnectionStatus{
+		Connection:   connection,
+		Flags:        bgapi.ConnectionStatusFlagConnected | bgapi.ConnectionStatusFlagCompleted,
+		Address:      address,
+		ConnInterval: 60,
+		Timeout:      100,
+		Bonding:      0xff,
+	})
+}
+
+// RawRx a connection raw_rx event
+func RawRx(connection byte, data []byte) Event {
+	return Event{Class: 3, Event: 3, Payload: encode(connection, array(data))}
+}
+
+// Disconnected a connection disconnected event
+func Disconnected(connection byte, reason uint16) Event {
+	return Event{Class: 3, Event: 4, Payload: encode(connection, reason)}
+}
+
+// ProcedureCompleted an attclient procedure_completed event
+func ProcedureCompleted(connection byte, result uint16, handle uint16) Event {
+	return Event{Class: 4, Event: 1, Payload: encode(connection, result, handle)}
+}
+
+// GroupFound an attclient group_found event, uuid least significant byte
+// first
+func GroupFound(connection byte, start uint16, end uint16, uuid []byte) Event {
+	return Event{Class: 4, Event: 2, Payload: encode(connection, start, end, array(uuid))}
+}
+
+// FindInformationFound an attclient find_information_found event
+func FindInformationFound(connection byte, handle uint16, uuid []byte) Event {
+	return Event{Class: 4, Event: 4, Payload: encode(connection, handle, array(uuid))}
+}
+
+// AttributeValue an attclient attribute_value event, valueType as numbered
+// by the protocol: 0 read, 1 notify, 2 indicate, 3 read by type, 4 read
+// blob, 5 indicate with confirmation requested
+func AttributeValue(connection byte, handle uint16, valueType byte, value []byte) Event {
+	return Event{Class: 4, Event: 5, Payload: encode(connection, handle, valueType, array(value))}
+}
+
+// BondingFail an sm bonding_fail event
+func BondingFail(connection byte, result uint16) Event {
+	return Event{Class: 5, Event: 1, Payload: encode(connection, result)}
+}
+
+// PasskeyDisplay an sm passkey_display event
+func PasskeyDisplay(connection byte, passkey uint32) Event {
+	return Event{Class: 5, Event: 2, Payload: encode(connection, passkey)}
+}
+
+// PasskeyRequest an sm passkey_request event
+func PasskeyRequest(connection byte) Event {
+	return Event{Class: 5, Event: 3, Payload: encode(connection)}
+}
+
+// BondStatus an sm bond_status event
+func BondStatus(status bgapi.SmBondStatus) Event {
+	return Event{Class: 5, Event: 4, Payload: encode(status)}
+}
+
+// ScanResponse a gap scan_response event
+func ScanResponse(resp *bgapi.GapScanRespone) Event {
+	payload := encode(resp.RSSI, resp.PacketType, resp.Address.Address, resp.Address.AddrType, resp.Bond, array(resp.Data))
+	return Event{Class: 6, Event: 0, Payload: payload}
+}
+
+// IoPortStatus a hardware io_port_status event, Time is not encoded
+func IoPortStatus(status bgapi.IoPortStatus) Event {
+	return Event{Class: 7, Event: 0, Payload: encode(status.Timestamp, status.Port, status.Irq, status.State)}
+}
+
+// SoftTimer a hardware soft_timer event
+func SoftTimer(handle byte) Event {
+	return Event{Class: 7, Event: 1, Payload: encode(handle)}
+}
+
+// ADCResult a hardware adc_result event
+func ADCResult(input byte, value int16) Event {
+	return Event{Class: 7, Event: 2, Payload: encode(input, value)}
+}
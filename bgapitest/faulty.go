@@ -0,0 +1,233 @@
+package bgapitest
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"syscall"
+	"time"
+
+	bgapi "github.com/jsakwa/go_bgapi"
+)
+
+// ErrUnplugged returned by a FaultyTransport once disconnected, the API
+// deems it fatal as it does a vanished USB device
+var ErrUnplugged = fmt.Errorf("bgapitest: device unplugged: %w", syscall.EIO)
+
+// ErrInjectedRead a transient read error injected by a FaultyTransport
+var ErrInjectedRead = errors.New("bgapitest: injected read error")
+
+// Faults the probabilities, between 0 and 1, of the faults a
+// FaultyTransport injects into each frame it reads
+type Faults struct {
+	// Corrupt flip a bit of a frame, header included
+	Corrupt float64
+	// Drop lose a response, the command times out
+	Drop float64
+	// Delay hold a response, and the frames after it, for DelayBy
+	Delay   float64
+	DelayBy time.Duration
+	// ReadError fail the read with ErrInjectedRead, the frame is delivered
+	// by the next read
+	ReadError float64
+	// Disconnect unplug the device, see FaultyTransport.Disconnect
+	Disconnect float64
+}
+
+// FaultStats the faults a FaultyTransport injected
+type FaultStats struct {
+	Frames     int
+	Corrupted  int
+	Dropped    int
+	Delayed    int
+	ReadErrors int
+	// Unplugged the transport was disconnected
+	Unplugged bool
+}
+
+// FaultyTransport wraps a transport, usually a Module but possibly a real
+// device, and injects the faults seen with flaky USB and serial links into
+// the frames read from it, to check that an application recovers from
+// them. Faults are drawn from a seeded source so a failing run can be
+// replayed. Packet mode is not supported.
+type FaultyTransport struct {
+	// Clock times the delays, the system clock when nil; set before the
+	// first read
+	Clock bgapi.Clock
+
+	t bgapi.Transport
+
+	mutex     sync.Mutex
+	rand      *rand.Rand
+	faults    Faults
+	stats     FaultStats
+	in        []byte // received, not yet a complete frame
+	out       []byte // frames ready to be read
+	held      []byte // frame of a failed read
+	unplugged bool
+}
+
+// NewFaultyTransport wrap t, injecting no fault until SetFaults is called
+func NewFaultyTransport(t bgapi.Transport, seed int64) *FaultyTransport {
+	return &FaultyTransport{t: t, rand: rand.New(rand.NewSource(seed))}
+}
+
+// SetFaults change the faults injected from the next frame on, e.g. once
+// the application is up
+func (f *FaultyTransport) SetFaults(faults Faults) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.faults = faults
+}
+
+// Stats returns the faults injected so far
+func (f *FaultyTransport) Stats() FaultStats {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.stats
+}
+
+// Disconnect unplug the device: the wrapped transport is closed and reads
+// and writes fail with ErrUnplugged from now on. The API shuts down, or
+// obtains a new transport through TransportOptions.Reopen.
+func (f *FaultyTransport) Disconnect() {
+	f.mutex.Lock()
+	f.unplug()
+	f.mutex.Unlock()
+
+	f.t.Close()
+}
+
+// unplug mark the transport unplugged, the mutex must be held
+func (f *FaultyTransport) unplug() {
+	f.unplugged = true
+	f.stats.Unplugged = true
+}
+
+// Read implements bgapi.Transport
+func (f *FaultyTransport) Read(p []byte) (int, error) {
+	for {
+		f.mutex.Lock()
+		if f.unplugged {
+			f.mutex.Unlock()
+			return 0, ErrUnplugged
+		}
+		if len(f.out) > 0 {
+			n := copy(p, f.out)
+			f.out = f.out[n:]
+			f.mutex.Unlock()
+			return n, nil
+		}
+		if len(f.held) > 0 {
+			f.out, f.held = f.held, nil
+			f.mutex.Unlock()
+			continue
+		}
+		f.mutex.Unlock()
+
+		buf := make([]byte, len(p))
+		n, err := f.t.Read(buf)
+		f.mutex.Lock()
+		if f.unplugged {
+			f.mutex.Unlock()
+			return 0, ErrUnplugged
+		}
+		if err != nil {
+			f.mutex.Unlock()
+			return 0, err
+		}
+		f.in = append(f.in, buf[:n]...)
+		delay, readErr := f.frames()
+		unplugged := f.unplugged
+		f.mutex.Unlock()
+
+		if unplugged {
+			f.t.Close()
+			return 0, ErrUnplugged
+		}
+		if delay > 0 {
+			f.sleep(delay)
+		}
+		if readErr {
+			return 0, ErrInjectedRead
+		}
+	}
+}
+
+// frames move the complete frames received to out, injecting faults; returns
+// how long to hold them and whether to fail the read. The mutex must be held.
+func (f *FaultyTransport) frames() (time.Duration, bool) {
+	var delay time.Duration
+	readErr := false
+	for len(f.in) >= 4 {
+		length := int(f.in[0]&0x07)<<8 | int(f.in[1])
+		if len(f.in) < 4+length {
+			break
+		}
+		frame := append([]byte(nil), f.in[:4+length]...)
+		f.in = f.in[4+length:]
+		f.stats.Frames++
+		response := frame[0]&0x80 == 0
+
+		if f.hit(f.faults.Disconnect) {
+			f.unplug()
+			return 0, false
+		}
+		if response && f.hit(f.faults.Drop) {
+			f.stats.Dropped++
+			continue
+		}
+		if f.hit(f.faults.Corrupt) {
+			frame[f.rand.Intn(len(frame))] ^= 1 << uint(f.rand.Intn(8))
+			f.stats.Corrupted++
+		}
+		if response && f.faults.DelayBy > 0 && f.hit(f.faults.Delay) {
+			delay += f.faults.DelayBy
+			f.stats.Delayed++
+		}
+		if !readErr && f.hit(f.faults.ReadError) {
+			readErr = true
+			f.stats.ReadErrors++
+		}
+		f.out = append(f.out, frame...)
+	}
+	if readErr {
+		f.held, f.out = f.out, nil
+	}
+	return delay, readErr
+}
+
+// hit draw a fault of probability p, the mutex must be held
+func (f *FaultyTransport) hit(p float64) bool {
+	return p > 0 && f.rand.Float64() < p
+}
+
+// sleep wait for d on the clock of the transport
+func (f *FaultyTransport) sleep(d time.Duration) {
+	clock := f.Clock
+	if clock == nil {
+		clock = bgapi.SystemClock
+	}
+	t := clock.NewTimer(d)
+	<-t.C()
+}
+
+// Write implements bgapi.Transport
+func (f *FaultyTransport) Write(p []byte) (int, error) {
+	f.mutex.Lock()
+	unplugged := f.unplugged
+	f.mutex.Unlock()
+
+	if unplugged {
+		return 0, ErrUnplugged
+	}
+	return f.t.Write(p)
+}
+
+// Close implements bgapi.Transport
+func (f *FaultyTransport) Close() error {
+	return f.t.Close()
+}
@@ -0,0 +1,179 @@
+// Package bgapitest provides an in-memory module, a recording delegate and
+// a fake clock to unit test code built on the bgapi package without
+// hardware and without sleeping, a transport injecting faults to chaos test
+// it, and builders of events to replay scripted sequences into a delegate.
+package bgapitest
+
+import (
+	"io"
+	"sync"
+)
+
+// Command a command the API sent to the module
+type Command struct {
+	Class, Command byte
+	Payload        []byte
+}
+
+// Event an event sent by the module
+type Event struct {
+	Class, Event byte
+	Payload      []byte
+}
+
+// CommandHandler answers a command with the payload of its response and the
+// events that follow it; a nil response leaves the command unanswered, e.g.
+// to exercise timeouts
+type CommandHandler func(payload []byte) (response []byte, events []Event)
+
+// defaultResponse the payload answering commands without handler, a
+// successful result as most responses start with one
+var defaultResponse = []byte{0, 0}
+
+// Module an in-memory Transport emulating a module: it answers every command
+// the API sends and lets tests push events. Commands without a handler get
+// a response carrying only a successful result, except system_reset which
+// gets none; register the responses of commands returning more, and the
+// boot event following a reset, with Respond or Handle. Packet mode is not
+// supported.
+type Module struct {
+	mutex    sync.Mutex
+	readable *sync.Cond
+	rx       []byte
+	tx       []byte
+	closed   bool
+
+	handlers map[uint16]CommandHandler
+	commands []Command
+}
+
+// NewModule returns a module to hand to API.OpenTransport
+func NewModule() *Module {
+	m := &Module{handlers: make(map[uint16]CommandHandler)}
+	m.readable = sync.NewCond(&m.mutex)
+	return m
+}
+
+// Respond answer a command with a fixed response payload
+func (m *Module) Respond(class byte, cmd byte, response []byte) {
+	m.Handle(class, cmd, func([]byte) ([]byte, []Event) { return response, nil })
+}
+
+// Handle answer a command through a handler, nil restores the default
+// response
+func (m *Module) Handle(class byte, cmd byte, handler CommandHandler) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	key := uint16(class)<<8 | uint16(cmd)
+	if handler == nil {
+		delete(m.handlers, key)
+		return
+	}
+	m.handlers[key] = handler
+}
+
+// Event send an event to the API; use API.InjectEvent instead to wait for
+// the delegate to process it
+func (m *Module) Event(class byte, event byte, payload []byte) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.push(0x80, class, event, payload)
+}
+
+// Commands returns the commands received so far, in order
+func (m *Module) Commands() []Command {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return append([]Command(nil), m.commands...)
+}
+
+// Read implements Transport
+func (m *Module) Read(p []byte) (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for len(m.rx) == 0 && !m.closed {
+		m.readable.Wait()
+	}
+	if len(m.rx) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, m.rx)
+	m.rx = m.rx[n:]
+	return n, nil
+}
+
+// Write implements Transport, answering the commands once complete
+func (m *Module) Write(p []byte) (int, error) {
+	m.mutex.Lock()
+	if m.closed {
+		m.mutex.Unlock()
+		return 0, io.ErrClosedPipe
+	}
+	m.tx = append(m.tx, p...)
+	var commands []Command
+	for len(m.tx) >= 4 {
+		length := int(m.tx[0]&0x07)<<8 | int(m.tx[1])
+		if len(m.tx) < 4+length {
+			break
+		}
+		cmd := Command{Class: m.tx[2], Command: m.tx[3], Payload: append([]byte(nil), m.tx[4:4+length]...)}
+		m.tx = m.tx[4+length:]
+		m.commands = append(m.commands, cmd)
+		commands = append(commands, cmd)
+	}
+	m.mutex.Unlock()
+
+	for _, cmd := range commands {
+		m.answer(cmd)
+	}
+	return len(p), nil
+}
+
+// answer run the handler of a command and send its response and events
+func (m *Module) answer(cmd Command) {
+	m.mutex.Lock()
+	handler := m.handlers[uint16(cmd.Class)<<8|uint16(cmd.Command)]
+	m.mutex.Unlock()
+
+	response, events := defaultResponse, []Event(nil)
+	if handler != nil {
+		response, events = handler(cmd.Payload)
+	} else if cmd.Class == 0 && cmd.Command == 0 {
+		// system_reset is never answered
+		response = nil
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if response != nil {
+		m.push(0x00, cmd.Class, cmd.Command, response)
+	}
+	for _, e := range events {
+		m.push(0x80, e.Class, e.Event, e.Payload)
+	}
+}
+
+// push queue a frame for the API to read, the mutex must be held
+func (m *Module) push(messageType byte, class byte, id byte, payload []byte) {
+	if m.closed {
+		return
+	}
+	m.rx = append(m.rx, messageType|byte(len(payload)>>8)&0x07, byte(len(payload)), class, id)
+	m.rx = append(m.rx, payload...)
+	m.readable.Broadcast()
+}
+
+// Close implements Transport, pending reads return io.EOF
+func (m *Module) Close() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.closed = true
+	m.readable.Broadcast()
+	return nil
+}
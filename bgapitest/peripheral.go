@@ -0,0 +1,247 @@
+package bgapitest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync"
+
+	bgapi "github.com/jsakwa/go_bgapi"
+)
+
+// ATT errors as reported by the module
+const (
+	// attErrorInvalidHandle the peripheral holds no such handle
+	attErrorInvalidHandle = 0x0401
+	// attErrorNotFound no attribute matches, ending a search
+	attErrorNotFound = 0x040a
+)
+
+// attribute value types of the attclient attribute_value event, as sent by
+// the module rather than taken from the API under test
+const (
+	attValueTypeRead       = 0
+	attValueTypeNotify     = 1
+	attValueTypeReadByType = 3
+	attValueTypeReadBlob   = 4
+)
+
+// peripheralConnection the connection handle of the emulated peripheral
+const peripheralConnection byte = 0
+
+// Peripheral a connectable peripheral serving a GATT database, e.g. one
+// captured in the field with Connection.Snapshot and loaded with
+// LoadGattDatabase. It answers the connection, discovery, read and write
+// commands the module receives; values written are kept and notifications
+// are sent with Notify. One connection at a time is emulated.
+type Peripheral struct {
+	mutex      sync.Mutex
+	module     *Module
+	attributes []bgapi.GattAttribute
+	serviceEnd map[uint16]uint16 // last handle of the services, by handle
+}
+
+// NewPeripheral validate db and register the handlers serving it on the
+// module, replacing those of the commands involved
+func NewPeripheral(module *Module, db *bgapi.GattDatabase) (*Peripheral, error) {
+	if err := db.Validate(); err != nil {
+		return nil, err
+	}
+	p := &Peripheral{module: module, attributes: db.Attributes(), serviceEnd: make(map[uint16]uint16)}
+	for _, s := range db.Services {
+		p.serviceEnd[s.Handle] = s.End
+	}
+
+	module.Handle(6, 3, p.connect)
+	module.Handle(3, 0, p.disconnect)
+	module.Handle(4, 1, p.readByGroupType)
+	module.Handle(4, 2, p.readByType)
+	module.Handle(4, 3, p.findInformation)
+	module.Handle(4, 4, p.readByHandle)
+	module.Handle(4, 5, p.attributeWrite)
+	module.Handle(4, 6, p.writeCommand)
+	module.Handle(4, 8, p.readLong)
+	return p, nil
+}
+
+// Value returns the current value of an attribute, nil when the peripheral
+// has no such handle
+func (p *Peripheral) Value(handle uint16) []byte {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if at := p.attribute(handle); at != nil {
+		return append([]byte{}, at.Value...)
+	}
+	return nil
+}
+
+// Notify set the value of an attribute and notify it to the API
+func (p *Peripheral) Notify(handle uint16, value []byte) {
+	p.mutex.Lock()
+	if at := p.attribute(handle); at != nil {
+		at.Value = append([]byte(nil), value...)
+	}
+	p.mutex.Unlock()
+
+	p.module.Event(4, 5, AttributeValue(peripheralConnection, handle, attValueTypeNotify, value).Payload)
+}
+
+// attribute returns the attribute with handle, the mutex must be held
+func (p *Peripheral) attribute(handle uint16) *bgapi.GattAttribute {
+	for i := range p.attributes {
+		if p.attributes[i].Handle == handle {
+			return &p.attributes[i]
+		}
+	}
+	return nil
+}
+
+// connect accept the connection at once, with the address and type asked
+func (p *Peripheral) connect(payload []byte) ([]byte, []Event) {
+	status := make([]byte, 0, 16)
+	status = append(status, peripheralConnection, 0x05)
+	status = append(status, payload[:7]...)
+	status = append(status, le16(60)...)
+	status = append(status, le16(100)...)
+	status = append(status, le16(0)...)
+	status = append(status, 0xff)
+	return []byte{0, 0, peripheralConnection}, []Event{{Class: 3, Event: 0, Payload: status}}
+}
+
+// disconnect report the connection closed by the local host
+func (p *Peripheral) disconnect(payload []byte) ([]byte, []Event) {
+	event := append([]byte{payload[0]}, le16(0x216)...)
+	return []byte{payload[0], 0, 0}, []Event{{Class: 3, Event: 4, Payload: event}}
+}
+
+// readByGroupType report the primary services
+func (p *Peripheral) readByGroupType(payload []byte) ([]byte, []Event) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	connection := payload[0]
+	start, end := binary.LittleEndian.Uint16(payload[1:]), binary.LittleEndian.Uint16(payload[3:])
+	uuid := payload[6:]
+	var events []Event
+	for _, at := range p.attributes {
+		if at.Handle < start || at.Handle > end || !bytes.Equal(at.Type, uuid) {
+			continue
+		}
+		group := append([]byte{connection}, le16(at.Handle)...)
+		group = append(group, le16(p.serviceEnd[at.Handle])...)
+		group = append(group, byte(len(at.Value)))
+		events = append(events, Event{Class: 4, Event: 2, Payload: append(group, at.Value...)})
+	}
+	events = append(events, ProcedureCompleted(connection, 0, start))
+	return []byte{connection, 0, 0}, events
+}
+
+// readByType report the value of the attributes of a type
+func (p *Peripheral) readByType(payload []byte) ([]byte, []Event) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	connection := payload[0]
+	start, end := binary.LittleEndian.Uint16(payload[1:]), binary.LittleEndian.Uint16(payload[3:])
+	uuid := payload[6:]
+	var events []Event
+	for _, at := range p.attributes {
+		if at.Handle >= start && at.Handle <= end && bytes.Equal(at.Type, uuid) {
+			events = append(events, AttributeValue(connection, at.Handle, attValueTypeReadByType, at.Value))
+		}
+	}
+	// the search ends when no more attribute is found
+	events = append(events, ProcedureCompleted(connection, attErrorNotFound, start))
+	return []byte{connection, 0, 0}, events
+}
+
+// findInformation report the handle and type of the attributes in a range
+func (p *Peripheral) findInformation(payload []byte) ([]byte, []Event) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	connection := payload[0]
+	start, end := binary.LittleEndian.Uint16(payload[1:]), binary.LittleEndian.Uint16(payload[3:])
+	var events []Event
+	for _, at := range p.attributes {
+		if at.Handle >= start && at.Handle <= end {
+			info := append([]byte{connection}, le16(at.Handle)...)
+			info = append(info, byte(len(at.Type)))
+			events = append(events, Event{Class: 4, Event: 4, Payload: append(info, at.Type...)})
+		}
+	}
+	events = append(events, ProcedureCompleted(connection, 0, start))
+	return []byte{connection, 0, 0}, events
+}
+
+// readByHandle report the value of an attribute
+func (p *Peripheral) readByHandle(payload []byte) ([]byte, []Event) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	connection, handle := payload[0], binary.LittleEndian.Uint16(payload[1:])
+	at := p.attribute(handle)
+	if at == nil {
+		return []byte{connection, 0, 0}, []Event{ProcedureCompleted(connection, attErrorInvalidHandle, handle)}
+	}
+	return []byte{connection, 0, 0}, []Event{AttributeValue(connection, handle, attValueTypeRead, at.Value)}
+}
+
+// readLong report the value of an attribute in parts, as the module does
+// with the default ATT MTU
+func (p *Peripheral) readLong(payload []byte) ([]byte, []Event) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	connection, handle := payload[0], binary.LittleEndian.Uint16(payload[1:])
+	at := p.attribute(handle)
+	if at == nil {
+		return []byte{connection, 0, 0}, []Event{ProcedureCompleted(connection, attErrorInvalidHandle, handle)}
+	}
+	var events []Event
+	for offset := 0; offset < len(at.Value); offset += 22 {
+		part := at.Value[offset:]
+		if len(part) > 22 {
+			part = part[:22]
+		}
+		events = append(events, AttributeValue(connection, handle, attValueTypeReadBlob, part))
+	}
+	events = append(events, ProcedureCompleted(connection, 0, handle))
+	return []byte{connection, 0, 0}, events
+}
+
+// attributeWrite store a value written with a write request
+func (p *Peripheral) attributeWrite(payload []byte) ([]byte, []Event) {
+	connection, handle := payload[0], binary.LittleEndian.Uint16(payload[1:])
+	result := uint16(0)
+	if !p.write(handle, payload[4:]) {
+		result = attErrorInvalidHandle
+	}
+	return []byte{connection, 0, 0}, []Event{ProcedureCompleted(connection, result, handle)}
+}
+
+// writeCommand store a value written without response
+func (p *Peripheral) writeCommand(payload []byte) ([]byte, []Event) {
+	p.write(binary.LittleEndian.Uint16(payload[1:]), payload[4:])
+	return []byte{payload[0], 0, 0}, nil
+}
+
+// write set the value of an attribute, false when there is none
+func (p *Peripheral) write(handle uint16, value []byte) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	at := p.attribute(handle)
+	if at == nil {
+		return false
+	}
+	at.Value = append([]byte(nil), value...)
+	return true
+}
+
+// le16 encode a value least significant byte first
+func le16(v uint16) []byte {
+	b := make([]byte, 2)
+	binary.LittleEndian.PutUint16(b, v)
+	return b
+}
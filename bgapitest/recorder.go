@@ -0,0 +1,335 @@
+package bgapitest
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	bgapi "github.com/jsakwa/go_bgapi"
+)
+
+// Call a delegate method invocation, slices and structures are copied
+type Call struct {
+	Method string
+	Args   []interface{}
+}
+
+// String format the call as Method(arg, ...)
+func (c Call) String() string {
+	args := make([]string, len(c.Args))
+	for i, arg := range c.Args {
+		args[i] = fmt.Sprintf("%v", arg)
+	}
+	return c.Method + "(" + strings.Join(args, ", ") + ")"
+}
+
+// Recorder a Delegate recording its calls, so tests can assert the exact
+// sequence of events an API delivered. Combined with API.InjectEvent and
+// API.WaitIdle, assertions need no sleeps.
+type Recorder struct {
+	// Next invoked after every call is recorded, e.g. the delegate under
+	// test; may be nil
+	Next bgapi.Delegate
+
+	mutex sync.Mutex
+	calls []Call
+}
+
+// Calls returns the calls recorded so far, in order
+func (r *Recorder) Calls() []Call {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return append([]Call(nil), r.calls...)
+}
+
+// Methods returns the names of the methods called so far, in order
+func (r *Recorder) Methods() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	methods := make([]string, len(r.calls))
+	for i, c := range r.calls {
+		methods[i] = c.Method
+	}
+	return methods
+}
+
+// Reset forget the calls recorded so far
+func (r *Recorder) Reset() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.calls = nil
+}
+
+// record append a call
+func (r *Recorder) record(method string, args ...interface{}) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.calls = append(r.calls, Call{Method: method, Args: args})
+}
+
+// clone copy a slice the API may reuse once the delegate returns
+func clone(b []byte) []byte {
+	return append([]byte(nil), b...)
+}
+
+// OnSystemBoot implements Delegate
+func (r *Recorder) OnSystemBoot(info *bgapi.SystemInfo) {
+	r.record("OnSystemBoot", *info)
+	if r.Next != nil {
+		r.Next.OnSystemBoot(info)
+	}
+}
+
+// OnSystemDebug implements Delegate
+func (r *Recorder) OnSystemDebug(data []byte) {
+	r.record("OnSystemDebug", clone(data))
+	if r.Next != nil {
+		r.Next.OnSystemDebug(data)
+	}
+}
+
+// OnSystemEndpointWatermarkRx implements Delegate
+func (r *Recorder) OnSystemEndpointWatermarkRx(endpoint byte, data byte) {
+	r.record("OnSystemEndpointWatermarkRx", endpoint, data)
+	if r.Next != nil {
+		r.Next.OnSystemEndpointWatermarkRx(endpoint, data)
+	}
+}
+
+// OnSystemEndpointWatermarkTx implements Delegate
+func (r *Recorder) OnSystemEndpointWatermarkTx(endpoint byte, data byte) {
+	r.record("OnSystemEndpointWatermarkTx", endpoint, data)
+	if r.Next != nil {
+		r.Next.OnSystemEndpointWatermarkTx(endpoint, data)
+	}
+}
+
+// OnSystemScriptFailure implements Delegate
+func (r *Recorder) OnSystemScriptFailure(addr uint16, reason uint16) {
+	r.record("OnSystemScriptFailure", addr, reason)
+	if r.Next != nil {
+		r.Next.OnSystemScriptFailure(addr, reason)
+	}
+}
+
+// OnSystemNoLicenseKey implements Delegate
+func (r *Recorder) OnSystemNoLicenseKey() {
+	r.record("OnSystemNoLicenseKey")
+	if r.Next != nil {
+		r.Next.OnSystemNoLicenseKey()
+	}
+}
+
+// OnFlashPsKey implements Delegate
+func (r *Recorder) OnFlashPsKey(key uint16, value []byte) {
+	r.record("OnFlashPsKey", key, clone(value))
+	if r.Next != nil {
+		r.Next.OnFlashPsKey(key, value)
+	}
+}
+
+// OnAttributeValue implements Delegate
+func (r *Recorder) OnAttributeValue(connection byte, reason byte, handle uint16, offset uint16, value []byte) {
+	r.record("OnAttributeValue", connection, reason, handle, offset, clone(value))
+	if r.Next != nil {
+		r.Next.OnAttributeValue(connection, reason, handle, offset, value)
+	}
+}
+
+// OnAttributeUserReadRequest implements Delegate
+func (r *Recorder) OnAttributeUserReadRequest(connection byte, handle uint16, offset uint16, maxSize byte) {
+	r.record("OnAttributeUserReadRequest", connection, handle, offset, maxSize)
+	if r.Next != nil {
+		r.Next.OnAttributeUserReadRequest(connection, handle, offset, maxSize)
+	}
+}
+
+// OnAttributeStatus implements Delegate
+func (r *Recorder) OnAttributeStatus(handle uint16, flags byte) {
+	r.record("OnAttributeStatus", handle, flags)
+	if r.Next != nil {
+		r.Next.OnAttributeStatus(handle, flags)
+	}
+}
+
+// OnConnectionStatus implements Delegate
+func (r *Recorder) OnConnectionStatus(status *bgapi.ConnectionStatus) {
+	r.record("OnConnectionStatus", *status)
+	if r.Next != nil {
+		r.Next.OnConnectionStatus(status)
+	}
+}
+
+// OnConnectionVersionIndication implements Delegate
+func (r *Recorder) OnConnectionVersionIndication(ind *bgapi.ConnectionVersionIndication) {
+	r.record("OnConnectionVersionIndication", *ind)
+	if r.Next != nil {
+		r.Next.OnConnectionVersionIndication(ind)
+	}
+}
+
+// OnConnectionFeatureIndication implements Delegate
+func (r *Recorder) OnConnectionFeatureIndication(connection byte, features []byte) {
+	r.record("OnConnectionFeatureIndication", connection, clone(features))
+	if r.Next != nil {
+		r.Next.OnConnectionFeatureIndication(connection, features)
+	}
+}
+
+// OnConnectionRawRx implements Delegate
+func (r *Recorder) OnConnectionRawRx(connection byte, data []byte) {
+	r.record("OnConnectionRawRx", connection, clone(data))
+	if r.Next != nil {
+		r.Next.OnConnectionRawRx(connection, data)
+	}
+}
+
+// OnConnectionDisconnected implements Delegate
+func (r *Recorder) OnConnectionDisconnected(connection byte, reason uint16) {
+	r.record("OnConnectionDisconnected", connection, reason)
+	if r.Next != nil {
+		r.Next.OnConnectionDisconnected(connection, reason)
+	}
+}
+
+// OnAttrclientIndicated implements Delegate
+func (r *Recorder) OnAttrclientIndicated(connection byte, attrHandle uint16) {
+	r.record("OnAttrclientIndicated", connection, attrHandle)
+	if r.Next != nil {
+		r.Next.OnAttrclientIndicated(connection, attrHandle)
+	}
+}
+
+// OnAttrclientProcedureCompleted implements Delegate
+func (r *Recorder) OnAttrclientProcedureCompleted(connection byte, result uint16, chrHandle uint16) {
+	r.record("OnAttrclientProcedureCompleted", connection, result, chrHandle)
+	if r.Next != nil {
+		r.Next.OnAttrclientProcedureCompleted(connection, result, chrHandle)
+	}
+}
+
+// OnAttrclientGroupFound implements Delegate
+func (r *Recorder) OnAttrclientGroupFound(connection byte, start uint16, end uint16, uuid []byte) {
+	r.record("OnAttrclientGroupFound", connection, start, end, clone(uuid))
+	if r.Next != nil {
+		r.Next.OnAttrclientGroupFound(connection, start, end, uuid)
+	}
+}
+
+// OnAttrclientAttributeFound implements Delegate
+func (r *Recorder) OnAttrclientAttributeFound(connection byte, chrdecl uint16, value uint16, properties byte, uuid []byte) {
+	r.record("OnAttrclientAttributeFound", connection, chrdecl, value, properties, clone(uuid))
+	if r.Next != nil {
+		r.Next.OnAttrclientAttributeFound(connection, chrdecl, value, properties, uuid)
+	}
+}
+
+// OnAttrclientFindInformationFound implements Delegate
+func (r *Recorder) OnAttrclientFindInformationFound(connection byte, chrHandle uint16, uuid []byte) {
+	r.record("OnAttrclientFindInformationFound", connection, chrHandle, clone(uuid))
+	if r.Next != nil {
+		r.Next.OnAttrclientFindInformationFound(connection, chrHandle, uuid)
+	}
+}
+
+// OnAttrclientAttributeValue implements Delegate
+func (r *Recorder) OnAttrclientAttributeValue(connection byte, attHandle uint16, valueType byte, value []byte) {
+	r.record("OnAttrclientAttributeValue", connection, attHandle, valueType, clone(value))
+	if r.Next != nil {
+		r.Next.OnAttrclientAttributeValue(connection, attHandle, valueType, value)
+	}
+}
+
+// OnAttrclientReadMultipleResponse implements Delegate
+func (r *Recorder) OnAttrclientReadMultipleResponse(connection byte, handles []byte) {
+	r.record("OnAttrclientReadMultipleResponse", connection, clone(handles))
+	if r.Next != nil {
+		r.Next.OnAttrclientReadMultipleResponse(connection, handles)
+	}
+}
+
+// OnGapScanResponse implements Delegate
+func (r *Recorder) OnGapScanResponse(resp *bgapi.GapScanRespone) {
+	copied := *resp
+	copied.Data = clone(resp.Data)
+	r.record("OnGapScanResponse", copied)
+	if r.Next != nil {
+		r.Next.OnGapScanResponse(resp)
+	}
+}
+
+// OnGapModeChanged implements Delegate
+func (r *Recorder) OnGapModeChanged(discover byte, connect byte) {
+	r.record("OnGapModeChanged", discover, connect)
+	if r.Next != nil {
+		r.Next.OnGapModeChanged(discover, connect)
+	}
+}
+
+// OnSmSmpData implements Delegate
+func (r *Recorder) OnSmSmpData(handle byte, packet byte, data []byte) {
+	r.record("OnSmSmpData", handle, packet, clone(data))
+	if r.Next != nil {
+		r.Next.OnSmSmpData(handle, packet, data)
+	}
+}
+
+// OnSmBondingFail implements Delegate
+func (r *Recorder) OnSmBondingFail(handle byte, result uint16) {
+	r.record("OnSmBondingFail", handle, result)
+	if r.Next != nil {
+		r.Next.OnSmBondingFail(handle, result)
+	}
+}
+
+// OnSmPasskeyDisplay implements Delegate
+func (r *Recorder) OnSmPasskeyDisplay(handle byte, passkey uint32) {
+	r.record("OnSmPasskeyDisplay", handle, passkey)
+	if r.Next != nil {
+		r.Next.OnSmPasskeyDisplay(handle, passkey)
+	}
+}
+
+// OnSmPasskeyRequest implements Delegate
+func (r *Recorder) OnSmPasskeyRequest(handle byte) {
+	r.record("OnSmPasskeyRequest", handle)
+	if r.Next != nil {
+		r.Next.OnSmPasskeyRequest(handle)
+	}
+}
+
+// OnSmBondStatus implements Delegate
+func (r *Recorder) OnSmBondStatus(status *bgapi.SmBondStatus) {
+	r.record("OnSmBondStatus", *status)
+	if r.Next != nil {
+		r.Next.OnSmBondStatus(status)
+	}
+}
+
+// OnHardwareIoPortStatus implements Delegate
+func (r *Recorder) OnHardwareIoPortStatus(status *bgapi.IoPortStatus) {
+	r.record("OnHardwareIoPortStatus", *status)
+	if r.Next != nil {
+		r.Next.OnHardwareIoPortStatus(status)
+	}
+}
+
+// OnHardwareSoftTimer implements Delegate
+func (r *Recorder) OnHardwareSoftTimer(handle byte) {
+	r.record("OnHardwareSoftTimer", handle)
+	if r.Next != nil {
+		r.Next.OnHardwareSoftTimer(handle)
+	}
+}
+
+// OnHardwareAdcResult implements Delegate
+func (r *Recorder) OnHardwareAdcResult(input byte, value int16) {
+	r.record("OnHardwareAdcResult", input, value)
+	if r.Next != nil {
+		r.Next.OnHardwareAdcResult(input, value)
+	}
+}
@@ -0,0 +1,143 @@
+package bgapi
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Capability an optional command, named after it, that the module firmware
+// may not implement
+type Capability string
+
+// capabilities ProbeCapabilities tests for
+const (
+	CapEndpointRx          Capability = "system_endpoint_rx"
+	CapEndpointWatermarks  Capability = "system_endpoint_set_watermarks"
+	CapDirectedConnectable Capability = "gap_set_directed_connectable_mode"
+	CapTimerComparator     Capability = "hardware_timer_comparator"
+	CapUsbEnable           Capability = "hardware_usb_enable"
+)
+
+// result codes of commands the firmware lacks
+const (
+	resultNotImplemented = 0x0183
+	resultNotRecognized  = 0x0184
+)
+
+// capabilityProbe a command sent to test for a capability, with arguments
+// the firmware rejects or that change nothing
+type capabilityProbe struct {
+	capability Capability
+	class, cmd byte
+	payload    []byte
+}
+
+var capabilityProbes = []capabilityProbe{
+	// read nothing from the API endpoint
+	{CapEndpointRx, 0, 13, []byte{0, 0}},
+	// 0xff leaves the watermarks unchanged
+	{CapEndpointWatermarks, 0, 14, []byte{0, 0xff, 0xff}},
+	// an invalid address type, rejected before advertising starts
+	{CapDirectedConnectable, 6, 10, []byte{0, 0, 0, 0, 0, 0, 0xff}},
+	// an invalid timer
+	{CapTimerComparator, 7, 13, []byte{0xff, 0, 0, 0, 0}},
+	// enable USB, as it already is on the modules that have it
+	{CapUsbEnable, 7, 20, []byte{1}},
+}
+
+// ProbeCapabilities test the module for the optional commands by sending
+// them with harmless arguments: a command answered with any result other
+// than "feature not implemented" or "command not recognized" is supported,
+// one left unanswered is not. The result is recorded on the API, see
+// HasCapability, until the module boots again; probed capabilities take
+// precedence over the firmware version when gating commands.
+func (api *API) ProbeCapabilities() (map[Capability]bool, error) {
+	capabilities := make(map[Capability]bool, len(capabilityProbes))
+	for _, probe := range capabilityProbes {
+		supported, err := api.probe(probe)
+		if err != nil {
+			return nil, fmt.Errorf("probing %s: %w", probe.capability, err)
+		}
+		capabilities[probe.capability] = supported
+	}
+
+	api.mutex.Lock()
+	api.capabilities = capabilities
+	api.mutex.Unlock()
+
+	result := make(map[Capability]bool, len(capabilities))
+	for c, supported := range capabilities {
+		result[c] = supported
+	}
+	return result, nil
+}
+
+// probe send the command of a probe, bypassing the firmware version gate
+func (api *API) probe(probe capabilityProbe) (bool, error) {
+	type reply struct {
+		result uint16
+		err    error
+	}
+	replyC := make(chan reply, 1)
+	err := api.enqueue(&operation{
+		class:   probe.class,
+		cmd:     probe.cmd,
+		txData:  encodeCommand(probe.class, probe.cmd, probe.payload),
+		timeout: defaultTimeoutMs,
+		completion: func(buf *bytes.Buffer, err error) {
+			if err != nil {
+				replyC <- reply{err: err}
+				return
+			}
+			d := newDecoder(buf)
+			result := d.u16()
+			replyC <- reply{result: result, err: d.err}
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+
+	r := <-replyC
+	switch {
+	case r.err == errOperationTimedOut:
+		// the parser of older firmware drops unknown commands
+		return false, nil
+	case r.err != nil:
+		return false, r.err
+	}
+	return r.result != resultNotImplemented && r.result != resultNotRecognized, nil
+}
+
+// HasCapability returns whether the module supports c, probed is false
+// until ProbeCapabilities ran since the module booted
+func (api *API) HasCapability(c Capability) (supported bool, probed bool) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	if api.capabilities == nil {
+		return false, false
+	}
+	supported, probed = api.capabilities[c]
+	return supported, probed
+}
+
+// probedCapability returns whether the module supports a command, known
+// when the command is probed and ProbeCapabilities ran
+func (api *API) probedCapability(class byte, cmd byte) (supported bool, known bool) {
+	for _, probe := range capabilityProbes {
+		if probe.class == class && probe.cmd == cmd {
+			return api.HasCapability(probe.capability)
+		}
+	}
+	return false, false
+}
+
+// dropCapabilities forget the probed capabilities, the module booted
+// possibly with new firmware
+func (api *API) dropCapabilities() {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	api.capabilities = nil
+}
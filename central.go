@@ -5,7 +5,13 @@ package bgapi
 
 import (
 	"bytes"
+	"context"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -16,8 +22,39 @@ import (
 const (
 	gapFuncNone int = iota
 	gapFuncScanning
+	gapFuncConnecting
 )
 
+var gapFuncNames = []string{"none", "scanning", "connecting"}
+
+// gapWaitTimeout how long ConflictWait waits for the active GAP procedure
+const gapWaitTimeout = 10 * time.Second
+
+// ConflictPolicy what to do when a GAP procedure is requested while another
+// one is active; the module runs one at a time
+type ConflictPolicy int
+
+const (
+	// ConflictReject fail the request with a ConflictError
+	ConflictReject ConflictPolicy = iota
+	// ConflictWait wait for the active procedure to finish, failing with a
+	// ConflictError if it does not within 10 seconds
+	ConflictWait
+	// ConflictEndActive end the active scan (or advertising) to start the
+	// request, connection attempts are waited for
+	ConflictEndActive
+)
+
+// ConflictError a GAP procedure was refused because another one is active
+type ConflictError struct {
+	Requested string
+	Active    string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("cannot start %s while %s", e.Requested, e.Active)
+}
+
 const (
 	// GapDiscoverLimited limitted discovery mode
 	GapDiscoverLimited byte = iota
@@ -40,6 +77,24 @@ const (
 	ConnectionStatusFlagParametersChange = 8
 )
 
+// attribute value types, as reported by the attclient attribute_value
+// event
+const (
+	// AttValueTypeRead value read by handle
+	AttValueTypeRead byte = 0
+	// AttValueTypeNotify value notified by the peripheral
+	AttValueTypeNotify byte = 1
+	// AttValueTypeIndicate value indicated, confirmation already sent
+	AttValueTypeIndicate byte = 2
+	// AttValueTypeReadByType value read by type
+	AttValueTypeReadByType byte = 3
+	// AttValueTypeReadBlob part of a long read
+	AttValueTypeReadBlob byte = 4
+	// AttValueTypeIndicateRspReq value indicated, the indication must be
+	// confirmed with AttrclientIndicateConfirm
+	AttValueTypeIndicateRspReq byte = 5
+)
+
 /*
    dsef get_ad_type_string(self, type_ord):
        return {
@@ -92,6 +147,32 @@ var PrimaryServiceUUID = []byte{0x00, 0x28}
 // SecondaryServiceUUID used to lookup secondary service
 var SecondaryServiceUUID = []byte{0x01, 0x28}
 
+// ParseUUID parse a UUID in its usual textual form (e.g. "180d" or
+// "6e400001-b5a3-f393-e0a9-e50e24dcca9e") into the least significant byte
+// first form used by BGAPI
+func ParseUUID(s string) ([]byte, error) {
+	b, err := hex.DecodeString(strings.Replace(s, "-", "", -1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != 2 && len(b) != 4 && len(b) != 16 {
+		return nil, fmt.Errorf("invalid UUID length %d", len(b))
+	}
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return b, nil
+}
+
+// MustParseUUID like ParseUUID but panics on error
+func MustParseUUID(s string) []byte {
+	b, err := ParseUUID(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
 type apiDelegate struct {
 	central *Central
 }
@@ -103,7 +184,10 @@ type Central struct {
 	apiDelegate      *apiDelegate
 	api              *API
 	gapFunc          int
+	gapIdle          chan struct{} // closed when gapFunc is released
 	knownPeripherals map[string]*GapScanRespone
+	knownPayloads    map[string][]byte        // keyed by address and packet type, see payloadChanged
+	reportedScans    map[string]*reportedScan // keyed likewise, see reportScan
 
 	// ScanInterval time from window to window
 	ScanInterval uint16
@@ -111,9 +195,138 @@ type Central struct {
 	// ScanWindow time to allow devices to advertise
 	ScanWindow uint16
 
+	// OnScanResponse invoked for every scan response received while scanning
+	// that passes DuplicatePolicy
+	OnScanResponse func(resp *GapScanRespone)
+
+	// DuplicatePolicy the scan responses of an advertiser reported to
+	// OnScanResponse, every one by default; DuplicateInterval the period
+	// of DuplicatesInterval. Set them before scanning.
+	DuplicatePolicy   DuplicatePolicy
+	DuplicateInterval time.Duration
+
+	// AutoIndicateConfirm confirm indications once OnValueChanged returns
+	// (the default), clear it to confirm with Connection.ConfirmIndication
+	// after processing; the peer sends nothing more until confirmed
+	AutoIndicateConfirm bool
+
+	// ConflictPolicy how scanning and connecting requests conflicting with
+	// the active GAP procedure are handled, rejected by default
+	ConflictPolicy ConflictPolicy
+
+	// GattRetry how reads, writes and subscriptions failing with transient
+	// results are retried, not at all by default
+	GattRetry RetryPolicy
+
+	// AddressBook names peripherals for Connect and Resolve, may be nil
+	AddressBook *AddressBook
+
+	// Store keeps the bonds obtained by Pair, the GATT databases
+	// discovered by Open, the devices connected and the whitelist set with
+	// SetWhitelist across restarts, may be nil. Open skips the discovery of
+	// a database kept, forget it with DeleteGattDatabase once the
+	// peripheral changes it.
+	Store Store
+
+	// SecurityPolicy the security connections must reach when opened
+	// before values are delivered, nil to deliver them over any link
+	SecurityPolicy *SecurityPolicy
+
+	// Auditor receives the security events (pairing, encryption, bond
+	// deletion) for an audit trail, may be nil
+	Auditor SecurityAuditor
+
 	// existing connections
 	openConnections map[byte]*Connection
 	connections     map[string]*Connection
+
+	// application data attached to peripherals
+	contexts map[string]interface{}
+
+	// connection running Pair, if any
+	pairing *Connection
+
+	// handlers of the device events, see Watch
+	watchers    map[int]func(*DeviceEvent)
+	nextWatcher int
+
+	// consumers of the scan responses, see addScanHook
+	scanHooks    map[int]func(*GapScanRespone)
+	nextScanHook int
+
+	// guards the maps above, they are updated from the API's receive loop
+	mutex sync.Mutex
+}
+
+// NewCentral returns a new Central along with the API it drives
+func NewCentral() *Central {
+	c := &Central{
+		knownPeripherals:    make(map[string]*GapScanRespone),
+		ScanInterval:        75,
+		ScanWindow:          50,
+		AutoIndicateConfirm: true,
+		openConnections:     make(map[byte]*Connection),
+		connections:         make(map[string]*Connection),
+		contexts:            make(map[string]interface{}),
+	}
+	c.apiDelegate = &apiDelegate{central: c}
+	c.api = NewAPI(c.apiDelegate)
+	return c
+}
+
+// API returns the API driven by the central
+func (c *Central) API() *API {
+	return c.api
+}
+
+// KnownPeripheral returns the last scan response received from address
+func (c *Central) KnownPeripheral(address QualifiedMac) *GapScanRespone {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.knownPeripherals[address.Hashable()]
+}
+
+// addScanHook call hook with every scan response until the returned
+// function is called, alongside OnScanResponse. Hooks run on the API's
+// receive goroutine.
+func (c *Central) addScanHook(hook func(*GapScanRespone)) (remove func()) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.scanHooks == nil {
+		c.scanHooks = make(map[int]func(*GapScanRespone))
+	}
+	id := c.nextScanHook
+	c.nextScanHook++
+	c.scanHooks[id] = hook
+	return func() {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		delete(c.scanHooks, id)
+	}
+}
+
+// runScanHooks hand a scan response to the hooks
+func (c *Central) runScanHooks(resp *GapScanRespone) {
+	c.mutex.Lock()
+	hooks := make([]func(*GapScanRespone), 0, len(c.scanHooks))
+	for _, hook := range c.scanHooks {
+		hooks = append(hooks, hook)
+	}
+	c.mutex.Unlock()
+
+	for _, hook := range hooks {
+		hook(resp)
+	}
+}
+
+// connectionForHandle returns the open connection with the given handle
+func (c *Central) connectionForHandle(handle byte) *Connection {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.openConnections[handle]
 }
 
 // AdvertisementData parsed advertisement data
@@ -123,24 +336,61 @@ type AdvertisementData map[byte][]byte
 type ServiceUUIDs [][]byte
 
 func (c *Central) gapTake(gapFunc int) error {
-	var err error
-	if c.gapFunc == gapFuncNone {
-		c.gapFunc = gapFunc
-	} else {
-		err = errors.New("GAP service already in use by another function")
+	for {
+		c.mutex.Lock()
+		if c.gapFunc == gapFuncNone && (gapFunc != gapFuncScanning || c.api.CurrentGapMode() == GapMode{}) {
+			c.gapFunc = gapFunc
+			c.gapIdle = make(chan struct{})
+			if gapFunc == gapFuncScanning {
+				c.resetDuplicates()
+			}
+			c.mutex.Unlock()
+			return nil
+		}
+		active, idle := c.gapFunc, c.gapIdle
+		c.mutex.Unlock()
+
+		if active == gapFuncNone {
+			// the module is advertising, which forbids scanning
+			if c.ConflictPolicy != ConflictEndActive {
+				return &ConflictError{Requested: gapFuncNames[gapFunc], Active: "advertising"}
+			}
+			if err := c.api.GapSetMode(GapNonDiscoverable, GapNonConnectable); err != nil {
+				return err
+			}
+			c.api.setGapMode(GapMode{})
+			continue
+		}
+
+		conflict := &ConflictError{Requested: gapFuncNames[gapFunc], Active: gapFuncNames[active]}
+		switch {
+		case c.ConflictPolicy == ConflictEndActive && active == gapFuncScanning:
+			if err := c.StopScanning(); err != nil {
+				return err
+			}
+		case c.ConflictPolicy == ConflictWait || c.ConflictPolicy == ConflictEndActive:
+			// a connection attempt is never cancelled, wait for it instead
+			select {
+			case <-idle:
+			case <-c.api.clock.After(gapWaitTimeout):
+				return conflict
+			}
+		default:
+			return conflict
+		}
 	}
-	return err
 }
 
 func (c *Central) gapGive(gapFunc int) error {
-	var err error
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
 	if c.gapFunc != gapFunc {
-		err = errors.New("Attempt to release a GAP function not in use")
-	} else {
-		c.gapFunc = gapFuncNone
+		return errors.New("Attempt to release a GAP function not in use")
 	}
-
-	return err
+	c.gapFunc = gapFuncNone
+	close(c.gapIdle)
+	return nil
 }
 
 // StartScanning start the scanning process
@@ -156,12 +406,47 @@ func (c *Central) StartScanning(mode byte) error {
 func (c *Central) StopScanning() error {
 	var err error
 	if err = c.gapGive(gapFuncScanning); err == nil {
-		c.api.GapEndProcedure()
+		c.api.GapEndProcedure(func(uint16) {})
 	}
 
 	return err
 }
 
+// Scan scan until ctx is done, then end the procedure and wait for the
+// module to confirm it stopped
+func (c *Central) Scan(ctx context.Context, mode byte) error {
+	if err := c.StartScanning(mode); err != nil {
+		return err
+	}
+	<-ctx.Done()
+
+	if err := c.gapGive(gapFuncScanning); err != nil {
+		return err
+	}
+	if err := c.endProcedure(); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// endProcedure end the current GAP procedure and wait for the response
+func (c *Central) endProcedure() error {
+	resultC := make(chan uint16, 1)
+	if err := c.api.GapEndProcedure(func(result uint16) { resultC <- result }); err != nil {
+		return err
+	}
+
+	select {
+	case result := <-resultC:
+		if result != 0 {
+			return &ProcedureError{Result: result}
+		}
+		return nil
+	case <-c.api.clock.After(defaultTimeoutMs * time.Millisecond):
+		return errors.New("end procedure timed-out")
+	}
+}
+
 // ScanRequestEnable enable the transmission of ScanRequest packets
 func (c *Central) ScanRequestEnable() {
 	c.api.GapSetScanParameters(c.ScanInterval, c.ScanWindow, 1)
@@ -195,6 +480,7 @@ const (
 )
 
 const (
+	// procedureTimeout also designates that no procedure is pending
 	procedureTimeout int = iota
 	procedureConnect
 	procedureDisconnect
@@ -202,6 +488,10 @@ const (
 	procedureEncrypt
 	procedureGeneral
 	procedureReadAttribute
+	procedureFeatures
+	procedureVersion
+	procedureBond
+	procedureRefused
 )
 
 // ConnectionDelegate connection delegate to be implemented by client
@@ -212,13 +502,31 @@ type ConnectionDelegate interface {
 // Attribute represents GATT Characteristic Attribute
 type Attribute struct {
 	handle         uint16
+	uuid           []byte
 	value          []byte
 	parse          func(data []byte)
 	OnValueChanged func(data []byte)
 }
 
+// Handle returns the attribute handle
+func (at *Attribute) Handle() uint16 {
+	return at.handle
+}
+
+// UUID returns the attribute type (least significant byte first)
+func (at *Attribute) UUID() []byte {
+	return at.uuid
+}
+
+// Value returns the last value read or notified
+func (at *Attribute) Value() []byte {
+	return at.value
+}
+
 // update the attribute
 func (at *Attribute) update(value []byte) {
+	// the payload belongs to the API's receive buffer
+	value = append([]byte(nil), value...)
 	at.value = value
 
 	if at.parse != nil {
@@ -230,16 +538,63 @@ func (at *Attribute) update(value []byte) {
 	}
 }
 
+// Characteristic properties
+const (
+	CharPropBroadcast      = 0x01
+	CharPropRead           = 0x02
+	CharPropWriteNoResp    = 0x04
+	CharPropWrite          = 0x08
+	CharPropNotify         = 0x10
+	CharPropIndicate       = 0x20
+	CharPropAuthSignWrites = 0x40
+	CharPropExtended       = 0x80
+)
+
 // Characteristic represents a GATT Characteristic
 type Characteristic struct {
 	// FIXME we should probably also order these as a list
 	attribs    map[string]*Attribute
 	properties byte
+	declHandle uint16
+	uuid       []byte
+	value      *Attribute
+	service    *Service
+	conn       *Connection
+}
+
+// UUID returns the characteristic UUID (least significant byte first)
+func (c *Characteristic) UUID() []byte {
+	return c.uuid
+}
+
+// Properties returns the characteristic properties (CharProp flags)
+func (c *Characteristic) Properties() byte {
+	return c.properties
+}
+
+// Service returns the service the characteristic belongs to
+func (c *Characteristic) Service() *Service {
+	return c.service
+}
+
+// ValueAttribute returns the attribute holding the characteristic value
+func (c *Characteristic) ValueAttribute() *Attribute {
+	return c.value
+}
+
+// Descriptor returns the descriptor attribute with the given UUID or nil
+func (c *Characteristic) Descriptor(uuid []byte) *Attribute {
+	return c.attribs[string(uuid)]
 }
 
 // one UUID can have multiple handles,
 func (c *Characteristic) addDescriptor(uuid []byte, handle uint16, value []byte) *Attribute {
-	at := Attribute{handle: handle, value: value}
+	at := Attribute{handle: handle, uuid: append([]byte(nil), uuid...), value: value}
+	if c.value == nil && !bytes.Equal(uuid, CharacteristicUUID) {
+		// the value attribute immediately follows the declaration
+		c.uuid = append([]byte(nil), uuid...)
+		c.value = &at
+	}
 
 	// CharacteristicUUID the characteristic UUID
 	if bytes.Equal(uuid, CharacteristicUUID) {
@@ -265,47 +620,135 @@ func (c *Characteristic) parseCharacteristicAttribute(value []byte) {
 
 // Service GATTService
 type Service struct {
-	startHandle uint16
-	endHandle   uint16
-	uuid        []byte
+	startHandle     uint16
+	endHandle       uint16
+	uuid            []byte
+	characteristics []*Characteristic
+}
+
+// UUID returns the service UUID (least significant byte first)
+func (s *Service) UUID() []byte {
+	return s.uuid
+}
+
+// Characteristics returns the characteristics discovered in the service
+func (s *Service) Characteristics() []*Characteristic {
+	return s.characteristics
 }
 
 type procedureManager struct {
 	operC       chan int
 	procPending int
+	refused     uint16 // result of the procedure refused or failed, see refuse and fail
+	clock       Clock
+	mutex       sync.Mutex
+}
+
+func newProcedureManager(clock Clock) procedureManager {
+	return procedureManager{operC: make(chan int, 1), clock: clock}
 }
 
 // perform the procedure
 func (mgr *procedureManager) perform(timeoutMs time.Duration, proc int, procedure func()) error {
+	return mgr.performContext(context.Background(), timeoutMs, proc, procedure)
+}
 
+// performContext perform the procedure, giving up when ctx is done
+func (mgr *procedureManager) performContext(ctx context.Context, timeoutMs time.Duration, proc int, procedure func()) error {
+	mgr.mutex.Lock()
 	mgr.procPending = proc
-
-	// FIXME need a way to extend timeout
-	// start failsafe timer
-	go func() {
-		time.Sleep(timeoutMs * time.Millisecond)
-		mgr.operC <- procedureTimeout
-	}()
+	mgr.mutex.Unlock()
 
 	// perform operation
 	procedure()
 
 	// wait for result
-	result := <-mgr.operC
+	// FIXME need a way to extend timeout
+	var result int
+	select {
+	case result = <-mgr.operC:
+	case <-ctx.Done():
+		mgr.abandon()
+		return ctx.Err()
+	case <-mgr.clock.After(timeoutMs * time.Millisecond):
+		mgr.abandon()
+		result = procedureTimeout
+	}
 
 	// check to see if the operation completed successfully
 	var err error
 	if result == procedureTimeout {
-		err = errors.New("Connection.Open timed-out")
+		err = errors.New("Connection procedure timed-out")
+	} else if result == procedureDisconnect && proc != procedureDisconnect {
+		err = ErrConnectionLost
+	} else if result == procedureRefused {
+		mgr.mutex.Lock()
+		err = &ProcedureError{Result: mgr.refused}
+		mgr.mutex.Unlock()
 	} else if result != proc {
-		err = errors.New("Connection.Open handled wrong event type")
+		err = errors.New("Connection procedure handled wrong event type")
 	}
 	return err
 }
 
+// abandon stop waiting for the pending procedure
+func (mgr *procedureManager) abandon() {
+	mgr.mutex.Lock()
+	mgr.procPending = procedureTimeout
+	mgr.mutex.Unlock()
+
+	// drop a completion that raced the timer
+	select {
+	case <-mgr.operC:
+	default:
+	}
+}
+
+// disconnected fail the pending procedure, it cannot complete once the
+// link is lost
+func (mgr *procedureManager) disconnected() {
+	mgr.mutex.Lock()
+	defer mgr.mutex.Unlock()
+
+	if mgr.procPending != procedureTimeout {
+		mgr.procPending = procedureTimeout
+		mgr.operC <- procedureDisconnect
+	}
+}
+
+// refuse fail the pending procedure, the module refused the command
+// starting it with result
+func (mgr *procedureManager) refuse(result uint16) {
+	mgr.mutex.Lock()
+	defer mgr.mutex.Unlock()
+
+	if mgr.procPending != procedureTimeout {
+		mgr.procPending = procedureTimeout
+		mgr.refused = result
+		mgr.operC <- procedureRefused
+	}
+}
+
+// fail fail the pending procedure when it is proc, the peripheral
+// answered it with result
+func (mgr *procedureManager) fail(proc int, result uint16) {
+	mgr.mutex.Lock()
+	defer mgr.mutex.Unlock()
+
+	if mgr.procPending == proc {
+		mgr.procPending = procedureTimeout
+		mgr.refused = result
+		mgr.operC <- procedureRefused
+	}
+}
+
 // complete notify that the procedure completed
 func (mgr *procedureManager) complete(proc int) {
+	mgr.mutex.Lock()
+	defer mgr.mutex.Unlock()
+
 	if mgr.procPending == proc {
+		mgr.procPending = procedureTimeout
 		mgr.operC <- proc
 	}
 }
@@ -321,11 +764,80 @@ type Connection struct {
 	characteristics map[uint16]*Characteristic
 	attribs         map[uint16]*Attribute // find descriptor by handle
 	charByUUID      map[string]*Characteristic
+	curService      *Service        // service being discovered
 	curChar         *Characteristic // charicteristc being discovered
 	procMgr         procedureManager
+	procResult      uint16 // result of the last completed GATT procedure
+	pairingUI       PairingUI
+	pairingErr      error
+	bond            *BondInfo
+	progress        ProgressReporter
+	features        LEFeatures
+	version         *ConnectionVersionIndication
+	dataChannel     *DataChannel
+	rawReader       *rawReader // guarded by the central's mutex
+	context         interface{}
+	reading         uint16                  // handle being read by Read or ReadDescriptor
+	longRead        *Attribute              // attribute being read by ReadLong
+	longValue       []byte                  // value accumulated by ReadLong
+	subscriptions   map[string]func([]byte) // value handlers of the subscribed characteristics, by UUID
+	lazyDiscovery   bool                    // Open leaves discovery to a Discoverer
+	serviceFound    func(*Service)          // streams the services to a Discoverer
+	security        *SecurityPolicy         // policy applied when opened, guarded by the central's mutex
+	secured         bool                    // the link satisfies security, guarded by the central's mutex
+	pastStats       ConnectionStats         // counters of the links closed, guarded by the central's mutex
+	opens           uint64                  // times opened, guarded by the central's mutex
 	state           int
 }
 
+// ErrConnectionLost the link dropped while a procedure was pending
+var ErrConnectionLost = errors.New("connection lost")
+
+// ProcedureError a GATT or connection procedure completed with a non-zero result
+type ProcedureError struct {
+	Result uint16
+}
+
+func (e *ProcedureError) Error() string {
+	return fmt.Sprintf("procedure failed with result 0x%04x", e.Result)
+}
+
+// performGatt perform a GATT procedure and check its result
+func (c *Connection) performGatt(timeoutMs time.Duration, procedure func()) error {
+	err := c.procMgr.perform(timeoutMs, procedureGeneral, procedure)
+	if err == nil && c.procResult != 0 {
+		err = &ProcedureError{Result: c.procResult}
+	}
+	return err
+}
+
+// Address returns the address of the peripheral
+func (c *Connection) Address() QualifiedMac {
+	return c.resp.Address
+}
+
+// SetDelegate set the delegate notified of connection events
+func (c *Connection) SetDelegate(delegate ConnectionDelegate) {
+	c.delegate = delegate
+}
+
+// SetProgress set the reporter notified as discovery and long reads advance
+func (c *Connection) SetProgress(progress ProgressReporter) {
+	c.progress = progress
+}
+
+// Services returns the discovered services ordered by handle
+func (c *Connection) Services() []*Service {
+	services := make([]*Service, 0, len(c.services))
+	for _, s := range c.services {
+		services = append(services, s)
+	}
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].startHandle < services[j].startHandle
+	})
+	return services
+}
+
 // ConnectionParameters get the connection parameters
 func (c *Connection) ConnectionParameters() ConnectionParameters {
 	return c.params
@@ -356,10 +868,40 @@ func (c *Connection) attclientFindInformation(service *Service, timeoutMs time.D
 	})
 }
 
+// resetGatt forget the services discovered on a previous connection, the
+// peer may have changed its database (e.g. rebooted into a bootloader)
+func (c *Connection) resetGatt() {
+	c.services = make(map[uint16]*Service)
+	c.characteristics = make(map[uint16]*Characteristic)
+	c.attribs = make(map[uint16]*Attribute)
+	c.charByUUID = make(map[string]*Characteristic)
+	c.curService = nil
+	c.curChar = nil
+}
+
+// discoverService discover the characteristics and descriptors of a service
+func (c *Connection) discoverService(s *Service, timeoutMs time.Duration) error {
+	c.curService = s
+	c.curChar = nil
+	if err := c.attclientFindInformation(s, timeoutMs); err != nil {
+		return err
+	}
+	if err := c.attclientReadByType(s, CharacteristicUUID, timeoutMs); err != nil {
+		return err
+	}
+	if err := c.attclientReadByType(s, ClientCharacteristicConfigUUID, timeoutMs); err != nil {
+		return err
+	}
+	return c.attclientReadByType(s, UserDescriptionUUID, timeoutMs)
+}
+
 // addService add a new service
 func (c *Connection) addService(service *Service) {
 	if c.services[service.startHandle] == nil {
 		c.services[service.startHandle] = service
+		if c.serviceFound != nil {
+			c.serviceFound(service)
+		}
 	}
 }
 
@@ -368,12 +910,21 @@ func (c *Connection) addCharacteristicInfo(chrHandle uint16, uuid []byte) {
 	if bytes.Equal(uuid, CharacteristicUUID) {
 		// found the characteristic UUID -- always listed first in a characteristic
 		// and designates the begginging of a new char decl
-		c.curChar = &Characteristic{}
+		c.curChar = &Characteristic{attribs: make(map[string]*Attribute), declHandle: chrHandle, service: c.curService, conn: c}
 		c.characteristics[chrHandle] = c.curChar
+		if c.curService != nil {
+			c.curService.characteristics = append(c.curService.characteristics, c.curChar)
+		}
+	} else if c.curChar == nil {
+		// service and include declarations precede the first characteristic
+		return
 	}
 
 	// populate the descriptor tables
 	c.attribs[chrHandle] = c.curChar.addDescriptor(uuid, chrHandle, []byte{})
+	if c.curChar.value != nil && c.curChar.value.handle == chrHandle {
+		c.charByUUID[string(c.curChar.uuid)] = c.curChar
+	}
 }
 
 // updateStatus update connection status
@@ -382,9 +933,16 @@ func (c *Connection) updateStatus(status *ConnectionStatus) {
 
 	if status.Flags&ConnectionStatusFlagCompleted != 0 {
 		// connection attempt succeeded
-		if c.central.openConnections[status.Connection] == nil {
-			// notify listern that the connection attempt succeeded
+		c.central.mutex.Lock()
+		opened := c.central.openConnections[status.Connection] == nil
+		if opened {
 			c.central.openConnections[status.Connection] = c
+			c.opens++
+		}
+		c.central.mutex.Unlock()
+
+		if opened {
+			// notify listern that the connection attempt succeeded
 			c.state = connectionStateConnected
 			c.procMgr.complete(procedureConnect)
 		}
@@ -398,39 +956,89 @@ func (c *Connection) updateStatus(status *ConnectionStatus) {
 
 // Open open connection
 func (c *Connection) Open() error {
+	return c.OpenContext(context.Background())
+}
+
+// OpenContext open connection, when ctx is done before the connection is
+// established the attempt is ended in the module
+func (c *Connection) OpenContext(ctx context.Context) error {
+	if err := c.resp.Address.Validate(); err != nil {
+		return err
+	}
+	if policy := c.central.SecurityPolicy; policy != nil && policy.Pairing != nil {
+		// fail before connecting rather than once connected
+		if err := policy.Pairing.Validate(); err != nil {
+			return fmt.Errorf("%w: %v", ErrSecurityPolicy, err)
+		}
+	}
+
+	if err := c.central.gapTake(gapFuncConnecting); err != nil {
+		return err
+	}
+	if err := c.central.api.checkFreeConnection(); err != nil {
+		c.central.gapGive(gapFuncConnecting)
+		return err
+	}
+
 	var timeout time.Duration = 5000
-	err := c.procMgr.perform(timeout, connectionStateConnected, func() {
+	reportProgress(c.progress, "connect", 0, 0)
+	err := c.procMgr.performContext(ctx, timeout, procedureConnect, func() {
 		c.central.api.GapConnectDirect(c.resp.Address, &c.params)
 	})
+	if err != nil {
+		// stop the module from connecting behind our back
+		if endErr := c.central.endProcedure(); endErr != nil && ctx.Err() != nil {
+			err = endErr
+		}
+	}
+	c.central.gapGive(gapFuncConnecting)
+
+	if policy := c.central.SecurityPolicy; err == nil && policy != nil {
+		c.central.mutex.Lock()
+		c.security = policy
+		c.secured = false
+		c.central.mutex.Unlock()
+		reportProgress(c.progress, "secure", 0, 0)
+		err = c.secure(policy)
+	}
 
-	if err == nil {
+	if err == nil && c.lazyDiscovery {
+		c.resetGatt()
+	} else if err == nil && c.restoreCachedGatt() {
+		c.restoreSubscriptions()
+	} else if err == nil {
 		// FIXME need to define these timeouts as global variables
 		// FIXME timeout
 		// connection is Open, query the primary service to find out what services are supported
 		// these will be registered
+		reportProgress(c.progress, "discover services", 0, 0)
+		c.resetGatt()
 		c.attclientReadByGroupType(PrimaryServiceUUID, timeout)
 
 		// FIXME we need to add timeouts to the API
 		// iterate through the list of services to discover the characteristics
-		for _, s := range c.services {
-			if err = c.attclientFindInformation(s, timeout); err != nil {
-				break
-			}
-
-			if err = c.attclientReadByType(s, CharacteristicUUID, timeout); err != nil {
+		services := c.Services()
+		for i, s := range services {
+			reportProgress(c.progress, "discover characteristics", i, len(services))
+			if err = ctx.Err(); err != nil {
 				break
 			}
-
-			if err = c.attclientReadByType(s, ClientCharacteristicConfigUUID, timeout); err != nil {
-				break
-			}
-
-			if err = c.attclientReadByType(s, UserDescriptionUUID, timeout); err != nil {
+			if err = c.discoverService(s, timeout); err != nil {
 				break
 			}
 		}
+		if err == nil {
+			reportProgress(c.progress, "discover characteristics", len(services), len(services))
+			err = c.cacheGatt()
+		}
+		if err == nil {
+			c.restoreSubscriptions()
+		}
 	}
 
+	if err == nil {
+		err = c.registerDevice()
+	}
 	return err
 }
 
@@ -444,11 +1052,180 @@ func (c *Connection) CharacteristicByHandle(handle uint16) *Characteristic {
 	return c.characteristics[handle]
 }
 
+// Features query the link layer features supported by the peripheral
+func (c *Connection) Features() (LEFeatures, error) {
+	var timeout time.Duration = 5000
+	err := c.procMgr.perform(timeout, procedureFeatures, func() {
+		c.procResult = 0
+		c.central.api.ConnectionFeaturesGet(c.status.Connection, func(result uint16) {
+			if result != 0 {
+				// no indication follows a failed request
+				c.procResult = result
+				c.procMgr.complete(procedureFeatures)
+			}
+		})
+	})
+	if err == nil && c.procResult != 0 {
+		err = &ProcedureError{Result: c.procResult}
+	}
+	return c.features, err
+}
+
+// PeerVersion returns the link layer version and vendor of the peripheral,
+// exchanging versions first unless the peer already indicated them
+func (c *Connection) PeerVersion() (*ConnectionVersionIndication, error) {
+	if c.version != nil {
+		return c.version, nil
+	}
+
+	var timeout time.Duration = 5000
+	err := c.procMgr.perform(timeout, procedureVersion, func() {
+		c.procResult = 0
+		c.central.api.ConnectionVersionUpdate(c.status.Connection, func(result uint16) {
+			if result != 0 {
+				// no indication follows a failed request
+				c.procResult = result
+				c.procMgr.complete(procedureVersion)
+			}
+		})
+	})
+	if err == nil && c.procResult != 0 {
+		err = &ProcedureError{Result: c.procResult}
+	}
+	return c.version, err
+}
+
+// ConfirmIndication confirm the last indication received, only needed when
+// AutoIndicateConfirm is disabled
+func (c *Connection) ConfirmIndication() error {
+	return c.central.api.AttrclientIndicateConfirm(c.status.Connection)
+}
+
+// Close disconnect from the peripheral
+func (c *Connection) Close() error {
+	var timeout time.Duration = 5000
+	return c.procMgr.perform(timeout, procedureDisconnect, func() {
+		c.central.api.ConnectionDisconnect(c.status.Connection)
+	})
+}
+
+// Read read the value of a characteristic
+func (c *Connection) Read(char *Characteristic) ([]byte, error) {
+	if char.value == nil {
+		return nil, errors.New("characteristic has no value attribute")
+	}
+
+	err := c.readAttribute(char.value.handle)
+	return char.value.value, err
+}
+
+// readAttribute read the value of the attribute with handle, retrying
+// transient failures
+func (c *Connection) readAttribute(handle uint16) error {
+	c.reading = handle
+	var timeout time.Duration = 5000
+	return c.withRetry(func() error {
+		return c.procMgr.perform(timeout, procedureReadAttribute, func() {
+			c.attclientCommand(4, new(encoder).write(c.status.Connection).write(handle))
+		})
+	})
+}
+
+// ReadLong read a value longer than fits a single read, the peripheral
+// returns it in parts which are reported to the progress reporter
+func (c *Connection) ReadLong(char *Characteristic) ([]byte, error) {
+	if char.value == nil {
+		return nil, errors.New("characteristic has no value attribute")
+	}
+
+	c.longRead = char.value
+	c.longValue = nil
+	defer func() { c.longRead = nil }()
+
+	var timeout time.Duration = 5000
+	err := c.withRetry(func() error {
+		c.longValue = nil
+		return c.performGatt(timeout, func() {
+			c.attclientCommand(8, new(encoder).write(c.status.Connection).write(char.value.handle))
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	char.value.update(c.longValue)
+	return char.value.value, nil
+}
+
+// Write write the value of a characteristic, without waiting for the
+// peripheral to acknowledge it when withoutResponse is set
+func (c *Connection) Write(char *Characteristic, data []byte, withoutResponse bool) error {
+	if char.value == nil {
+		return errors.New("characteristic has no value attribute")
+	}
+
+	if withoutResponse {
+		return c.central.api.AttclientWriteCommand(c.status.Connection, char.value.handle, data, func(uint16) {})
+	}
+
+	var timeout time.Duration = 5000
+	return c.withRetry(func() error {
+		return c.performGatt(timeout, func() {
+			c.attclientCommand(5, new(encoder).write(c.status.Connection).write(char.value.handle).uint8array(data))
+		})
+	})
+}
+
+// Subscribe enable (or disable) notifications or indications of a
+// characteristic, values are then delivered to OnValueChanged of its value
+// attribute. Subscriptions are restored when the connection is opened
+// again, see Subscriptions.
+func (c *Connection) Subscribe(char *Characteristic, enable bool) error {
+	cccd := char.Descriptor(ClientCharacteristicConfigUUID)
+	if cccd == nil {
+		return errors.New("characteristic has no client characteristic configuration")
+	}
+
+	value := []byte{0, 0}
+	if enable {
+		if char.properties&CharPropNotify != 0 {
+			value[0] = 1
+		} else {
+			value[0] = 2
+		}
+	}
+
+	var timeout time.Duration = 5000
+	err := c.withRetry(func() error {
+		return c.performGatt(timeout, func() {
+			c.attclientCommand(5, new(encoder).write(c.status.Connection).write(cccd.handle).uint8array(value))
+		})
+	})
+	if err == nil {
+		c.rememberSubscription(char, enable)
+		c.central.api.trackSubscription(c.status.Connection, cccd.handle, enable)
+	}
+	return err
+}
+
 // NewConnection construct a new connection
 func (c *Central) NewConnection(resp *GapScanRespone, params *ConnectionParameters) *Connection {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
 	var conn = c.connections[resp.Address.Hashable()]
 	if conn == nil {
-		conn = &Connection{resp: *resp, params: *params, central: c}
+		conn = &Connection{
+			resp:            *resp,
+			params:          *params,
+			central:         c,
+			services:        make(map[uint16]*Service),
+			characteristics: make(map[uint16]*Characteristic),
+			attribs:         make(map[uint16]*Attribute),
+			charByUUID:      make(map[string]*Characteristic),
+			procMgr:         newProcedureManager(c.api.clock),
+			state:           connectionStateDisconnected,
+		}
 		c.connections[resp.Address.Hashable()] = conn
 	}
 
@@ -462,13 +1239,18 @@ func ParseGapScanResponse(adv *GapScanRespone) *AdvertisementData {
 
 	total := len(adv.Data)
 	for (cur + 1) < total {
-		// parse atrribute header
+		// parse atrribute header, the length covers the type byte
 		segLen := int(adv.Data[cur])
+		if segLen == 0 {
+			// zero padding ends the significant part
+			break
+		}
 		cur++
 		segType := adv.Data[cur]
 		cur++
+		segLen--
 
-		if (cur + segLen) >= total {
+		if (cur + segLen) > total {
 			// exit sielently
 			break
 		}
@@ -482,17 +1264,29 @@ func ParseGapScanResponse(adv *GapScanRespone) *AdvertisementData {
 	return &result
 }
 
+// ServiceUUIDs returns the service UUIDs listed in the advertisement
+func (adv AdvertisementData) ServiceUUIDs() ServiceUUIDs {
+	return findServicesForParsedAdvertisement(adv)
+}
+
+// LocalName returns the complete or shortened local name, if advertised
+func (adv AdvertisementData) LocalName() string {
+	if name, ok := adv[AdCompleteName]; ok {
+		return string(name)
+	}
+	return string(adv[AdShortName])
+}
+
 func findServicesForParsedAdvertisement(adv AdvertisementData) ServiceUUIDs {
 	var head = ServiceUUIDs{}
 	for segType := range adv {
 		var dim = 0
-		// FIXME we should define constants
 		switch segType {
-		case 2, 3: // incomplete/complete list of 16-bit UUIDs
+		case AdIncomplete16, AdComplete16:
 			dim = 2
-		case 4, 5: // incomplete list of 32-bit UUIDs
+		case AdIncomplete32, AdComplete32:
 			dim = 4
-		case 6, 7: // incomplete list of 128-bit UUIDs
+		case AdIncomplete128, AdComplete128:
 			dim = 16
 		}
 
@@ -514,7 +1308,10 @@ func findServicesForParsedAdvertisement(adv AdvertisementData) ServiceUUIDs {
 
 // OnSystemBoot invoked when the BLED112 boots
 func (dgt *apiDelegate) OnSystemBoot(info *SystemInfo) {
-
+	if store := dgt.central.Store; store != nil {
+		// the module forgets its whitelist when reset
+		RestoreWhitelist(dgt.central.api, store)
+	}
 }
 
 // OnSystemDebug invoked when BLED112 generates debug reply
@@ -564,7 +1361,9 @@ func (dgt *apiDelegate) OnAttributeStatus(handle uint16, flags byte) {
 // OnConnectionStatus invoked when the connection status changes
 func (dgt *apiDelegate) OnConnectionStatus(status *ConnectionStatus) {
 	// connection is already open
+	dgt.central.mutex.Lock()
 	var conn = dgt.central.connections[status.Address.Hashable()]
+	dgt.central.mutex.Unlock()
 	if conn != nil {
 		conn.updateStatus(status)
 	}
@@ -572,24 +1371,52 @@ func (dgt *apiDelegate) OnConnectionStatus(status *ConnectionStatus) {
 
 // OnConnectionVersionIndication invoked when version indication is updated
 func (dgt *apiDelegate) OnConnectionVersionIndication(ind *ConnectionVersionIndication) {
+	if conn := dgt.central.connectionForHandle(ind.Connection); conn != nil {
+		version := *ind
+		conn.version = &version
+		conn.procMgr.complete(procedureVersion)
+	}
 }
 
 // OnConnectionFeatureIndication invoked when feature indication is updated
 func (dgt *apiDelegate) OnConnectionFeatureIndication(connection byte, features []byte) {
+	if conn := dgt.central.connectionForHandle(connection); conn != nil {
+		conn.features = ParseLEFeatures(features)
+		conn.procMgr.complete(procedureFeatures)
+	}
 }
 
 // OnConnectionRawRx invoked when raw data is received
 func (dgt *apiDelegate) OnConnectionRawRx(connection byte, data []byte) {
+	conn := dgt.central.connectionForHandle(connection)
+	if conn == nil {
+		return
+	}
+	if conn.dataChannel != nil {
+		conn.dataChannel.receive(data)
+	}
+	if r := conn.rawReceiver(); r != nil {
+		r.receive(data)
+	}
 }
 
 // OnConnectionDisconnected invoked when the connection is lost
 func (dgt *apiDelegate) OnConnectionDisconnected(handle byte, reason uint16) {
+	dgt.central.mutex.Lock()
 	conn := dgt.central.openConnections[handle]
+	delete(dgt.central.openConnections, handle)
+	dgt.central.mutex.Unlock()
+
 	if conn != nil {
-		dgt.central.openConnections[handle] = nil
+		conn.closeStats()
 		conn.state = connectionStateDisconnected
-		conn.procMgr.complete(procedureDisconnect)
-		conn.delegate.OnDisconnected(reason)
+		conn.version = nil
+		conn.procMgr.disconnected()
+		conn.endRawReader()
+		if conn.delegate != nil {
+			conn.delegate.OnDisconnected(reason)
+		}
+		conn.publishDisconnected(reason)
 	}
 }
 
@@ -599,15 +1426,20 @@ func (dgt *apiDelegate) OnAttrclientIndicated(connection byte, attrHandle uint16
 
 // OnAttrclientProcedureCompleted invoked upon procedure completion
 func (dgt *apiDelegate) OnAttrclientProcedureCompleted(connHandle byte, result uint16, chrHandle uint16) {
-	if conn := dgt.central.openConnections[connHandle]; conn != nil {
+	if conn := dgt.central.connectionForHandle(connHandle); conn != nil {
+		conn.procResult = result
+		if result != 0 {
+			// a failed read reports no value
+			conn.procMgr.fail(procedureReadAttribute, result)
+		}
 		conn.procMgr.complete(procedureGeneral)
 	}
 }
 
 // OnAttrclientGroupFound invoked when the group is found
 func (dgt *apiDelegate) OnAttrclientGroupFound(connHandle byte, start uint16, end uint16, uuid []byte) {
-	if conn := dgt.central.openConnections[connHandle]; conn != nil {
-		conn.addService(&Service{startHandle: start, endHandle: end, uuid: uuid})
+	if conn := dgt.central.connectionForHandle(connHandle); conn != nil {
+		conn.addService(&Service{startHandle: start, endHandle: end, uuid: append([]byte(nil), uuid...)})
 	}
 }
 
@@ -618,19 +1450,40 @@ func (dgt *apiDelegate) OnAttrclientAttributeFound(connection byte, chrdecl uint
 
 // OnAttrclientFindInformationFound invoked when information is available
 func (dgt *apiDelegate) OnAttrclientFindInformationFound(connHandle byte, chrHandle uint16, uuid []byte) {
-	if conn := dgt.central.openConnections[connHandle]; conn != nil {
+	if conn := dgt.central.connectionForHandle(connHandle); conn != nil {
 		conn.addCharacteristicInfo(chrHandle, uuid)
 	}
 }
 
 // OnAttrclientAttributeValue invoked when value changes
 func (dgt *apiDelegate) OnAttrclientAttributeValue(connHandle byte, atrHandle uint16, valueType byte, value []byte) {
-	if conn := dgt.central.openConnections[connHandle]; conn != nil {
-		if at := conn.attribs[atrHandle]; at != nil {
+	if conn := dgt.central.connectionForHandle(connHandle); conn != nil {
+		if valueType == AttValueTypeReadBlob && conn.longRead != nil && conn.longRead.handle == atrHandle {
+			// accumulate the parts, the value is updated once the read completes
+			conn.longValue = append(conn.longValue, value...)
+			reportProgress(conn.progress, "read long", len(conn.longValue), 0)
+			return
+		}
+
+		// values are withheld until the link satisfies the security policy
+		deliver := conn.delivers()
+		if at := conn.attribs[atrHandle]; at != nil && deliver {
 			at.update(value)
 		}
+		switch valueType {
+		case AttValueTypeNotify, AttValueTypeIndicate, AttValueTypeIndicateRspReq:
+			if deliver {
+				conn.publishValue(atrHandle, value)
+			}
+		}
+
+		if valueType == AttValueTypeIndicateRspReq && dgt.central.AutoIndicateConfirm {
+			conn.ConfirmIndication()
+		}
 
-		conn.procMgr.complete(procedureReadAttribute) // FIXME What about indications etc?
+		if (valueType == AttValueTypeRead || valueType == AttValueTypeReadByType) && atrHandle == conn.reading {
+			conn.procMgr.complete(procedureReadAttribute)
+		}
 	}
 }
 
@@ -639,8 +1492,25 @@ func (dgt *apiDelegate) OnAttrclientReadMultipleResponse(connection byte, handle
 
 // OnGapScanResponse invoked when GAP Scan Response is available
 func (dgt *apiDelegate) OnGapScanResponse(resp *GapScanRespone) {
-	// accumulate repsonses
-	dgt.central.knownPeripherals[resp.Address.Hashable()] = resp
+	// accumulate repsonses, the payload belongs to the API's receive buffer
+	known := *resp
+	known.Data = append([]byte(nil), resp.Data...)
+	dgt.central.mutex.Lock()
+	_, seen := dgt.central.knownPeripherals[resp.Address.Hashable()]
+	dgt.central.knownPeripherals[resp.Address.Hashable()] = &known
+	previous, changed := dgt.central.payloadChanged(&known)
+	dgt.central.mutex.Unlock()
+
+	if !seen {
+		dgt.central.publishAppeared(&known)
+	} else if changed {
+		dgt.central.publishPayloadChanged(&known, previous)
+	}
+
+	dgt.central.runScanHooks(&known)
+	if dgt.central.OnScanResponse != nil && dgt.central.reportScan(&known) {
+		dgt.central.OnScanResponse(&known)
+	}
 }
 
 // OnGapModeChanged invoked when the GAP mode changes
@@ -652,16 +1522,39 @@ func (dgt *apiDelegate) OnGapModeChanged(discover byte, connect byte) {
 func (dgt *apiDelegate) OnSmSmpData(handle byte, packet byte, data []byte) {}
 
 // OnSmBondingFail invoked when the bonding fails
-func (dgt *apiDelegate) OnSmBondingFail(handle byte, result uint16) {}
+func (dgt *apiDelegate) OnSmBondingFail(handle byte, result uint16) {
+	if conn := dgt.central.connectionForHandle(handle); conn != nil {
+		conn.procResult = result
+		conn.procMgr.complete(procedureBond)
+		conn.procMgr.complete(procedureEncrypt)
+	}
+}
 
 // OnSmPasskeyDisplay inovked when the paskey is displayed
-func (dgt *apiDelegate) OnSmPasskeyDisplay(handle byte, passkey uint32) {}
+func (dgt *apiDelegate) OnSmPasskeyDisplay(handle byte, passkey uint32) {
+	if conn := dgt.central.connectionForHandle(handle); conn != nil {
+		conn.passkeyDisplayed(passkey)
+	}
+}
 
 // OnSmPasskeyRequest invoked when the paskey is requested
-func (dgt *apiDelegate) OnSmPasskeyRequest(handle byte) {}
+func (dgt *apiDelegate) OnSmPasskeyRequest(handle byte) {
+	if conn := dgt.central.connectionForHandle(handle); conn != nil {
+		conn.passkeyRequested()
+	}
+}
 
 // OnSmBondStatus invoked when the bond status is updated
-func (dgt *apiDelegate) OnSmBondStatus(status *SmBondStatus) {}
+func (dgt *apiDelegate) OnSmBondStatus(status *SmBondStatus) {
+	dgt.central.mutex.Lock()
+	conn := dgt.central.pairing
+	dgt.central.mutex.Unlock()
+
+	if conn != nil {
+		conn.bond = &BondInfo{Bond: status.Bond, KeySize: status.KeySize, MITM: status.MITM != 0, Keys: SmKeys(status.Keys)}
+		conn.procMgr.complete(procedureBond)
+	}
+}
 
 // OnHardwareIoPortStatus invoked when the IO port status is changed
 func (dgt *apiDelegate) OnHardwareIoPortStatus(status *IoPortStatus) {}
@@ -0,0 +1,355 @@
+package bgapi_test
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	bgapi "github.com/jsakwa/go_bgapi"
+	"github.com/jsakwa/go_bgapi/bgapitest"
+)
+
+// TestValueTypes injects attribute_value events of every type, only those
+// notified or indicated are published
+func TestValueTypes(t *testing.T) {
+	central, _, _ := connectPeripheral(t, bgapitest.NewModule(), heartRate)
+	var published []bgapi.HexBytes
+	stop := central.Watch(func(event *bgapi.DeviceEvent) {
+		if event.Type == bgapi.DeviceValueChanged {
+			published = append(published, event.Value)
+		}
+	})
+	defer stop()
+
+	for valueType := byte(0); valueType <= 5; valueType++ {
+		// connection 0, handle 3, type, 1 byte value
+		if err := central.API().InjectEvent(4, 5, []byte{0x00, 0x03, 0x00, valueType, 0x01, valueType}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []bgapi.HexBytes{{1}, {2}, {5}}
+	if len(published) != len(want) {
+		t.Fatalf("published %v, want %v", published, want)
+	}
+	for i := range want {
+		if !bytes.Equal(published[i], want[i]) {
+			t.Fatalf("published %v, want %v", published, want)
+		}
+	}
+}
+
+func TestReadLong(t *testing.T) {
+	_, conn, peripheral := connectPeripheral(t, bgapitest.NewModule(), heartRate)
+	value := make([]byte, 50)
+	for i := range value {
+		value[i] = byte(i)
+	}
+	peripheral.Notify(3, value)
+
+	got, err := conn.ReadLong(conn.CharacteristicForUUID(bgapi.MustParseUUID("2a37")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, value) {
+		t.Fatalf("read % x", got)
+	}
+}
+
+// TestAutoIndicateConfirm confirms the indications requesting it, parts of
+// a long read are not
+func TestAutoIndicateConfirm(t *testing.T) {
+	module := bgapitest.NewModule()
+	central, _, _ := connectPeripheral(t, module, heartRate)
+
+	// indicate_rsp_req then read_blob, on handle 3
+	for _, valueType := range []byte{5, 4} {
+		if err := central.API().InjectEvent(4, 5, []byte{0x00, 0x03, 0x00, valueType, 0x01, 0x48}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// commands are sent in order, a confirmation precedes this one
+	central.API().SystemAddressGet(func(bgapi.Mac) {})
+	confirms := -1
+	for deadline := time.Now().Add(time.Second); confirms < 0 && time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		n := 0
+		for _, cmd := range module.Commands() {
+			switch {
+			case cmd.Class == 4 && cmd.Command == 7:
+				n++
+			case cmd.Class == 0 && cmd.Command == 2:
+				confirms = n
+			}
+		}
+	}
+	if confirms != 1 {
+		t.Fatalf("%d indications confirmed, want 1", confirms)
+	}
+}
+
+// TestReadRetried fails a read with a transient ATT result, the read is
+// retried rather than left to time out
+func TestReadRetried(t *testing.T) {
+	module := bgapitest.NewModule()
+	central, conn, peripheral := connectPeripheral(t, module, heartRate)
+	central.GattRetry = bgapi.RetryPolicy{Attempts: 1, Delay: time.Millisecond}
+	peripheral.Notify(3, []byte{0x48})
+
+	reads := 0
+	module.Handle(4, 4, func(payload []byte) ([]byte, []bgapitest.Event) {
+		reads++
+		if reads == 1 {
+			// connection 0, ATT insufficient resources on handle 3
+			return []byte{0, 0, 0}, []bgapitest.Event{bgapitest.ProcedureCompleted(0, 0x0411, 3)}
+		}
+		return []byte{0, 0, 0}, []bgapitest.Event{bgapitest.AttributeValue(0, 3, 0, []byte{0x49})}
+	})
+
+	start := time.Now()
+	got, err := conn.Read(conn.CharacteristicForUUID(bgapi.MustParseUUID("2a37")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, []byte{0x49}) || reads != 2 {
+		t.Fatalf("read % x in %d attempts", got, reads)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("read took %v", elapsed)
+	}
+}
+
+// TestReadIgnoresNotifications notifies the value being read, the read
+// completes with the read response only
+func TestReadIgnoresNotifications(t *testing.T) {
+	module := bgapitest.NewModule()
+	_, conn, _ := connectPeripheral(t, module, heartRate)
+
+	module.Handle(4, 4, func(payload []byte) ([]byte, []bgapitest.Event) {
+		return []byte{0, 0, 0}, []bgapitest.Event{
+			bgapitest.AttributeValue(0, 3, 1, []byte{0x01}),
+			bgapitest.AttributeValue(0, 4, 0, []byte{0x02}),
+			bgapitest.AttributeValue(0, 3, 0, []byte{0x03}),
+		}
+	})
+
+	got, err := conn.Read(conn.CharacteristicForUUID(bgapi.MustParseUUID("2a37")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, []byte{0x03}) {
+		t.Fatalf("read % x", got)
+	}
+}
+
+// TestAutoIndicateConfirmThrottled confirms indications over the rate
+// limit without stalling the receive goroutine
+func TestAutoIndicateConfirmThrottled(t *testing.T) {
+	module := bgapitest.NewModule()
+	central, _, _ := connectPeripheral(t, module, heartRate)
+	api := central.API()
+	if err := api.SetRateLimit(&bgapi.RateLimit{Rate: 0.1, Burst: 1}, nil); err != nil {
+		t.Fatal(err)
+	}
+	// lift the limit for closing
+	defer api.SetRateLimit(nil, nil)
+	// take the only token
+	api.SystemAddressGet(func(bgapi.Mac) {})
+
+	indication := bgapitest.AttributeValue(0, 3, 5, []byte{0x48})
+	for i := 0; i < 2; i++ {
+		module.Event(indication.Class, indication.Event, indication.Payload)
+	}
+	confirms := 0
+	for deadline := time.Now().Add(time.Second); confirms < 2 && time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		confirms = 0
+		for _, cmd := range module.Commands() {
+			if cmd.Class == 4 && cmd.Command == 7 {
+				confirms++
+			}
+		}
+	}
+	if confirms != 2 {
+		t.Fatalf("%d indications confirmed, want 2", confirms)
+	}
+}
+
+// iBeaconResponse returns an advertisement of an iBeacon
+func iBeaconResponse() *bgapi.GapScanRespone {
+	data := []byte{0x1a, 0xff, 0x4c, 0x00, 0x02, 0x15}
+	data = append(data, bytes.Repeat([]byte{0xe2}, 16)...)
+	data = append(data, 0x00, 0x01, 0x00, 0x02, 0xc5)
+	return &bgapi.GapScanRespone{RSSI: -60, Address: bgapi.QualifiedMac{Address: bgapi.Mac{1, 2, 3, 4, 5, 6}}, Data: data}
+}
+
+// TestMonitorBeaconsHandler observes the scan responses while monitoring
+// only, the handler of the application is called throughout
+func TestMonitorBeaconsHandler(t *testing.T) {
+	module := bgapitest.NewModule()
+	central := bgapi.NewCentral()
+	central.API().OpenTransport(module, nil)
+	defer central.API().Close()
+	handled := make(chan struct{}, 2)
+	central.OnScanResponse = func(*bgapi.GapScanRespone) { handled <- struct{}{} }
+
+	resp := iBeaconResponse()
+	beacon, _ := bgapi.ParseGapScanResponse(resp).IBeacon()
+	monitor := bgapi.NewBeaconMonitor([]bgapi.BeaconID{beacon.ID()})
+	ctx, cancel := context.WithCancel(context.Background())
+	monitored := make(chan error)
+	go func() { monitored <- central.MonitorBeacons(ctx, 2, monitor) }()
+
+	event := bgapitest.ScanResponse(resp)
+	for deadline := time.Now().Add(time.Second); monitor.Status()[0].LastSeen.IsZero(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("beacon not observed")
+		}
+		module.Event(event.Class, event.Event, event.Payload)
+		<-handled
+	}
+	cancel()
+	if err := <-monitored; err != nil {
+		t.Fatal(err)
+	}
+
+	seen := monitor.Status()[0].LastSeen
+	module.Event(event.Class, event.Event, event.Payload)
+	<-handled
+	if monitor.Status()[0].LastSeen != seen {
+		t.Fatal("observed once monitoring ended")
+	}
+}
+
+// TestScanMergerClose adds packets while the merger closes, nothing is
+// emitted once Close returned and the advertisements waiting are flushed
+func TestScanMergerClose(t *testing.T) {
+	var mutex sync.Mutex
+	flushed, closed := false, false
+	merger := bgapi.NewScanMerger(bgapi.SystemClock, time.Hour, func(m *bgapi.MergedAdvertisement) {
+		time.Sleep(time.Millisecond)
+		mutex.Lock()
+		defer mutex.Unlock()
+		if closed {
+			t.Error("emitted once closed")
+		}
+		if m.Type == bgapi.AdvScannable {
+			flushed = true
+		}
+	})
+	merger.Add(&bgapi.GapScanRespone{PacketType: byte(bgapi.AdvScannable), Address: bgapi.QualifiedMac{Address: bgapi.Mac{1}}})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				merger.Add(&bgapi.GapScanRespone{PacketType: byte(bgapi.AdvNonConnectable), Address: bgapi.QualifiedMac{Address: bgapi.Mac{2, byte(i), byte(j)}}})
+			}
+		}(i)
+	}
+	time.Sleep(5 * time.Millisecond)
+	merger.Close()
+	mutex.Lock()
+	closed = true
+	mutex.Unlock()
+	wg.Wait()
+	if !flushed {
+		t.Fatal("advertisement waiting for its scan response not flushed")
+	}
+}
+
+// TestDiffAdvertisementData tells a field advertised empty from a field
+// removed
+func TestDiffAdvertisementData(t *testing.T) {
+	old := bgapi.AdvertisementData{0x01: {0x06}, 0x09: []byte("kitchen"), 0xff: {0x4c, 0x00}}
+	new := bgapi.AdvertisementData{0x01: {0x06}, 0x09: {}, 0x16: {}}
+	want := []bgapi.AdvFieldChange{
+		{Type: 0x09, Old: bgapi.HexBytes("kitchen"), New: bgapi.HexBytes{}},
+		{Type: 0x16, New: bgapi.HexBytes{}, Added: true},
+		{Type: 0xff, Old: bgapi.HexBytes{0x4c, 0x00}, Removed: true},
+	}
+	if changes := bgapi.DiffAdvertisementData(old, new); !reflect.DeepEqual(changes, want) {
+		t.Fatalf("changes %+v, want %+v", changes, want)
+	}
+}
+
+// TestDuplicatePolicyMonitor filters the packets of a beacon repeating its
+// advertisement for OnScanResponse only, the monitor sees every one
+func TestDuplicatePolicyMonitor(t *testing.T) {
+	module := bgapitest.NewModule()
+	central := bgapi.NewCentral()
+	central.DuplicatePolicy = bgapi.DuplicatesOnChange
+	central.API().OpenTransport(module, nil)
+	defer central.API().Close()
+	handled := 0
+	central.OnScanResponse = func(*bgapi.GapScanRespone) { handled++ }
+
+	resp := iBeaconResponse()
+	beacon, _ := bgapi.ParseGapScanResponse(resp).IBeacon()
+	monitor := bgapi.NewBeaconMonitor([]bgapi.BeaconID{beacon.ID()})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go central.MonitorBeacons(ctx, 2, monitor)
+	// scanning forgets the packets reported, wait for it to start
+	for deadline := time.Now().Add(time.Second); !discovering(module); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("scan not started")
+		}
+	}
+
+	event := bgapitest.ScanResponse(resp)
+	var seen []time.Time
+	for deadline := time.Now().Add(time.Second); len(seen) < 3 && time.Now().Before(deadline); time.Sleep(2 * time.Millisecond) {
+		if err := central.API().InjectEvent(event.Class, event.Event, event.Payload); err != nil {
+			t.Fatal(err)
+		}
+		if last := monitor.Status()[0].LastSeen; !last.IsZero() && (len(seen) == 0 || last.After(seen[len(seen)-1])) {
+			seen = append(seen, last)
+		}
+	}
+	if len(seen) < 3 {
+		t.Fatalf("monitor saw %d packets", len(seen))
+	}
+	if handled != 1 {
+		t.Fatalf("%d packets handled, want 1", handled)
+	}
+}
+
+// TestDuplicatePolicyEviction forgets the advertisers reported first once
+// too many were, they are reported again
+func TestDuplicatePolicyEviction(t *testing.T) {
+	central := bgapi.NewCentral()
+	central.DuplicatePolicy = bgapi.DuplicatesFirst
+	central.API().OpenTransport(bgapitest.NewModule(), nil)
+	defer central.API().Close()
+	reported := make(map[bgapi.Mac]int)
+	central.OnScanResponse = func(resp *bgapi.GapScanRespone) { reported[resp.Address.Address]++ }
+
+	// one more than the bound on the advertisers remembered, then the first
+	const advertisers = 4096
+	for i := 0; i <= advertisers+1; i++ {
+		address := bgapi.Mac{1, byte(i), byte(i >> 8)}
+		if i > advertisers {
+			address = bgapi.Mac{1}
+		}
+		event := bgapitest.ScanResponse(&bgapi.GapScanRespone{Address: bgapi.QualifiedMac{Address: address}})
+		if err := central.API().InjectEvent(event.Class, event.Event, event.Payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := reported[bgapi.Mac{1}]; n != 2 {
+		t.Fatalf("first advertiser reported %d times, want 2", n)
+	}
+}
+
+// discovering returns true once the module was asked to scan
+func discovering(module *bgapitest.Module) bool {
+	for _, cmd := range module.Commands() {
+		if cmd.Class == 6 && cmd.Command == 2 {
+			return true
+		}
+	}
+	return false
+}
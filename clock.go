@@ -0,0 +1,65 @@
+package bgapi
+
+import "time"
+
+// Clock the source of time of the API: command and procedure timeouts,
+// retry delays, address rotation and timestamps. The system clock is used
+// unless TransportOptions.Clock replaces it, e.g. with a fake clock letting
+// tests trigger timeouts without waiting for them.
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+	// After returns a channel receiving the time once d elapsed
+	After(d time.Duration) <-chan time.Time
+	// NewTimer returns a timer firing once d elapsed
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer a timer created by a Clock, see time.Timer
+type Timer interface {
+	// C returns the channel receiving the time when the timer fires
+	C() <-chan time.Time
+	// Stop prevent the timer from firing, false if it already fired or
+	// was stopped
+	Stop() bool
+	// Reset change the timer to fire once d elapsed, false if it already
+	// fired or was stopped
+	Reset(d time.Duration) bool
+}
+
+// SystemClock the clock reading the time of the host
+var SystemClock Clock = systemClock{}
+
+// systemClock a Clock backed by the time package
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (systemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{time.NewTimer(d)}
+}
+
+// systemTimer a Timer backed by time.Timer
+type systemTimer struct {
+	*time.Timer
+}
+
+func (t systemTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+// Clock returns the clock of the API
+func (api *API) Clock() Clock {
+	return api.clock
+}
+
+// sleep wait for d to elapse on the clock of the API
+func (api *API) sleep(d time.Duration) {
+	<-api.clock.After(d)
+}
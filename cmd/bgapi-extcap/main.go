@@ -0,0 +1,179 @@
+// Command bgapi-extcap is a Wireshark extcap capturing through a BLED112:
+// the advertisements it receives as Bluetooth LE link layer packets, or the
+// BGAPI frames exchanged with it. Copy or link it into the extcap directory
+// of Wireshark (see About > Folders) and pick an interface in the capture
+// dialog.
+//
+// BGAPI frames are captured with the USER0 link type, each prefixed by a
+// byte giving its direction: 0 to the device, 1 from it.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	bgapi "github.com/jsakwa/go_bgapi"
+)
+
+// defaultPort the serial port of the device, unless configured
+const defaultPort = "/dev/ttyACM0"
+
+// capture interfaces
+const (
+	ifaceAdv   = "bled112-adv"
+	ifaceBGAPI = "bled112-bgapi"
+)
+
+var (
+	listInterfaces = flag.Bool("extcap-interfaces", false, "list the capture interfaces")
+	listDLTs       = flag.Bool("extcap-dlts", false, "list the link types of the interface")
+	listConfig     = flag.Bool("extcap-config", false, "list the options of the interface")
+	iface          = flag.String("extcap-interface", "", "capture interface")
+	capture        = flag.Bool("capture", false, "capture into the fifo")
+	fifo           = flag.String("fifo", "", "file the capture is written to")
+	_              = flag.String("extcap-version", "", "version of Wireshark")
+	_              = flag.String("extcap-capture-filter", "", "capture filter, unsupported")
+
+	port   = flag.String("port", defaultPort, "serial port of the device")
+	active = flag.Bool("active", false, "send scan requests to collect scan responses")
+)
+
+func main() {
+	flag.Parse()
+
+	var err error
+	switch {
+	case *listInterfaces:
+		printInterfaces()
+	case *listDLTs:
+		err = printDLTs(*iface)
+	case *listConfig:
+		err = printConfig(*iface)
+	case *capture:
+		err = runCapture(*iface, *fifo)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "bgapi-extcap: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func printInterfaces() {
+	fmt.Println("extcap {version=1.0}{help=https://github.com/jsakwa/go_bgapi}")
+	fmt.Printf("interface {value=%s}{display=BLED112 advertisements}\n", ifaceAdv)
+	fmt.Printf("interface {value=%s}{display=BLED112 BGAPI frames}\n", ifaceBGAPI)
+}
+
+func printDLTs(name string) error {
+	switch name {
+	case ifaceAdv:
+		fmt.Printf("dlt {number=%d}{name=BLUETOOTH_LE_LL_WITH_PHDR}{display=Bluetooth LE link layer}\n", dltBluetoothLELL)
+	case ifaceBGAPI:
+		fmt.Printf("dlt {number=%d}{name=USER0}{display=BGAPI}\n", dltUser0)
+	default:
+		return fmt.Errorf("unknown interface %q", name)
+	}
+	return nil
+}
+
+func printConfig(name string) error {
+	if name != ifaceAdv && name != ifaceBGAPI {
+		return fmt.Errorf("unknown interface %q", name)
+	}
+	fmt.Printf("arg {number=0}{call=--port}{display=Serial port}{type=string}{default=%s}{tooltip=Serial port of the BLED112}\n", defaultPort)
+	fmt.Println("arg {number=1}{call=--active}{display=Active scanning}{type=boolflag}{default=false}{tooltip=Send scan requests to collect scan responses}")
+	return nil
+}
+
+// runCapture scan and write the capture of the interface until Wireshark
+// stops it, by signal or by closing the fifo
+func runCapture(name string, path string) error {
+	if path == "" {
+		return errors.New("no fifo")
+	}
+	var linkType uint32
+	switch name {
+	case ifaceAdv:
+		linkType = dltBluetoothLELL
+	case ifaceBGAPI:
+		linkType = dltUser0
+	default:
+		return fmt.Errorf("unknown interface %q", name)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w, err := newPCAPWriter(f, linkType)
+	if err != nil {
+		return err
+	}
+
+	central := bgapi.NewCentral()
+	if _, err := central.API().OpenSerialReady(*port, nil, bgapi.HandshakeHello); err != nil {
+		return err
+	}
+	defer central.API().Close()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	// a write failing means Wireshark closed the fifo
+	fail := func(err error) {
+		if err != nil {
+			cancel()
+		}
+	}
+	if name == ifaceAdv {
+		central.OnScanResponse = func(resp *bgapi.GapScanRespone) {
+			fail(w.writePacket(time.Now(), advPacket(resp)))
+		}
+	} else {
+		central.API().SetTraceHook(&frameCapture{w: w, fail: fail})
+	}
+
+	if *active {
+		central.ScanRequestEnable()
+	} else {
+		central.ScanRequestDisable()
+	}
+	err = central.Scan(ctx, bgapi.GapDiscoverObservation)
+	central.API().SetTraceHook(nil)
+	if err == context.Canceled {
+		err = nil
+	}
+	return err
+}
+
+// frameCapture a trace hook capturing the BGAPI frames
+type frameCapture struct {
+	bgapi.NopTraceHook
+	w    *pcapWriter
+	fail func(err error)
+}
+
+// OnTransmit implements bgapi.TraceHook
+func (c *frameCapture) OnTransmit(f *bgapi.TraceFrame) {
+	c.fail(c.w.writePacket(f.Time, bgapiFrame(bgapiToDevice, f)))
+}
+
+// OnResponse implements bgapi.TraceHook
+func (c *frameCapture) OnResponse(f *bgapi.TraceFrame) {
+	c.fail(c.w.writePacket(f.Time, bgapiFrame(bgapiFromDevice, f)))
+}
+
+// OnEvent implements bgapi.TraceHook
+func (c *frameCapture) OnEvent(f *bgapi.TraceFrame) {
+	c.fail(c.w.writePacket(f.Time, bgapiFrame(bgapiFromDevice, f)))
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+	"time"
+
+	bgapi "github.com/jsakwa/go_bgapi"
+)
+
+// link types of the capture interfaces
+const (
+	// dltBluetoothLELL Bluetooth LE link layer packets with a pseudo header
+	dltBluetoothLELL = 256
+	// dltUser0 BGAPI frames prefixed by their direction, see bgapiDirection
+	dltUser0 = 147
+)
+
+// pcapWriter writes a classic pcap stream, packets may come from several
+// goroutines
+type pcapWriter struct {
+	mutex sync.Mutex
+	w     io.Writer
+	err   error
+}
+
+// newPCAPWriter write the file header of linkType
+func newPCAPWriter(w io.Writer, linkType uint32) (*pcapWriter, error) {
+	var hdr [24]byte
+	binary.LittleEndian.PutUint32(hdr[0:], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(hdr[4:], 2)
+	binary.LittleEndian.PutUint16(hdr[6:], 4)
+	binary.LittleEndian.PutUint32(hdr[16:], 65535)
+	binary.LittleEndian.PutUint32(hdr[20:], linkType)
+	if _, err := w.Write(hdr[:]); err != nil {
+		return nil, err
+	}
+	return &pcapWriter{w: w}, nil
+}
+
+// writePacket write a packet captured at t, the first error sticks
+func (p *pcapWriter) writePacket(t time.Time, data []byte) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.err != nil {
+		return p.err
+	}
+	var hdr [16]byte
+	binary.LittleEndian.PutUint32(hdr[0:], uint32(t.Unix()))
+	binary.LittleEndian.PutUint32(hdr[4:], uint32(t.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(hdr[8:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(hdr[12:], uint32(len(data)))
+	if _, p.err = p.w.Write(hdr[:]); p.err == nil {
+		_, p.err = p.w.Write(data)
+	}
+	return p.err
+}
+
+// flags of the LE link layer pseudo header
+const (
+	phdrDewhitened       = 0x0001
+	phdrSignalValid      = 0x0002
+	phdrRefAccessAddress = 0x0010
+)
+
+// advAccessAddress the access address of the advertising channels
+const advAccessAddress = 0x8e89bed6
+
+// txAddRandom the TxAdd bit of an advertising PDU header, the advertiser
+// address is random
+const txAddRandom = 0x40
+
+// advPacket rebuild the link layer packet of a scan response. BGAPI packet
+// types are the advertising PDU types; the channel is not reported, the
+// pseudo header claims the first advertising channel.
+func advPacket(resp *bgapi.GapScanRespone) []byte {
+	pdu := make([]byte, 0, 2+6+len(resp.Data))
+	header := resp.PacketType & 0x0f
+	if resp.Address.AddrType == bgapi.AddrTypeRandom {
+		header |= txAddRandom
+	}
+	pdu = append(pdu, header, byte(6+len(resp.Data)))
+	pdu = append(pdu, resp.Address.Address[:]...)
+	pdu = append(pdu, resp.Data...)
+
+	packet := make([]byte, 10, 10+4+len(pdu)+3)
+	packet[1] = byte(resp.RSSI)
+	binary.LittleEndian.PutUint32(packet[4:], advAccessAddress)
+	binary.LittleEndian.PutUint16(packet[8:], phdrDewhitened|phdrSignalValid|phdrRefAccessAddress)
+	packet = binary.LittleEndian.AppendUint32(packet, advAccessAddress)
+	packet = append(packet, pdu...)
+	crc := leCRC(pdu, 0x555555)
+	// the CRC is transmitted most significant bit first
+	for shift := 16; shift >= 0; shift -= 8 {
+		packet = append(packet, reverseBits(byte(crc>>uint(shift))))
+	}
+	return packet
+}
+
+// leCRC the link layer CRC of a PDU, bits taken least significant first
+func leCRC(pdu []byte, init uint32) uint32 {
+	crc := init
+	for _, b := range pdu {
+		for i := 0; i < 8; i++ {
+			feedback := (uint32(b)>>uint(i))&1 ^ (crc>>23)&1
+			crc = (crc << 1) & 0xffffff
+			if feedback != 0 {
+				crc ^= 0x00065b
+			}
+		}
+	}
+	return crc
+}
+
+// reverseBits returns b with its bits in reverse order
+func reverseBits(b byte) byte {
+	var r byte
+	for i := 0; i < 8; i++ {
+		r = r<<1 | b&1
+		b >>= 1
+	}
+	return r
+}
+
+// direction prefix of the BGAPI frames
+const (
+	bgapiToDevice   = 0
+	bgapiFromDevice = 1
+)
+
+// bgapiFrame rebuild a traced frame with its header, prefixed by dir
+func bgapiFrame(dir byte, f *bgapi.TraceFrame) []byte {
+	msgType := byte(0)
+	if f.Event {
+		msgType = 0x80
+	}
+	frame := []byte{dir, msgType | byte(len(f.Payload)>>8)&0x07, byte(len(f.Payload)), f.Class, f.Command}
+	return append(frame, f.Payload...)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	bgapi "github.com/jsakwa/go_bgapi"
+)
+
+var aliasCommand = &command{
+	name:  "alias",
+	args:  "add [-public] NAME ADDRESS | rm NAME | list",
+	short: "name peripherals, for the commands taking an address",
+	run:   runAlias,
+}
+
+func runAlias(cmd *command, args []string) error {
+	fs := commandFlags(cmd)
+	public := fs.Bool("public", false, "add: the address is public rather than random")
+	if len(args) == 0 {
+		fs.Usage()
+		return errors.New("expected an alias command")
+	}
+	fs.Parse(args[1:])
+
+	op, operands := args[0], fs.Args()
+	want := map[string]int{"add": 2, "rm": 1, "list": 0}
+	n, ok := want[op]
+	if !ok {
+		return fmt.Errorf("unknown alias command %q", op)
+	}
+	if len(operands) != n {
+		fs.Usage()
+		return fmt.Errorf("alias %s expects %d arguments", op, n)
+	}
+
+	book, err := openAddressBook()
+	if err != nil {
+		return err
+	}
+
+	switch op {
+	case "add":
+		address, err := parseAddress(operands[1], *public)
+		if err != nil {
+			return err
+		}
+		return book.Add(operands[0], address)
+	case "rm":
+		return book.Remove(operands[0])
+	default:
+		entries, err := book.List()
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			addrType := "random"
+			if e.Public {
+				addrType = "public"
+			}
+			fmt.Printf("%-24s %s %s\n", e.Name, e.Address, addrType)
+		}
+		return nil
+	}
+}
+
+// openAddressBook open the address book kept in the -book directory
+func openAddressBook() (*bgapi.AddressBook, error) {
+	dir := *bookDir
+	if dir == "" {
+		config, err := os.UserConfigDir()
+		if err != nil {
+			return nil, err
+		}
+		dir = filepath.Join(config, "bgtool", "addressbook")
+	}
+	store, err := bgapi.NewFileStore(dir)
+	if err != nil {
+		return nil, err
+	}
+	return bgapi.NewAddressBook(store), nil
+}
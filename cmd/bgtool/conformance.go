@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	bgapi "github.com/jsakwa/go_bgapi"
+	"github.com/jsakwa/go_bgapi/conformance"
+)
+
+var conformanceCommand = &command{
+	name:  "conformance",
+	args:  "[-peer ADDRESS|ALIAS [-public]] [-local HANDLE] [-pskey KEY]",
+	short: "check every command class against the device",
+	run:   runConformance,
+}
+
+func runConformance(cmd *command, args []string) error {
+	fs := commandFlags(cmd)
+	peer := fs.String("peer", "", "connectable peripheral for the connection and attribute client checks")
+	public := fs.Bool("public", false, "the peer address is public rather than random")
+	local := fs.String("local", "", "writable handle of the local GATT database for the attributes checks")
+	psKey := fs.String("pskey", "", "user PS key the flash checks may overwrite and erase (0x8000-0x807f)")
+	timeout := fs.Duration("timeout", 0, "bound on each check (default 5s)")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return errors.New("unexpected arguments")
+	}
+
+	opts := conformance.Options{Timeout: *timeout}
+	if *peer != "" {
+		address, err := parseAddress(*peer, *public)
+		if err != nil {
+			return err
+		}
+		opts.Peer = &address
+	}
+	if *local != "" {
+		handle, err := strconv.ParseUint(*local, 0, 16)
+		if err != nil {
+			return fmt.Errorf("invalid handle %q", *local)
+		}
+		opts.LocalHandle = uint16(handle)
+	}
+	if *psKey != "" {
+		key, err := strconv.ParseUint(*psKey, 0, 16)
+		if err != nil || !bgapi.IsUserPSKey(uint16(key)) {
+			return fmt.Errorf("invalid user PS key %q", *psKey)
+		}
+		opts.PSKey = uint16(key)
+	}
+
+	central, err := openCentral()
+	if err != nil {
+		return err
+	}
+	defer central.API().Close()
+
+	report := conformance.Run(context.Background(), central, opts)
+	fmt.Print(report)
+	if failed := len(report.Failed()); failed > 0 {
+		return fmt.Errorf("%d checks failed", failed)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var infoCommand = &command{
+	name:  "info",
+	args:  "[-selftest=false]",
+	short: "print the device information and run a self-test",
+	run:   runInfo,
+}
+
+// commandTimeout bound on the queries made by the commands
+const commandTimeout = 2 * time.Second
+
+// await call an API method taking a completion and wait for its result
+func await[T any](submit func(completion func(T)) error) (T, error) {
+	resultC := make(chan T, 1)
+	var zero T
+	if err := submit(func(v T) { resultC <- v }); err != nil {
+		return zero, err
+	}
+	select {
+	case v := <-resultC:
+		return v, nil
+	case <-time.After(commandTimeout):
+		return zero, errors.New("no response from the device")
+	}
+}
+
+func runInfo(cmd *command, args []string) error {
+	fs := commandFlags(cmd)
+	selfTest := fs.Bool("selftest", true, "run the self-test")
+	fs.Parse(args)
+
+	api, err := openAPI()
+	if err != nil {
+		return err
+	}
+	defer api.Close()
+
+	info, err := await(api.SystemInfoGet)
+	if err != nil {
+		return err
+	}
+	address, err := await(api.SystemAddressGet)
+	if err != nil {
+		return err
+	}
+	maxConnections, err := await(api.SystemConnectionsGet)
+	if err != nil {
+		return err
+	}
+	counters, err := await(api.SystemCountersGet)
+	if err != nil {
+		return err
+	}
+	fingerprint, err := api.Fingerprint()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("port:            %s\n", *port)
+	fmt.Printf("device:          %s\n", fingerprint.ID)
+	fmt.Printf("firmware:        %s\n", info.FirmwareVersion())
+	fmt.Printf("link layer:      %d\n", info.LLVersion)
+	fmt.Printf("protocol:        %d\n", info.ProtocolVersion)
+	fmt.Printf("hardware:        %d\n", info.HW)
+	fmt.Printf("address:         %s\n", address)
+	fmt.Printf("max connections: %d\n", maxConnections)
+	fmt.Printf("counters:        txok %d, txretry %d, rxok %d, rxfail %d, mbuf %d\n",
+		counters.Txok, counters.Txretry, counters.Rxok, counters.Rxfail, counters.Mbuf)
+
+	if !*selfTest {
+		return nil
+	}
+
+	fmt.Println("\nself-test:")
+	failed := 0
+	for _, r := range api.SelfTest() {
+		if r.Passed() {
+			fmt.Printf("  PASS  %-16s %v\n", r.Name, r.Elapsed.Round(time.Millisecond))
+		} else {
+			failed++
+			fmt.Printf("  FAIL  %-16s %v\n", r.Name, r.Err)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d self-test checks failed", failed)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+// Command bgtool inspects and drives a BLED112 (or other BGAPI v1 device)
+// from the command line.
+//
+//	bgtool [-port PORT] [-book DIR] COMMAND [ARGS]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	bgapi "github.com/jsakwa/go_bgapi"
+)
+
+// command a bgtool subcommand
+type command struct {
+	name  string
+	args  string
+	short string
+	// run executes the command, flags are parsed by the command itself
+	run func(cmd *command, args []string) error
+}
+
+var commands = []*command{
+	pairCommand,
+	psCommand,
+	infoCommand,
+	sniffCommand,
+	otaCommand,
+	aliasCommand,
+	conformanceCommand,
+	usbCommand,
+	supportCommand,
+}
+
+var port = flag.String("port", "/dev/ttyACM0", "serial port of the device")
+
+var bookDir = flag.String("book", "", "directory of the address book (default the bgtool directory of the user configuration)")
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: bgtool [-port PORT] [-book DIR] COMMAND [ARGS]\n\nflags:\n")
+	flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "\ncommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-40s %s\n", cmd.name+" "+cmd.args, cmd.short)
+	}
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	name := flag.Arg(0)
+	for _, cmd := range commands {
+		if cmd.name == name {
+			if err := cmd.run(cmd, flag.Args()[1:]); err != nil {
+				fmt.Fprintf(os.Stderr, "bgtool %s: %v\n", name, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "bgtool: unknown command %q\n", name)
+	usage()
+	os.Exit(2)
+}
+
+// commandFlags returns the flag set of a subcommand
+func commandFlags(cmd *command) *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: bgtool %s %s\n", cmd.name, cmd.args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// openAPI open the device
+func openAPI() (*bgapi.API, error) {
+	api := bgapi.NewAPI(&bgapi.LoggingDelegate{})
+	if _, err := api.OpenSerialReady(*port, nil, bgapi.HandshakeHello); err != nil {
+		return nil, err
+	}
+	return api, nil
+}
+
+// writeFile write to the named file, or to the standard output for "-"
+func writeFile(name string, write func(w io.Writer) error) error {
+	if name == "-" {
+		return write(os.Stdout)
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// openCentral open the device and return a central driving it
+func openCentral() (*bgapi.Central, error) {
+	central := bgapi.NewCentral()
+	if _, err := central.API().OpenSerialReady(*port, nil, bgapi.HandshakeHello); err != nil {
+		return nil, err
+	}
+	return central, nil
+}
+
+// parseAddress parse a peripheral address, random unless public is set, or
+// look up a name of the address book
+func parseAddress(s string, public bool) (bgapi.QualifiedMac, error) {
+	mac, err := bgapi.ParseMac(strings.ToLower(s))
+	if err != nil {
+		book, berr := openAddressBook()
+		if berr != nil {
+			return bgapi.QualifiedMac{}, berr
+		}
+		return book.Lookup(s)
+	}
+	addrType := bgapi.AddrTypeRandom
+	if public {
+		addrType = bgapi.AddrTypePublic
+	}
+	return bgapi.NewQualifiedMac(mac, addrType)
+}
+
+// connect open a connection to the peripheral at address
+func connect(central *bgapi.Central, address bgapi.QualifiedMac) (*bgapi.Connection, error) {
+	conn := central.NewConnection(&bgapi.GapScanRespone{Address: address}, bgapi.DefaultConnectionParameters())
+	if err := conn.Open(); err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	bgapi "github.com/jsakwa/go_bgapi"
+	"github.com/jsakwa/go_bgapi/ota"
+)
+
+var otaCommand = &command{
+	name:  "ota",
+	args:  "[-public] [-chunk N] [-fast] ADDRESS|ALIAS IMAGE",
+	short: "update the firmware of a Silicon Labs peripheral",
+	run:   runOTA,
+}
+
+func runOTA(cmd *command, args []string) error {
+	fs := commandFlags(cmd)
+	public := fs.Bool("public", false, "the address is public rather than random")
+	chunk := fs.Int("chunk", 0, "bytes per data write, defaults to the OTA client's")
+	fast := fs.Bool("fast", false, "write the image without waiting for each chunk to be acknowledged")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errors.New("expected an address and an image")
+	}
+
+	address, err := parseAddress(fs.Arg(0), *public)
+	if err != nil {
+		return err
+	}
+	image, err := os.ReadFile(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	if err := ota.VerifyImage(image); err != nil {
+		return err
+	}
+
+	central, err := openCentral()
+	if err != nil {
+		return err
+	}
+	defer central.API().Close()
+
+	bar := &progressBar{}
+	opts := &ota.Options{ChunkSize: *chunk, WithoutResponse: *fast, Progress: bar}
+	fmt.Fprintf(os.Stderr, "connecting to %s\n", address.Address)
+	client, err := ota.Dial(central, address, opts)
+	if err != nil {
+		return err
+	}
+
+	err = client.Update(image)
+	bar.done()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, "image verified, rebooting the peripheral")
+	return client.Close()
+}
+
+// progressBar renders the update progress on the standard error
+type progressBar struct {
+	phase   string
+	percent int
+}
+
+const progressBarWidth = 40
+
+// Progress implements bgapi.ProgressReporter
+func (b *progressBar) Progress(p bgapi.Progress) {
+	if p.Total == 0 {
+		if p.Phase != b.phase {
+			b.done()
+			fmt.Fprintf(os.Stderr, "%s\n", p.Phase)
+		}
+		b.phase = p.Phase
+		return
+	}
+
+	percent := int(p.Percent())
+	if p.Phase == b.phase && percent == b.percent {
+		return
+	}
+	b.phase, b.percent = p.Phase, percent
+
+	filled := percent * progressBarWidth / 100
+	fmt.Fprintf(os.Stderr, "\r%s [%s%s] %3d%% %d/%d", p.Phase,
+		strings.Repeat("#", filled), strings.Repeat(" ", progressBarWidth-filled), percent, p.Done, p.Total)
+}
+
+// done end the current bar line, if any
+func (b *progressBar) done() {
+	if b.percent > 0 {
+		fmt.Fprintln(os.Stderr)
+		b.percent = 0
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	bgapi "github.com/jsakwa/go_bgapi"
+)
+
+var pairCommand = &command{
+	name:  "pair",
+	args:  "[-public] [-io CAP] [-mitm] ADDRESS|ALIAS",
+	short: "pair and bond with a peripheral",
+	run:   runPair,
+}
+
+var ioCapabilities = map[string]bgapi.SmIOCapabilities{
+	"display":          bgapi.SmIODisplayOnly,
+	"display-yesno":    bgapi.SmIODisplayYesNo,
+	"keyboard":         bgapi.SmIOKeyboardOnly,
+	"none":             bgapi.SmIONoInputNoOutput,
+	"keyboard-display": bgapi.SmIOKeyboardDisplay,
+}
+
+func runPair(cmd *command, args []string) error {
+	fs := commandFlags(cmd)
+	public := fs.Bool("public", false, "the address is public rather than random")
+	io := fs.String("io", "keyboard-display", "IO capabilities: display, display-yesno, keyboard, none or keyboard-display")
+	mitm := fs.Bool("mitm", false, "require man-in-the-middle protection")
+	keySize := fs.Uint("keysize", 16, "minimum encryption key size (7-16)")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected an address")
+	}
+
+	ioCap, ok := ioCapabilities[*io]
+	if !ok {
+		return fmt.Errorf("unknown IO capabilities %q", *io)
+	}
+	if *keySize < 7 || *keySize > 16 {
+		return fmt.Errorf("key size %d out of range", *keySize)
+	}
+	opts := &bgapi.PairingOptions{IO: ioCap, MITM: *mitm, MinKeySize: byte(*keySize), UI: terminalPairingUI{}}
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	address, err := parseAddress(fs.Arg(0), *public)
+	if err != nil {
+		return err
+	}
+
+	central, err := openCentral()
+	if err != nil {
+		return err
+	}
+	defer central.API().Close()
+
+	fmt.Printf("connecting to %s\n", address.Address)
+	conn, err := connect(central, address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	fmt.Println("pairing")
+	bond, err := conn.Pair(opts)
+	if err != nil {
+		return err
+	}
+
+	if bond.Bond == 0xff {
+		fmt.Println("paired, bond not stored")
+	} else {
+		fmt.Printf("bonded, handle %d\n", bond.Bond)
+	}
+	fmt.Printf("key size: %d bytes\nMITM protection: %v\nkeys exchanged: %s\n", bond.KeySize, bond.MITM, bond.Keys)
+	return nil
+}
+
+// terminalPairingUI prompts for passkeys on the terminal
+type terminalPairingUI struct{}
+
+// DisplayPasskey implements bgapi.PairingUI
+func (terminalPairingUI) DisplayPasskey(passkey uint32) {
+	fmt.Printf("enter passkey %06d on the peripheral\n", passkey)
+}
+
+// RequestPasskey implements bgapi.PairingUI
+func (terminalPairingUI) RequestPasskey() (uint32, error) {
+	fmt.Print("passkey displayed by the peripheral: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return 0, err
+	}
+	passkey, err := strconv.ParseUint(strings.TrimSpace(line), 10, 32)
+	if err != nil || passkey > 999999 {
+		return 0, fmt.Errorf("invalid passkey %q", strings.TrimSpace(line))
+	}
+	return uint32(passkey), nil
+}
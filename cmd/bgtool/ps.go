@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	bgapi "github.com/jsakwa/go_bgapi"
+)
+
+var psCommand = &command{
+	name:  "ps",
+	args:  "dump | get KEY | set [-string] KEY VALUE | erase KEY | backup FILE | restore FILE",
+	short: "inspect and edit the persistent store",
+	run:   runPS,
+}
+
+func runPS(cmd *command, args []string) error {
+	fs := commandFlags(cmd)
+	asString := fs.Bool("string", false, "set: VALUE is a string rather than hex")
+	if len(args) == 0 {
+		fs.Usage()
+		return errors.New("expected a PS command")
+	}
+	fs.Parse(args[1:])
+
+	op, operands := args[0], fs.Args()
+	want := map[string]int{"dump": 0, "get": 1, "set": 2, "erase": 1, "backup": 1, "restore": 1}
+	n, ok := want[op]
+	if !ok {
+		return fmt.Errorf("unknown PS command %q", op)
+	}
+	if len(operands) != n {
+		fs.Usage()
+		return fmt.Errorf("ps %s expects %d arguments", op, n)
+	}
+
+	api, err := openAPI()
+	if err != nil {
+		return err
+	}
+	defer api.Close()
+
+	switch op {
+	case "dump":
+		entries, err := api.PSDump()
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			printPSEntry(e.Key, e.Value)
+		}
+		return nil
+
+	case "get":
+		key, err := parsePSKey(operands[0])
+		if err != nil {
+			return err
+		}
+		value, err := api.PSLoad(key)
+		if err != nil {
+			return err
+		}
+		printPSEntry(key, value)
+		return nil
+
+	case "set":
+		key, err := parsePSKey(operands[0])
+		if err != nil {
+			return err
+		}
+		value := []byte(operands[1])
+		if !*asString {
+			if value, err = parseHex(operands[1]); err != nil {
+				return err
+			}
+		}
+		return api.PSSave(key, value)
+
+	case "erase":
+		key, err := parsePSKey(operands[0])
+		if err != nil {
+			return err
+		}
+		return api.PSErase(key)
+
+	case "backup":
+		entries, err := api.PSDump()
+		if err != nil {
+			return err
+		}
+		return writeFile(operands[0], func(w io.Writer) error {
+			return writePSBackup(w, entries)
+		})
+
+	default: // restore
+		f, err := os.Open(operands[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		entries, err := readPSBackup(f)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if err := api.PSSave(e.Key, e.Value); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("restored %d keys\n", len(entries))
+		return nil
+	}
+}
+
+// parsePSKey parse a key number (decimal or 0x hex) or a known key name
+func parsePSKey(s string) (uint16, error) {
+	if s == "address" {
+		return bgapi.DefaultAddressPSKey, nil
+	}
+	key, err := strconv.ParseUint(s, 0, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid PS key %q", s)
+	}
+	return uint16(key), nil
+}
+
+// parseHex parse a hex value, bytes may be separated by colons or spaces
+func parseHex(s string) ([]byte, error) {
+	s = strings.TrimPrefix(strings.ToLower(s), "0x")
+	s = strings.NewReplacer(":", "", " ", "").Replace(s)
+	value, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex value: %v", err)
+	}
+	return value, nil
+}
+
+// printPSEntry print a key with its name, the value in hex and, when it
+// is printable, as a string
+func printPSEntry(key uint16, value []byte) {
+	fmt.Printf("0x%04x  %-10s %s", key, bgapi.PSKeyName(key), hex.EncodeToString(value))
+	if printable(value) {
+		fmt.Printf("  %q", value)
+	}
+	fmt.Println()
+}
+
+func printable(value []byte) bool {
+	if len(value) == 0 {
+		return false
+	}
+	for _, r := range string(value) {
+		if r == unicode.ReplacementChar || !unicode.IsPrint(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// writePSBackup write one "KEY HEXVALUE" line per entry
+func writePSBackup(w io.Writer, entries []bgapi.PSEntry) error {
+	for _, e := range entries {
+		if _, err := fmt.Fprintf(w, "0x%04x %s\n", e.Key, hex.EncodeToString(e.Value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readPSBackup read a backup written by writePSBackup, blank lines and
+// lines starting with # are ignored
+func readPSBackup(r io.Reader) ([]bgapi.PSEntry, error) {
+	var entries []bgapi.PSEntry
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("line %d: expected a key and a value", line)
+		}
+		key, err := parsePSKey(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		var value []byte
+		if len(fields) == 2 {
+			if value, err = parseHex(fields[1]); err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+		}
+		entries = append(entries, bgapi.PSEntry{Key: key, Value: value})
+	}
+	return entries, scanner.Err()
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	bgapi "github.com/jsakwa/go_bgapi"
+)
+
+var sniffCommand = &command{
+	name:  "sniff-adv",
+	args:  "[-o FILE] [-format json|csv|influx] [-active] [-duration D] [-dedup first|change|D]",
+	short: "log every advertisement as NDJSON, CSV or InfluxDB lines",
+	run:   runSniff,
+}
+
+// advRecord an advertisement as logged by sniff-adv
+type advRecord struct {
+	Time             time.Time         `json:"time"`
+	Address          string            `json:"address"`
+	AddressType      string            `json:"address_type"`
+	RSSI             int8              `json:"rssi"`
+	PacketType       byte              `json:"packet_type"`
+	Data             string            `json:"data"`
+	Flags            *byte             `json:"flags,omitempty"`
+	Name             string            `json:"name,omitempty"`
+	TxPower          *int8             `json:"tx_power,omitempty"`
+	Services         []string          `json:"services,omitempty"`
+	Company          *uint16           `json:"company,omitempty"`
+	CompanyName      string            `json:"company_name,omitempty"`
+	ManufacturerData string            `json:"manufacturer_data,omitempty"`
+	ServiceData      map[string]string `json:"service_data,omitempty"`
+	IBeacon          *iBeaconRecord    `json:"ibeacon,omitempty"`
+	Eddystone        *eddystoneRecord  `json:"eddystone,omitempty"`
+}
+
+type iBeaconRecord struct {
+	UUID          string `json:"uuid"`
+	Major         uint16 `json:"major"`
+	Minor         uint16 `json:"minor"`
+	MeasuredPower int8   `json:"measured_power"`
+}
+
+type eddystoneRecord struct {
+	Frame       string   `json:"frame"`
+	TxPower     int8     `json:"tx_power,omitempty"`
+	Namespace   string   `json:"namespace,omitempty"`
+	Instance    string   `json:"instance,omitempty"`
+	URL         string   `json:"url,omitempty"`
+	Battery     uint16   `json:"battery_mv,omitempty"`
+	Temperature *float64 `json:"temperature,omitempty"`
+	AdvCount    uint32   `json:"adv_count,omitempty"`
+	Uptime      float64  `json:"uptime_s,omitempty"`
+	EID         string   `json:"eid,omitempty"`
+}
+
+func runSniff(cmd *command, args []string) error {
+	fs := commandFlags(cmd)
+	output := fs.String("o", "-", "output file, - for the standard output")
+	active := fs.Bool("active", false, "send scan requests to collect scan responses")
+	duration := fs.Duration("duration", 0, "stop after this long, 0 runs until interrupted")
+	format := fs.String("format", "json", "output format: json, csv or influx")
+	dedup := fs.String("dedup", "", "log only the first advertisement of each device, those that changed, or one per duration")
+	fs.Parse(args)
+	switch *format {
+	case "json", "csv", "influx":
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+	policy, interval, err := parseDedup(*dedup)
+	if err != nil {
+		return err
+	}
+
+	central, err := openCentral()
+	if err != nil {
+		return err
+	}
+	defer central.API().Close()
+	central.DuplicatePolicy = policy
+	central.DuplicateInterval = interval
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	if *duration > 0 {
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+
+	if *active {
+		central.ScanRequestEnable()
+	} else {
+		central.ScanRequestDisable()
+	}
+
+	return writeFile(*output, func(w io.Writer) error {
+		switch *format {
+		case "csv":
+			return central.ExportScan(ctx, bgapi.GapDiscoverObservation, bgapi.NewCSVExporter(w))
+		case "influx":
+			return central.ExportScan(ctx, bgapi.GapDiscoverObservation, bgapi.NewInfluxExporter(w, ""))
+		}
+
+		enc := json.NewEncoder(w)
+		var mutex sync.Mutex
+		var writeErr error
+		stopped := false
+		central.OnScanResponse = func(resp *bgapi.GapScanRespone) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			if !stopped && writeErr == nil {
+				writeErr = enc.Encode(newAdvRecord(resp))
+			}
+		}
+
+		err := central.Scan(ctx, bgapi.GapDiscoverObservation)
+		mutex.Lock()
+		defer mutex.Unlock()
+		stopped = true
+		if writeErr != nil {
+			return writeErr
+		}
+		if err == context.Canceled || err == context.DeadlineExceeded {
+			return nil
+		}
+		return err
+	})
+}
+
+func newAdvRecord(resp *bgapi.GapScanRespone) *advRecord {
+	r := &advRecord{
+		Time:        time.Now().UTC(),
+		Address:     resp.Address.Address.String(),
+		AddressType: "public",
+		RSSI:        resp.RSSI,
+		PacketType:  resp.PacketType,
+		Data:        hex.EncodeToString(resp.Data),
+	}
+	if resp.Address.AddrType == bgapi.AddrTypeRandom {
+		r.AddressType = "random"
+	}
+
+	adv := *bgapi.ParseGapScanResponse(resp)
+	if flags, ok := adv.Flags(); ok {
+		r.Flags = &flags
+	}
+	r.Name = adv.LocalName()
+	if power, ok := adv.TxPower(); ok {
+		r.TxPower = &power
+	}
+	for _, uuid := range adv.ServiceUUIDs() {
+		r.Services = append(r.Services, bgapi.UUIDString(uuid))
+	}
+	if company, data, ok := adv.ManufacturerData(); ok {
+		r.Company = &company
+		r.CompanyName = bgapi.CompanyName(company)
+		r.ManufacturerData = hex.EncodeToString(data)
+	}
+	if uuid, data, ok := adv.ServiceData(); ok {
+		r.ServiceData = map[string]string{bgapi.UUIDString([]byte{byte(uuid), byte(uuid >> 8)}): hex.EncodeToString(data)}
+	}
+
+	if b, ok := adv.IBeacon(); ok {
+		r.IBeacon = &iBeaconRecord{UUID: bgapi.UUIDString(b.UUID), Major: b.Major, Minor: b.Minor, MeasuredPower: b.MeasuredPower}
+	}
+	if e, ok := adv.Eddystone(); ok {
+		er := &eddystoneRecord{Frame: e.Frame.String(), TxPower: e.TxPower, URL: e.URL,
+			Namespace: hex.EncodeToString(e.Namespace), Instance: hex.EncodeToString(e.Instance), EID: hex.EncodeToString(e.EID)}
+		if e.Frame == bgapi.EddystoneTLM {
+			temperature := e.Temperature
+			er.Battery, er.Temperature, er.AdvCount, er.Uptime = e.BatteryMillivolts, &temperature, e.AdvCount, e.Uptime.Seconds()
+		}
+		r.Eddystone = er
+	}
+	return r
+}
+
+// parseDedup parse the -dedup flag of sniff-adv
+func parseDedup(s string) (bgapi.DuplicatePolicy, time.Duration, error) {
+	switch s {
+	case "":
+		return bgapi.DuplicatesReport, 0, nil
+	case "first":
+		return bgapi.DuplicatesFirst, 0, nil
+	case "change":
+		return bgapi.DuplicatesOnChange, 0, nil
+	}
+	interval, err := time.ParseDuration(s)
+	if err != nil || interval <= 0 {
+		return 0, 0, fmt.Errorf("-dedup expects first, change or a duration, not %q", s)
+	}
+	return bgapi.DuplicatesInterval, interval, nil
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"io"
+)
+
+var supportCommand = &command{
+	name:  "support-bundle",
+	args:  "[-o FILE]",
+	short: "write a zip archive describing the device, for bug reports",
+	run:   runSupport,
+}
+
+func runSupport(cmd *command, args []string) error {
+	fs := commandFlags(cmd)
+	output := fs.String("o", "bgapi-support.zip", "output file, - for the standard output")
+	fs.Parse(args)
+
+	api, err := openAPI()
+	if err != nil {
+		return err
+	}
+	defer api.Close()
+
+	return writeFile(*output, func(w io.Writer) error {
+		return api.SupportBundle(w)
+	})
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+var usbCommand = &command{
+	name:  "usb",
+	args:  "on|off",
+	short: "enable or disable the USB interface of the module",
+	run:   runUSB,
+}
+
+func runUSB(cmd *command, args []string) error {
+	fs := commandFlags(cmd)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected on or off")
+	}
+	var enable bool
+	switch fs.Arg(0) {
+	case "on":
+		enable = true
+	case "off":
+		enable = false
+	default:
+		return fmt.Errorf("expected on or off, not %q", fs.Arg(0))
+	}
+
+	api, err := openAPI()
+	if err != nil {
+		return err
+	}
+	defer api.Close()
+
+	return api.EnableUSB(enable)
+}
@@ -0,0 +1,403 @@
+package bgapi
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// connection timing units
+const (
+	connIntervalUnit = 1250 * time.Microsecond
+	connTimeoutUnit  = 10 * time.Millisecond
+)
+
+// Duration a time.Duration written as a string (e.g. "100ms") in
+// configuration files
+type Duration time.Duration
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// Config declarative settings of a dongle and of the central driving it,
+// tagged for JSON, YAML and TOML. Open the port with Port and
+// SerialOptions, then apply the rest with ApplyConfig. Zero fields keep
+// the module defaults.
+type Config struct {
+	// Port serial port of the dongle, e.g. /dev/ttyACM0
+	Port string `json:"port" yaml:"port" toml:"port"`
+	// Baud baud rate, defaults to 115200
+	Baud int `json:"baud,omitempty" yaml:"baud,omitempty" toml:"baud,omitempty"`
+	// FlowControl enable RTS/CTS flow control
+	FlowControl bool `json:"flow_control,omitempty" yaml:"flow_control,omitempty" toml:"flow_control,omitempty"`
+
+	Scan        ScanConfig       `json:"scan" yaml:"scan" toml:"scan"`
+	Advertising AdvConfig        `json:"advertising" yaml:"advertising" toml:"advertising"`
+	Connection  ConnectionConfig `json:"connection" yaml:"connection" toml:"connection"`
+	Filter      FilterConfig     `json:"filter" yaml:"filter" toml:"filter"`
+	Reconnect   ReconnectPolicy  `json:"reconnect" yaml:"reconnect" toml:"reconnect"`
+	Security    SecurityConfig   `json:"security" yaml:"security" toml:"security"`
+}
+
+// ScanConfig scan timings, either a preset ("low-power", "balanced" or
+// "low-latency") or an explicit interval and window
+type ScanConfig struct {
+	Preset   string   `json:"preset,omitempty" yaml:"preset,omitempty" toml:"preset,omitempty"`
+	Interval Duration `json:"interval,omitempty" yaml:"interval,omitempty" toml:"interval,omitempty"`
+	Window   Duration `json:"window,omitempty" yaml:"window,omitempty" toml:"window,omitempty"`
+	// Active send scan requests to obtain scan responses
+	Active bool `json:"active,omitempty" yaml:"active,omitempty" toml:"active,omitempty"`
+}
+
+// AdvConfig advertising timings, either a preset ("fast", "balanced" or
+// "low-power") or explicit intervals; Channels lists channels among 37, 38
+// and 39, all by default
+type AdvConfig struct {
+	Preset      string   `json:"preset,omitempty" yaml:"preset,omitempty" toml:"preset,omitempty"`
+	IntervalMin Duration `json:"interval_min,omitempty" yaml:"interval_min,omitempty" toml:"interval_min,omitempty"`
+	IntervalMax Duration `json:"interval_max,omitempty" yaml:"interval_max,omitempty" toml:"interval_max,omitempty"`
+	Channels    []int    `json:"channels,omitempty" yaml:"channels,omitempty" toml:"channels,omitempty"`
+}
+
+// ConnectionConfig parameters of new connections, either a preset
+// ("default", "fast" or "low-power") or explicit timings
+type ConnectionConfig struct {
+	Preset      string   `json:"preset,omitempty" yaml:"preset,omitempty" toml:"preset,omitempty"`
+	IntervalMin Duration `json:"interval_min,omitempty" yaml:"interval_min,omitempty" toml:"interval_min,omitempty"`
+	IntervalMax Duration `json:"interval_max,omitempty" yaml:"interval_max,omitempty" toml:"interval_max,omitempty"`
+	// Timeout supervision timeout
+	Timeout Duration `json:"timeout,omitempty" yaml:"timeout,omitempty" toml:"timeout,omitempty"`
+	// Latency connection events the peripheral may skip
+	Latency uint16 `json:"latency,omitempty" yaml:"latency,omitempty" toml:"latency,omitempty"`
+}
+
+// connection presets
+var connectionPresets = map[string]ConnectionParameters{
+	"default":   *DefaultConnectionParameters(),
+	"fast":      {IntervalMin: 6, IntervalMax: 12, Timeout: 100, Latency: 0},
+	"low-power": {IntervalMin: 400, IntervalMax: 800, Timeout: 600, Latency: 4},
+}
+
+// FilterConfig scan and advertising filters. Scan is "all" or
+// "whitelist", Advertising is "all", "whitelist-scan", "whitelist-connect"
+// or "whitelist-all".
+type FilterConfig struct {
+	Scan        string `json:"scan,omitempty" yaml:"scan,omitempty" toml:"scan,omitempty"`
+	Advertising string `json:"advertising,omitempty" yaml:"advertising,omitempty" toml:"advertising,omitempty"`
+	// Duplicates report each advertiser once per scan
+	Duplicates bool `json:"duplicates,omitempty" yaml:"duplicates,omitempty" toml:"duplicates,omitempty"`
+	// Whitelist replaces the whitelist of the module when not empty
+	Whitelist []WhitelistEntry `json:"whitelist,omitempty" yaml:"whitelist,omitempty" toml:"whitelist,omitempty"`
+}
+
+// WhitelistEntry an address written as printed by Mac.String
+type WhitelistEntry struct {
+	Address string `json:"address" yaml:"address" toml:"address"`
+	Public  bool   `json:"public,omitempty" yaml:"public,omitempty" toml:"public,omitempty"`
+}
+
+// ReconnectPolicy how an application retries lost connections, the
+// library itself does not reconnect
+type ReconnectPolicy struct {
+	// Attempts retries before giving up, zero disables reconnecting and a
+	// negative value retries forever
+	Attempts int `json:"attempts,omitempty" yaml:"attempts,omitempty" toml:"attempts,omitempty"`
+	// Delay before the first retry, doubled after each failure
+	Delay Duration `json:"delay,omitempty" yaml:"delay,omitempty" toml:"delay,omitempty"`
+	// MaxDelay bound on the delay, unbounded when zero
+	MaxDelay Duration `json:"max_delay,omitempty" yaml:"max_delay,omitempty" toml:"max_delay,omitempty"`
+}
+
+// Backoff returns the delay before retry attempt (counted from 0), and
+// false once the policy gives up
+func (p *ReconnectPolicy) Backoff(attempt int) (time.Duration, bool) {
+	if p.Attempts == 0 || (p.Attempts > 0 && attempt >= p.Attempts) {
+		return 0, false
+	}
+	return backoff(time.Duration(p.Delay), time.Duration(p.MaxDelay), attempt), true
+}
+
+// SecurityConfig pairing requirements. IO is "display-only",
+// "display-yes-no", "keyboard-only", "no-input-no-output" (the default)
+// or "keyboard-display".
+type SecurityConfig struct {
+	IO         string `json:"io,omitempty" yaml:"io,omitempty" toml:"io,omitempty"`
+	MITM       bool   `json:"mitm,omitempty" yaml:"mitm,omitempty" toml:"mitm,omitempty"`
+	MinKeySize byte   `json:"min_key_size,omitempty" yaml:"min_key_size,omitempty" toml:"min_key_size,omitempty"`
+	// Bondable let peers store a bond with the module
+	Bondable bool `json:"bondable,omitempty" yaml:"bondable,omitempty" toml:"bondable,omitempty"`
+}
+
+var ioCapabilityNames = map[string]SmIOCapabilities{
+	"display-only":       SmIODisplayOnly,
+	"display-yes-no":     SmIODisplayYesNo,
+	"keyboard-only":      SmIOKeyboardOnly,
+	"no-input-no-output": SmIONoInputNoOutput,
+	"keyboard-display":   SmIOKeyboardDisplay,
+}
+
+var scanPolicyNames = map[string]ScanPolicy{
+	"all":       ScanPolicyAll,
+	"whitelist": ScanPolicyWhitelist,
+}
+
+var advPolicyNames = map[string]AdvPolicy{
+	"all":               AdvPolicyAll,
+	"whitelist-scan":    AdvPolicyWhitelistScan,
+	"whitelist-connect": AdvPolicyWhitelistConnect,
+	"whitelist-all":     AdvPolicyWhitelistAll,
+}
+
+// SerialOptions returns the serial settings of the configuration
+func (cfg *Config) SerialOptions() *SerialOptions {
+	return &SerialOptions{Baud: cfg.Baud, HardwareFlowControl: cfg.FlowControl}
+}
+
+// ScanParameters returns the scan timings, nil when not configured
+func (cfg *Config) ScanParameters() (*ScanParameters, error) {
+	s := &cfg.Scan
+	var p ScanParameters
+	switch s.Preset {
+	case "":
+		if s.Interval == 0 && s.Window == 0 {
+			return nil, nil
+		}
+		p = ScanParameters{Interval: GapUnits(time.Duration(s.Interval)), Window: GapUnits(time.Duration(s.Window))}
+	case "low-power":
+		p = ScanLowPower
+	case "balanced":
+		p = ScanBalanced
+	case "low-latency":
+		p = ScanLowLatency
+	default:
+		return nil, fmt.Errorf("unknown scan preset %q", s.Preset)
+	}
+	p.Active = s.Active
+	return &p, p.Validate()
+}
+
+// AdvParameters returns the advertising timings, nil when not configured
+func (cfg *Config) AdvParameters() (*AdvParameters, error) {
+	a := &cfg.Advertising
+	var p AdvParameters
+	switch a.Preset {
+	case "":
+		if a.IntervalMin == 0 && a.IntervalMax == 0 {
+			return nil, nil
+		}
+		p = AdvParameters{IntervalMin: GapUnits(time.Duration(a.IntervalMin)), IntervalMax: GapUnits(time.Duration(a.IntervalMax))}
+	case "fast":
+		p = AdvFast
+	case "balanced":
+		p = AdvBalanced
+	case "low-power":
+		p = AdvLowPower
+	default:
+		return nil, fmt.Errorf("unknown advertising preset %q", a.Preset)
+	}
+
+	p.Channels = AdvChannelsAll
+	if len(a.Channels) > 0 {
+		p.Channels = 0
+		for _, ch := range a.Channels {
+			if ch < 37 || ch > 39 {
+				return nil, fmt.Errorf("invalid advertising channel %d", ch)
+			}
+			p.Channels |= AdvChannel37 << uint(ch-37)
+		}
+	}
+	return &p, p.Validate()
+}
+
+// ConnectionParameters returns the parameters of new connections,
+// DefaultConnectionParameters when not configured
+func (cfg *Config) ConnectionParameters() (*ConnectionParameters, error) {
+	c := &cfg.Connection
+	if c.Preset != "" {
+		p, ok := connectionPresets[c.Preset]
+		if !ok {
+			return nil, fmt.Errorf("unknown connection preset %q", c.Preset)
+		}
+		return &p, nil
+	}
+
+	p := DefaultConnectionParameters()
+	if c.IntervalMin != 0 {
+		p.IntervalMin = uint16(time.Duration(c.IntervalMin) / connIntervalUnit)
+	}
+	if c.IntervalMax != 0 {
+		p.IntervalMax = uint16(time.Duration(c.IntervalMax) / connIntervalUnit)
+	}
+	if c.Timeout != 0 {
+		p.Timeout = uint16(time.Duration(c.Timeout) / connTimeoutUnit)
+	}
+	p.Latency = c.Latency
+	if p.IntervalMin > p.IntervalMax {
+		return nil, fmt.Errorf("connection interval minimum %v above the maximum %v",
+			time.Duration(p.IntervalMin)*connIntervalUnit, time.Duration(p.IntervalMax)*connIntervalUnit)
+	}
+	return p, nil
+}
+
+// FilterPolicy returns the scan and advertising filters
+func (cfg *Config) FilterPolicy() (*FilterPolicy, error) {
+	f := &cfg.Filter
+	policy := &FilterPolicy{DuplicateFiltering: f.Duplicates}
+	if f.Scan != "" {
+		scan, ok := scanPolicyNames[f.Scan]
+		if !ok {
+			return nil, fmt.Errorf("unknown scan filter %q", f.Scan)
+		}
+		policy.Scan = scan
+	}
+	if f.Advertising != "" {
+		adv, ok := advPolicyNames[f.Advertising]
+		if !ok {
+			return nil, fmt.Errorf("unknown advertising filter %q", f.Advertising)
+		}
+		policy.Adv = adv
+	}
+	return policy, nil
+}
+
+// Whitelist returns the parsed whitelist addresses
+func (cfg *Config) Whitelist() ([]QualifiedMac, error) {
+	addresses := make([]QualifiedMac, 0, len(cfg.Filter.Whitelist))
+	for _, entry := range cfg.Filter.Whitelist {
+		mac, err := ParseMac(strings.ToLower(entry.Address))
+		if err != nil {
+			return nil, err
+		}
+		addrType := AddrTypeRandom
+		if entry.Public {
+			addrType = AddrTypePublic
+		}
+		address, err := NewQualifiedMac(mac, addrType)
+		if err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, address)
+	}
+	return addresses, nil
+}
+
+// PairingOptions returns the pairing requirements, the UI is left to the
+// caller
+func (cfg *Config) PairingOptions() (*PairingOptions, error) {
+	s := &cfg.Security
+	opts := &PairingOptions{IO: SmIONoInputNoOutput, MITM: s.MITM, MinKeySize: s.MinKeySize}
+	if s.IO != "" {
+		io, ok := ioCapabilityNames[s.IO]
+		if !ok {
+			return nil, fmt.Errorf("unknown IO capabilities %q", s.IO)
+		}
+		opts.IO = io
+	}
+	if opts.MinKeySize != 0 && (opts.MinKeySize < 7 || opts.MinKeySize > 16) {
+		return nil, fmt.Errorf("minimum key size %d outside 7-16", opts.MinKeySize)
+	}
+	return opts, nil
+}
+
+// Validate check every section of the configuration
+func (cfg *Config) Validate() error {
+	if _, err := cfg.ScanParameters(); err != nil {
+		return err
+	}
+	if _, err := cfg.AdvParameters(); err != nil {
+		return err
+	}
+	if _, err := cfg.ConnectionParameters(); err != nil {
+		return err
+	}
+	if _, err := cfg.FilterPolicy(); err != nil {
+		return err
+	}
+	if _, err := cfg.Whitelist(); err != nil {
+		return err
+	}
+	_, err := cfg.PairingOptions()
+	return err
+}
+
+// ApplyConfig validate cfg and apply the scan, advertising, filter and
+// security settings to the open module. Connection parameters and the
+// reconnect policy are used by the application, see
+// Config.ConnectionParameters and ReconnectPolicy.Backoff.
+func ApplyConfig(api *API, cfg *Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	scan, _ := cfg.ScanParameters()
+	if scan != nil {
+		if err := api.GapApplyScanParameters(scan); err != nil {
+			return err
+		}
+	}
+	adv, _ := cfg.AdvParameters()
+	if adv != nil {
+		if err := api.GapApplyAdvParameters(adv); err != nil {
+			return err
+		}
+	}
+
+	whitelist, _ := cfg.Whitelist()
+	if len(whitelist) > 0 {
+		if err := api.SystemWhitelistClear(); err != nil {
+			return err
+		}
+		for _, address := range whitelist {
+			if err := api.SystemWhitelistAppend(address, func(uint16) {}); err != nil {
+				return err
+			}
+		}
+	}
+	policy, _ := cfg.FilterPolicy()
+	if err := api.GapSetFiltering(policy); err != nil {
+		return err
+	}
+
+	pairing, _ := cfg.PairingOptions()
+	keySize := pairing.MinKeySize
+	if keySize == 0 {
+		keySize = 16
+	}
+	if err := api.SmSetBondableMode(boolCast(cfg.Security.Bondable)); err != nil {
+		return err
+	}
+	if err := api.SmSetParameters(boolCast(pairing.MITM), keySize, byte(pairing.IO)); err != nil {
+		return err
+	}
+
+	applied := *cfg
+	api.mutex.Lock()
+	api.config = &applied
+	api.mutex.Unlock()
+	return nil
+}
+
+// ApplyConfig apply cfg to the central's module, keeping the scan timings
+// for the central's own scans
+func (c *Central) ApplyConfig(cfg *Config) error {
+	if err := ApplyConfig(c.api, cfg); err != nil {
+		return err
+	}
+	if scan, _ := cfg.ScanParameters(); scan != nil {
+		c.ScanInterval = scan.Interval
+		c.ScanWindow = scan.Window
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+// Package config loads bgapi.Config from JSON, YAML or TOML files
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	bgapi "github.com/jsakwa/go_bgapi"
+	"gopkg.in/yaml.v3"
+)
+
+// Format of a configuration file
+type Format string
+
+// supported formats
+const (
+	JSON Format = "json"
+	YAML Format = "yaml"
+	TOML Format = "toml"
+)
+
+// FormatOf returns the format matching the extension of path
+func FormatOf(path string) (Format, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return JSON, nil
+	case ".yaml", ".yml":
+		return YAML, nil
+	case ".toml":
+		return TOML, nil
+	}
+	return "", fmt.Errorf("cannot tell the format of %s from its extension", path)
+}
+
+// Parse decode and validate a configuration, unknown fields are rejected
+// so that typos do not go unnoticed
+func Parse(data []byte, format Format) (*bgapi.Config, error) {
+	cfg := &bgapi.Config{}
+	var err error
+	switch format {
+	case JSON:
+		d := json.NewDecoder(bytes.NewReader(data))
+		d.DisallowUnknownFields()
+		err = d.Decode(cfg)
+	case YAML:
+		d := yaml.NewDecoder(bytes.NewReader(data))
+		d.KnownFields(true)
+		err = d.Decode(cfg)
+	case TOML:
+		var md toml.MetaData
+		md, err = toml.Decode(string(data), cfg)
+		if err == nil && len(md.Undecoded()) > 0 {
+			err = fmt.Errorf("unknown field %s", md.Undecoded()[0])
+		}
+	default:
+		return nil, fmt.Errorf("unknown configuration format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Load read and parse the configuration file at path, its format is given
+// by its extension
+func Load(path string) (*bgapi.Config, error) {
+	format, err := FormatOf(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := Parse(data, format)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return cfg, nil
+}
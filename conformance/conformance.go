@@ -0,0 +1,584 @@
+// Package conformance exercises every BGAPI command class against a real
+// module and checks the responses and events, to validate changes of the
+// encoders and decoders on hardware. Commands wrapped without a completion
+// are checked through the responses traced by the API.
+//
+// The checks are safe to run on a development dongle: they leave the
+// whitelist empty and the module neither advertising nor scanning, and only
+// touch the flash, the local GATT database or a peer when told to.
+//
+// They run as tests with the hardware build tag, the module given by the
+// -port flag or BGAPI_PORT:
+//
+//	go test -tags=hardware ./conformance -port /dev/ttyACM0
+//
+// and from applications with Run, e.g. 'bgtool conformance'.
+package conformance
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	bgapi "github.com/jsakwa/go_bgapi"
+)
+
+// defaultTimeout bound on each check
+const defaultTimeout = 5 * time.Second
+
+// Options select the checks beyond those of the module alone
+type Options struct {
+	// Peer a connectable peripheral for the connection, attribute client
+	// and security manager checks, skipped when nil
+	Peer *bgapi.QualifiedMac
+	// LocalHandle a writable attribute of the local GATT database for the
+	// attributes checks, skipped when zero
+	LocalHandle uint16
+	// PSKey a user PS key the flash checks may overwrite and erase,
+	// skipped when zero
+	PSKey uint16
+	// Timeout bound on each check, defaults to 5 seconds
+	Timeout time.Duration
+}
+
+// Result the outcome of a check
+type Result struct {
+	// Class the command class, e.g. "system"
+	Class string
+	Name  string
+	Err   error
+	// Skipped why the check did not run, empty when it did
+	Skipped string
+	Elapsed time.Duration
+}
+
+// Passed returns true when the check ran and succeeded
+func (r *Result) Passed() bool {
+	return r.Skipped == "" && r.Err == nil
+}
+
+func (r *Result) String() string {
+	status := "ok"
+	switch {
+	case r.Skipped != "":
+		status = "skipped: " + r.Skipped
+	case r.Err != nil:
+		status = "FAIL: " + r.Err.Error()
+	}
+	return fmt.Sprintf("%-10s %-28s %s", r.Class, r.Name, status)
+}
+
+// Report the results of a run
+type Report struct {
+	// Firmware the firmware version of the module
+	Firmware string
+	Results  []Result
+}
+
+// Failed returns the checks that ran and failed
+func (r *Report) Failed() []Result {
+	var failed []Result
+	for _, result := range r.Results {
+		if result.Skipped == "" && result.Err != nil {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+func (r *Report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "firmware %s\n", r.Firmware)
+	for i := range r.Results {
+		fmt.Fprintln(&b, r.Results[i].String())
+	}
+	fmt.Fprintf(&b, "%d checks, %d failed\n", len(r.Results), len(r.Failed()))
+	return b.String()
+}
+
+// check a conformance check, skip returns why it cannot run
+type check struct {
+	class string
+	name  string
+	skip  func(s *suite) string
+	run   func(ctx context.Context, s *suite) error
+}
+
+// suite the state shared by the checks of a run
+type suite struct {
+	central *bgapi.Central
+	api     *bgapi.API
+	opts    Options
+	tracer  *responseTracer
+	conn    *bgapi.Connection // open once the connection check passed
+}
+
+// Run the checks against the module driven by central, which must be open
+// and idle. The trace hook of the API is replaced for the duration of the
+// run. Every check runs, even after a failure; those depending on a
+// connection are skipped when it cannot be opened.
+func Run(ctx context.Context, central *bgapi.Central, opts Options) *Report {
+	s := newSuite(central, opts)
+	defer s.close()
+
+	report := &Report{Firmware: "unknown"}
+	if version, known := s.api.FirmwareVersion(); known {
+		report.Firmware = version.String()
+	}
+	for _, c := range checks {
+		report.Results = append(report.Results, s.check(ctx, c))
+	}
+	return report
+}
+
+// newSuite prepare a run, tracing the responses of the API until close
+func newSuite(central *bgapi.Central, opts Options) *suite {
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultTimeout
+	}
+	s := &suite{central: central, api: central.API(), opts: opts, tracer: &responseTracer{}}
+	s.api.SetTraceHook(s.tracer)
+	return s
+}
+
+// check run a check unless it must be skipped
+func (s *suite) check(ctx context.Context, c check) Result {
+	result := Result{Class: c.class, Name: c.name}
+	if c.skip != nil {
+		result.Skipped = c.skip(s)
+	}
+	if result.Skipped == "" {
+		checkCtx, cancel := context.WithTimeout(ctx, s.opts.Timeout)
+		start := time.Now()
+		result.Err = c.run(checkCtx, s)
+		result.Elapsed = time.Since(start)
+		cancel()
+	}
+	return result
+}
+
+// close end a run, closing the connection the checks opened
+func (s *suite) close() {
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	s.api.SetTraceHook(nil)
+}
+
+// responseTracer a trace hook handing the responses to the checks waiting
+// for them
+type responseTracer struct {
+	bgapi.NopTraceHook
+
+	mutex   sync.Mutex
+	waiters map[uint16]chan []byte
+}
+
+// expect register a waiter for the next response to class and cmd
+func (t *responseTracer) expect(class byte, cmd byte) chan []byte {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.waiters == nil {
+		t.waiters = make(map[uint16]chan []byte)
+	}
+	c := make(chan []byte, 1)
+	t.waiters[uint16(class)<<8|uint16(cmd)] = c
+	return c
+}
+
+// OnResponse implements bgapi.TraceHook
+func (t *responseTracer) OnResponse(f *bgapi.TraceFrame) {
+	key := uint16(f.Class)<<8 | uint16(f.Command)
+	t.mutex.Lock()
+	c := t.waiters[key]
+	delete(t.waiters, key)
+	t.mutex.Unlock()
+
+	if c != nil {
+		c <- append([]byte(nil), f.Payload...)
+	}
+}
+
+// response submit a command through its wrapper and return the payload of
+// its response
+func (s *suite) response(ctx context.Context, class byte, cmd byte, submit func() error) ([]byte, error) {
+	c := s.tracer.expect(class, cmd)
+	if err := submit(); err != nil {
+		return nil, err
+	}
+	select {
+	case payload := <-c:
+		return payload, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("no response to %s: %w", bgapi.CommandName(class, cmd), ctx.Err())
+	}
+}
+
+// result submit a command through its wrapper and check the result
+// carried by its response at offset
+func (s *suite) result(ctx context.Context, class byte, cmd byte, offset int, submit func() error) ([]byte, error) {
+	payload, err := s.response(ctx, class, cmd, submit)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkResult(payload, offset); err != nil {
+		return nil, fmt.Errorf("%s: %w", bgapi.CommandName(class, cmd), err)
+	}
+	return payload, nil
+}
+
+// checkResult check the result field of a payload at offset
+func checkResult(payload []byte, offset int) error {
+	if len(payload) < offset+2 {
+		return fmt.Errorf("response of %d bytes has no result", len(payload))
+	}
+	if result := binary.LittleEndian.Uint16(payload[offset:]); result != 0 {
+		return &bgapi.ProcedureError{Result: result}
+	}
+	return nil
+}
+
+// await call a wrapper taking a completion and wait for its result
+func await[T any](ctx context.Context, submit func(completion func(T)) error) (T, error) {
+	c := make(chan T, 1)
+	var zero T
+	if err := submit(func(v T) { c <- v }); err != nil {
+		return zero, err
+	}
+	select {
+	case v := <-c:
+		return v, nil
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	}
+}
+
+// raw send a command as is and return its response
+func (s *suite) raw(ctx context.Context, class byte, cmd byte, payload ...byte) ([]byte, error) {
+	return s.api.SendRaw(ctx, class, cmd, payload)
+}
+
+// compare fail when the decoded and raw forms of a response differ
+func compare(what string, decoded []byte, raw []byte) error {
+	if !bytes.Equal(decoded, raw) {
+		return fmt.Errorf("%s decoded as %x, the module sent %x", what, decoded, raw)
+	}
+	return nil
+}
+
+func needPeer(s *suite) string {
+	if s.opts.Peer == nil {
+		return "no peer"
+	}
+	return ""
+}
+
+func needConnection(s *suite) string {
+	if s.opts.Peer == nil {
+		return "no peer"
+	}
+	if s.conn == nil {
+		return "not connected"
+	}
+	return ""
+}
+
+var errNoEvent = errors.New("expected event not received")
+
+var checks = []check{
+	{class: "system", name: "hello", run: func(ctx context.Context, s *suite) error {
+		_, err := await(ctx, func(done func(struct{})) error {
+			return s.api.SystemHello(func() { done(struct{}{}) })
+		})
+		return err
+	}},
+	{class: "system", name: "address_get", run: func(ctx context.Context, s *suite) error {
+		mac, err := await(ctx, s.api.SystemAddressGet)
+		if err != nil {
+			return err
+		}
+		raw, err := s.raw(ctx, 0, 2)
+		if err != nil {
+			return err
+		}
+		return compare("address", mac[:], raw)
+	}},
+	{class: "system", name: "get_info", run: func(ctx context.Context, s *suite) error {
+		info, err := await(ctx, s.api.SystemInfoGet)
+		if err != nil {
+			return err
+		}
+		raw, err := s.raw(ctx, 0, 8)
+		if err != nil {
+			return err
+		}
+		var decoded bytes.Buffer
+		binary.Write(&decoded, binary.LittleEndian, info)
+		return compare("system info", decoded.Bytes(), raw)
+	}},
+	{class: "system", name: "get_connections", run: func(ctx context.Context, s *suite) error {
+		n, err := await(ctx, s.api.SystemConnectionsGet)
+		if err != nil {
+			return err
+		}
+		raw, err := s.raw(ctx, 0, 6)
+		if err != nil {
+			return err
+		}
+		return compare("maximum connections", []byte{n}, raw)
+	}},
+	{class: "system", name: "get_counters", run: func(ctx context.Context, s *suite) error {
+		// the counters reset when read, only the shape can be compared
+		if _, err := await(ctx, s.api.SystemCountersGet); err != nil {
+			return err
+		}
+		raw, err := s.raw(ctx, 0, 5)
+		if err != nil {
+			return err
+		}
+		if len(raw) != 5 {
+			return fmt.Errorf("counters of %d bytes", len(raw))
+		}
+		return nil
+	}},
+	{class: "system", name: "whitelist", run: func(ctx context.Context, s *suite) error {
+		address := bgapi.QualifiedMac{Address: bgapi.Mac{0x01, 0x02, 0x03, 0x04, 0x05, 0xc6}, AddrType: bgapi.AddrTypeRandom}
+		if _, err := s.response(ctx, 0, 12, s.api.SystemWhitelistClear); err != nil {
+			return err
+		}
+		result, err := await(ctx, func(completion func(uint16)) error {
+			return s.api.SystemWhitelistAppend(address, completion)
+		})
+		if err != nil {
+			return err
+		}
+		if result != 0 {
+			return &bgapi.ProcedureError{Result: result}
+		}
+		if _, err := s.result(ctx, 0, 11, 0, func() error { return s.api.SystemWhitelistRemove(address) }); err != nil {
+			return err
+		}
+		_, err = s.response(ctx, 0, 12, s.api.SystemWhitelistClear)
+		return err
+	}},
+	{class: "system", name: "self-test", run: func(ctx context.Context, s *suite) error {
+		for _, r := range s.api.SelfTest() {
+			if !r.Passed() {
+				return fmt.Errorf("%s: %w", r.Name, r.Err)
+			}
+		}
+		return nil
+	}},
+
+	{class: "flash", name: "ps_save_load_erase", skip: func(s *suite) string {
+		if s.opts.PSKey == 0 {
+			return "no PS key"
+		}
+		return ""
+	}, run: func(ctx context.Context, s *suite) error {
+		key := s.opts.PSKey
+		value := []byte{0xc0, 0xff, 0xee, 0x42}
+		if err := s.api.PSSave(key, value); err != nil {
+			return err
+		}
+		loaded, err := s.api.PSLoad(key)
+		if err != nil {
+			return err
+		}
+		if err := compare("PS value", loaded, value); err != nil {
+			return err
+		}
+		if err := s.api.PSErase(key); err != nil {
+			return err
+		}
+		if _, err := s.api.PSLoad(key); err == nil {
+			return errors.New("erased key still loads")
+		}
+		return nil
+	}},
+
+	{class: "attributes", name: "write_read", skip: func(s *suite) string {
+		if s.opts.LocalHandle == 0 {
+			return "no local handle"
+		}
+		return ""
+	}, run: func(ctx context.Context, s *suite) error {
+		handle := s.opts.LocalHandle
+		value := []byte{0x5a, 0xa5, 0x01}
+		if err := s.api.SetLocalValue(handle, value); err != nil {
+			return err
+		}
+		payload, err := s.result(ctx, 2, 1, 4, func() error { return s.api.AttributesRead(handle, 0) })
+		if err != nil {
+			return err
+		}
+		// handle, offset, result, value
+		if len(payload) < 7 || int(payload[6]) != len(payload)-7 {
+			return fmt.Errorf("malformed read response %x", payload)
+		}
+		if err := compare("local value", payload[7:], value); err != nil {
+			return err
+		}
+		_, err = s.result(ctx, 2, 2, 2, func() error { return s.api.AttributesReadType(handle) })
+		return err
+	}},
+
+	{class: "gap", name: "discover_end_procedure", run: func(ctx context.Context, s *suite) error {
+		if _, err := s.result(ctx, 6, 7, 0, func() error { return s.api.GapSetScanParameters(75, 50, 0) }); err != nil {
+			return err
+		}
+		if _, err := s.result(ctx, 6, 2, 0, func() error { return s.api.GapDiscover(bgapi.GapDiscoverObservation) }); err != nil {
+			return err
+		}
+		result, err := await(ctx, s.api.GapEndProcedure)
+		if err != nil {
+			return err
+		}
+		if result != 0 {
+			return &bgapi.ProcedureError{Result: result}
+		}
+		return nil
+	}},
+	{class: "gap", name: "advertise", run: func(ctx context.Context, s *suite) error {
+		if _, err := s.result(ctx, 6, 8, 0, func() error { return s.api.GapSetAdvParameters(0x200, 0x200, 0x07) }); err != nil {
+			return err
+		}
+		data := []byte{0x02, 0x01, 0x04, 0x05, 0x09, 'b', 'g', 'c', 't'}
+		if _, err := s.result(ctx, 6, 9, 0, func() error { return s.api.GapSetAdvData(0, data) }); err != nil {
+			return err
+		}
+		if _, err := s.result(ctx, 6, 1, 0, func() error {
+			return s.api.GapSetMode(bgapi.GapBroadcast, bgapi.GapNonConnectable)
+		}); err != nil {
+			return err
+		}
+		_, err := s.result(ctx, 6, 1, 0, func() error {
+			return s.api.GapSetMode(bgapi.GapNonDiscoverable, bgapi.GapNonConnectable)
+		})
+		return err
+	}},
+
+	{class: "hardware", name: "io_port_read", run: func(ctx context.Context, s *suite) error {
+		payload, err := s.result(ctx, 7, 7, 0, func() error { return s.api.HardwareIoPortRead(0, 0xff) })
+		if err != nil {
+			return err
+		}
+		if len(payload) != 4 || payload[2] != 0 {
+			return fmt.Errorf("malformed port read response %x", payload)
+		}
+		return nil
+	}},
+	{class: "hardware", name: "adc_read", run: func(ctx context.Context, s *suite) error {
+		// the internal temperature sensor, whatever the board
+		events := make(chan []byte, 1)
+		s.api.HandleEvent(7, 2, func(payload []byte) {
+			select {
+			case events <- append([]byte(nil), payload...):
+			default:
+			}
+		})
+		defer s.api.HandleEvent(7, 2, nil)
+
+		if _, err := s.result(ctx, 7, 2, 0, func() error { return s.api.HardwareAdcRead(0x0e, 3, 1) }); err != nil {
+			return err
+		}
+		select {
+		case payload := <-events:
+			if len(payload) != 3 || payload[0] != 0x0e {
+				return fmt.Errorf("malformed adc result %x", payload)
+			}
+			return nil
+		case <-ctx.Done():
+			return errNoEvent
+		}
+	}},
+
+	{class: "test", name: "get_channel_map", run: func(ctx context.Context, s *suite) error {
+		payload, err := s.response(ctx, 8, 4, s.api.TestGetChannelMap)
+		if err != nil {
+			return err
+		}
+		if len(payload) < 1 || int(payload[0]) != len(payload)-1 {
+			return fmt.Errorf("malformed channel map %x", payload)
+		}
+		return nil
+	}},
+
+	{class: "sm", name: "get_bonds", run: func(ctx context.Context, s *suite) error {
+		payload, err := s.response(ctx, 5, 5, s.api.SmGetBonds)
+		if err != nil {
+			return err
+		}
+		if len(payload) != 1 {
+			return fmt.Errorf("malformed bond count %x", payload)
+		}
+		return nil
+	}},
+	{class: "sm", name: "set_parameters", run: func(ctx context.Context, s *suite) error {
+		if _, err := s.response(ctx, 5, 3, func() error { return s.api.SmSetParameters(0, 7, byte(bgapi.SmIONoInputNoOutput)) }); err != nil {
+			return err
+		}
+		_, err := s.response(ctx, 5, 1, func() error { return s.api.SmSetBondableMode(0) })
+		return err
+	}},
+
+	{class: "connection", name: "connect", skip: needPeer, run: func(ctx context.Context, s *suite) error {
+		conn := s.central.NewConnection(&bgapi.GapScanRespone{Address: *s.opts.Peer}, bgapi.DefaultConnectionParameters())
+		if err := conn.OpenContext(ctx); err != nil {
+			return err
+		}
+		s.conn = conn
+		return nil
+	}},
+	{class: "connection", name: "get_rssi", skip: needConnection, run: func(ctx context.Context, s *suite) error {
+		handle := s.conn.ConnectionStatus().Connection
+		payload, err := s.response(ctx, 3, 1, func() error { return s.api.ConnectionGetRssi(handle) })
+		if err != nil {
+			return err
+		}
+		if len(payload) != 2 || payload[0] != handle || int8(payload[1]) >= 0 {
+			return fmt.Errorf("malformed rssi %x", payload)
+		}
+		return nil
+	}},
+	{class: "connection", name: "channel_map_get", skip: needConnection, run: func(ctx context.Context, s *suite) error {
+		handle := s.conn.ConnectionStatus().Connection
+		payload, err := s.response(ctx, 3, 4, func() error { return s.api.ConnectionChannelMapGet(handle) })
+		if err != nil {
+			return err
+		}
+		if len(payload) != 7 || payload[0] != handle || payload[1] != 5 {
+			return fmt.Errorf("malformed channel map %x", payload)
+		}
+		return nil
+	}},
+	{class: "connection", name: "features_get", skip: needConnection, run: func(ctx context.Context, s *suite) error {
+		_, err := s.conn.Features()
+		return err
+	}},
+	{class: "connection", name: "version_update", skip: needConnection, run: func(ctx context.Context, s *suite) error {
+		_, err := s.conn.PeerVersion()
+		return err
+	}},
+	{class: "attclient", name: "discover_read", skip: needConnection, run: func(ctx context.Context, s *suite) error {
+		db, err := s.conn.Snapshot(true)
+		if err != nil {
+			return err
+		}
+		if len(db.Services) == 0 {
+			return errors.New("peer exposes no service")
+		}
+		return db.Validate()
+	}},
+	{class: "connection", name: "disconnect", skip: needConnection, run: func(ctx context.Context, s *suite) error {
+		conn := s.conn
+		s.conn = nil
+		return conn.Close()
+	}},
+}
@@ -0,0 +1,111 @@
+//go:build hardware
+
+package conformance
+
+import (
+	"context"
+	"flag"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	bgapi "github.com/jsakwa/go_bgapi"
+)
+
+// the module and the optional peer, from the flags or the environment
+var (
+	port    = flag.String("port", os.Getenv("BGAPI_PORT"), "serial port of the module (BGAPI_PORT)")
+	peer    = flag.String("peer", os.Getenv("BGAPI_PEER"), "connectable peripheral for the connection and attribute client checks (BGAPI_PEER)")
+	public  = flag.Bool("public", os.Getenv("BGAPI_PEER_PUBLIC") != "", "the peer address is public rather than random (BGAPI_PEER_PUBLIC)")
+	local   = flag.String("local", os.Getenv("BGAPI_LOCAL_HANDLE"), "writable handle of the local GATT database for the attributes checks (BGAPI_LOCAL_HANDLE)")
+	psKey   = flag.String("pskey", os.Getenv("BGAPI_PSKEY"), "user PS key the flash checks may overwrite and erase (BGAPI_PSKEY)")
+	timeout = flag.Duration("check-timeout", 0, "bound on each check (default 5s)")
+)
+
+// hardwareOptions returns the options given by the flags
+func hardwareOptions(t *testing.T) Options {
+	t.Helper()
+	opts := Options{Timeout: *timeout}
+	if *peer != "" {
+		mac, err := bgapi.ParseMac(strings.ToLower(*peer))
+		if err != nil {
+			t.Fatalf("peer: %v", err)
+		}
+		addrType := bgapi.AddrTypeRandom
+		if *public {
+			addrType = bgapi.AddrTypePublic
+		}
+		address, err := bgapi.NewQualifiedMac(mac, addrType)
+		if err != nil {
+			t.Fatalf("peer: %v", err)
+		}
+		opts.Peer = &address
+	}
+	if *local != "" {
+		handle, err := strconv.ParseUint(*local, 0, 16)
+		if err != nil {
+			t.Fatalf("invalid handle %q", *local)
+		}
+		opts.LocalHandle = uint16(handle)
+	}
+	if *psKey != "" {
+		key, err := strconv.ParseUint(*psKey, 0, 16)
+		if err != nil || !bgapi.IsUserPSKey(uint16(key)) {
+			t.Fatalf("invalid user PS key %q", *psKey)
+		}
+		opts.PSKey = uint16(key)
+	}
+	return opts
+}
+
+// openModule returns a central over the module, closed with the test
+func openModule(t *testing.T) *bgapi.Central {
+	t.Helper()
+	if *port == "" {
+		t.Skip("no module, set -port or BGAPI_PORT")
+	}
+	central := bgapi.NewCentral()
+	if _, err := central.API().OpenSerialReady(*port, nil, bgapi.HandshakeHello); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { central.API().Close() })
+	return central
+}
+
+// TestHardware run every check as a subtest, e.g. -run
+// 'TestHardware/attclient' for those of a command class
+func TestHardware(t *testing.T) {
+	opts := hardwareOptions(t)
+	central := openModule(t)
+	s := newSuite(central, opts)
+	defer s.close()
+
+	if version, known := s.api.FirmwareVersion(); known {
+		t.Logf("firmware %s", version)
+	}
+	for _, c := range checks {
+		t.Run(c.class+"/"+c.name, func(t *testing.T) {
+			result := s.check(context.Background(), c)
+			switch {
+			case result.Skipped != "":
+				t.Skip(result.Skipped)
+			case result.Err != nil:
+				t.Fatal(result.Err)
+			}
+			t.Logf("%v", result.Elapsed.Round(time.Millisecond))
+		})
+	}
+}
+
+// TestHardwareIdle the module is left neither scanning nor advertising,
+// so the suite can run again
+func TestHardwareIdle(t *testing.T) {
+	central := openModule(t)
+	Run(context.Background(), central, hardwareOptions(t))
+
+	if mode := central.API().State().GapMode; mode.Discoverable != bgapi.GapNonDiscoverable || mode.Connectable != bgapi.GapNonConnectable {
+		t.Fatalf("left in mode %+v", mode)
+	}
+}
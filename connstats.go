@@ -0,0 +1,152 @@
+package bgapi
+
+// ConnectionStats counters of a connection
+type ConnectionStats struct {
+	// GattOps attribute client commands issued
+	GattOps uint64
+	// Notifications values notified or indicated by the peer
+	Notifications uint64
+	// ATTBytesOut and ATTBytesIn payload bytes of the attribute client
+	// commands and events
+	ATTBytesOut uint64
+	ATTBytesIn  uint64
+	// RawBytesOut and RawBytesIn bytes of the raw link layer data
+	// exchanged, see Connection.RawReader and RawWriter
+	RawBytesOut uint64
+	RawBytesIn  uint64
+	// Reconnects times the connection was opened again after the first
+	Reconnects uint64
+	// RSSI the last RSSI read, valid when RSSIKnown
+	RSSI      int8
+	RSSIKnown bool
+}
+
+// add accumulate the counters of o, the RSSI is o's when known
+func (s *ConnectionStats) add(o *ConnectionStats) {
+	s.GattOps += o.GattOps
+	s.Notifications += o.Notifications
+	s.ATTBytesOut += o.ATTBytesOut
+	s.ATTBytesIn += o.ATTBytesIn
+	s.RawBytesOut += o.RawBytesOut
+	s.RawBytesIn += o.RawBytesIn
+	if o.RSSIKnown {
+		s.RSSI = o.RSSI
+		s.RSSIKnown = true
+	}
+}
+
+// countLinkCommand account a command to its connection, the mutex must be
+// held
+func (api *API) countLinkCommand(op *operation) {
+	connection, ok := operationConnection(op)
+	if !ok {
+		return
+	}
+	stats := api.linkStats[connection]
+	if stats == nil {
+		return
+	}
+	payload := len(op.txData) - 4
+	switch {
+	case op.class == 4:
+		stats.GattOps++
+		stats.ATTBytesOut += uint64(payload)
+	case op.class == 3 && op.cmd == 8 && payload > 2:
+		// raw_tx: connection, length, data
+		stats.RawBytesOut += uint64(payload - 2)
+	}
+}
+
+// countLinkEvent account an event to its connection, the first byte of the
+// payload of every connection and attribute client event; the mutex must be
+// held
+func (api *API) countLinkEvent(class byte, event byte, payload []byte) {
+	if (class != 3 && class != 4) || len(payload) == 0 {
+		return
+	}
+	stats := api.linkStats[payload[0]]
+	if stats == nil {
+		return
+	}
+	switch {
+	case class == 4:
+		stats.ATTBytesIn += uint64(len(payload))
+		// attribute_value: connection, handle, type, value
+		if event == 5 && len(payload) > 3 {
+			switch payload[3] {
+			case AttValueTypeNotify, AttValueTypeIndicate, AttValueTypeIndicateRspReq:
+				stats.Notifications++
+			}
+		}
+	case class == 3 && event == 3 && len(payload) > 2:
+		// raw_rx: connection, length, data
+		stats.RawBytesIn += uint64(len(payload) - 2)
+	}
+}
+
+// resetLinkStats start counting for a new connection, the mutex must be
+// held
+func (api *API) resetLinkStats(connection byte) {
+	if api.linkStats == nil {
+		api.linkStats = make(map[byte]*ConnectionStats)
+	}
+	api.linkStats[connection] = &ConnectionStats{}
+}
+
+// LinkStats returns the counters of the connections by handle, counting
+// from when each was established; the counters of a closed connection are
+// kept until its handle is reused
+func (api *API) LinkStats() map[byte]ConnectionStats {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	links := make(map[byte]ConnectionStats, len(api.linkStats))
+	for connection, stats := range api.linkStats {
+		links[connection] = *stats
+	}
+	return links
+}
+
+// linkStatsOf returns the counters of a connection, false when unknown
+func (api *API) linkStatsOf(connection byte) (ConnectionStats, bool) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	stats := api.linkStats[connection]
+	if stats == nil {
+		return ConnectionStats{}, false
+	}
+	return *stats, true
+}
+
+// Stats returns the counters of the connection, accumulated over every
+// time it was opened
+func (c *Connection) Stats() ConnectionStats {
+	c.central.mutex.Lock()
+	stats := c.pastStats
+	opens := c.opens
+	open := c.central.openConnections[c.status.Connection] == c
+	c.central.mutex.Unlock()
+
+	if open {
+		if link, ok := c.central.api.linkStatsOf(c.status.Connection); ok {
+			stats.add(&link)
+		}
+	}
+	if opens > 1 {
+		stats.Reconnects = opens - 1
+	}
+	return stats
+}
+
+// closeStats keep the counters of the link that closed
+func (c *Connection) closeStats() {
+	link, ok := c.central.api.linkStatsOf(c.status.Connection)
+
+	c.central.mutex.Lock()
+	defer c.central.mutex.Unlock()
+
+	if ok {
+		c.pastStats.add(&link)
+	}
+}
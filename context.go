@@ -0,0 +1,46 @@
+package bgapi
+
+// SetContext attach application data to a peripheral, it is kept as the
+// peripheral is re-discovered and passed nil to detach it
+func (c *Central) SetContext(address QualifiedMac, v interface{}) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if v == nil {
+		delete(c.contexts, address.Hashable())
+	} else {
+		c.contexts[address.Hashable()] = v
+	}
+}
+
+// Context returns the application data attached to a peripheral
+func (c *Central) Context(address QualifiedMac) interface{} {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.contexts[address.Hashable()]
+}
+
+// SetContext attach application data to the connection
+func (c *Connection) SetContext(v interface{}) {
+	c.context = v
+}
+
+// Context returns the application data attached to the connection
+func (c *Connection) Context() interface{} {
+	return c.context
+}
+
+// PeripheralContext returns the application data of type T attached to a
+// peripheral
+func PeripheralContext[T any](c *Central, address QualifiedMac) (T, bool) {
+	v, ok := c.Context(address).(T)
+	return v, ok
+}
+
+// ConnectionContext returns the application data of type T attached to a
+// connection
+func ConnectionContext[T any](conn *Connection) (T, bool) {
+	v, ok := conn.Context().(T)
+	return v, ok
+}
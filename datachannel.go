@@ -0,0 +1,147 @@
+package bgapi
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// rawPacketMax largest payload of a link layer data packet
+const rawPacketMax = 27
+
+var errDatagramTooLong = errors.New("datagram does not fit a raw packet, enable framing")
+
+// DataChannelOptions configure a data channel
+type DataChannelOptions struct {
+	// Framing prefix each datagram with its length so it may span several
+	// raw packets, otherwise every raw packet carries one datagram
+	Framing bool
+	// CRC append a CRC-16/CCITT to each datagram and drop those that fail it
+	CRC bool
+}
+
+// DataChannel exchanges datagrams with the peer over raw link layer packets
+// (ConnectionRawTx and raw receive events), for custom link layer protocols
+type DataChannel struct {
+	conn *Connection
+	opts DataChannelOptions
+
+	// OnDatagram invoked for every datagram received
+	OnDatagram func(data []byte)
+	// OnError invoked when a received datagram fails its CRC or framing
+	OnError func(err error)
+
+	rx []byte // frame being reassembled
+}
+
+// OpenDataChannel route the raw packets of the connection to a data channel,
+// opts may be nil
+func (c *Connection) OpenDataChannel(opts *DataChannelOptions) *DataChannel {
+	ch := &DataChannel{conn: c}
+	if opts != nil {
+		ch.opts = *opts
+	}
+	c.dataChannel = ch
+	return ch
+}
+
+// Close stop routing raw packets to the channel
+func (ch *DataChannel) Close() {
+	if ch.conn.dataChannel == ch {
+		ch.conn.dataChannel = nil
+	}
+}
+
+// Send transmit a datagram
+func (ch *DataChannel) Send(datagram []byte) error {
+	frame := datagram
+	if ch.opts.CRC {
+		crc := crc16(frame)
+		frame = append(append([]byte(nil), frame...), byte(crc), byte(crc>>8))
+	}
+
+	if !ch.opts.Framing {
+		if len(frame) > rawPacketMax {
+			return errDatagramTooLong
+		}
+		return ch.conn.central.api.ConnectionRawTx(ch.conn.status.Connection, frame)
+	}
+
+	if len(frame) > 0xffff {
+		return errDatagramTooLong
+	}
+	frame = append([]byte{byte(len(frame)), byte(len(frame) >> 8)}, frame...)
+	for len(frame) > 0 {
+		n := len(frame)
+		if n > rawPacketMax {
+			n = rawPacketMax
+		}
+		if err := ch.conn.central.api.ConnectionRawTx(ch.conn.status.Connection, frame[:n]); err != nil {
+			return err
+		}
+		frame = frame[n:]
+	}
+	return nil
+}
+
+// receive handle a raw packet
+func (ch *DataChannel) receive(data []byte) {
+	if !ch.opts.Framing {
+		ch.deliver(data)
+		return
+	}
+
+	ch.rx = append(ch.rx, data...)
+	for len(ch.rx) >= 2 {
+		n := int(binary.LittleEndian.Uint16(ch.rx))
+		if len(ch.rx) < 2+n {
+			return
+		}
+		frame := ch.rx[2 : 2+n]
+		ch.rx = ch.rx[2+n:]
+		ch.deliver(frame)
+	}
+}
+
+// deliver check and hand a datagram to the application
+func (ch *DataChannel) deliver(frame []byte) {
+	if ch.opts.CRC {
+		if len(frame) < 2 {
+			ch.fail(errors.New("datagram shorter than its CRC"))
+			return
+		}
+		n := len(frame) - 2
+		if crc, want := crc16(frame[:n]), binary.LittleEndian.Uint16(frame[n:]); crc != want {
+			ch.fail(fmt.Errorf("datagram CRC 0x%04x, expected 0x%04x", crc, want))
+			return
+		}
+		frame = frame[:n]
+	}
+
+	if ch.OnDatagram != nil {
+		// raw packets belong to the API's receive buffer
+		ch.OnDatagram(append([]byte(nil), frame...))
+	}
+}
+
+func (ch *DataChannel) fail(err error) {
+	if ch.OnError != nil {
+		ch.OnError(err)
+	}
+}
+
+// crc16 CRC-16/CCITT-FALSE
+func crc16(data []byte) uint16 {
+	crc := uint16(0xffff)
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,167 @@
+package bgapi
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+const (
+	// debugLogDepth lines kept until read, older ones are dropped
+	debugLogDepth = 256
+	// debugLineMax longest line, longer output is split
+	debugLineMax = 1024
+)
+
+// DebugLog reassembles the output of the module's debug endpoint, which
+// arrives as fragments of arbitrary length, into lines. Attach it with
+// API.SetDebugLog and consume the lines with ReadLine, Lines or as an
+// io.Reader; only one of them should be used. Lines not read in time are
+// dropped, oldest first, rather than stalling the receive loop.
+type DebugLog struct {
+	pending []byte // remainder of the line being returned by Read
+
+	mutex    sync.Mutex
+	readable *sync.Cond
+	partial  []byte
+	lines    []string
+	dropped  int
+	closed   bool
+}
+
+// NewDebugLog returns an empty debug log
+func NewDebugLog() *DebugLog {
+	l := &DebugLog{}
+	l.readable = sync.NewCond(&l.mutex)
+	return l
+}
+
+// SetDebugLog route the system debug events to log instead of the
+// delegate's OnSystemDebug, nil restores the delegate
+func (api *API) SetDebugLog(log *DebugLog) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	api.debugLog = log
+}
+
+// debugLogger returns the debug log, nil when none is attached
+func (api *API) debugLogger() *DebugLog {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	return api.debugLog
+}
+
+// write append a fragment, queueing the lines it completes
+func (l *DebugLog) write(data []byte) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.closed {
+		return
+	}
+	l.partial = append(l.partial, data...)
+	for {
+		end := bytes.IndexByte(l.partial, '\n')
+		if end < 0 {
+			if len(l.partial) < debugLineMax {
+				break
+			}
+			end = debugLineMax
+			l.queue(l.partial[:end])
+			l.partial = l.partial[end:]
+			continue
+		}
+		l.queue(l.partial[:end])
+		l.partial = l.partial[end+1:]
+	}
+	// keep the partial line in a buffer of its own
+	l.partial = append([]byte(nil), l.partial...)
+}
+
+// queue add a line, the mutex must be held
+func (l *DebugLog) queue(line []byte) {
+	line = bytes.TrimSuffix(line, []byte{'\r'})
+	if len(l.lines) == debugLogDepth {
+		l.lines = l.lines[1:]
+		l.dropped++
+	}
+	l.lines = append(l.lines, string(line))
+	l.readable.Broadcast()
+}
+
+// ReadLine returns the next line, without its terminator, waiting for one
+// to be complete; io.EOF once the log is closed and drained
+func (l *DebugLog) ReadLine() (string, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for len(l.lines) == 0 && !l.closed {
+		l.readable.Wait()
+	}
+	if len(l.lines) == 0 {
+		return "", io.EOF
+	}
+	line := l.lines[0]
+	l.lines = l.lines[1:]
+	return line, nil
+}
+
+// Read implements io.Reader, returning the lines terminated by a newline
+func (l *DebugLog) Read(p []byte) (int, error) {
+	if len(l.pending) == 0 {
+		line, err := l.ReadLine()
+		if err != nil {
+			return 0, err
+		}
+		l.pending = append([]byte(line), '\n')
+	}
+	n := copy(p, l.pending)
+	l.pending = l.pending[n:]
+	return n, nil
+}
+
+// Lines returns a channel receiving the lines, closed once the log is
+// closed and drained
+func (l *DebugLog) Lines() <-chan string {
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		for {
+			line, err := l.ReadLine()
+			if err != nil {
+				return
+			}
+			lines <- line
+		}
+	}()
+	return lines
+}
+
+// Dropped returns the number of lines dropped because they were not read
+// in time
+func (l *DebugLog) Dropped() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.dropped
+}
+
+// Close queue the pending partial line and end the log once drained;
+// detach it from the API first
+func (l *DebugLog) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.closed {
+		return nil
+	}
+	if len(l.partial) > 0 {
+		l.queue(l.partial)
+		l.partial = nil
+	}
+	l.closed = true
+	l.readable.Broadcast()
+	return nil
+}
@@ -0,0 +1,134 @@
+package bgapi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var errShortPayload = errors.New("payload shorter than its length field")
+
+//
+// payload decoder
+//
+
+// decoder reads little-endian BGAPI fields out of a frame payload. The first
+// error is latched so a whole message can be decoded before checking err;
+// fields read after a failure decode as zero values.
+type decoder struct {
+	buf *bytes.Buffer
+	err error
+}
+
+func newDecoder(buf *bytes.Buffer) *decoder {
+	return &decoder{buf: buf}
+}
+
+// read decode into v, which must be a pointer to a fixed size value
+func (d *decoder) read(v interface{}) {
+	if d.err == nil {
+		d.err = binary.Read(d.buf, binary.LittleEndian, v)
+	}
+}
+
+// next return the following n bytes, the scalar fields are decoded from it
+// directly as binary.Read allocates on every call
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if d.buf.Len() < n {
+		d.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	return d.buf.Next(n)
+}
+
+func (d *decoder) u8() byte {
+	if b := d.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *decoder) i8() int8 {
+	return int8(d.u8())
+}
+
+func (d *decoder) u16() uint16 {
+	if b := d.next(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (d *decoder) i16() int16 {
+	return int16(d.u16())
+}
+
+func (d *decoder) u32() uint32 {
+	if b := d.next(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (d *decoder) mac() Mac {
+	var v Mac
+	if b := d.next(len(v)); b != nil {
+		copy(v[:], b)
+	}
+	return v
+}
+
+func (d *decoder) qualifiedMac() QualifiedMac {
+	return QualifiedMac{Address: d.mac(), AddrType: d.u8()}
+}
+
+// uint8array decode a BGAPI uint8array (a length byte followed by data)
+func (d *decoder) uint8array() []byte {
+	n := int(d.u8())
+	if d.err != nil {
+		return nil
+	}
+	if d.buf.Len() < n {
+		d.err = errShortPayload
+		return nil
+	}
+	return d.buf.Next(n)
+}
+
+// rest return the remainder of the payload
+func (d *decoder) rest() []byte {
+	if d.err != nil {
+		return nil
+	}
+	return d.buf.Bytes()
+}
+
+//
+// payload encoder
+//
+
+// encoder builds a little-endian BGAPI command payload
+type encoder struct {
+	buf bytes.Buffer
+}
+
+// write encode v, which must be a fixed size value
+func (e *encoder) write(v interface{}) *encoder {
+	binary.Write(&e.buf, binary.LittleEndian, v)
+	return e
+}
+
+// uint8array encode a BGAPI uint8array (a length byte followed by data)
+func (e *encoder) uint8array(data []byte) *encoder {
+	e.buf.WriteByte(byte(len(data)))
+	e.buf.Write(data)
+	return e
+}
+
+func (e *encoder) bytes() []byte {
+	return e.buf.Bytes()
+}
@@ -0,0 +1,233 @@
+package bgapi
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// device answers the commands written to a transport: the first with a
+// captured response, the following ones (e.g. the shutdown sequence) with
+// a success result
+func device(conn net.Conn, response []byte, commands chan<- []byte) {
+	for first := true; ; first = false {
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		payload := make([]byte, int(header[0]&0x07)<<8|int(header[1]))
+		if _, err := io.ReadFull(conn, payload); err != nil {
+			return
+		}
+		if first {
+			commands <- append(header, payload...)
+			conn.Write(response)
+		} else {
+			conn.Write([]byte{0x00, 0x02, header[2], header[3], 0x00, 0x00})
+		}
+	}
+}
+
+// goldenCommands commands with the frame they encode into and the captured
+// response their completion decodes
+var goldenCommands = []struct {
+	name string
+	// send issues the command, its completion sends the decoded result
+	send func(api *API, result chan<- interface{}) error
+	tx   string
+	rx   string
+	want interface{}
+}{
+	{
+		name: "system_address_get",
+		send: func(api *API, result chan<- interface{}) error {
+			return api.SystemAddressGet(func(address Mac) { result <- address })
+		},
+		tx:   "00 00 00 02",
+		rx:   "00 06 00 02 9f 3c 4b 80 07 00",
+		want: Mac{0x9f, 0x3c, 0x4b, 0x80, 0x07, 0x00},
+	},
+	{
+		name: "system_reg_read",
+		send: func(api *API, result chan<- interface{}) error {
+			return api.SystemRegRead(0x70ab, func(addr uint16, value uint8) { result <- [2]uint16{addr, uint16(value)} })
+		},
+		tx:   "00 02 00 04 ab 70",
+		rx:   "00 03 00 04 ab 70 2a",
+		want: [2]uint16{0x70ab, 0x2a},
+	},
+	{
+		name: "system_get_info",
+		send: func(api *API, result chan<- interface{}) error {
+			return api.SystemInfoGet(func(info *SystemInfo) { result <- *info })
+		},
+		tx:   "00 00 00 08",
+		rx:   "00 0c 00 08 01 00 03 00 02 00 7a 00 03 00 01 01",
+		want: SystemInfo{Major: 1, Minor: 3, Patch: 2, Build: 122, LLVersion: 3, ProtocolVersion: 1, HW: 1},
+	},
+	{
+		name: "system_endpoint_tx",
+		send: func(api *API, result chan<- interface{}) error {
+			return api.SystemEndpointTx(5, []byte{1, 2, 3}, func(r uint16) { result <- r })
+		},
+		tx:   "00 05 00 09 05 03 01 02 03",
+		rx:   "00 02 00 09 82 01",
+		want: uint16(0x0182),
+	},
+	{
+		name: "system_whitelist_append",
+		send: func(api *API, result chan<- interface{}) error {
+			address := QualifiedMac{Address: Mac{0x9f, 0x3c, 0x4b, 0x80, 0x07, 0x00}, AddrType: 1}
+			return api.SystemWhitelistAppend(address, func(r uint16) { result <- r })
+		},
+		tx:   "00 07 00 0a 9f 3c 4b 80 07 00 01",
+		rx:   "00 02 00 0a 00 00",
+		want: uint16(0),
+	},
+	{
+		name: "flash_ps_save",
+		send: func(api *API, result chan<- interface{}) error {
+			return api.FlashPsSave(0x8000, []byte{0xaa, 0xbb}, func(r uint16) { result <- r })
+		},
+		tx:   "00 05 01 03 00 80 02 aa bb",
+		rx:   "00 02 01 03 00 00",
+		want: uint16(0),
+	},
+	{
+		name: "flash_ps_load",
+		send: func(api *API, result chan<- interface{}) error {
+			return api.FlashPsLoad(0x8000, func(r uint16, value []byte) { result <- append([]byte{byte(r)}, value...) })
+		},
+		tx:   "00 02 01 04 00 80",
+		rx:   "00 05 01 04 00 00 02 aa bb",
+		want: []byte{0x00, 0xaa, 0xbb},
+	},
+	{
+		name: "attclient_read_by_group_type",
+		send: func(api *API, result chan<- interface{}) error {
+			return api.AttclientReadByGroupType(0, 0x0001, 0xffff, []byte{0x00, 0x28})
+		},
+		tx: "00 08 04 01 00 01 00 ff ff 02 00 28",
+		rx: "00 03 04 01 00 00 00",
+	},
+	{
+		name: "attclient_find_information",
+		send: func(api *API, result chan<- interface{}) error {
+			return api.AttclientFindInformation(1, 0x0010, 0x0020)
+		},
+		tx: "00 05 04 03 01 10 00 20 00",
+		rx: "00 03 04 03 01 00 00",
+	},
+	{
+		name: "attclient_read_by_handle",
+		send: func(api *API, result chan<- interface{}) error {
+			return api.AttclientReadByHandle(1, 0x002a)
+		},
+		tx: "00 03 04 04 01 2a 00",
+		rx: "00 03 04 04 01 00 00",
+	},
+	{
+		name: "attclient_attribute_write",
+		send: func(api *API, result chan<- interface{}) error {
+			return api.AttclientAttributeWrite(0, 0x002b, []byte{0x01, 0x00})
+		},
+		tx: "00 06 04 05 00 2b 00 02 01 00",
+		rx: "00 03 04 05 00 00 00",
+	},
+}
+
+func TestGoldenCommands(t *testing.T) {
+	for _, tc := range goldenCommands {
+		t.Run(tc.name, func(t *testing.T) {
+			a, b := net.Pipe()
+			commands := make(chan []byte, 1)
+			go device(b, frame(t, tc.rx), commands)
+
+			api := NewAPI(&LoggingDelegate{})
+			api.OpenTransport(a, nil)
+			defer api.Close()
+
+			result := make(chan interface{}, 1)
+			if err := tc.send(api, result); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case tx := <-commands:
+				if want := frame(t, tc.tx); !bytes.Equal(tx, want) {
+					t.Fatalf("sent % x, want % x", tx, want)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("command not sent")
+			}
+			if tc.want == nil {
+				return
+			}
+			select {
+			case got := <-result:
+				if !reflect.DeepEqual(got, tc.want) {
+					t.Fatalf("decoded %#v, want %#v", got, tc.want)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("no result")
+			}
+		})
+	}
+}
+
+func TestDecoderLatchesError(t *testing.T) {
+	d := newDecoder(bytes.NewBuffer([]byte{0x01, 0x02, 0x03}))
+	if v := d.u16(); v != 0x0201 || d.err != nil {
+		t.Fatal(v, d.err)
+	}
+	if v := d.u16(); v != 0 || !errors.Is(d.err, io.ErrUnexpectedEOF) {
+		t.Fatal(v, d.err)
+	}
+	// the byte left is not read once the decoder failed
+	if v := d.u8(); v != 0 || !errors.Is(d.err, io.ErrUnexpectedEOF) {
+		t.Fatal(v, d.err)
+	}
+	if d.rest() != nil || d.uint8array() != nil {
+		t.Fatal("read past the error")
+	}
+
+	d = newDecoder(bytes.NewBuffer([]byte{0x05, 0xaa, 0xbb}))
+	if v := d.uint8array(); v != nil || d.err != errShortPayload {
+		t.Fatal(v, d.err)
+	}
+	if v := d.mac(); v != (Mac{}) || d.err != errShortPayload {
+		t.Fatal(v, d.err)
+	}
+}
+
+func TestEncoderRoundTrip(t *testing.T) {
+	address := QualifiedMac{Address: Mac{0x9f, 0x3c, 0x4b, 0x80, 0x07, 0x00}, AddrType: 1}
+	enc := new(encoder).write(byte(7)).write(uint16(0x1234)).write(uint32(0xdeadbeef)).write(address).uint8array([]byte("abc"))
+	if want, _ := hex.DecodeString("073412efbeadde9f3c4b8007000103616263"); !bytes.Equal(enc.bytes(), want) {
+		t.Fatalf("encoded % x", enc.bytes())
+	}
+
+	d := newDecoder(bytes.NewBuffer(enc.bytes()))
+	if v := d.u8(); v != 7 {
+		t.Error("u8", v)
+	}
+	if v := d.u16(); v != 0x1234 {
+		t.Error("u16", v)
+	}
+	if v := d.u32(); v != 0xdeadbeef {
+		t.Error("u32", v)
+	}
+	if v := d.qualifiedMac(); v != address {
+		t.Error("address", v)
+	}
+	if v := d.uint8array(); string(v) != "abc" {
+		t.Error("array", v)
+	}
+	if d.err != nil || len(d.rest()) != 0 {
+		t.Error(d.err, d.rest())
+	}
+}
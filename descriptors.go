@@ -0,0 +1,176 @@
+package bgapi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sort"
+)
+
+var (
+	// ExtendedPropertiesUUID the characteristic extended properties
+	// descriptor
+	ExtendedPropertiesUUID = []byte{0x00, 0x29}
+	// ServerConfigUUID the server characteristic configuration descriptor
+	ServerConfigUUID = []byte{0x03, 0x29}
+	// PresentationFormatUUID the characteristic presentation format
+	// descriptor
+	PresentationFormatUUID = []byte{0x04, 0x29}
+	// AggregateFormatUUID the characteristic aggregate format descriptor
+	AggregateFormatUUID = []byte{0x05, 0x29}
+)
+
+// DescriptorKind the type of a characteristic descriptor
+type DescriptorKind int
+
+const (
+	// DescriptorOther a descriptor defined by a profile or vendor
+	DescriptorOther DescriptorKind = iota
+	// DescriptorExtendedProperties the extended properties (CEP)
+	DescriptorExtendedProperties
+	// DescriptorUserDescription the user description
+	DescriptorUserDescription
+	// DescriptorClientConfig the client characteristic configuration (CCCD)
+	DescriptorClientConfig
+	// DescriptorServerConfig the server characteristic configuration
+	DescriptorServerConfig
+	// DescriptorPresentationFormat the presentation format
+	DescriptorPresentationFormat
+	// DescriptorAggregateFormat the aggregate format
+	DescriptorAggregateFormat
+)
+
+var descriptorKindNames = []string{"other", "extended properties", "user description",
+	"client configuration", "server configuration", "presentation format", "aggregate format"}
+
+func (k DescriptorKind) String() string {
+	return descriptorKindNames[k]
+}
+
+// DescriptorKindOf classify a descriptor by its UUID
+func DescriptorKindOf(uuid []byte) DescriptorKind {
+	if len(uuid) != 2 || uuid[1] != 0x29 || uuid[0] > 0x05 {
+		return DescriptorOther
+	}
+	return DescriptorKind(uuid[0] + 1)
+}
+
+// Extended properties
+const (
+	ExtPropReliableWrite       = 0x0001
+	ExtPropWritableAuxiliaries = 0x0002
+)
+
+// PresentationFormat the characteristic presentation format descriptor,
+// describing how to interpret the value
+type PresentationFormat struct {
+	// Format of the value, one of the Format constants
+	Format byte
+	// Exponent the value is multiplied by 10^Exponent
+	Exponent int8
+	// Unit the Bluetooth SIG assigned unit UUID, e.g. 0x272f for degrees
+	// Celsius
+	Unit uint16
+	// Namespace of Description, 1 for the Bluetooth SIG
+	Namespace   byte
+	Description uint16
+}
+
+// ParsePresentationFormat decode the value of a presentation format
+// descriptor
+func ParsePresentationFormat(value []byte) (*PresentationFormat, error) {
+	if len(value) != 7 {
+		return nil, errors.New("presentation format descriptor is not 7 bytes long")
+	}
+	return &PresentationFormat{
+		Format:      value[0],
+		Exponent:    int8(value[1]),
+		Unit:        binary.LittleEndian.Uint16(value[2:]),
+		Namespace:   value[4],
+		Description: binary.LittleEndian.Uint16(value[5:]),
+	}, nil
+}
+
+// Descriptors returns the descriptors of the characteristic, in handle
+// order; the declaration and value attributes are not descriptors
+func (c *Characteristic) Descriptors() []*Attribute {
+	descriptors := make([]*Attribute, 0, len(c.attribs))
+	for _, at := range c.attribs {
+		if at == c.value || bytes.Equal(at.uuid, CharacteristicUUID) {
+			continue
+		}
+		descriptors = append(descriptors, at)
+	}
+	sort.Slice(descriptors, func(i, j int) bool { return descriptors[i].handle < descriptors[j].handle })
+	return descriptors
+}
+
+// descriptorValue returns the last value read of the descriptor, false when
+// the characteristic has none or it was not read yet
+func (c *Characteristic) descriptorValue(uuid []byte) ([]byte, bool) {
+	at := c.Descriptor(uuid)
+	if at == nil || len(at.value) == 0 {
+		return nil, false
+	}
+	return at.value, true
+}
+
+// ExtendedProperties returns the extended properties (ExtProp flags), false
+// until the descriptor is read (see ReadDescriptors)
+func (c *Characteristic) ExtendedProperties() (uint16, bool) {
+	value, ok := c.descriptorValue(ExtendedPropertiesUUID)
+	if !ok || len(value) < 2 {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint16(value), true
+}
+
+// UserDescription returns the user description, false until the
+// descriptor is read (discovery reads it)
+func (c *Characteristic) UserDescription() (string, bool) {
+	value, ok := c.descriptorValue(UserDescriptionUUID)
+	return string(value), ok
+}
+
+// ClientConfig returns the client characteristic configuration, 1 when
+// notifying and 2 when indicating, false until the descriptor is read
+// (discovery reads it)
+func (c *Characteristic) ClientConfig() (uint16, bool) {
+	value, ok := c.descriptorValue(ClientCharacteristicConfigUUID)
+	if !ok || len(value) < 2 {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint16(value), true
+}
+
+// PresentationFormat returns the presentation format, false until the
+// descriptor is read (see ReadDescriptors) or when it is malformed
+func (c *Characteristic) PresentationFormat() (*PresentationFormat, bool) {
+	value, ok := c.descriptorValue(PresentationFormatUUID)
+	if !ok {
+		return nil, false
+	}
+	format, err := ParsePresentationFormat(value)
+	return format, err == nil
+}
+
+// ReadDescriptor read the value of a descriptor
+func (c *Connection) ReadDescriptor(at *Attribute) ([]byte, error) {
+	err := c.readAttribute(at.handle)
+	return at.value, err
+}
+
+// ReadDescriptors read the descriptors of a characteristic discovery does
+// not read, i.e. all but the user description and client configuration
+func (c *Connection) ReadDescriptors(char *Characteristic) error {
+	for _, at := range char.Descriptors() {
+		switch DescriptorKindOf(at.uuid) {
+		case DescriptorUserDescription, DescriptorClientConfig:
+			continue
+		}
+		if _, err := c.ReadDescriptor(at); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package bgapi
+
+import (
+	"time"
+)
+
+// DeviceEventType the kind of a DeviceEvent
+type DeviceEventType string
+
+// device event types
+const (
+	// DeviceAppeared first scan response received from a peripheral
+	DeviceAppeared DeviceEventType = "device_appeared"
+	// DevicePayloadChanged the data a peripheral advertises changed, not
+	// only its RSSI
+	DevicePayloadChanged DeviceEventType = "payload_changed"
+	// DeviceValueChanged value notified or indicated by a peripheral
+	DeviceValueChanged DeviceEventType = "value_changed"
+	// DeviceDisconnected connection to a peripheral lost or closed
+	DeviceDisconnected DeviceEventType = "disconnected"
+)
+
+// DeviceEvent an event of a peripheral, tagged for JSON, as reported to
+// the handlers registered with Central.Watch
+type DeviceEvent struct {
+	Type DeviceEventType `json:"type"`
+	Time time.Time       `json:"time"`
+	// Address as printed by Mac.String
+	Address string `json:"address"`
+
+	// RSSI and Name of an appeared device or of one whose payload changed
+	RSSI int8   `json:"rssi,omitempty"`
+	Name string `json:"name,omitempty"`
+	// Changes the AD fields of a changed payload
+	Changes []AdvFieldChange `json:"changes,omitempty"`
+
+	// Characteristic UUID, Handle and Value of a changed value
+	Characteristic string   `json:"characteristic,omitempty"`
+	Handle         uint16   `json:"handle,omitempty"`
+	Value          HexBytes `json:"value,omitempty"`
+
+	// Reason the disconnection reason
+	Reason uint16 `json:"reason,omitempty"`
+}
+
+// Watch call handler with the events of every peripheral until the
+// returned function is called. Handlers run on the API's receive
+// goroutine and must not block; the event is theirs to keep.
+func (c *Central) Watch(handler func(event *DeviceEvent)) (stop func()) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.watchers == nil {
+		c.watchers = make(map[int]func(*DeviceEvent))
+	}
+	id := c.nextWatcher
+	c.nextWatcher++
+	c.watchers[id] = handler
+	return func() {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		delete(c.watchers, id)
+	}
+}
+
+// publish report an event to the watchers, built only when there is one
+func (c *Central) publish(event func() *DeviceEvent) {
+	c.mutex.Lock()
+	handlers := make([]func(*DeviceEvent), 0, len(c.watchers))
+	for _, handler := range c.watchers {
+		handlers = append(handlers, handler)
+	}
+	c.mutex.Unlock()
+
+	for _, handler := range handlers {
+		handler(event())
+	}
+}
+
+// publishAppeared report the first scan response of a peripheral
+func (c *Central) publishAppeared(resp *GapScanRespone) {
+	c.publish(func() *DeviceEvent {
+		return &DeviceEvent{
+			Type:    DeviceAppeared,
+			Time:    c.api.clock.Now(),
+			Address: resp.Address.Address.String(),
+			RSSI:    resp.RSSI,
+			Name:    ParseGapScanResponse(resp).LocalName(),
+		}
+	})
+}
+
+// publishValue report a value notified or indicated on the connection
+func (c *Connection) publishValue(handle uint16, value []byte) {
+	c.central.publish(func() *DeviceEvent {
+		event := &DeviceEvent{
+			Type:    DeviceValueChanged,
+			Time:    c.central.api.clock.Now(),
+			Address: c.resp.Address.Address.String(),
+			Handle:  handle,
+			Value:   append(HexBytes(nil), value...),
+		}
+		if at := c.attribs[handle]; at != nil {
+			event.Characteristic = UUIDString(at.uuid)
+		}
+		return event
+	})
+}
+
+// publishDisconnected report the loss of the connection
+func (c *Connection) publishDisconnected(reason uint16) {
+	c.central.publish(func() *DeviceEvent {
+		return &DeviceEvent{
+			Type:    DeviceDisconnected,
+			Time:    c.central.api.clock.Now(),
+			Address: c.resp.Address.Address.String(),
+			Reason:  reason,
+		}
+	})
+}
+
+// EventSink receives device events, e.g. to stream them into messaging
+// infrastructure; adapters for NATS, Kafka and MQTT are in the sink
+// subpackages. Publish runs on the API's receive goroutine so it must
+// queue the event rather than wait for it to be delivered.
+type EventSink interface {
+	Publish(event *DeviceEvent) error
+}
+
+// PublishTo publish the events of every peripheral to sink until the
+// returned function is called, onError (may be nil) is notified of the
+// events the sink refused
+func (c *Central) PublishTo(sink EventSink, onError func(event *DeviceEvent, err error)) (stop func()) {
+	return c.Watch(func(event *DeviceEvent) {
+		if err := sink.Publish(event); err != nil && onError != nil {
+			onError(event, err)
+		}
+	})
+}
@@ -0,0 +1,111 @@
+package bgapi
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// BLED112 USB identifiers
+const (
+	// BLED112VendorID Bluegiga's USB vendor identifier
+	BLED112VendorID = 0x2458
+	// BLED112ProductID the dongle running its application firmware
+	BLED112ProductID = 0x0001
+	// BLED112DFUProductID the dongle running its DFU bootloader
+	BLED112DFUProductID = 0xfffe
+)
+
+const (
+	// dfuBootLength payload of the bootloader's boot event (its version)
+	dfuBootLength = 4
+	// dfuChunkSize bytes per upload, a multiple of the 4 byte flash word
+	dfuChunkSize = 64
+	// dfuTimeoutMs flash writes take longer than regular commands
+	dfuTimeoutMs = 5000
+)
+
+// ErrDFUMode the device runs its DFU bootloader rather than the application,
+// RecoverFromDFU can flash a firmware image
+var ErrDFUMode = errors.New("device is in DFU mode")
+
+// DFU commands, the bootloader only implements class 0
+const (
+	dfuReset          = 0
+	dfuFlashSetAddr   = 1
+	dfuFlashUpload    = 2
+	dfuFlashUploadEnd = 3
+)
+
+// dfuCall send a bootloader command and check its result
+func (api *API) dfuCall(cmd byte, data []byte) error {
+	var result uint16
+	resultC := make(chan error, 1)
+	err := api.submit(0, cmd, data, dfuTimeoutMs, func(buf *bytes.Buffer, err error) {
+		if err == nil {
+			d := newDecoder(buf)
+			result = d.u16()
+			err = d.err
+		}
+		resultC <- err
+	})
+	if err == nil {
+		err = <-resultC
+	}
+	if err == nil && result != 0 {
+		err = fmt.Errorf("%s failed with result 0x%04x", dfuCommandNames[cmd], result)
+	}
+	return err
+}
+
+var dfuCommandNames = []string{"dfu_reset", "dfu_flash_set_address", "dfu_flash_upload", "dfu_flash_upload_finish"}
+
+// RecoverFromDFU flash a firmware image (the raw binary, not the hex file)
+// through the bootloader of a device in DFU mode, then boot it. UART modules
+// resume and their system information is returned; USB dongles re-enumerate
+// and must be reopened, in which case nil is returned. progress may be nil.
+func (api *API) RecoverFromDFU(image []byte, progress ProgressReporter) (*SystemInfo, error) {
+	if len(image) == 0 {
+		return nil, errors.New("empty firmware image")
+	}
+
+	reportProgress(progress, "dfu upload", 0, len(image))
+	if err := api.dfuCall(dfuFlashSetAddr, new(encoder).write(uint32(0)).bytes()); err != nil {
+		return nil, err
+	}
+
+	for sent := 0; sent < len(image); {
+		end := sent + dfuChunkSize
+		if end > len(image) {
+			end = len(image)
+		}
+		chunk := image[sent:end]
+		for len(chunk)%4 != 0 {
+			// pad the last word with erased flash
+			chunk = append(chunk[:len(chunk):len(chunk)], 0xff)
+		}
+		if err := api.dfuCall(dfuFlashUpload, new(encoder).uint8array(chunk).bytes()); err != nil {
+			return nil, fmt.Errorf("uploading offset %d: %v", sent, err)
+		}
+		sent = end
+		reportProgress(progress, "dfu upload", sent, len(image))
+	}
+
+	reportProgress(progress, "dfu finish", 0, 0)
+	if err := api.dfuCall(dfuFlashUploadEnd, []byte{}); err != nil {
+		return nil, err
+	}
+
+	// boot the application, which answers with a regular boot event
+	select {
+	case <-api.bootC:
+	default:
+	}
+	api.submitNoReply(0, dfuReset, []byte{0}, func(*bytes.Buffer, error) {})
+	select {
+	case info := <-api.bootC:
+		return info, nil
+	case <-api.clock.After(readyTimeout):
+		return nil, nil
+	}
+}
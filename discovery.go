@@ -0,0 +1,167 @@
+package bgapi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// discoveryTimeoutMs bound on each discovery procedure
+const discoveryTimeoutMs time.Duration = 5000
+
+// SetLazyDiscovery make Open skip the discovery of the GATT database, which
+// is then walked with a Discoverer. Subscriptions are not restored when the
+// connection reopens (see Subscriptions).
+func (c *Connection) SetLazyDiscovery(lazy bool) {
+	c.lazyDiscovery = lazy
+}
+
+// Discoverer walks the GATT database of a peripheral as it is discovered
+// rather than once it is complete, e.g. to find a characteristic of a large
+// database without discovering the services that follow it. Services are
+// returned as the peripheral reports them; the characteristics of a service
+// are discovered when first requested, once all services are known.
+type Discoverer struct {
+	conn *Connection
+	ctx  context.Context
+
+	mutex    sync.Mutex
+	found    *sync.Cond
+	services []*Service // found but not returned yet
+	complete bool       // all services were found
+	err      error
+
+	service *Service          // returned by the last NextService
+	chars   []*Characteristic // of service, not returned yet
+	walked  bool              // the characteristics of service were discovered
+}
+
+// Discover start walking the database of an open connection, forgetting
+// what was discovered before. Other GATT procedures must wait for
+// NextService to return io.EOF, or for the first NextCharacteristic call.
+func (c *Connection) Discover(ctx context.Context) *Discoverer {
+	d := &Discoverer{conn: c, ctx: ctx}
+	d.found = sync.NewCond(&d.mutex)
+
+	c.resetGatt()
+	c.serviceFound = d.add
+	go func() {
+		reportProgress(c.progress, "discover services", 0, 0)
+		err := c.procMgr.performContext(ctx, discoveryTimeoutMs, procedureGeneral, func() {
+			c.central.api.AttclientReadByGroupType(c.status.Connection, 1, 0xffff, PrimaryServiceUUID)
+		})
+		c.serviceFound = nil
+
+		d.mutex.Lock()
+		defer d.mutex.Unlock()
+		d.complete = true
+		d.err = err
+		d.found.Broadcast()
+	}()
+	return d
+}
+
+// add queue a service reported by the peripheral
+func (d *Discoverer) add(s *Service) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.services = append(d.services, s)
+	d.found.Broadcast()
+}
+
+// NextService returns the next service, waiting for the peripheral to
+// report it; io.EOF once all were returned
+func (d *Discoverer) NextService() (*Service, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for len(d.services) == 0 && !d.complete {
+		d.found.Wait()
+	}
+	if len(d.services) == 0 {
+		if d.err != nil {
+			return nil, d.err
+		}
+		return nil, io.EOF
+	}
+	d.service = d.services[0]
+	d.services = d.services[1:]
+	d.chars = nil
+	d.walked = false
+	return d.service, nil
+}
+
+// NextCharacteristic returns the next characteristic of the service last
+// returned by NextService, discovering them on the first call; io.EOF once
+// all were returned
+func (d *Discoverer) NextCharacteristic() (*Characteristic, error) {
+	if d.service == nil {
+		return nil, errors.New("no service, call NextService first")
+	}
+	if !d.walked {
+		if err := d.walk(); err != nil {
+			return nil, err
+		}
+	}
+	if len(d.chars) == 0 {
+		return nil, io.EOF
+	}
+	char := d.chars[0]
+	d.chars = d.chars[1:]
+	return char, nil
+}
+
+// walk discover the characteristics of the current service, once the
+// service discovery completed as the connection runs one procedure at a
+// time
+func (d *Discoverer) walk() error {
+	d.mutex.Lock()
+	for !d.complete {
+		d.found.Wait()
+	}
+	err := d.err
+	d.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := d.ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := d.conn.discoverService(d.service, discoveryTimeoutMs); err != nil {
+		return err
+	}
+	d.walked = true
+	d.chars = append([]*Characteristic(nil), d.service.characteristics...)
+	return nil
+}
+
+// FindCharacteristic walk the database until the characteristic uuid of
+// the service serviceUUID is found, nil for any service; io.EOF when the
+// peripheral has none
+func (d *Discoverer) FindCharacteristic(serviceUUID []byte, uuid []byte) (*Characteristic, error) {
+	for {
+		s, err := d.NextService()
+		if err != nil {
+			return nil, err
+		}
+		if serviceUUID != nil && !bytes.Equal(s.uuid, serviceUUID) {
+			continue
+		}
+		for {
+			char, err := d.NextCharacteristic()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			if bytes.Equal(char.uuid, uuid) {
+				return char, nil
+			}
+		}
+	}
+}
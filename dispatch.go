@@ -0,0 +1,267 @@
+package bgapi
+
+import "sync"
+
+// DispatchMode how events are delivered to the delegate
+type DispatchMode int
+
+const (
+	// DispatchInline call the delegate on the receive goroutine (the
+	// default): every event is delivered in order, and a slow delegate
+	// delays all of them as well as command responses
+	DispatchInline DispatchMode = iota
+	// DispatchPerConnection call the delegate on one goroutine per
+	// connection, plus one for the events not tied to a connection (system,
+	// GAP, hardware...). Events of a connection are delivered in order, and
+	// so are the other events, but a slow handler only delays its own
+	// queue. Payloads are copied, see TransportOptions.CopyPayloads.
+	DispatchPerConnection
+)
+
+const (
+	// dispatchQueueDepth events queued per connection before the receive
+	// loop waits for the delegate
+	dispatchQueueDepth = 64
+	// dispatchGlobal queue of the events not tied to a connection
+	dispatchGlobal = -1
+)
+
+// dispatcher serializes delegate calls per queue, each run by its own
+// goroutine until the API is closed
+type dispatcher struct {
+	delegate Delegate
+	done     <-chan struct{}
+
+	mutex  sync.Mutex
+	queues map[int]chan func()
+}
+
+// newDispatcher returns a delegate running the methods of delegate through
+// per connection queues
+func newDispatcher(delegate Delegate, done <-chan struct{}) *dispatcher {
+	return &dispatcher{delegate: delegate, done: done, queues: make(map[int]chan func())}
+}
+
+// dispatch queue a call, blocking while the queue is full; calls queued
+// when the API closes are dropped
+func (d *dispatcher) dispatch(queue int, call func()) {
+	d.mutex.Lock()
+	calls := d.queues[queue]
+	if calls == nil {
+		calls = make(chan func(), dispatchQueueDepth)
+		d.queues[queue] = calls
+		go d.run(calls)
+	}
+	d.mutex.Unlock()
+
+	select {
+	case calls <- call:
+	case <-d.done:
+	}
+}
+
+// run invoke the calls of a queue in order
+func (d *dispatcher) run(calls chan func()) {
+	for {
+		select {
+		case call := <-calls:
+			call()
+		case <-d.done:
+			return
+		}
+	}
+}
+
+// flush wait for the calls queued so far to complete
+func (d *dispatcher) flush() {
+	d.mutex.Lock()
+	queues := make([]int, 0, len(d.queues))
+	for queue := range d.queues {
+		queues = append(queues, queue)
+	}
+	d.mutex.Unlock()
+
+	flushed := make([]chan struct{}, len(queues))
+	for i, queue := range queues {
+		f := make(chan struct{})
+		flushed[i] = f
+		d.dispatch(queue, func() { close(f) })
+	}
+	for _, f := range flushed {
+		select {
+		case <-f:
+		case <-d.done:
+			return
+		}
+	}
+}
+
+// global queue a call not tied to a connection
+func (d *dispatcher) global(call func()) {
+	d.dispatch(dispatchGlobal, call)
+}
+
+// connection queue a call for a connection
+func (d *dispatcher) connection(handle byte, call func()) {
+	d.dispatch(int(handle), call)
+}
+
+// OnSystemBoot implements Delegate
+func (d *dispatcher) OnSystemBoot(info *SystemInfo) {
+	d.global(func() { d.delegate.OnSystemBoot(info) })
+}
+
+// OnSystemDebug implements Delegate
+func (d *dispatcher) OnSystemDebug(data []byte) {
+	d.global(func() { d.delegate.OnSystemDebug(data) })
+}
+
+// OnSystemEndpointWatermarkRx implements Delegate
+func (d *dispatcher) OnSystemEndpointWatermarkRx(endpoint byte, data byte) {
+	d.global(func() { d.delegate.OnSystemEndpointWatermarkRx(endpoint, data) })
+}
+
+// OnSystemEndpointWatermarkTx implements Delegate
+func (d *dispatcher) OnSystemEndpointWatermarkTx(endpoint byte, data byte) {
+	d.global(func() { d.delegate.OnSystemEndpointWatermarkTx(endpoint, data) })
+}
+
+// OnSystemScriptFailure implements Delegate
+func (d *dispatcher) OnSystemScriptFailure(addr uint16, reason uint16) {
+	d.global(func() { d.delegate.OnSystemScriptFailure(addr, reason) })
+}
+
+// OnSystemNoLicenseKey implements Delegate
+func (d *dispatcher) OnSystemNoLicenseKey() {
+	d.global(func() { d.delegate.OnSystemNoLicenseKey() })
+}
+
+// OnFlashPsKey implements Delegate
+func (d *dispatcher) OnFlashPsKey(key uint16, value []byte) {
+	d.global(func() { d.delegate.OnFlashPsKey(key, value) })
+}
+
+// OnAttributeValue implements Delegate
+func (d *dispatcher) OnAttributeValue(connection byte, reason byte, handle uint16, offset uint16, value []byte) {
+	d.connection(connection, func() { d.delegate.OnAttributeValue(connection, reason, handle, offset, value) })
+}
+
+// OnAttributeUserReadRequest implements Delegate
+func (d *dispatcher) OnAttributeUserReadRequest(connection byte, handle uint16, offset uint16, maxSize byte) {
+	d.connection(connection, func() { d.delegate.OnAttributeUserReadRequest(connection, handle, offset, maxSize) })
+}
+
+// OnAttributeStatus implements Delegate
+func (d *dispatcher) OnAttributeStatus(handle uint16, flags byte) {
+	d.global(func() { d.delegate.OnAttributeStatus(handle, flags) })
+}
+
+// OnConnectionStatus implements Delegate
+func (d *dispatcher) OnConnectionStatus(status *ConnectionStatus) {
+	d.connection(status.Connection, func() { d.delegate.OnConnectionStatus(status) })
+}
+
+// OnConnectionVersionIndication implements Delegate
+func (d *dispatcher) OnConnectionVersionIndication(ind *ConnectionVersionIndication) {
+	d.connection(ind.Connection, func() { d.delegate.OnConnectionVersionIndication(ind) })
+}
+
+// OnConnectionFeatureIndication implements Delegate
+func (d *dispatcher) OnConnectionFeatureIndication(connection byte, features []byte) {
+	d.connection(connection, func() { d.delegate.OnConnectionFeatureIndication(connection, features) })
+}
+
+// OnConnectionRawRx implements Delegate
+func (d *dispatcher) OnConnectionRawRx(connection byte, data []byte) {
+	d.connection(connection, func() { d.delegate.OnConnectionRawRx(connection, data) })
+}
+
+// OnConnectionDisconnected implements Delegate
+func (d *dispatcher) OnConnectionDisconnected(connection byte, reason uint16) {
+	d.connection(connection, func() { d.delegate.OnConnectionDisconnected(connection, reason) })
+}
+
+// OnAttrclientIndicated implements Delegate
+func (d *dispatcher) OnAttrclientIndicated(connection byte, attrHandle uint16) {
+	d.connection(connection, func() { d.delegate.OnAttrclientIndicated(connection, attrHandle) })
+}
+
+// OnAttrclientProcedureCompleted implements Delegate
+func (d *dispatcher) OnAttrclientProcedureCompleted(connection byte, result uint16, chrHandle uint16) {
+	d.connection(connection, func() { d.delegate.OnAttrclientProcedureCompleted(connection, result, chrHandle) })
+}
+
+// OnAttrclientGroupFound implements Delegate
+func (d *dispatcher) OnAttrclientGroupFound(connection byte, start uint16, end uint16, uuid []byte) {
+	d.connection(connection, func() { d.delegate.OnAttrclientGroupFound(connection, start, end, uuid) })
+}
+
+// OnAttrclientAttributeFound implements Delegate
+func (d *dispatcher) OnAttrclientAttributeFound(connection byte, chrdecl uint16, value uint16, properties byte, uuid []byte) {
+	d.connection(connection, func() { d.delegate.OnAttrclientAttributeFound(connection, chrdecl, value, properties, uuid) })
+}
+
+// OnAttrclientFindInformationFound implements Delegate
+func (d *dispatcher) OnAttrclientFindInformationFound(connection byte, chrHandle uint16, uuid []byte) {
+	d.connection(connection, func() { d.delegate.OnAttrclientFindInformationFound(connection, chrHandle, uuid) })
+}
+
+// OnAttrclientAttributeValue implements Delegate
+func (d *dispatcher) OnAttrclientAttributeValue(connection byte, attHandle uint16, valueType byte, value []byte) {
+	d.connection(connection, func() { d.delegate.OnAttrclientAttributeValue(connection, attHandle, valueType, value) })
+}
+
+// OnAttrclientReadMultipleResponse implements Delegate
+func (d *dispatcher) OnAttrclientReadMultipleResponse(connection byte, handles []byte) {
+	d.connection(connection, func() { d.delegate.OnAttrclientReadMultipleResponse(connection, handles) })
+}
+
+// OnGapScanResponse implements Delegate
+func (d *dispatcher) OnGapScanResponse(resp *GapScanRespone) {
+	d.global(func() { d.delegate.OnGapScanResponse(resp) })
+}
+
+// OnGapModeChanged implements Delegate
+func (d *dispatcher) OnGapModeChanged(discover byte, connect byte) {
+	d.global(func() { d.delegate.OnGapModeChanged(discover, connect) })
+}
+
+// OnSmSmpData implements Delegate
+func (d *dispatcher) OnSmSmpData(handle byte, packet byte, data []byte) {
+	d.connection(handle, func() { d.delegate.OnSmSmpData(handle, packet, data) })
+}
+
+// OnSmBondingFail implements Delegate
+func (d *dispatcher) OnSmBondingFail(handle byte, result uint16) {
+	d.connection(handle, func() { d.delegate.OnSmBondingFail(handle, result) })
+}
+
+// OnSmPasskeyDisplay implements Delegate
+func (d *dispatcher) OnSmPasskeyDisplay(handle byte, passkey uint32) {
+	d.connection(handle, func() { d.delegate.OnSmPasskeyDisplay(handle, passkey) })
+}
+
+// OnSmPasskeyRequest implements Delegate
+func (d *dispatcher) OnSmPasskeyRequest(handle byte) {
+	d.connection(handle, func() { d.delegate.OnSmPasskeyRequest(handle) })
+}
+
+// OnSmBondStatus implements Delegate
+func (d *dispatcher) OnSmBondStatus(status *SmBondStatus) {
+	d.global(func() { d.delegate.OnSmBondStatus(status) })
+}
+
+// OnHardwareIoPortStatus implements Delegate
+func (d *dispatcher) OnHardwareIoPortStatus(status *IoPortStatus) {
+	d.global(func() { d.delegate.OnHardwareIoPortStatus(status) })
+}
+
+// OnHardwareSoftTimer implements Delegate
+func (d *dispatcher) OnHardwareSoftTimer(handle byte) {
+	d.global(func() { d.delegate.OnHardwareSoftTimer(handle) })
+}
+
+// OnHardwareAdcResult implements Delegate
+func (d *dispatcher) OnHardwareAdcResult(input byte, value int16) {
+	d.global(func() { d.delegate.OnHardwareAdcResult(input, value) })
+}
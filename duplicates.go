@@ -0,0 +1,109 @@
+package bgapi
+
+import (
+	"bytes"
+	"sort"
+	"time"
+)
+
+// DuplicatePolicy which scan responses of an advertiser reach
+// Central.OnScanResponse; MonitorBeacons, ScanMerged and ExportScan see
+// every one. Filtering in software behaves the same whatever the firmware
+// does with FilterPolicy.DuplicateFiltering, which reports each advertiser
+// once or not at all; leave that one off to see every packet.
+type DuplicatePolicy int
+
+const (
+	// DuplicatesReport report every packet (the default)
+	DuplicatesReport DuplicatePolicy = iota
+	// DuplicatesFirst report the first packet of each advertiser per scan
+	DuplicatesFirst
+	// DuplicatesOnChange report a packet when its data differs from the
+	// advertiser's last one reported
+	DuplicatesOnChange
+	// DuplicatesInterval report a packet of each advertiser at most once
+	// per Central.DuplicateInterval
+	DuplicatesInterval
+)
+
+// maxReportedScans bound on the packets remembered by the duplicate
+// policy, the older half is forgotten when reached and their advertisers
+// are reported again
+const maxReportedScans = 4096
+
+// reportedScan the last packet of an advertiser reported
+type reportedScan struct {
+	data []byte
+	time time.Time
+}
+
+// reportScan returns true when a scan response passes the duplicate
+// policy, recording it. Advertisements and scan responses are filtered
+// separately so an active scan reports both.
+func (c *Central) reportScan(resp *GapScanRespone) bool {
+	if c.DuplicatePolicy == DuplicatesReport {
+		return true
+	}
+	now := c.api.clock.Now()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.reportedScans == nil {
+		c.reportedScans = make(map[string]*reportedScan)
+	}
+	key := resp.Address.Hashable() + string(resp.PacketType)
+	last := c.reportedScans[key]
+	if last != nil {
+		switch c.DuplicatePolicy {
+		case DuplicatesFirst:
+			return false
+		case DuplicatesOnChange:
+			if bytes.Equal(last.data, resp.Data) {
+				return false
+			}
+		case DuplicatesInterval:
+			if now.Sub(last.time) < c.DuplicateInterval {
+				return false
+			}
+		}
+	}
+	if last == nil && len(c.reportedScans) >= maxReportedScans {
+		c.evictReportedScans(now)
+	}
+	c.reportedScans[key] = &reportedScan{data: resp.Data, time: now}
+	return true
+}
+
+// evictReportedScans forget the packets reported longer than the duplicate
+// interval ago, and the older half when that is not enough; the mutex must
+// be held
+func (c *Central) evictReportedScans(now time.Time) {
+	if c.DuplicatePolicy == DuplicatesInterval {
+		for key, last := range c.reportedScans {
+			if now.Sub(last.time) >= c.DuplicateInterval {
+				delete(c.reportedScans, key)
+			}
+		}
+	}
+	if len(c.reportedScans) < maxReportedScans/2 {
+		return
+	}
+	times := make([]time.Time, 0, len(c.reportedScans))
+	for _, last := range c.reportedScans {
+		times = append(times, last.time)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	cutoff := times[len(times)/2]
+	for key, last := range c.reportedScans {
+		if !last.time.After(cutoff) {
+			delete(c.reportedScans, key)
+		}
+	}
+}
+
+// resetDuplicates forget the packets reported, a scan starts; the mutex
+// must be held
+func (c *Central) resetDuplicates() {
+	c.reportedScans = nil
+}
@@ -0,0 +1,171 @@
+package bgapi
+
+import "time"
+
+// results of endpoint_tx reporting a full transmit buffer
+const (
+	resultOutOfMemory uint16 = 0x0182
+	resultFlow        uint16 = 0x0187
+)
+
+// EndpointStats activity of a system endpoint (UART, USB, script...)
+type EndpointStats struct {
+	// RxWatermarks receive watermark events, the endpoint has data to read
+	RxWatermarks uint64
+	// TxWatermarks transmit watermark events, the transmit buffer drained
+	// below its watermark
+	TxWatermarks uint64
+	// LastRx, LastTx data of the last watermark events
+	LastRx, LastTx byte
+
+	// TxBytes bytes written with SystemEndpointTx and accepted
+	TxBytes uint64
+	// TxRejected writes refused by the module, usually for lack of room
+	TxRejected uint64
+	// Outstanding bytes accepted since the last transmit watermark event
+	Outstanding int
+
+	// Pressure the transmit buffer is deemed full, see
+	// SetEndpointTxBudget; producers should hold off until it clears
+	Pressure bool
+	// PressureEvents times the pressure rose
+	PressureEvents uint64
+	// PressureTime time spent under pressure, up to the last change
+	PressureTime time.Duration
+
+	budget        int       // outstanding bytes raising the pressure, 0 for none
+	pressureSince time.Time // when the pressure rose
+}
+
+// EndpointPressureHandler notified when the transmit pressure of an
+// endpoint rises or clears, on the receive goroutine
+type EndpointPressureHandler func(endpoint byte, pressure bool)
+
+// SetEndpointPressureHandler attach a pressure handler, nil detaches it
+func (api *API) SetEndpointPressureHandler(handler EndpointPressureHandler) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	api.pressureHandler = handler
+}
+
+// SetEndpointTxBudget raise the transmit pressure of endpoint once budget
+// bytes are outstanding, i.e. written without a transmit watermark event
+// since; usually the transmit buffer size minus the watermark set with
+// SystemEndpointSetWatermarks. Zero only raises it when the module refuses
+// a write.
+func (api *API) SetEndpointTxBudget(endpoint byte, budget int) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	api.endpoint(endpoint).budget = budget
+}
+
+// EndpointStats returns a copy of the statistics of the endpoints seen so
+// far, keyed by endpoint
+func (api *API) EndpointStats() map[byte]EndpointStats {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	stats := make(map[byte]EndpointStats, len(api.endpoints))
+	for endpoint, s := range api.endpoints {
+		stats[endpoint] = *s
+	}
+	return stats
+}
+
+// EndpointPressure returns true while the transmit buffer of endpoint is
+// deemed full
+func (api *API) EndpointPressure(endpoint byte) bool {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	s := api.endpoints[endpoint]
+	return s != nil && s.Pressure
+}
+
+// endpoint returns the statistics of an endpoint, the mutex must be held
+func (api *API) endpoint(endpoint byte) *EndpointStats {
+	if api.endpoints == nil {
+		api.endpoints = make(map[byte]*EndpointStats)
+	}
+	s := api.endpoints[endpoint]
+	if s == nil {
+		s = &EndpointStats{}
+		api.endpoints[endpoint] = s
+	}
+	return s
+}
+
+// setPressure update the pressure, the mutex must be held; returns true
+// when it changed
+func (api *API) setPressure(s *EndpointStats, pressure bool) bool {
+	if s.Pressure == pressure {
+		return false
+	}
+	now := api.clock.Now()
+	s.Pressure = pressure
+	if pressure {
+		s.PressureEvents++
+		s.pressureSince = now
+	} else {
+		s.PressureTime += now.Sub(s.pressureSince)
+	}
+	return true
+}
+
+// notifyPressure invoke the pressure handler
+func (api *API) notifyPressure(endpoint byte, pressure bool) {
+	api.mutex.Lock()
+	handler := api.pressureHandler
+	api.mutex.Unlock()
+
+	if handler != nil {
+		handler(endpoint, pressure)
+	}
+}
+
+// endpointWatermark account for a watermark event
+func (api *API) endpointWatermark(endpoint byte, tx bool, data byte) {
+	api.mutex.Lock()
+	s := api.endpoint(endpoint)
+	changed := false
+	if tx {
+		s.TxWatermarks++
+		s.LastTx = data
+		s.Outstanding = 0
+		changed = api.setPressure(s, false)
+	} else {
+		s.RxWatermarks++
+		s.LastRx = data
+	}
+	api.mutex.Unlock()
+
+	if changed {
+		api.notifyPressure(endpoint, false)
+	}
+}
+
+// endpointTx account for the result of an endpoint_tx command
+func (api *API) endpointTx(endpoint byte, size int, result uint16) {
+	api.mutex.Lock()
+	s := api.endpoint(endpoint)
+	pressure := false
+	switch {
+	case result == 0:
+		s.TxBytes += uint64(size)
+		s.Outstanding += size
+		pressure = s.budget > 0 && s.Outstanding >= s.budget
+	case result == resultOutOfMemory || result == resultFlow:
+		s.TxRejected++
+		pressure = true
+	default:
+		s.TxRejected++
+	}
+	changed := pressure && api.setPressure(s, true)
+	api.mutex.Unlock()
+
+	if changed {
+		api.notifyPressure(endpoint, true)
+	}
+}
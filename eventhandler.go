@@ -0,0 +1,33 @@
+package bgapi
+
+// EventHandler consumes the raw payload of an event, which is only valid
+// until the handler returns (see Delegate)
+type EventHandler func(payload []byte)
+
+// HandleEvent register a handler for an event, e.g. one sent by
+// experimental firmware the package does not parse yet; nil removes it.
+// A handler registered for a known event overrides the built-in parser:
+// the delegate is not called and the API does not update the state it
+// derives from the event (connection status, GAP mode, boot detection...).
+func (api *API) HandleEvent(class byte, event byte, handler EventHandler) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	key := uint16(class)<<8 | uint16(event)
+	if handler == nil {
+		delete(api.eventHandlers, key)
+		return
+	}
+	if api.eventHandlers == nil {
+		api.eventHandlers = make(map[uint16]EventHandler)
+	}
+	api.eventHandlers[key] = handler
+}
+
+// eventHandler returns the handler registered for an event, if any
+func (api *API) eventHandler(class byte, event byte) EventHandler {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	return api.eventHandlers[uint16(class)<<8|uint16(event)]
+}
@@ -0,0 +1,270 @@
+// Command gateway bridges the BLE devices around a BLED112 to MQTT: it
+// scans continuously, decodes the iBeacon and Eddystone advertisements,
+// publishes the readings and the device events to a broker, exports
+// Prometheus metrics and reopens the dongle when it is unplugged.
+//
+//	gateway [-config FILE] [-port PORT] [-broker HOST:PORT] [-prefix TOPIC] [-metrics ADDR] [-every DURATION]
+//
+// Readings are published to PREFIX/ADDRESS/reading, device events to
+// PREFIX/ADDRESS/TYPE (see sink/mqtt). The configuration file (JSON, YAML or
+// TOML, see package config) sets the scan timings, the filtering and the
+// reconnect policy; without one the gateway publishes a reading of each
+// device at most every 10 seconds (see -every), the beacons repeating
+// themselves several times a second, and retries forever, backing off up to
+// 30 seconds.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	bgapi "github.com/jsakwa/go_bgapi"
+	"github.com/jsakwa/go_bgapi/config"
+	"github.com/jsakwa/go_bgapi/metrics/prometheus"
+	"github.com/jsakwa/go_bgapi/sink/mqtt"
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	configFile  = flag.String("config", "", "configuration file of the dongle")
+	port        = flag.String("port", "/dev/ttyACM0", "serial port of the dongle, unless set by the configuration")
+	broker      = flag.String("broker", "localhost:1883", "address of the MQTT broker")
+	clientID    = flag.String("client-id", "", "MQTT client identifier (default assigned by the broker)")
+	prefix      = flag.String("prefix", "bgapi", "prefix of the MQTT topics")
+	metricsAddr = flag.String("metrics", ":9110", "address serving the Prometheus metrics, empty to disable")
+	mergeWait   = flag.Duration("merge", 200*time.Millisecond, "how long an advertisement waits for its scan response")
+	pollEvery   = flag.Duration("poll", 30*time.Second, "interval of the radio counter polls")
+	every       = flag.Duration("every", 10*time.Second, "least interval between the readings of a device, unless set by the configuration filtering; 0 publishes every advertisement")
+)
+
+// maxPublished devices whose last reading is remembered beyond which those
+// due for another reading are forgotten
+const maxPublished = 4096
+
+// defaultReconnect the reconnect policy without configuration file
+var defaultReconnect = bgapi.ReconnectPolicy{
+	Attempts: -1,
+	Delay:    bgapi.Duration(time.Second),
+	MaxDelay: bgapi.Duration(30 * time.Second),
+}
+
+// reading a decoded advertisement, as published
+type reading struct {
+	Time      time.Time        `json:"time"`
+	Address   string           `json:"address"`
+	RSSI      int8             `json:"rssi"`
+	Name      string           `json:"name,omitempty"`
+	Beacon    bgapi.BeaconID   `json:"beacon,omitempty"`
+	IBeacon   *bgapi.IBeacon   `json:"ibeacon,omitempty"`
+	Eddystone *bgapi.Eddystone `json:"eddystone,omitempty"`
+}
+
+// gateway the state shared by the sessions of the supervisor
+type gateway struct {
+	cfg      *bgapi.Config
+	sink     *mqtt.Sink
+	registry *prom.Registry
+
+	// every least interval between the readings of a device, zero to
+	// publish them all
+	every     time.Duration
+	mutex     sync.Mutex
+	published map[string]time.Time // last reading of the devices, by address
+
+	readings    *prom.CounterVec
+	transitions *prom.CounterVec
+	refused     prom.Counter
+}
+
+func main() {
+	flag.Parse()
+
+	cfg := &bgapi.Config{Port: *port, Reconnect: defaultReconnect}
+	interval := *every
+	if *configFile != "" {
+		// the configuration chooses its own filtering
+		interval = 0
+		loaded, err := config.Load(*configFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if loaded.Port == "" {
+			loaded.Port = *port
+		}
+		cfg = loaded
+	}
+
+	sink, err := mqtt.NewSink(mqtt.Options{
+		Broker:   *broker,
+		ClientID: *clientID,
+		Prefix:   *prefix,
+		OnError:  func(err error) { log.Printf("mqtt: %v", err) },
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	g := newGateway(cfg, sink, interval)
+
+	if *metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.HandlerFor(g.registry, promhttp.HandlerOpts{}))
+		go func() {
+			log.Fatal(http.ListenAndServe(*metricsAddr, mux))
+		}()
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	supervisor := &bgapi.Supervisor{
+		Open:      g.open,
+		Reconnect: cfg.Reconnect,
+		OnState:   g.state,
+	}
+	runErr := supervisor.Run(ctx, g.session)
+
+	closeCtx, closeCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer closeCancel()
+	if err := sink.Close(closeCtx); err != nil && !errors.Is(err, mqtt.ErrClosed) {
+		log.Printf("mqtt: %v", err)
+	}
+	if runErr != nil {
+		log.Fatal(runErr)
+	}
+}
+
+// newGateway returns a gateway publishing a reading of each device to sink
+// at most every interval, with its metrics registered
+func newGateway(cfg *bgapi.Config, sink *mqtt.Sink, every time.Duration) *gateway {
+	g := &gateway{
+		cfg:       cfg,
+		sink:      sink,
+		registry:  prom.NewRegistry(),
+		every:     every,
+		published: make(map[string]time.Time),
+		readings: prom.NewCounterVec(prom.CounterOpts{
+			Name: "bgapi_gateway_readings_total",
+			Help: "Advertisements decoded and published.",
+		}, []string{"kind"}),
+		transitions: prom.NewCounterVec(prom.CounterOpts{
+			Name: "bgapi_gateway_transitions_total",
+			Help: "Lifecycle transitions of the dongle.",
+		}, []string{"state"}),
+		refused: prom.NewCounter(prom.CounterOpts{
+			Name: "bgapi_gateway_refused_messages_total",
+			Help: "Messages the MQTT sink did not queue.",
+		}),
+	}
+	g.registry.MustRegister(g.readings, g.transitions, g.refused)
+	return g
+}
+
+// open open the dongle and configure it
+func (g *gateway) open() (*bgapi.Central, error) {
+	central := bgapi.NewCentral()
+	api := central.API()
+	if _, err := api.OpenSerialReady(g.cfg.Port, g.cfg.SerialOptions(), bgapi.HandshakeHello); err != nil {
+		return nil, err
+	}
+	if err := central.ApplyConfig(g.cfg); err != nil {
+		api.Close()
+		return nil, err
+	}
+	return central, nil
+}
+
+// state log the transitions of the supervisor
+func (g *gateway) state(state bgapi.SupervisorState, err error) {
+	g.transitions.WithLabelValues(string(state)).Inc()
+	if err != nil {
+		log.Printf("dongle %s: %v", state, err)
+	} else {
+		log.Printf("dongle %s", state)
+	}
+}
+
+// session wire a newly opened central to the sink and the metrics, then
+// scan until the dongle is lost or the gateway stops
+func (g *gateway) session(ctx context.Context, central *bgapi.Central) error {
+	// the collector reads the API of this session only, a new one is
+	// registered after a reconnection
+	collector, err := prometheus.Register(g.registry, central.API(), prom.Labels{"port": g.cfg.Port})
+	if err != nil {
+		return err
+	}
+	defer g.registry.Unregister(collector)
+	defer collector.Poll(*pollEvery)()
+
+	defer central.PublishTo(g.sink, func(event *bgapi.DeviceEvent, err error) {
+		g.refused.Inc()
+	})()
+
+	return central.ScanMerged(ctx, bgapi.GapDiscoverObservation, *mergeWait, g.decode)
+}
+
+// decode publish the readings of the beacons among the advertisements
+func (g *gateway) decode(m *bgapi.MergedAdvertisement) {
+	adv := m.Parse()
+	r := reading{Time: time.Now(), Address: m.Address.Address.String(), RSSI: m.RSSI, Name: adv.LocalName()}
+	var kind string
+	if ibeacon, ok := adv.IBeacon(); ok {
+		kind = "ibeacon"
+		r.IBeacon = ibeacon
+		r.Beacon = ibeacon.ID()
+	} else if eddystone, ok := adv.Eddystone(); ok {
+		kind = "eddystone-" + eddystone.Frame.String()
+		r.Eddystone = eddystone
+		if eddystone.Frame == bgapi.EddystoneUID {
+			r.Beacon = bgapi.EddystoneUIDID(eddystone.Namespace, eddystone.Instance)
+		}
+	} else {
+		return
+	}
+	if !g.due(r.Address, r.Time) {
+		return
+	}
+
+	payload, err := json.Marshal(&r)
+	if err != nil {
+		log.Printf("encoding a reading: %v", err)
+		return
+	}
+	if err := g.sink.PublishMessage(g.sink.Prefix()+"/"+r.Address+"/reading", payload); err != nil {
+		g.refused.Inc()
+		return
+	}
+	g.readings.WithLabelValues(kind).Inc()
+}
+
+// due returns true when a reading of address is due at now, recording it
+func (g *gateway) due(address string, now time.Time) bool {
+	if g.every <= 0 {
+		return true
+	}
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if last, ok := g.published[address]; ok && now.Sub(last) < g.every {
+		return false
+	}
+	if len(g.published) >= maxPublished {
+		// forget the devices whose next reading is due anyway
+		for known, last := range g.published {
+			if now.Sub(last) >= g.every {
+				delete(g.published, known)
+			}
+		}
+	}
+	g.published[address] = now
+	return true
+}
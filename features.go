@@ -0,0 +1,81 @@
+package bgapi
+
+import (
+	"strings"
+)
+
+// LEFeatures the link layer feature set exchanged with a peer
+type LEFeatures uint64
+
+// link layer features, as numbered in the Bluetooth Core specification
+const (
+	// LEFeatureEncryption LE encryption
+	LEFeatureEncryption LEFeatures = 1 << iota
+	// LEFeatureConnParamsRequest connection parameters request procedure
+	LEFeatureConnParamsRequest
+	// LEFeatureExtendedReject extended reject indication
+	LEFeatureExtendedReject
+	// LEFeatureSlaveFeatureExchange slave-initiated features exchange
+	LEFeatureSlaveFeatureExchange
+	// LEFeaturePing LE ping
+	LEFeaturePing
+	// LEFeatureDataLengthExtension LE data packet length extension
+	LEFeatureDataLengthExtension
+	// LEFeaturePrivacy LL privacy
+	LEFeaturePrivacy
+	// LEFeatureExtendedScannerFilter extended scanner filter policies
+	LEFeatureExtendedScannerFilter
+	// LEFeature2MPhy LE 2M PHY
+	LEFeature2MPhy
+	// LEFeatureStableModulationTx stable modulation index, transmitter
+	LEFeatureStableModulationTx
+	// LEFeatureStableModulationRx stable modulation index, receiver
+	LEFeatureStableModulationRx
+	// LEFeatureCodedPhy LE coded PHY
+	LEFeatureCodedPhy
+	// LEFeatureExtendedAdvertising LE extended advertising
+	LEFeatureExtendedAdvertising
+	// LEFeaturePeriodicAdvertising LE periodic advertising
+	LEFeaturePeriodicAdvertising
+	// LEFeatureChannelSelection2 channel selection algorithm #2
+	LEFeatureChannelSelection2
+	// LEFeaturePowerClass1 LE power class 1
+	LEFeaturePowerClass1
+)
+
+var leFeatureNames = []string{
+	"encryption", "conn_params_request", "extended_reject", "slave_feature_exchange",
+	"ping", "data_length_extension", "privacy", "extended_scanner_filter",
+	"2m_phy", "stable_modulation_tx", "stable_modulation_rx", "coded_phy",
+	"extended_advertising", "periodic_advertising", "channel_selection_2", "power_class_1",
+}
+
+// ParseLEFeatures decode the feature set reported by a feature indication,
+// least significant byte first
+func ParseLEFeatures(data []byte) LEFeatures {
+	var f LEFeatures
+	for i := 0; i < len(data) && i < 8; i++ {
+		f |= LEFeatures(data[i]) << (8 * uint(i))
+	}
+	return f
+}
+
+// Has returns true when all the features in mask are supported
+func (f LEFeatures) Has(mask LEFeatures) bool {
+	return f&mask == mask
+}
+
+// Names returns the names of the supported features
+func (f LEFeatures) Names() []string {
+	var names []string
+	for i, name := range leFeatureNames {
+		if f&(1<<uint(i)) != 0 {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (f LEFeatures) String() string {
+	return strings.Join(f.Names(), ",")
+}
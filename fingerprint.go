@@ -0,0 +1,150 @@
+package bgapi
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Fingerprint the identity of a module: its hardware, firmware and address,
+// and optionally PS keys the application stores per unit (a serial number,
+// an asset tag), so that fleets of dongles can attribute logs and metrics
+// to the unit they come from
+type Fingerprint struct {
+	// ID a short stable digest of the fields below
+	ID              string
+	Address         Mac
+	Hardware        byte
+	Firmware        FirmwareVersion
+	LLVersion       uint16
+	ProtocolVersion byte
+	// PSKeys the selected keys present in the store, in the order requested
+	PSKeys []PSEntry
+}
+
+func (f *Fingerprint) String() string {
+	return fmt.Sprintf("%s (%s, hw %d, fw %s)", f.ID, f.Address, f.Hardware, f.Firmware)
+}
+
+// Labels returns the fingerprint as labels for metrics or structured logs
+func (f *Fingerprint) Labels() map[string]string {
+	return map[string]string{
+		"device":   f.ID,
+		"address":  f.Address.String(),
+		"hardware": strconv.Itoa(int(f.Hardware)),
+		"firmware": f.Firmware.String(),
+	}
+}
+
+// digest compute the ID of the fingerprint
+func (f *Fingerprint) digest() string {
+	h := sha256.New()
+	h.Write(f.Address[:])
+	binary.Write(h, binary.LittleEndian, []uint16{f.Firmware.Major, f.Firmware.Minor, f.Firmware.Patch, f.Firmware.Build, f.LLVersion})
+	h.Write([]byte{f.Hardware, f.ProtocolVersion})
+	for _, e := range f.PSKeys {
+		binary.Write(h, binary.LittleEndian, e.Key)
+		h.Write([]byte{byte(len(e.Value))})
+		h.Write(e.Value)
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// Fingerprint returns the identity of the module, including the values of
+// psKeys; keys absent from the store are left out. The fingerprint is read
+// from the module once and cached until it boots again or psKeys change.
+func (api *API) Fingerprint(psKeys ...uint16) (*Fingerprint, error) {
+	api.mutex.Lock()
+	cached := api.fingerprint
+	api.mutex.Unlock()
+	if cached != nil && sameKeys(cached.keys, psKeys) {
+		return cached.fingerprint, nil
+	}
+
+	f := &Fingerprint{}
+	buf, err := api.call(0, 2, nil)
+	if err != nil {
+		return nil, err
+	}
+	d := newDecoder(buf)
+	d.read(&f.Address)
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	buf, err = api.call(0, 8, nil)
+	if err != nil {
+		return nil, err
+	}
+	var info SystemInfo
+	d = newDecoder(buf)
+	d.read(&info)
+	if d.err != nil {
+		return nil, d.err
+	}
+	api.setFirmware(&info)
+	f.Hardware = info.HW
+	f.Firmware = info.FirmwareVersion()
+	f.LLVersion = info.LLVersion
+	f.ProtocolVersion = info.ProtocolVersion
+
+	for _, key := range psKeys {
+		value, err := api.PSLoad(key)
+		var psErr *PSError
+		if errors.As(err, &psErr) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		f.PSKeys = append(f.PSKeys, PSEntry{Key: key, Value: value})
+	}
+	f.ID = f.digest()
+
+	api.mutex.Lock()
+	api.fingerprint = &cachedFingerprint{fingerprint: f, keys: append([]uint16(nil), psKeys...)}
+	api.mutex.Unlock()
+	return f, nil
+}
+
+// CachedFingerprint returns the fingerprint last read by Fingerprint, nil
+// when none was read since the module booted
+func (api *API) CachedFingerprint() *Fingerprint {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	if api.fingerprint == nil {
+		return nil
+	}
+	return api.fingerprint.fingerprint
+}
+
+// dropFingerprint forget the cached fingerprint, the module booted possibly
+// with new firmware
+func (api *API) dropFingerprint() {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	api.fingerprint = nil
+}
+
+// cachedFingerprint a fingerprint and the keys it was read with
+type cachedFingerprint struct {
+	fingerprint *Fingerprint
+	keys        []uint16
+}
+
+func sameKeys(a []uint16, b []uint16) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,100 @@
+package bgapi
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrUnsupportedFirmware the module firmware predates the command
+var ErrUnsupportedFirmware = errors.New("command not supported by the module firmware")
+
+// FirmwareVersion version of the module firmware
+type FirmwareVersion struct {
+	Major, Minor, Patch, Build uint16
+}
+
+func (v FirmwareVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d-%d", v.Major, v.Minor, v.Patch, v.Build)
+}
+
+// Compare returns -1, 0 or 1 as v is older than, the same as or newer than
+// o, ignoring the build number
+func (v FirmwareVersion) Compare(o FirmwareVersion) int {
+	for _, d := range []int{int(v.Major) - int(o.Major), int(v.Minor) - int(o.Minor), int(v.Patch) - int(o.Patch)} {
+		if d < 0 {
+			return -1
+		} else if d > 0 {
+			return 1
+		}
+	}
+	return 0
+}
+
+// AtLeast returns true when v is o or newer
+func (v FirmwareVersion) AtLeast(o FirmwareVersion) bool {
+	return v.Compare(o) >= 0
+}
+
+// FirmwareVersion returns the firmware version reported by the module
+func (info *SystemInfo) FirmwareVersion() FirmwareVersion {
+	return FirmwareVersion{Major: info.Major, Minor: info.Minor, Patch: info.Patch, Build: info.Build}
+}
+
+// commands added after the first BLED112 firmware release, keyed by class
+// and command
+var commandMinFirmware = map[[2]byte]FirmwareVersion{
+	{0, 13}: {Major: 1, Minor: 1}, // system_endpoint_rx
+	{0, 14}: {Major: 1, Minor: 1}, // system_endpoint_set_watermarks
+	{6, 10}: {Major: 1, Minor: 1}, // gap_set_directed_connectable_mode
+	{7, 13}: {Major: 1, Minor: 1}, // hardware_timer_comparator
+	{7, 14}: {Major: 1, Minor: 1}, // hardware_io_port_irq_enable
+	{7, 15}: {Major: 1, Minor: 1}, // hardware_io_port_irq_direction
+	{7, 16}: {Major: 1, Minor: 1}, // hardware_analog_comparator_enable
+	{7, 17}: {Major: 1, Minor: 1}, // hardware_analog_comparator_read
+	{7, 18}: {Major: 1, Minor: 1}, // hardware_analog_comparator_config_irq
+	{7, 19}: {Major: 1, Minor: 2}, // hardware_set_rxgain
+	{7, 20}: {Major: 1, Minor: 3}, // hardware_usb_enable
+	{7, 21}: {Major: 1, Minor: 3}, // hardware_sleep_enable
+}
+
+// FirmwareVersion returns the firmware version of the module, known once it
+// booted or answered SystemInfoGet
+func (api *API) FirmwareVersion() (FirmwareVersion, bool) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	if api.firmware == nil {
+		return FirmwareVersion{}, false
+	}
+	return *api.firmware, true
+}
+
+// setFirmware record the firmware version of the module
+func (api *API) setFirmware(info *SystemInfo) {
+	version := info.FirmwareVersion()
+
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	api.firmware = &version
+}
+
+// checkFirmware returns ErrUnsupportedFirmware when the module is known to
+// run firmware older than the command, or was probed without it
+func (api *API) checkFirmware(class byte, cmd byte) error {
+	if supported, known := api.probedCapability(class, cmd); known {
+		if !supported {
+			return fmt.Errorf("%w: %s not implemented by the module", ErrUnsupportedFirmware, CommandName(class, cmd))
+		}
+		return nil
+	}
+	required, gated := commandMinFirmware[[2]byte{class, cmd}]
+	if !gated {
+		return nil
+	}
+
+	if version, known := api.FirmwareVersion(); known && !version.AtLeast(required) {
+		return fmt.Errorf("%w: %s needs %s, the module runs %s", ErrUnsupportedFirmware, CommandName(class, cmd), required, version)
+	}
+	return nil
+}
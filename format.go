@@ -0,0 +1,307 @@
+package bgapi
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"unicode/utf16"
+)
+
+// Format types of the presentation format, assigned by the Bluetooth SIG
+const (
+	FormatBoolean byte = 0x01
+	Format2Bit    byte = 0x02
+	FormatNibble  byte = 0x03
+	FormatUint8   byte = 0x04
+	FormatUint12  byte = 0x05
+	FormatUint16  byte = 0x06
+	FormatUint24  byte = 0x07
+	FormatUint32  byte = 0x08
+	FormatUint48  byte = 0x09
+	FormatUint64  byte = 0x0a
+	FormatUint128 byte = 0x0b
+	FormatSint8   byte = 0x0c
+	FormatSint12  byte = 0x0d
+	FormatSint16  byte = 0x0e
+	FormatSint24  byte = 0x0f
+	FormatSint32  byte = 0x10
+	FormatSint48  byte = 0x11
+	FormatSint64  byte = 0x12
+	FormatSint128 byte = 0x13
+	FormatFloat32 byte = 0x14
+	FormatFloat64 byte = 0x15
+	// FormatSFloat the IEEE 11073 16-bit float
+	FormatSFloat byte = 0x16
+	// FormatFloat the IEEE 11073 32-bit float
+	FormatFloat   byte = 0x17
+	FormatDUint16 byte = 0x18
+	FormatUTF8    byte = 0x19
+	FormatUTF16   byte = 0x1a
+	FormatStruct  byte = 0x1b
+)
+
+var (
+	// ErrNoPresentationFormat the characteristic has no presentation
+	// format descriptor, or it was not read yet
+	ErrNoPresentationFormat = errors.New("characteristic has no presentation format")
+	// ErrUnsupportedFormat the format has no Go counterpart, e.g. 128-bit
+	// integers or opaque structures; the raw value is still available
+	ErrUnsupportedFormat = errors.New("unsupported presentation format")
+)
+
+// integer formats, by format: size in bytes, significant bits, signed
+var integerFormats = map[byte]struct {
+	size, bits int
+	signed     bool
+}{
+	Format2Bit:   {1, 2, false},
+	FormatNibble: {1, 4, false},
+	FormatUint8:  {1, 8, false},
+	FormatUint12: {2, 12, false},
+	FormatUint16: {2, 16, false},
+	FormatUint24: {3, 24, false},
+	FormatUint32: {4, 32, false},
+	FormatUint48: {6, 48, false},
+	FormatUint64: {8, 64, false},
+	FormatSint8:  {1, 8, true},
+	FormatSint12: {2, 12, true},
+	FormatSint16: {2, 16, true},
+	FormatSint24: {3, 24, true},
+	FormatSint32: {4, 32, true},
+	FormatSint48: {6, 48, true},
+	FormatSint64: {8, 64, true},
+}
+
+// unit symbols of common units, by the assigned unit UUID
+var unitSymbols = map[uint16]string{
+	0x2700: "",
+	0x2701: "m",
+	0x2702: "kg",
+	0x2703: "s",
+	0x2704: "A",
+	0x2705: "K",
+	0x2724: "Pa",
+	0x2726: "W",
+	0x2728: "V",
+	0x272f: "°C",
+	0x2731: "lx",
+	0x27a7: "bpm",
+	0x27ad: "%",
+	0x27b0: "dBm",
+	0x2780: "m/s²",
+}
+
+// UnitSymbol returns the symbol of a unit, or its UUID when unknown
+func UnitSymbol(unit uint16) string {
+	if symbol, ok := unitSymbols[unit]; ok {
+		return symbol
+	}
+	return fmt.Sprintf("unit 0x%04x", unit)
+}
+
+// Value a characteristic value decoded according to its presentation
+// format
+type Value struct {
+	// Raw the value as transferred, always set
+	Raw []byte
+	// Format the presentation format the value was decoded with
+	Format *PresentationFormat
+	// Decoded the value as bool, uint64, int64, float64, [2]uint16 or
+	// string; integers with a non-zero exponent decode to float64 with the
+	// exponent applied. Nil when the format is unsupported.
+	Decoded interface{}
+}
+
+// Float returns a numeric value as a float64
+func (v *Value) Float() (float64, bool) {
+	switch d := v.Decoded.(type) {
+	case uint64:
+		return float64(d), true
+	case int64:
+		return float64(d), true
+	case float64:
+		return d, true
+	}
+	return 0, false
+}
+
+// String format the decoded value followed by its unit
+func (v *Value) String() string {
+	if v.Decoded == nil {
+		return fmt.Sprintf("% x", v.Raw)
+	}
+	if symbol := unitSymbols[v.Format.Unit]; symbol != "" {
+		return fmt.Sprintf("%v %s", v.Decoded, symbol)
+	}
+	return fmt.Sprint(v.Decoded)
+}
+
+// DecodeValue decode raw according to format. Values of unsupported
+// formats are returned undecoded along with ErrUnsupportedFormat.
+func DecodeValue(format *PresentationFormat, raw []byte) (*Value, error) {
+	v := &Value{Raw: raw, Format: format}
+	decoded, err := decodeFormat(format.Format, format.Exponent, raw)
+	if err != nil {
+		return v, err
+	}
+	v.Decoded = decoded
+	return v, nil
+}
+
+// decodeFormat decode a value of the given format
+func decodeFormat(format byte, exponent int8, raw []byte) (interface{}, error) {
+	if f, ok := integerFormats[format]; ok {
+		if len(raw) < f.size {
+			return nil, fmt.Errorf("%d byte value too short for its format", len(raw))
+		}
+		var u uint64
+		for i := f.size - 1; i >= 0; i-- {
+			u = u<<8 | uint64(raw[i])
+		}
+		if f.bits < 64 {
+			u &= 1<<uint(f.bits) - 1
+		}
+		if !f.signed {
+			if exponent != 0 {
+				return float64(u) * math.Pow10(int(exponent)), nil
+			}
+			return u, nil
+		}
+		// sign extend
+		i := int64(u<<uint(64-f.bits)) >> uint(64-f.bits)
+		if exponent != 0 {
+			return float64(i) * math.Pow10(int(exponent)), nil
+		}
+		return i, nil
+	}
+
+	switch format {
+	case FormatBoolean:
+		if len(raw) < 1 {
+			return nil, errors.New("empty boolean value")
+		}
+		return raw[0]&1 != 0, nil
+	case FormatFloat32:
+		if len(raw) < 4 {
+			return nil, errors.New("float32 value shorter than 4 bytes")
+		}
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(raw))), nil
+	case FormatFloat64:
+		if len(raw) < 8 {
+			return nil, errors.New("float64 value shorter than 8 bytes")
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(raw)), nil
+	case FormatSFloat:
+		if len(raw) < 2 {
+			return nil, errors.New("SFLOAT value shorter than 2 bytes")
+		}
+		return decodeSFloat(binary.LittleEndian.Uint16(raw)), nil
+	case FormatFloat:
+		if len(raw) < 4 {
+			return nil, errors.New("FLOAT value shorter than 4 bytes")
+		}
+		return decodeFloat(binary.LittleEndian.Uint32(raw)), nil
+	case FormatDUint16:
+		if len(raw) < 4 {
+			return nil, errors.New("duint16 value shorter than 4 bytes")
+		}
+		return [2]uint16{binary.LittleEndian.Uint16(raw), binary.LittleEndian.Uint16(raw[2:])}, nil
+	case FormatUTF8:
+		return string(raw), nil
+	case FormatUTF16:
+		units := make([]uint16, len(raw)/2)
+		for i := range units {
+			units[i] = binary.LittleEndian.Uint16(raw[2*i:])
+		}
+		return string(utf16.Decode(units)), nil
+	}
+	return nil, ErrUnsupportedFormat
+}
+
+// decodeSFloat decode an IEEE 11073 16-bit float: a 12-bit mantissa and a
+// 4-bit exponent, both signed
+func decodeSFloat(raw uint16) float64 {
+	switch raw {
+	case 0x07ff, 0x0800, 0x0801:
+		// NaN, not at this resolution, reserved
+		return math.NaN()
+	case 0x07fe:
+		return math.Inf(1)
+	case 0x0802:
+		return math.Inf(-1)
+	}
+	mantissa := int64(int16(raw<<4) >> 4)
+	exponent := int(int8(byte(raw>>8)) >> 4)
+	return float64(mantissa) * math.Pow10(exponent)
+}
+
+// decodeFloat decode an IEEE 11073 32-bit float: a 24-bit mantissa and an
+// 8-bit exponent, both signed
+func decodeFloat(raw uint32) float64 {
+	switch raw {
+	case 0x007fffff, 0x00800000, 0x00800001:
+		return math.NaN()
+	case 0x007ffffe:
+		return math.Inf(1)
+	case 0x00800002:
+		return math.Inf(-1)
+	}
+	mantissa := int64(int32(raw<<8) >> 8)
+	exponent := int(int8(raw >> 24))
+	return float64(mantissa) * math.Pow10(exponent)
+}
+
+// Decode decode raw according to the presentation format of the
+// characteristic, which must have been read (see ReadDescriptors)
+func (c *Characteristic) Decode(raw []byte) (*Value, error) {
+	format, ok := c.PresentationFormat()
+	if !ok {
+		return &Value{Raw: raw}, ErrNoPresentationFormat
+	}
+	return DecodeValue(format, raw)
+}
+
+// presentationFormat read the presentation format descriptor of char when
+// it has one not read yet
+func (c *Connection) presentationFormat(char *Characteristic) error {
+	at := char.Descriptor(PresentationFormatUUID)
+	if at == nil {
+		return ErrNoPresentationFormat
+	}
+	if len(at.value) == 0 {
+		if _, err := c.ReadDescriptor(at); err != nil {
+			return fmt.Errorf("reading the presentation format: %w", err)
+		}
+	}
+	return nil
+}
+
+// ReadDecoded read a characteristic and decode its value according to its
+// presentation format, reading the descriptor first if needed. The raw
+// value is returned (in Value.Raw) along with decoding errors.
+func (c *Connection) ReadDecoded(char *Characteristic) (*Value, error) {
+	if err := c.presentationFormat(char); err != nil {
+		return nil, err
+	}
+	raw, err := c.Read(char)
+	if err != nil {
+		return nil, err
+	}
+	return char.Decode(raw)
+}
+
+// SubscribeDecoded subscribe to a characteristic, handing the notified
+// values decoded according to its presentation format to handler
+func (c *Connection) SubscribeDecoded(char *Characteristic, handler func(v *Value, err error)) error {
+	if err := c.presentationFormat(char); err != nil {
+		return err
+	}
+	if char.value == nil {
+		return errors.New("characteristic has no value attribute")
+	}
+	char.value.OnValueChanged = func(raw []byte) {
+		handler(char.Decode(raw))
+	}
+	return c.Subscribe(char, true)
+}
@@ -0,0 +1,331 @@
+package bgapi
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// call a delegate method invoked by the parser
+type call struct {
+	method string
+	args   []interface{}
+}
+
+// recordingDelegate records the events it receives
+type recordingDelegate struct {
+	LoggingDelegate
+	calls []call
+}
+
+func (r *recordingDelegate) record(method string, args ...interface{}) {
+	r.calls = append(r.calls, call{method, args})
+}
+
+func (r *recordingDelegate) OnSystemBoot(info *SystemInfo) {
+	r.record("OnSystemBoot", *info)
+}
+
+func (r *recordingDelegate) OnSystemDebug(data []byte) {
+	r.record("OnSystemDebug", append([]byte(nil), data...))
+}
+
+func (r *recordingDelegate) OnFlashPsKey(key uint16, value []byte) {
+	r.record("OnFlashPsKey", key, append([]byte(nil), value...))
+}
+
+func (r *recordingDelegate) OnConnectionStatus(status *ConnectionStatus) {
+	r.record("OnConnectionStatus", *status)
+}
+
+func (r *recordingDelegate) OnConnectionDisconnected(connection byte, reason uint16) {
+	r.record("OnConnectionDisconnected", connection, reason)
+}
+
+func (r *recordingDelegate) OnAttrclientProcedureCompleted(connection byte, result uint16, chrHandle uint16) {
+	r.record("OnAttrclientProcedureCompleted", connection, result, chrHandle)
+}
+
+func (r *recordingDelegate) OnAttrclientGroupFound(connection byte, start uint16, end uint16, uuid []byte) {
+	r.record("OnAttrclientGroupFound", connection, start, end, append([]byte(nil), uuid...))
+}
+
+func (r *recordingDelegate) OnAttrclientAttributeValue(connection byte, attHandle uint16, valueType byte, value []byte) {
+	r.record("OnAttrclientAttributeValue", connection, attHandle, valueType, append([]byte(nil), value...))
+}
+
+func (r *recordingDelegate) OnGapScanResponse(resp *GapScanRespone) {
+	copied := *resp
+	copied.Data = append([]byte(nil), resp.Data...)
+	r.record("OnGapScanResponse", copied)
+}
+
+func (r *recordingDelegate) OnSmBondStatus(status *SmBondStatus) {
+	r.record("OnSmBondStatus", *status)
+}
+
+func (r *recordingDelegate) OnHardwareAdcResult(input byte, value int16) {
+	r.record("OnHardwareAdcResult", input, value)
+}
+
+// goldenAPI returns an API parsing into a recording delegate
+func goldenAPI() (*API, *recordingDelegate) {
+	delegate := &recordingDelegate{}
+	return NewAPI(delegate), delegate
+}
+
+// frame decode a frame written as hex, spaces ignored
+func frame(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// goldenEvents events captured from a BLED112, with the call each parses
+// into
+var goldenEvents = []struct {
+	name  string
+	frame string
+	want  call
+}{
+	{
+		"system_boot",
+		"80 0c 00 00 01 00 03 00 02 00 7a 00 03 00 01 01",
+		call{"OnSystemBoot", []interface{}{SystemInfo{Major: 1, Minor: 3, Patch: 2, Build: 122, LLVersion: 3, ProtocolVersion: 1, HW: 1}}},
+	},
+	{
+		"flash_ps_key",
+		"80 05 01 00 40 00 02 aa bb",
+		call{"OnFlashPsKey", []interface{}{uint16(0x0040), []byte{0xaa, 0xbb}}},
+	},
+	{
+		"connection_status",
+		"80 10 03 00 00 05 9f 3c 4b 80 07 00 00 3c 00 64 00 00 00 ff",
+		call{"OnConnectionStatus", []interface{}{ConnectionStatus{
+			Connection:   0,
+			Flags:        5,
+			Address:      QualifiedMac{Address: Mac{0x9f, 0x3c, 0x4b, 0x80, 0x07, 0x00}, AddrType: 0},
+			ConnInterval: 60,
+			Timeout:      100,
+			Latency:      0,
+			Bonding:      0xff,
+		}}},
+	},
+	{
+		"connection_disconnected",
+		"80 03 03 04 00 13 02",
+		call{"OnConnectionDisconnected", []interface{}{byte(0), uint16(0x0213)}},
+	},
+	{
+		"attclient_procedure_completed",
+		"80 05 04 01 00 00 00 2a 00",
+		call{"OnAttrclientProcedureCompleted", []interface{}{byte(0), uint16(0), uint16(0x002a)}},
+	},
+	{
+		"attclient_group_found",
+		"80 08 04 02 00 01 00 05 00 02 00 18",
+		call{"OnAttrclientGroupFound", []interface{}{byte(0), uint16(1), uint16(5), []byte{0x00, 0x18}}},
+	},
+	{
+		"attclient_attribute_value",
+		"80 08 04 05 00 2a 00 01 03 64 00 01",
+		call{"OnAttrclientAttributeValue", []interface{}{byte(0), uint16(0x002a), byte(1), []byte{0x64, 0x00, 0x01}}},
+	},
+	{
+		"gap_scan_response",
+		"80 14 06 00 c3 00 9f 3c 4b 80 07 00 00 ff 09 02 01 06 05 09 54 45 53 54",
+		call{"OnGapScanResponse", []interface{}{GapScanRespone{
+			RSSI:       -61,
+			PacketType: 0,
+			Address:    QualifiedMac{Address: Mac{0x9f, 0x3c, 0x4b, 0x80, 0x07, 0x00}, AddrType: 0},
+			Bond:       0xff,
+			Data:       []byte{0x02, 0x01, 0x06, 0x05, 0x09, 'T', 'E', 'S', 'T'},
+		}}},
+	},
+	{
+		"sm_bond_status",
+		"80 04 05 04 00 10 00 03",
+		call{"OnSmBondStatus", []interface{}{SmBondStatus{Bond: 0, KeySize: 16, MITM: 0, Keys: 3}}},
+	},
+	{
+		"hardware_adc_result",
+		"80 03 07 02 0e f0 7f",
+		call{"OnHardwareAdcResult", []interface{}{byte(14), int16(0x7ff0)}},
+	},
+}
+
+func TestGoldenEvents(t *testing.T) {
+	for _, tc := range goldenEvents {
+		t.Run(tc.name, func(t *testing.T) {
+			api, delegate := goldenAPI()
+			api.onSerialPortData(frame(t, tc.frame))
+			if len(delegate.calls) != 1 || !reflect.DeepEqual(delegate.calls[0], tc.want) {
+				t.Fatalf("got %+v, want %+v", delegate.calls, tc.want)
+			}
+		})
+	}
+}
+
+// TestGoldenStream feeds every golden event as a single stream, one byte
+// at a time, as a slow transport would deliver it
+func TestGoldenStream(t *testing.T) {
+	api, delegate := goldenAPI()
+	for _, tc := range goldenEvents {
+		for _, b := range frame(t, tc.frame) {
+			api.onSerialPortData([]byte{b})
+		}
+	}
+	if len(delegate.calls) != len(goldenEvents) {
+		t.Fatalf("got %d calls, want %d", len(delegate.calls), len(goldenEvents))
+	}
+	for i, tc := range goldenEvents {
+		if !reflect.DeepEqual(delegate.calls[i], tc.want) {
+			t.Errorf("%s: got %+v, want %+v", tc.name, delegate.calls[i], tc.want)
+		}
+	}
+}
+
+// TestGoldenAttributeValueTypes decodes the type of attribute_value events
+// as numbered by the protocol
+func TestGoldenAttributeValueTypes(t *testing.T) {
+	for _, tc := range []struct {
+		frame     string
+		valueType byte
+	}{
+		{"80 07 04 05 00 2a 00 00 02 64 00", AttValueTypeRead},
+		{"80 07 04 05 00 2a 00 01 02 64 00", AttValueTypeNotify},
+		{"80 07 04 05 00 2a 00 02 02 64 00", AttValueTypeIndicate},
+		{"80 07 04 05 00 2a 00 03 02 64 00", AttValueTypeReadByType},
+		{"80 07 04 05 00 2a 00 04 02 64 00", AttValueTypeReadBlob},
+		{"80 07 04 05 00 2a 00 05 02 64 00", AttValueTypeIndicateRspReq},
+	} {
+		api, delegate := goldenAPI()
+		api.onSerialPortData(frame(t, tc.frame))
+		want := call{"OnAttrclientAttributeValue", []interface{}{byte(0), uint16(0x002a), tc.valueType, []byte{0x64, 0x00}}}
+		if len(delegate.calls) != 1 || !reflect.DeepEqual(delegate.calls[0], want) {
+			t.Errorf("%s: got %+v, want %+v", tc.frame, delegate.calls, want)
+		}
+	}
+}
+
+func TestGoldenResponse(t *testing.T) {
+	api, _ := goldenAPI()
+	var got Mac
+	var gotErr error
+	api.pendingOp = &operation{class: 0, cmd: 2, completion: func(buf *bytes.Buffer, err error) {
+		gotErr = err
+		got = newDecoder(buf).mac()
+	}}
+	// system_address_get
+	api.onSerialPortData(frame(t, "00 06 00 02 9f 3c 4b 80 07 00"))
+	if gotErr != nil || got != (Mac{0x9f, 0x3c, 0x4b, 0x80, 0x07, 0x00}) {
+		t.Fatal(got, gotErr)
+	}
+}
+
+func TestGoldenResponseMismatch(t *testing.T) {
+	api, _ := goldenAPI()
+	var gotErr error
+	api.pendingOp = &operation{class: 0, cmd: 2, completion: func(buf *bytes.Buffer, err error) {
+		gotErr = err
+	}}
+	// system_hello answering system_address_get
+	api.onSerialPortData(frame(t, "00 00 00 01"))
+	if gotErr == nil {
+		t.Fatal("mismatched response accepted")
+	}
+}
+
+func TestGoldenTruncated(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		frame string
+	}{
+		// the header announces more than was received yet
+		{"partial payload", "80 03 03 04 00"},
+		// fields missing from a complete frame
+		{"short fields", "80 02 03 04 00 13"},
+		// a uint8array longer than the frame
+		{"short array", "80 0d 06 00 c3 00 9f 3c 4b 80 07 00 00 ff 20 02 01"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			api, delegate := goldenAPI()
+			api.onSerialPortData(frame(t, tc.frame))
+			if len(delegate.calls) != 0 {
+				t.Fatalf("truncated frame parsed into %+v", delegate.calls)
+			}
+		})
+	}
+
+	// a dropped frame does not hide the ones that follow
+	api, delegate := goldenAPI()
+	api.onSerialPortData(frame(t, "80 02 03 04 00 13"))
+	api.onSerialPortData(frame(t, "80 03 03 04 01 16 00"))
+	want := call{"OnConnectionDisconnected", []interface{}{byte(1), uint16(0x0016)}}
+	if len(delegate.calls) != 1 || !reflect.DeepEqual(delegate.calls[0], want) {
+		t.Fatalf("got %+v", delegate.calls)
+	}
+}
+
+func TestGoldenLength(t *testing.T) {
+	for _, tc := range []struct {
+		header   uint16
+		length   int
+		event    bool
+		techType int
+	}{
+		{0x8004, 4, true, 0},
+		{0x0006, 6, false, 0},
+		{0x8100, 256, true, 0},
+		{0x87ff, 2047, true, 0},
+		{0x8804, 4, true, 1},
+	} {
+		hdr := bgFrameHeader{length: tc.header}
+		if hdr.frameLengthGet() != tc.length || (hdr.messageTypeGet() == 1) != tc.event || hdr.technologyTypeGet() != tc.techType {
+			t.Errorf("header %04x: length %d, event %v, technology %d", tc.header, hdr.frameLengthGet(), hdr.messageTypeGet() == 1, hdr.technologyTypeGet())
+		}
+	}
+
+	// system_debug carrying 255 bytes, the length spills into the first
+	// header byte
+	data := make([]byte, 255)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	api, delegate := goldenAPI()
+	api.onSerialPortData(append(frame(t, "81 00 00 01 ff"), data...))
+	want := call{"OnSystemDebug", []interface{}{data}}
+	if len(delegate.calls) != 1 || !reflect.DeepEqual(delegate.calls[0], want) {
+		t.Fatalf("got %d calls", len(delegate.calls))
+	}
+}
+
+// TestGoldenPacketMode feeds the golden events framed for a UART without
+// flow control, each preceded by its length
+func TestGoldenPacketMode(t *testing.T) {
+	api, delegate := goldenAPI()
+	api.framer.packetMode = true
+	var stream []byte
+	for _, tc := range goldenEvents {
+		f := frame(t, tc.frame)
+		stream = append(append(stream, byte(len(f))), f...)
+	}
+	// split mid-frame, after the length byte of the second frame
+	split := len(frame(t, goldenEvents[0].frame)) + 2
+	api.onSerialPortData(stream[:split])
+	api.onSerialPortData(stream[split:])
+
+	if len(delegate.calls) != len(goldenEvents) {
+		t.Fatalf("got %d calls, want %d", len(delegate.calls), len(goldenEvents))
+	}
+	for i, tc := range goldenEvents {
+		if !reflect.DeepEqual(delegate.calls[i], tc.want) {
+			t.Errorf("%s: got %+v, want %+v", tc.name, delegate.calls[i], tc.want)
+		}
+	}
+}
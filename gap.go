@@ -0,0 +1,266 @@
+package bgapi
+
+import (
+	"fmt"
+	"time"
+)
+
+// gapUnit duration of the 0.625 ms unit of GAP scan and advertising timings
+const gapUnit = 625 * time.Microsecond
+
+// scan interval and window range in gapUnits (2.5 ms to 10.24 s)
+const (
+	scanTimingMin = 0x0004
+	scanTimingMax = 0x4000
+)
+
+// advertising interval range in gapUnits (20 ms to 10.24 s)
+const (
+	advIntervalMin = 0x0020
+	advIntervalMax = 0x4000
+)
+
+// GapUnits convert a duration to 0.625 ms units, rounding to the nearest
+func GapUnits(d time.Duration) uint16 {
+	units := (d + gapUnit/2) / gapUnit
+	if units > 0xffff {
+		units = 0xffff
+	}
+	return uint16(units)
+}
+
+// GapDuration convert 0.625 ms units to a duration
+func GapDuration(units uint16) time.Duration {
+	return time.Duration(units) * gapUnit
+}
+
+// ScanParameters scan timing, in 0.625 ms units
+type ScanParameters struct {
+	// Interval time from the start of a scan window to the next
+	Interval uint16
+	// Window time spent scanning during every interval
+	Window uint16
+	// Active send scan requests to obtain scan responses
+	Active bool
+}
+
+// scan presets
+var (
+	// ScanLowPower scan 11.25 ms every 1.28 s
+	ScanLowPower = ScanParameters{Interval: 0x0800, Window: 0x0012}
+	// ScanBalanced scan 50 ms every 100 ms
+	ScanBalanced = ScanParameters{Interval: 0x00a0, Window: 0x0050}
+	// ScanLowLatency scan continuously, in back to back 60 ms windows
+	ScanLowLatency = ScanParameters{Interval: 0x0060, Window: 0x0060}
+)
+
+// NewScanParameters returns validated scan parameters
+func NewScanParameters(interval time.Duration, window time.Duration, active bool) (ScanParameters, error) {
+	p := ScanParameters{Interval: GapUnits(interval), Window: GapUnits(window), Active: active}
+	return p, p.Validate()
+}
+
+// Validate check the timings are within range and the window fits the
+// interval
+func (p *ScanParameters) Validate() error {
+	if p.Interval < scanTimingMin || p.Interval > scanTimingMax {
+		return fmt.Errorf("scan interval %v outside 2.5ms-10.24s", GapDuration(p.Interval))
+	}
+	if p.Window < scanTimingMin || p.Window > scanTimingMax {
+		return fmt.Errorf("scan window %v outside 2.5ms-10.24s", GapDuration(p.Window))
+	}
+	if p.Window > p.Interval {
+		return fmt.Errorf("scan window %v longer than the interval %v", GapDuration(p.Window), GapDuration(p.Interval))
+	}
+	return nil
+}
+
+// GapApplyScanParameters validate and apply scan parameters
+func (api *API) GapApplyScanParameters(p *ScanParameters) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	return api.GapSetScanParameters(p.Interval, p.Window, boolCast(p.Active))
+}
+
+// SetScanParameters validate and keep the scan timings used when scanning,
+// whether to send scan requests is chosen by ScanRequestEnable and
+// ScanRequestDisable
+func (c *Central) SetScanParameters(p ScanParameters) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	c.ScanInterval = p.Interval
+	c.ScanWindow = p.Window
+	return nil
+}
+
+// AdvChannels the advertising channels to use
+type AdvChannels uint8
+
+// advertising channels
+const (
+	// AdvChannel37 advertise on channel 37 (2402 MHz)
+	AdvChannel37 AdvChannels = 1 << iota
+	// AdvChannel38 advertise on channel 38 (2426 MHz)
+	AdvChannel38
+	// AdvChannel39 advertise on channel 39 (2480 MHz)
+	AdvChannel39
+
+	// AdvChannelsAll advertise on all three channels
+	AdvChannelsAll = AdvChannel37 | AdvChannel38 | AdvChannel39
+)
+
+// AdvParameters advertising timing, in 0.625 ms units
+type AdvParameters struct {
+	IntervalMin uint16
+	IntervalMax uint16
+	Channels    AdvChannels
+}
+
+// advertising presets
+var (
+	// AdvFast advertise every 20-30 ms, to be found quickly
+	AdvFast = AdvParameters{IntervalMin: 0x0020, IntervalMax: 0x0030, Channels: AdvChannelsAll}
+	// AdvBalanced advertise every 152.5-211.25 ms
+	AdvBalanced = AdvParameters{IntervalMin: 0x00f4, IntervalMax: 0x0152, Channels: AdvChannelsAll}
+	// AdvLowPower advertise every 1022.5-1285 ms
+	AdvLowPower = AdvParameters{IntervalMin: 0x0664, IntervalMax: 0x0808, Channels: AdvChannelsAll}
+)
+
+// NewAdvParameters returns validated advertising parameters
+func NewAdvParameters(intervalMin time.Duration, intervalMax time.Duration, channels AdvChannels) (AdvParameters, error) {
+	p := AdvParameters{IntervalMin: GapUnits(intervalMin), IntervalMax: GapUnits(intervalMax), Channels: channels}
+	return p, p.Validate()
+}
+
+// Validate check the intervals are within range and ordered and that at
+// least one channel is used
+func (p *AdvParameters) Validate() error {
+	if p.IntervalMin < advIntervalMin || p.IntervalMax > advIntervalMax {
+		return fmt.Errorf("advertising interval %v-%v outside 20ms-10.24s",
+			GapDuration(p.IntervalMin), GapDuration(p.IntervalMax))
+	}
+	if p.IntervalMin > p.IntervalMax {
+		return fmt.Errorf("advertising interval minimum %v above the maximum %v",
+			GapDuration(p.IntervalMin), GapDuration(p.IntervalMax))
+	}
+	if p.Channels == 0 || p.Channels&^AdvChannelsAll != 0 {
+		return fmt.Errorf("invalid advertising channel mask 0x%02x", byte(p.Channels))
+	}
+	return nil
+}
+
+// GapApplyAdvParameters validate and apply advertising parameters
+func (api *API) GapApplyAdvParameters(p *AdvParameters) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	return api.GapSetAdvParameters(p.IntervalMin, p.IntervalMax, uint8(p.Channels))
+}
+
+// ScanPolicy which advertisers the scanner reports
+type ScanPolicy byte
+
+// scan policies
+const (
+	// ScanPolicyAll report all advertisers
+	ScanPolicyAll ScanPolicy = iota
+	// ScanPolicyWhitelist report whitelisted advertisers only
+	ScanPolicyWhitelist
+)
+
+// AdvPolicy which devices may scan or connect to the advertiser
+type AdvPolicy byte
+
+// advertising policies
+const (
+	// AdvPolicyAll accept scan and connection requests from all devices
+	AdvPolicyAll AdvPolicy = iota
+	// AdvPolicyWhitelistScan accept scan requests from whitelisted devices only
+	AdvPolicyWhitelistScan
+	// AdvPolicyWhitelistConnect accept connection requests from whitelisted
+	// devices only
+	AdvPolicyWhitelistConnect
+	// AdvPolicyWhitelistAll accept scan and connection requests from
+	// whitelisted devices only
+	AdvPolicyWhitelistAll
+)
+
+// FilterPolicy the scan and advertising filters, applied together
+type FilterPolicy struct {
+	Scan ScanPolicy
+	Adv  AdvPolicy
+	// DuplicateFiltering report each advertiser once per scan
+	DuplicateFiltering bool
+}
+
+// GapSetFiltering set the scan and advertising filters
+func (api *API) GapSetFiltering(policy *FilterPolicy) error {
+	if policy.Scan > ScanPolicyWhitelist {
+		return fmt.Errorf("invalid scan policy %d", policy.Scan)
+	}
+	if policy.Adv > AdvPolicyWhitelistAll {
+		return fmt.Errorf("invalid advertising policy %d", policy.Adv)
+	}
+	return api.GapSetFilteringRaw(byte(policy.Scan), byte(policy.Adv), boolCast(policy.DuplicateFiltering))
+}
+
+// GapDiscoverableMode how the module advertises
+type GapDiscoverableMode byte
+
+// discoverable modes
+const (
+	// GapNonDiscoverable not discoverable
+	GapNonDiscoverable GapDiscoverableMode = 0
+	// GapLimitedDiscoverable discoverable by limited and general discovery
+	GapLimitedDiscoverable GapDiscoverableMode = 1
+	// GapGeneralDiscoverable discoverable by general discovery
+	GapGeneralDiscoverable GapDiscoverableMode = 2
+	// GapBroadcast non-discoverable broadcasting
+	GapBroadcast GapDiscoverableMode = 3
+	// GapUserData advertise the data set by GapSetAdvData
+	GapUserData GapDiscoverableMode = 4
+	// GapEnhancedBroadcasting broadcasting with the enhanced (scan response)
+	// data
+	GapEnhancedBroadcasting GapDiscoverableMode = 0x80
+)
+
+// GapConnectableMode whether the module accepts connections
+type GapConnectableMode byte
+
+// connectable modes
+const (
+	// GapNonConnectable not connectable
+	GapNonConnectable GapConnectableMode = iota
+	// GapDirectedConnectable connectable by a single device
+	GapDirectedConnectable
+	// GapUndirectedConnectable connectable by any device
+	GapUndirectedConnectable
+	// GapScannableNonConnectable answers scan requests but is not connectable
+	GapScannableNonConnectable
+)
+
+// GapMode the discoverable and connectable modes of the module
+type GapMode struct {
+	Discoverable GapDiscoverableMode
+	Connectable  GapConnectableMode
+}
+
+// CurrentGapMode returns the last mode applied by GapSetMode or reported by
+// a mode changed event; the module restarts non-discoverable and
+// non-connectable after a reset
+func (api *API) CurrentGapMode() GapMode {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	return api.gapMode
+}
+
+// setGapMode record the mode of the module
+func (api *API) setGapMode(mode GapMode) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	api.gapMode = mode
+}
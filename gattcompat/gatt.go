@@ -0,0 +1,188 @@
+// Package gattcompat adapts the bgapi Central to the Device and Peripheral
+// interfaces of github.com/paypal/gatt, easing the migration of existing
+// central applications onto a BLED112.
+package gattcompat
+
+import (
+	"errors"
+	"sync"
+
+	bgapi "github.com/jsakwa/go_bgapi"
+)
+
+// State the state of the device
+type State int
+
+const (
+	// StateUnknown the device has not been initialized
+	StateUnknown State = iota
+	// StatePoweredOff the device is closed
+	StatePoweredOff
+	// StatePoweredOn the device is ready
+	StatePoweredOn
+)
+
+func (s State) String() string {
+	switch s {
+	case StatePoweredOff:
+		return "PoweredOff"
+	case StatePoweredOn:
+		return "PoweredOn"
+	}
+	return "Unknown"
+}
+
+// Device the central device
+type Device interface {
+	// Init open the device, stateChanged is invoked once it is ready
+	Init(stateChanged func(Device, State)) error
+	// Scan start scanning, reporting peripherals advertising any of ss (all
+	// peripherals when ss is empty) to the PeripheralDiscovered handler
+	Scan(ss []UUID, dup bool)
+	// StopScanning stop scanning
+	StopScanning()
+	// Connect connect to a peripheral, the result is reported to the
+	// PeripheralConnected handler
+	Connect(p Peripheral)
+	// CancelConnection disconnect from a peripheral
+	CancelConnection(p Peripheral)
+	// Handle register handlers
+	Handle(hh ...Handler)
+}
+
+// Handler configures a device event handler
+type Handler func(Device)
+
+// Advertisement decoded advertisement data
+type Advertisement struct {
+	LocalName        string
+	ManufacturerData []byte
+	Services         []UUID
+	TxPowerLevel     int
+	Connectable      bool
+}
+
+// PeripheralDiscovered handle discovered peripherals
+func PeripheralDiscovered(f func(Peripheral, *Advertisement, int)) Handler {
+	return func(d Device) { d.(*device).peripheralDiscovered = f }
+}
+
+// PeripheralConnected handle the result of Connect
+func PeripheralConnected(f func(Peripheral, error)) Handler {
+	return func(d Device) { d.(*device).peripheralConnected = f }
+}
+
+// PeripheralDisconnected handle disconnections
+func PeripheralDisconnected(f func(Peripheral, error)) Handler {
+	return func(d Device) { d.(*device).peripheralDisconnected = f }
+}
+
+type device struct {
+	port    string
+	central *bgapi.Central
+	params  *bgapi.ConnectionParameters
+
+	peripheralDiscovered   func(Peripheral, *Advertisement, int)
+	peripheralConnected    func(Peripheral, error)
+	peripheralDisconnected func(Peripheral, error)
+
+	mutex  sync.Mutex
+	filter []UUID
+	dup    bool
+	seen   map[string]bool
+}
+
+// NewDevice returns a Device driving the BLED112 on the given serial port
+func NewDevice(port string, hh ...Handler) Device {
+	d := &device{port: port, central: bgapi.NewCentral(), params: bgapi.DefaultConnectionParameters()}
+	d.central.OnScanResponse = d.onScanResponse
+	// gatt applications connect straight from their discovery handler
+	d.central.ConflictPolicy = bgapi.ConflictEndActive
+	d.Handle(hh...)
+	return d
+}
+
+func (d *device) Init(stateChanged func(Device, State)) error {
+	if err := d.central.API().OpenBLED112(d.port); err != nil {
+		return err
+	}
+	if stateChanged != nil {
+		go stateChanged(d, StatePoweredOn)
+	}
+	return nil
+}
+
+func (d *device) Handle(hh ...Handler) {
+	for _, h := range hh {
+		h(d)
+	}
+}
+
+func (d *device) Scan(ss []UUID, dup bool) {
+	d.mutex.Lock()
+	d.filter = ss
+	d.dup = dup
+	d.seen = make(map[string]bool)
+	d.mutex.Unlock()
+
+	d.central.StartScanBasic()
+}
+
+func (d *device) StopScanning() {
+	d.central.StopScanBasic()
+}
+
+func (d *device) onScanResponse(resp *bgapi.GapScanRespone) {
+	adv := bgapi.ParseGapScanResponse(resp)
+	a := &Advertisement{
+		LocalName:   adv.LocalName(),
+		Connectable: resp.Type().Connectable(),
+	}
+	if md, ok := (*adv)[0xff]; ok {
+		a.ManufacturerData = md
+	}
+	if tx, ok := (*adv)[0x0a]; ok && len(tx) > 0 {
+		a.TxPowerLevel = int(int8(tx[0]))
+	}
+	for _, u := range adv.ServiceUUIDs() {
+		a.Services = append(a.Services, UUID{u})
+	}
+
+	d.mutex.Lock()
+	match := len(d.filter) == 0
+	for _, u := range a.Services {
+		match = match || uuidIn(u, d.filter)
+	}
+	id := resp.Address.Hashable()
+	if match && !d.dup {
+		match = !d.seen[id]
+		d.seen[id] = true
+	}
+	handler := d.peripheralDiscovered
+	d.mutex.Unlock()
+
+	if match && handler != nil {
+		handler(&peripheral{d: d, resp: *resp, name: a.LocalName}, a, int(resp.RSSI))
+	}
+}
+
+func (d *device) Connect(p Peripheral) {
+	pp := p.(*peripheral)
+	go func() {
+		pp.conn = d.central.NewConnection(&pp.resp, d.params)
+		pp.conn.SetDelegate(pp)
+		err := pp.conn.Open()
+		if d.peripheralConnected != nil {
+			d.peripheralConnected(p, err)
+		}
+	}()
+}
+
+func (d *device) CancelConnection(p Peripheral) {
+	if pp := p.(*peripheral); pp.conn != nil {
+		pp.conn.Close()
+	}
+}
+
+// ErrNotConnected returned by peripheral operations before Connect succeeded
+var ErrNotConnected = errors.New("peripheral not connected")
@@ -0,0 +1,155 @@
+package gattcompat
+
+import (
+	"fmt"
+
+	bgapi "github.com/jsakwa/go_bgapi"
+)
+
+// Service a discovered GATT service
+type Service struct {
+	uuid            UUID
+	svc             *bgapi.Service
+	characteristics []*Characteristic
+}
+
+// UUID returns the service UUID
+func (s *Service) UUID() UUID {
+	return s.uuid
+}
+
+// Characteristics returns the characteristics discovered so far
+func (s *Service) Characteristics() []*Characteristic {
+	return s.characteristics
+}
+
+// Characteristic a discovered GATT characteristic
+type Characteristic struct {
+	uuid UUID
+	svc  *Service
+	char *bgapi.Characteristic
+}
+
+// UUID returns the characteristic UUID
+func (c *Characteristic) UUID() UUID {
+	return c.uuid
+}
+
+// Service returns the service the characteristic belongs to
+func (c *Characteristic) Service() *Service {
+	return c.svc
+}
+
+// Properties returns the characteristic properties
+func (c *Characteristic) Properties() byte {
+	return c.char.Properties()
+}
+
+// Peripheral a remote peripheral
+type Peripheral interface {
+	Device() Device
+	ID() string
+	Name() string
+	Services() []*Service
+	DiscoverServices(ss []UUID) ([]*Service, error)
+	DiscoverCharacteristics(cs []UUID, s *Service) ([]*Characteristic, error)
+	ReadCharacteristic(c *Characteristic) ([]byte, error)
+	WriteCharacteristic(c *Characteristic, b []byte, noRsp bool) error
+	SetNotifyValue(c *Characteristic, f func(*Characteristic, []byte, error)) error
+}
+
+type peripheral struct {
+	d        *device
+	resp     bgapi.GapScanRespone
+	name     string
+	conn     *bgapi.Connection
+	services []*Service
+}
+
+func (p *peripheral) Device() Device {
+	return p.d
+}
+
+func (p *peripheral) ID() string {
+	return p.resp.Address.Address.String()
+}
+
+func (p *peripheral) Name() string {
+	return p.name
+}
+
+func (p *peripheral) Services() []*Service {
+	return p.services
+}
+
+// DiscoverServices the BGAPI central discovers the whole database when it
+// connects, so this only filters the result
+func (p *peripheral) DiscoverServices(ss []UUID) ([]*Service, error) {
+	if p.conn == nil {
+		return nil, ErrNotConnected
+	}
+
+	p.services = nil
+	for _, s := range p.conn.Services() {
+		u := UUID{s.UUID()}
+		if uuidIn(u, ss) {
+			p.services = append(p.services, &Service{uuid: u, svc: s})
+		}
+	}
+	return p.services, nil
+}
+
+func (p *peripheral) DiscoverCharacteristics(cs []UUID, s *Service) ([]*Characteristic, error) {
+	if p.conn == nil {
+		return nil, ErrNotConnected
+	}
+
+	s.characteristics = nil
+	for _, c := range s.svc.Characteristics() {
+		u := UUID{c.UUID()}
+		if uuidIn(u, cs) {
+			s.characteristics = append(s.characteristics, &Characteristic{uuid: u, svc: s, char: c})
+		}
+	}
+	return s.characteristics, nil
+}
+
+func (p *peripheral) ReadCharacteristic(c *Characteristic) ([]byte, error) {
+	if p.conn == nil {
+		return nil, ErrNotConnected
+	}
+	return p.conn.Read(c.char)
+}
+
+func (p *peripheral) WriteCharacteristic(c *Characteristic, b []byte, noRsp bool) error {
+	if p.conn == nil {
+		return ErrNotConnected
+	}
+	return p.conn.Write(c.char, b, noRsp)
+}
+
+// SetNotifyValue subscribe to notifications, a nil f unsubscribes
+func (p *peripheral) SetNotifyValue(c *Characteristic, f func(*Characteristic, []byte, error)) error {
+	if p.conn == nil {
+		return ErrNotConnected
+	}
+
+	value := c.char.ValueAttribute()
+	if value == nil {
+		return fmt.Errorf("characteristic %s has no value attribute", c.uuid)
+	}
+
+	if f != nil {
+		value.OnValueChanged = func(data []byte) { f(c, data, nil) }
+	} else {
+		value.OnValueChanged = nil
+	}
+	return p.conn.Subscribe(c.char, f != nil)
+}
+
+// OnDisconnected implements bgapi.ConnectionDelegate
+func (p *peripheral) OnDisconnected(reason uint16) {
+	if p.d.peripheralDisconnected != nil {
+		p.d.peripheralDisconnected(p, fmt.Errorf("disconnected, reason 0x%04x", reason))
+	}
+}
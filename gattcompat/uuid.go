@@ -0,0 +1,72 @@
+package gattcompat
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+
+	bgapi "github.com/jsakwa/go_bgapi"
+)
+
+// UUID a 16-bit or 128-bit Bluetooth UUID, stored least significant byte
+// first as transferred by BGAPI
+type UUID struct {
+	b []byte
+}
+
+// UUID16 returns a 16-bit UUID
+func UUID16(i uint16) UUID {
+	b := make([]byte, 2)
+	binary.LittleEndian.PutUint16(b, i)
+	return UUID{b}
+}
+
+// ParseUUID parse a UUID in its usual textual form, e.g. "180d" or
+// "6e400001-b5a3-f393-e0a9-e50e24dcca9e"
+func ParseUUID(s string) (UUID, error) {
+	b, err := bgapi.ParseUUID(s)
+	return UUID{b}, err
+}
+
+// MustParseUUID like ParseUUID but panics on error
+func MustParseUUID(s string) UUID {
+	u, err := ParseUUID(s)
+	if err != nil {
+		panic(err)
+	}
+	return u
+}
+
+// Bytes returns the UUID least significant byte first
+func (u UUID) Bytes() []byte {
+	return u.b
+}
+
+// Equal compare two UUIDs
+func (u UUID) Equal(v UUID) bool {
+	return bytes.Equal(u.b, v.b)
+}
+
+func (u UUID) String() string {
+	return hex.EncodeToString(reverse(u.b))
+}
+
+func reverse(b []byte) []byte {
+	r := make([]byte, len(b))
+	for i, v := range b {
+		r[len(b)-1-i] = v
+	}
+	return r
+}
+
+func uuidIn(u UUID, set []UUID) bool {
+	if len(set) == 0 {
+		return true
+	}
+	for _, v := range set {
+		if u.Equal(v) {
+			return true
+		}
+	}
+	return false
+}
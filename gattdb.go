@@ -0,0 +1,281 @@
+package bgapi
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// HexBytes a byte string written in hexadecimal (e.g. "0648") in JSON
+type HexBytes []byte
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (b *HexBytes) UnmarshalText(text []byte) error {
+	v, err := hex.DecodeString(string(text))
+	if err != nil {
+		return err
+	}
+	*b = v
+	return nil
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (b HexBytes) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(b)), nil
+}
+
+// GattDatabase snapshot of the GATT database of a peripheral, tagged for
+// JSON. Capture one with Connection.Snapshot and save it with Save; load it
+// back with LoadGattDatabase, e.g. to serve it from bgapitest.Peripheral.
+// UUIDs are written in their usual textual form, see UUIDString.
+type GattDatabase struct {
+	// Address of the peripheral, as printed by Mac.String
+	Address  string        `json:"address,omitempty"`
+	Services []GattService `json:"services"`
+}
+
+// GattService a primary service and the handle range it spans
+type GattService struct {
+	Handle          uint16               `json:"handle"`
+	End             uint16               `json:"end"`
+	UUID            string               `json:"uuid"`
+	Characteristics []GattCharacteristic `json:"characteristics,omitempty"`
+}
+
+// GattCharacteristic a characteristic, Handle being that of its
+// declaration; Value is empty unless it was read
+type GattCharacteristic struct {
+	Handle      uint16           `json:"handle"`
+	UUID        string           `json:"uuid"`
+	Properties  byte             `json:"properties"`
+	ValueHandle uint16           `json:"value_handle"`
+	Value       HexBytes         `json:"value,omitempty"`
+	Descriptors []GattDescriptor `json:"descriptors,omitempty"`
+}
+
+// GattDescriptor a characteristic descriptor and its value, if read
+type GattDescriptor struct {
+	Handle uint16   `json:"handle"`
+	UUID   string   `json:"uuid"`
+	Value  HexBytes `json:"value,omitempty"`
+}
+
+// GattAttribute an attribute of the flattened database, see
+// GattDatabase.Attributes
+type GattAttribute struct {
+	Handle uint16
+	// Type attribute type (least significant byte first)
+	Type  []byte
+	Value []byte
+}
+
+// Snapshot returns the services, characteristics and descriptors
+// discovered on the connection. The values known, i.e. the descriptors
+// read by discovery and values read or notified since, are included; with
+// readValues the value of every readable characteristic and every
+// descriptor is read first.
+func (c *Connection) Snapshot(readValues bool) (*GattDatabase, error) {
+	db := &GattDatabase{Address: c.Address().Address.String(), Services: []GattService{}}
+	for _, s := range c.Services() {
+		service := GattService{Handle: s.startHandle, End: s.endHandle, UUID: UUIDString(s.uuid)}
+		for _, char := range s.characteristics {
+			if readValues {
+				if err := c.readSnapshotValues(char); err != nil {
+					return nil, err
+				}
+			}
+			service.Characteristics = append(service.Characteristics, snapshotCharacteristic(char))
+		}
+		db.Services = append(db.Services, service)
+	}
+	return db, nil
+}
+
+// readSnapshotValues read the value and descriptors of a characteristic
+func (c *Connection) readSnapshotValues(char *Characteristic) error {
+	if char.value != nil && char.properties&CharPropRead != 0 {
+		value, err := c.Read(char)
+		if err == nil && len(value) >= maxReadLength {
+			_, err = c.ReadLong(char)
+		}
+		if err != nil {
+			return fmt.Errorf("reading characteristic %s: %w", UUIDString(char.uuid), err)
+		}
+	}
+	for _, at := range char.Descriptors() {
+		if _, err := c.ReadDescriptor(at); err != nil {
+			return fmt.Errorf("reading descriptor 0x%04x: %w", at.handle, err)
+		}
+	}
+	return nil
+}
+
+// snapshotCharacteristic copy a discovered characteristic
+func snapshotCharacteristic(char *Characteristic) GattCharacteristic {
+	snapshot := GattCharacteristic{Handle: char.declHandle, UUID: UUIDString(char.uuid), Properties: char.properties}
+	if char.value != nil {
+		snapshot.ValueHandle = char.value.handle
+		snapshot.Value = append(HexBytes(nil), char.value.value...)
+	}
+	for _, at := range char.Descriptors() {
+		snapshot.Descriptors = append(snapshot.Descriptors, GattDescriptor{
+			Handle: at.handle,
+			UUID:   UUIDString(at.uuid),
+			Value:  append(HexBytes(nil), at.value...),
+		})
+	}
+	return snapshot
+}
+
+// restoreGatt rebuild the services, characteristics and descriptors of a
+// snapshot as discovery would, db must be valid
+func (c *Connection) restoreGatt(db *GattDatabase) {
+	c.resetGatt()
+	for _, s := range db.Services {
+		uuid, _ := ParseUUID(s.UUID)
+		service := &Service{startHandle: s.Handle, endHandle: s.End, uuid: uuid}
+		c.addService(service)
+		c.curService = service
+		c.curChar = nil
+		for _, char := range s.Characteristics {
+			c.addCharacteristicInfo(char.Handle, CharacteristicUUID)
+			c.curChar.properties = char.Properties
+			uuid, _ := ParseUUID(char.UUID)
+			c.addCharacteristicInfo(char.ValueHandle, uuid)
+			c.attribs[char.ValueHandle].value = append([]byte(nil), char.Value...)
+			for _, d := range char.Descriptors {
+				uuid, _ := ParseUUID(d.UUID)
+				c.addCharacteristicInfo(d.Handle, uuid)
+				c.attribs[d.Handle].value = append([]byte(nil), d.Value...)
+			}
+		}
+	}
+}
+
+// restoreCachedGatt restore the database kept in the central's Store for
+// the peripheral, false when there is none to skip discovery with
+func (c *Connection) restoreCachedGatt() bool {
+	if c.central.Store == nil {
+		return false
+	}
+	db, err := LoadCachedGattDatabase(c.central.Store, c.resp.Address.Address)
+	if err != nil {
+		return false
+	}
+	c.restoreGatt(db)
+	return true
+}
+
+// cacheGatt keep the database discovered in the central's Store, if any
+func (c *Connection) cacheGatt() error {
+	if c.central.Store == nil {
+		return nil
+	}
+	db, err := c.Snapshot(false)
+	if err == nil {
+		err = SaveGattDatabase(c.central.Store, db)
+	}
+	if err != nil {
+		return fmt.Errorf("caching the GATT database: %w", err)
+	}
+	return nil
+}
+
+// Save write the database as indented JSON
+func (db *GattDatabase) Save(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(db)
+}
+
+// LoadGattDatabase read a database written by Save and validate it
+func LoadGattDatabase(r io.Reader) (*GattDatabase, error) {
+	var db GattDatabase
+	if err := json.NewDecoder(r).Decode(&db); err != nil {
+		return nil, err
+	}
+	if err := db.Validate(); err != nil {
+		return nil, err
+	}
+	return &db, nil
+}
+
+// Validate check the UUIDs and that handles increase and stay within the
+// range of their service
+func (db *GattDatabase) Validate() error {
+	var last uint16
+	next := func(handle uint16, what string) error {
+		if handle == 0 || handle <= last {
+			return fmt.Errorf("%s handle 0x%04x out of order", what, handle)
+		}
+		last = handle
+		return nil
+	}
+	uuid := func(s string, what string, handle uint16) error {
+		if _, err := ParseUUID(s); err != nil {
+			return fmt.Errorf("%s 0x%04x: %v", what, handle, err)
+		}
+		return nil
+	}
+
+	for _, s := range db.Services {
+		if err := next(s.Handle, "service"); err != nil {
+			return err
+		}
+		if err := uuid(s.UUID, "service", s.Handle); err != nil {
+			return err
+		}
+		for _, char := range s.Characteristics {
+			if err := next(char.Handle, "characteristic"); err != nil {
+				return err
+			}
+			if err := uuid(char.UUID, "characteristic", char.Handle); err != nil {
+				return err
+			}
+			if err := next(char.ValueHandle, "value"); err != nil {
+				return err
+			}
+			for _, d := range char.Descriptors {
+				if err := next(d.Handle, "descriptor"); err != nil {
+					return err
+				}
+				if err := uuid(d.UUID, "descriptor", d.Handle); err != nil {
+					return err
+				}
+			}
+		}
+		if last > s.End {
+			return fmt.Errorf("service 0x%04x ends at 0x%04x before handle 0x%04x", s.Handle, s.End, last)
+		}
+		last = s.End
+	}
+	return nil
+}
+
+// Attributes returns the attributes of the database ordered by handle, as
+// a GATT server holds them: the service and characteristic declarations
+// are rebuilt from the snapshot. The database must be valid.
+func (db *GattDatabase) Attributes() []GattAttribute {
+	var attributes []GattAttribute
+	for _, s := range db.Services {
+		serviceUUID, _ := ParseUUID(s.UUID)
+		attributes = append(attributes, GattAttribute{Handle: s.Handle, Type: PrimaryServiceUUID, Value: serviceUUID})
+		for _, char := range s.Characteristics {
+			uuid, _ := ParseUUID(char.UUID)
+			declaration := make([]byte, 3, 3+len(uuid))
+			declaration[0] = char.Properties
+			binary.LittleEndian.PutUint16(declaration[1:], char.ValueHandle)
+			declaration = append(declaration, uuid...)
+			attributes = append(attributes,
+				GattAttribute{Handle: char.Handle, Type: CharacteristicUUID, Value: declaration},
+				GattAttribute{Handle: char.ValueHandle, Type: uuid, Value: append([]byte(nil), char.Value...)})
+			for _, d := range char.Descriptors {
+				descriptorUUID, _ := ParseUUID(d.UUID)
+				attributes = append(attributes, GattAttribute{Handle: d.Handle, Type: descriptorUUID, Value: append([]byte(nil), d.Value...)})
+			}
+		}
+	}
+	return attributes
+}
@@ -0,0 +1,343 @@
+package bgapi
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// defaultPinBuffer events a PinSubscription channel holds by default
+const defaultPinBuffer = 16
+
+// ErrPinSubscribed the pin already has a subscription
+var ErrPinSubscribed = errors.New("pin already subscribed")
+
+// Edge the direction of a transition of a pin
+type Edge int
+
+const (
+	// EdgeRising the pin went high
+	EdgeRising Edge = iota
+	// EdgeFalling the pin went low
+	EdgeFalling
+)
+
+// String returns "rising" or "falling"
+func (e Edge) String() string {
+	if e == EdgeFalling {
+		return "falling"
+	}
+	return "rising"
+}
+
+// PinEvent a debounced transition of a pin
+type PinEvent struct {
+	Port, Pin byte
+	Edge      Edge
+	// Timestamp device time of the last interrupt of the burst, see
+	// IoPortStatus; Time its host time, translated when a TimeSync runs,
+	// the arrival of the interrupt otherwise
+	Timestamp uint32
+	Time      time.Time
+	// Bounces interrupts of the burst folded into the event
+	Bounces int
+}
+
+// PinOptions configure a PinSubscription
+type PinOptions struct {
+	// Debounce time the pin must be quiet before its state is reported,
+	// interrupts in between are bounces; zero reports every change
+	Debounce time.Duration
+	// FallingEdge interrupt on the falling edge rather than the rising
+	// one. The module sets the edge per port: the subscriptions of a port
+	// must agree. Transitions on the other edge are only seen through the
+	// state reported by the interrupts of a bouncing contact.
+	FallingEdge bool
+	// Buffer events the channel holds, 16 when zero; events the
+	// application does not receive in time are dropped
+	Buffer int
+}
+
+// PinStats the interrupts of a PinSubscription
+type PinStats struct {
+	// IRQs interrupts received
+	IRQs uint64
+	// Events transitions reported
+	Events uint64
+	// Suppressed interrupts folded as bounces, including bursts that left
+	// the pin as it was
+	Suppressed uint64
+	// Dropped events the channel had no room for
+	Dropped uint64
+}
+
+// PinSubscription the debounced transitions of a pin, see SubscribePin
+type PinSubscription struct {
+	// C receives the events, closed by Close
+	C <-chan PinEvent
+
+	api       *API
+	port, pin byte
+	debounce  time.Duration
+	c         chan PinEvent
+
+	mutex   sync.Mutex
+	pending *IoPortStatus // latest interrupt of the burst, nil when none
+	bounces int
+	level   bool // last state reported
+	known   bool // level was reported
+	stats   PinStats
+	wake    chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+	closed  bool
+}
+
+// pinIrq the interrupt configuration of a port
+type pinIrq struct {
+	mask        byte
+	fallingEdge bool
+}
+
+// SubscribePin enable the interrupt of a pin and report its transitions on
+// the channel of the subscription, debounced in software: a bouncy switch
+// sends a burst of io_port_status events, folded into a single event with
+// the edge inferred from the state the burst left. The delegate still
+// receives every OnHardwareIoPortStatus.
+func (api *API) SubscribePin(port byte, pin byte, opts PinOptions) (*PinSubscription, error) {
+	if pin > 7 {
+		return nil, fmt.Errorf("pin %d out of range", pin)
+	}
+	buffer := opts.Buffer
+	if buffer <= 0 {
+		buffer = defaultPinBuffer
+	}
+	c := make(chan PinEvent, buffer)
+	s := &PinSubscription{
+		C:        c,
+		api:      api,
+		port:     port,
+		pin:      pin,
+		debounce: opts.Debounce,
+		c:        c,
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+
+	api.pinMutex.Lock()
+	defer api.pinMutex.Unlock()
+
+	key := uint16(port)<<8 | uint16(pin)
+	if api.pins[key] != nil {
+		return nil, ErrPinSubscribed
+	}
+	irq := api.pinIrqs[port]
+	if irq.mask != 0 && irq.fallingEdge != opts.FallingEdge {
+		return nil, fmt.Errorf("port %d interrupts on the other edge", port)
+	}
+	irq.mask |= 1 << pin
+	irq.fallingEdge = opts.FallingEdge
+	if err := api.configIrq(port, irq); err != nil {
+		return nil, err
+	}
+
+	api.mutex.Lock()
+	if api.pins == nil {
+		api.pins = make(map[uint16]*PinSubscription)
+		api.pinIrqs = make(map[byte]pinIrq)
+	}
+	api.pins[key] = s
+	api.pinIrqs[port] = irq
+	api.mutex.Unlock()
+
+	go s.run()
+	return s, nil
+}
+
+// configIrq configure the interrupt of a port and check the result
+func (api *API) configIrq(port byte, irq pinIrq) error {
+	var fallingEdge byte
+	if irq.fallingEdge {
+		fallingEdge = 1
+	}
+	buf, err := api.call(7, 0, []byte{port, irq.mask, fallingEdge})
+	if err != nil {
+		return err
+	}
+	d := newDecoder(buf)
+	if result := d.u16(); d.err != nil {
+		return d.err
+	} else if result != 0 {
+		return &ProcedureError{Result: result}
+	}
+	return nil
+}
+
+// Close disable the interrupt of the pin, unless another subscription of
+// the port needs it, and close the channel; a burst being debounced is
+// dropped
+func (s *PinSubscription) Close() error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+	s.mutex.Unlock()
+	<-s.stopped
+
+	api := s.api
+	api.pinMutex.Lock()
+	defer api.pinMutex.Unlock()
+
+	api.mutex.Lock()
+	delete(api.pins, uint16(s.port)<<8|uint16(s.pin))
+	irq := api.pinIrqs[s.port]
+	irq.mask &^= 1 << s.pin
+	api.pinIrqs[s.port] = irq
+	api.mutex.Unlock()
+
+	return api.configIrq(s.port, irq)
+}
+
+// Stats returns the statistics of the subscription
+func (s *PinSubscription) Stats() PinStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.stats
+}
+
+// pinInterrupts hand an io_port_status event to the subscriptions of the
+// pins that interrupted
+func (api *API) pinInterrupts(status *IoPortStatus) {
+	api.mutex.Lock()
+	var subs []*PinSubscription
+	for pin := byte(0); pin < 8; pin++ {
+		if status.Irq&(1<<pin) == 0 {
+			continue
+		}
+		if s := api.pins[uint16(status.Port)<<8|uint16(pin)]; s != nil {
+			subs = append(subs, s)
+		}
+	}
+	api.mutex.Unlock()
+
+	if len(subs) == 0 {
+		return
+	}
+	received := *status
+	if received.Time.IsZero() {
+		received.Time = api.clock.Now()
+	}
+	for _, s := range subs {
+		s.interrupt(&received)
+	}
+}
+
+// interrupt record an interrupt of the pin, without blocking
+func (s *PinSubscription) interrupt(status *IoPortStatus) {
+	s.mutex.Lock()
+	if s.pending != nil {
+		s.bounces++
+	}
+	s.pending = status
+	s.stats.IRQs++
+	s.mutex.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run report the bursts once the pin is quiet for the debounce time
+func (s *PinSubscription) run() {
+	defer close(s.stopped)
+	defer close(s.c)
+
+	var timer Timer
+	var timerC <-chan time.Time
+	for {
+		select {
+		case <-s.done:
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		case <-s.wake:
+			if s.debounce <= 0 {
+				s.settle()
+				continue
+			}
+			// restart the quiet period at every interrupt
+			if timer != nil {
+				timer.Stop()
+			}
+			timer = s.api.clock.NewTimer(s.debounce)
+			timerC = timer.C()
+		case <-timerC:
+			timer, timerC = nil, nil
+			s.settle()
+		}
+	}
+}
+
+// settle report the state the burst left the pin in, when it changed
+func (s *PinSubscription) settle() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	status := s.pending
+	if status == nil {
+		return
+	}
+	bounces := s.bounces
+	s.pending = nil
+	s.bounces = 0
+
+	level := status.State&(1<<s.pin) != 0
+	if s.known && level == s.level {
+		// the pin glitched back to where it was
+		s.stats.Suppressed += uint64(bounces) + 1
+		return
+	}
+	s.level, s.known = level, true
+	s.stats.Suppressed += uint64(bounces)
+
+	event := PinEvent{Port: s.port, Pin: s.pin, Edge: EdgeRising, Timestamp: status.Timestamp, Time: status.Time, Bounces: bounces}
+	if !level {
+		event.Edge = EdgeFalling
+	}
+	select {
+	case s.c <- event:
+		s.stats.Events++
+	default:
+		s.stats.Dropped++
+	}
+}
+
+// restorePinIrqs configure the interrupts of the subscribed pins again
+// after a boot, without waiting for the responses
+func (api *API) restorePinIrqs() {
+	api.mutex.Lock()
+	irqs := make(map[byte]pinIrq, len(api.pinIrqs))
+	for port, irq := range api.pinIrqs {
+		irqs[port] = irq
+	}
+	api.mutex.Unlock()
+
+	for port, irq := range irqs {
+		if irq.mask == 0 {
+			continue
+		}
+		var fallingEdge byte
+		if irq.fallingEdge {
+			fallingEdge = 1
+		}
+		api.HardwareIoPortConfigIrq(port, irq.mask, fallingEdge)
+	}
+}
@@ -0,0 +1,126 @@
+package bgapi
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ProtocolVersion the BGAPI protocol version spoken by this package
+const ProtocolVersion = 1
+
+// readyTimeout how long Ready waits for the device to boot
+const readyTimeout = 3 * time.Second
+
+// ErrNotBGAPI the device did not answer the handshake
+var ErrNotBGAPI = errors.New("device did not answer the BGAPI handshake")
+
+// Handshake how Ready checks the device is responsive
+type Handshake int
+
+const (
+	// HandshakeHello say hello then query the system information, leaves
+	// the device state untouched
+	HandshakeHello Handshake = iota
+	// HandshakeReset reset the device and wait for its boot event. Not
+	// suitable for USB dongles such as the BLED112, which re-enumerate and
+	// invalidate the port when reset
+	HandshakeReset
+)
+
+// Ready perform the handshake and check the protocol version, it returns the
+// device's system information once it is verified responsive
+func (api *API) Ready(handshake Handshake) (*SystemInfo, error) {
+	var info *SystemInfo
+	if handshake == HandshakeReset {
+		// discard a stale boot event
+		select {
+		case <-api.bootC:
+		default:
+		}
+
+		api.SystemReset(false, func() {})
+		select {
+		case info = <-api.bootC:
+		case <-api.dfuBootC:
+			return nil, ErrDFUMode
+		case <-api.clock.After(readyTimeout):
+			return nil, ErrNotBGAPI
+		}
+	} else {
+		buf, err := api.call(0, 1, []byte{})
+		if err != nil {
+			return nil, ErrNotBGAPI
+		}
+		if buf.Len() != 0 {
+			// hello has an empty response, the bootloader takes the command
+			// for dfu_flash_set_address and returns a result code
+			return nil, ErrDFUMode
+		}
+
+		buf, err = api.call(0, 8, []byte{})
+		if err != nil {
+			return nil, ErrNotBGAPI
+		}
+		info = new(SystemInfo)
+		d := newDecoder(buf)
+		d.read(info)
+		if d.err != nil {
+			return nil, fmt.Errorf("malformed system information: %v", d.err)
+		}
+		api.setFirmware(info)
+	}
+
+	if info.ProtocolVersion != ProtocolVersion {
+		return info, fmt.Errorf("device speaks BGAPI protocol %d, expected %d", info.ProtocolVersion, ProtocolVersion)
+	}
+
+	// without it connection attempts are not checked against the slots
+	if err := api.queryMaxConnections(); err != nil {
+		api.historyError(fmt.Sprintf("querying the connection slots: %v", err))
+	}
+	return info, nil
+}
+
+// OpenSerialReady open the serial port and wait for the device to be ready,
+// the port is closed again when the device does not answer as a BGAPI device
+func (api *API) OpenSerialReady(port string, opts *SerialOptions, handshake Handshake) (*SystemInfo, error) {
+	if err := api.OpenSerial(port, opts); err != nil {
+		return nil, err
+	}
+
+	if vid, pid, err := usbID(port); err == nil && vid == BLED112VendorID && pid == BLED112DFUProductID {
+		return nil, fmt.Errorf("%s: %v", port, ErrDFUMode)
+	}
+
+	info, err := api.Ready(handshake)
+	if err != nil {
+		api.closeTransport()
+		return nil, fmt.Errorf("%s: %v", port, err)
+	}
+	return info, nil
+}
+
+// call send a command and wait for its response, which is copied as the
+// receive buffer is reused
+func (api *API) call(class byte, cmd byte, data []byte) (*bytes.Buffer, error) {
+	type reply struct {
+		buf *bytes.Buffer
+		err error
+	}
+	replyC := make(chan reply, 1)
+	err := api.submit(class, cmd, data, defaultTimeoutMs, func(buf *bytes.Buffer, err error) {
+		if err != nil {
+			replyC <- reply{err: err}
+			return
+		}
+		replyC <- reply{buf: bytes.NewBuffer(append([]byte(nil), buf.Bytes()...))}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r := <-replyC
+	return r.buf, r.err
+}
@@ -0,0 +1,137 @@
+package bgapi
+
+import "time"
+
+// AnalogComparatorStatus an analog_comparator_status event, sent on a
+// change of the comparator output once its interrupt is enabled
+type AnalogComparatorStatus struct {
+	// Timestamp device time of the change, see IoPortStatus; Time its host
+	// time while a TimeSync runs, zero otherwise
+	Timestamp uint32
+	Time      time.Time
+	// High the positive input is above the negative one
+	High bool
+}
+
+// HardwareIoPortIrqEnable enable the interrupts of the pins of a port set
+// in enableBits, checking the result
+func (api *API) HardwareIoPortIrqEnable(port byte, enableBits byte) error {
+	return api.hardwareCall(14, []byte{port, enableBits})
+}
+
+// HardwareIoPortIrqDirection select the edge the pins of a port interrupt
+// on
+func (api *API) HardwareIoPortIrqDirection(port byte, fallingEdge bool) error {
+	return api.hardwareCall(15, []byte{port, boolCast(fallingEdge)})
+}
+
+// HardwareAnalogComparatorEnable power the analog comparator up or down
+func (api *API) HardwareAnalogComparatorEnable(enable bool) error {
+	// the response carries no result
+	_, err := api.call(7, 16, []byte{boolCast(enable)})
+	return err
+}
+
+// HardwareAnalogComparatorRead returns the output of the analog
+// comparator, true when the positive input is above the negative one
+func (api *API) HardwareAnalogComparatorRead() (bool, error) {
+	buf, err := api.call(7, 17, nil)
+	if err != nil {
+		return false, err
+	}
+	d := newDecoder(buf)
+	result := d.u16()
+	output := d.u8()
+	if d.err != nil {
+		return false, d.err
+	} else if result != 0 {
+		return false, &ProcedureError{Result: result}
+	}
+	return output != 0, nil
+}
+
+// HardwareAnalogComparatorConfigIrq enable the analog_comparator_status
+// events, see SetAnalogComparatorHandler
+func (api *API) HardwareAnalogComparatorConfigIrq(enable bool) error {
+	return api.hardwareCall(18, []byte{boolCast(enable)})
+}
+
+// HardwareSetRxGain select the gain of the receiver, 0 for the standard
+// gain and 1 for the high one
+func (api *API) HardwareSetRxGain(gain byte) error {
+	// the response carries no result
+	_, err := api.call(7, 19, []byte{gain})
+	return err
+}
+
+// HardwareUsbEnable enable or disable the USB interface of the module,
+// e.g. to save power on a BLE113 run from its UART; disabling it on a
+// BLED112 drops the link to the host
+func (api *API) HardwareUsbEnable(enable bool) error {
+	return api.hardwareCall(20, []byte{boolCast(enable)})
+}
+
+// EnableUSB enable or disable the USB interface of the module, see
+// HardwareUsbEnable. Unless ProbeCapabilities already ran the module is
+// probed first, so firmware lacking the command fails with
+// ErrUnsupportedFirmware rather than leaving it unanswered.
+func (api *API) EnableUSB(enable bool) error {
+	if _, probed := api.HasCapability(CapUsbEnable); !probed {
+		if _, err := api.ProbeCapabilities(); err != nil {
+			return err
+		}
+	}
+	return api.HardwareUsbEnable(enable)
+}
+
+// HardwareSleepEnable allow the module to enter its sleep modes, or keep it
+// awake
+func (api *API) HardwareSleepEnable(enable bool) error {
+	return api.hardwareCall(21, []byte{boolCast(enable)})
+}
+
+// SetAnalogComparatorHandler set the function receiving the
+// analog_comparator_status events on the receive goroutine, nil for none
+func (api *API) SetAnalogComparatorHandler(handler func(status *AnalogComparatorStatus)) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	api.comparator = handler
+}
+
+// analogComparatorStatus deliver an analog_comparator_status event
+func (api *API) analogComparatorStatus(d *decoder) {
+	status := AnalogComparatorStatus{Timestamp: d.u32(), High: d.u8() != 0}
+	if d.err != nil {
+		return
+	}
+
+	api.mutex.Lock()
+	handler := api.comparator
+	timeSync := api.timeSync
+	api.mutex.Unlock()
+
+	if handler == nil {
+		return
+	}
+	if timeSync != nil {
+		status.Time, _ = timeSync.DeviceTime(status.Timestamp)
+	}
+	handler(&status)
+}
+
+// hardwareCall send a hardware command and check the result of the
+// response
+func (api *API) hardwareCall(cmd byte, data []byte) error {
+	buf, err := api.call(7, cmd, data)
+	if err != nil {
+		return err
+	}
+	d := newDecoder(buf)
+	if result := d.u16(); d.err != nil {
+		return d.err
+	} else if result != 0 {
+		return &ProcedureError{Result: result}
+	}
+	return nil
+}
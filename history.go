@@ -0,0 +1,145 @@
+package bgapi
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"time"
+)
+
+// HistoryKind identifies the type of frame recorded in the history
+type HistoryKind int
+
+const (
+	// HistoryCommand a command sent to the device
+	HistoryCommand HistoryKind = iota
+	// HistoryResponse a response received from the device
+	HistoryResponse
+	// HistoryEvent an event received from the device
+	HistoryEvent
+)
+
+func (k HistoryKind) String() string {
+	switch k {
+	case HistoryCommand:
+		return "command"
+	case HistoryResponse:
+		return "response"
+	case HistoryEvent:
+		return "event"
+	}
+	return "unknown"
+}
+
+// HistoryEntry a frame recorded in the history
+type HistoryEntry struct {
+	Time    time.Time
+	Kind    HistoryKind
+	Class   byte
+	Command byte
+	Payload []byte
+}
+
+// Name returns the BGAPI name of the frame
+func (e *HistoryEntry) Name() string {
+	if e.Kind == HistoryEvent {
+		return EventName(e.Class, e.Command)
+	}
+	return CommandName(e.Class, e.Command)
+}
+
+func (e *HistoryEntry) String() string {
+	return fmt.Sprintf("%s %-8s %s %s", e.Time.Format("15:04:05.000000"), e.Kind, e.Name(), hex.EncodeToString(e.Payload))
+}
+
+// history ring buffer of the last frames exchanged with the device
+type history struct {
+	entries []HistoryEntry
+	next    int
+	count   int
+	dump    io.Writer
+}
+
+// EnableHistory keep the last size frames exchanged with the device. When
+// dump is not nil the history is written to it whenever a command times out,
+// a response does not match its command or an event fails to decode.
+// A size of zero disables the history.
+func (api *API) EnableHistory(size int, dump io.Writer) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	api.history = history{entries: make([]HistoryEntry, size), dump: dump}
+}
+
+// History returns the recorded frames, oldest first
+func (api *API) History() []HistoryEntry {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	return api.historySnapshot()
+}
+
+// DumpHistory write the recorded frames to w, oldest first
+func (api *API) DumpHistory(w io.Writer) error {
+	for _, e := range api.History() {
+		if _, err := fmt.Fprintln(w, e.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// historySnapshot copy the history, the caller must hold the mutex
+func (api *API) historySnapshot() []HistoryEntry {
+	h := &api.history
+	entries := make([]HistoryEntry, 0, h.count)
+	start := h.next - h.count
+	if start < 0 {
+		start += len(h.entries)
+	}
+	for i := 0; i < h.count; i++ {
+		entries = append(entries, h.entries[(start+i)%len(h.entries)])
+	}
+	return entries
+}
+
+// recordHistory append a frame to the history, the caller must hold the mutex
+func (api *API) recordHistory(kind HistoryKind, class byte, cmd byte, payload []byte) {
+	h := &api.history
+	if len(h.entries) == 0 {
+		return
+	}
+
+	// frames are parsed in place so keep a copy of the payload
+	h.entries[h.next] = HistoryEntry{
+		Time:    api.clock.Now(),
+		Kind:    kind,
+		Class:   class,
+		Command: cmd,
+		Payload: append([]byte(nil), payload...),
+	}
+	h.next = (h.next + 1) % len(h.entries)
+	if h.count < len(h.entries) {
+		h.count++
+	}
+}
+
+// historyError dump the history after an error, if requested
+func (api *API) historyError(reason string) {
+	api.mutex.Lock()
+	dump := api.history.dump
+	var entries []HistoryEntry
+	if dump != nil {
+		entries = api.historySnapshot()
+	}
+	api.mutex.Unlock()
+
+	if dump == nil {
+		return
+	}
+
+	fmt.Fprintf(dump, "bgapi: %s, last %d frames:\n", reason, len(entries))
+	for _, e := range entries {
+		fmt.Fprintln(dump, e.String())
+	}
+}
@@ -0,0 +1,44 @@
+package bgapi
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// InjectEvent deliver a synthetic event as if the device had sent it, e.g.
+// to exercise a delegate from unit tests. The event goes through the same
+// path as received ones (state tracking, history, handlers registered with
+// HandleEvent) and InjectEvent returns once the delegate has processed it,
+// whatever the dispatch mode.
+func (api *API) InjectEvent(class byte, event byte, payload []byte) error {
+	if len(payload) > maxPayload {
+		return fmt.Errorf("payload of %d bytes exceeds the %d byte maximum", len(payload), maxPayload)
+	}
+	if api.isClosed() {
+		return ErrClosed
+	}
+
+	hdr := &bgFrameHeader{packetClass: class, packetCommand: event}
+	frame := append([]byte(nil), payload...)
+
+	api.rxMutex.Lock()
+	api.mutex.Lock()
+	api.stats.Events++
+	api.recordHistory(HistoryEvent, class, event, frame)
+	api.mutex.Unlock()
+	api.parseEvent(hdr, bytes.NewBuffer(frame))
+	api.rxMutex.Unlock()
+
+	api.WaitIdle()
+	return nil
+}
+
+// WaitIdle wait for the delegate to process the events received so far.
+// Events are delivered before the receive loop moves on unless dispatched
+// per connection (see DispatchMode), so this only waits in that mode; tests
+// call it to assert on the delegate without sleeping.
+func (api *API) WaitIdle() {
+	if d, ok := api.delegate.(*dispatcher); ok {
+		d.flush()
+	}
+}
@@ -0,0 +1,126 @@
+package bgapi
+
+import (
+	"sort"
+	"time"
+)
+
+// latencyBuckets upper bounds of the command latency histogram buckets
+var latencyBuckets = []time.Duration{
+	time.Millisecond,
+	2 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	20 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	200 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2 * time.Second,
+	5 * time.Second,
+}
+
+// LatencyHistogram distribution of the round-trip time of a command, from
+// writing it to receiving its response
+type LatencyHistogram struct {
+	// Buckets upper bounds of the buckets, shared between histograms and
+	// not to be modified
+	Buckets []time.Duration
+	// Counts round trips per bucket, not cumulative; the extra last entry
+	// counts the round trips above the largest bound
+	Counts []uint64
+	Count  uint64
+	Sum    time.Duration
+	Max    time.Duration
+}
+
+// CommandLatency the latency histogram of a command
+type CommandLatency struct {
+	Class   byte
+	Command byte
+	LatencyHistogram
+}
+
+// Name returns the BGAPI name of the command
+func (l *CommandLatency) Name() string {
+	return CommandName(l.Class, l.Command)
+}
+
+func (h *LatencyHistogram) observe(d time.Duration) {
+	if h.Counts == nil {
+		h.Buckets = latencyBuckets
+		h.Counts = make([]uint64, len(h.Buckets)+1)
+	}
+	h.Counts[sort.Search(len(h.Buckets), func(i int) bool { return d <= h.Buckets[i] })]++
+	h.Count++
+	h.Sum += d
+	if d > h.Max {
+		h.Max = d
+	}
+}
+
+// Mean returns the average round-trip time
+func (h *LatencyHistogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// Quantile returns the upper bound of the bucket holding the q quantile
+// (0 < q <= 1), or Max when it falls in the overflow bucket. Useful to pick
+// a timeout, e.g. a multiple of Quantile(0.99).
+func (h *LatencyHistogram) Quantile(q float64) time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	rank := uint64(q * float64(h.Count))
+	if rank == 0 {
+		rank = 1
+	}
+	var seen uint64
+	for i, n := range h.Counts {
+		seen += n
+		if seen >= rank && i < len(h.Buckets) {
+			return h.Buckets[i]
+		}
+	}
+	return h.Max
+}
+
+// recordLatency add a round trip to the command's histogram, the caller
+// must hold the mutex
+func (api *API) recordLatency(class byte, cmd byte, d time.Duration) {
+	if api.latencies == nil {
+		api.latencies = make(map[uint16]*LatencyHistogram)
+	}
+	key := uint16(class)<<8 | uint16(cmd)
+	h := api.latencies[key]
+	if h == nil {
+		h = new(LatencyHistogram)
+		api.latencies[key] = h
+	}
+	h.observe(d)
+}
+
+// Latencies returns the latency histograms of the commands sent so far,
+// ordered by class then command
+func (api *API) Latencies() []CommandLatency {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	latencies := make([]CommandLatency, 0, len(api.latencies))
+	for key, h := range api.latencies {
+		l := CommandLatency{Class: byte(key >> 8), Command: byte(key), LatencyHistogram: *h}
+		l.Counts = append([]uint64(nil), h.Counts...)
+		latencies = append(latencies, l)
+	}
+	sort.Slice(latencies, func(i, j int) bool {
+		if latencies[i].Class != latencies[j].Class {
+			return latencies[i].Class < latencies[j].Class
+		}
+		return latencies[i].Command < latencies[j].Command
+	})
+	return latencies
+}
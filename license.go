@@ -0,0 +1,82 @@
+package bgapi
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// licenseCheckDelay how long after booting the module may still report a
+// missing license key
+const licenseCheckDelay = 500 * time.Millisecond
+
+var (
+	// ErrLicenseKeyMissing the module reported no license key after
+	// booting
+	ErrLicenseKeyMissing = errors.New("module reports no license key")
+	// ErrNoBoot the module did not boot after being reset
+	ErrNoBoot = errors.New("module did not boot after the reset")
+)
+
+// LicenseKeyMissing returns true when the module reported a missing license
+// key since it last booted
+func (api *API) LicenseKeyMissing() bool {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	return api.noLicense
+}
+
+// setLicenseKeyMissing record whether the module misses its license key
+func (api *API) setLicenseKeyMissing(missing bool) {
+	api.mutex.Lock()
+	api.noLicense = missing
+	api.mutex.Unlock()
+
+	if missing {
+		select {
+		case api.noLicenseC <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// RestoreLicenseKey write license to the PS key holding it, on modules
+// where it is user restorable, then reset the module and check that it no
+// longer reports a missing key. The key is specific to the module and
+// firmware, check its documentation. Not suitable for USB dongles such as
+// the BLED112, which re-enumerate when reset (see HandshakeReset).
+func (api *API) RestoreLicenseKey(psKey uint16, license []byte) error {
+	if len(license) == 0 {
+		return errors.New("empty license key")
+	}
+	if err := api.PSSave(psKey, license); err != nil {
+		return fmt.Errorf("writing the license key: %w", err)
+	}
+
+	// discard stale boot and license events
+	select {
+	case <-api.bootC:
+	default:
+	}
+	select {
+	case <-api.noLicenseC:
+	default:
+	}
+
+	if err := api.SystemReset(false, func() {}); err != nil {
+		return err
+	}
+	select {
+	case <-api.bootC:
+	case <-api.clock.After(readyTimeout):
+		return ErrNoBoot
+	}
+
+	select {
+	case <-api.noLicenseC:
+		return ErrLicenseKeyMissing
+	case <-api.clock.After(licenseCheckDelay):
+		return nil
+	}
+}
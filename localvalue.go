@@ -0,0 +1,52 @@
+package bgapi
+
+import "fmt"
+
+const (
+	// localWriteChunk bytes written per attributes write, well within the
+	// command buffer of the smallest modules
+	localWriteChunk = 32
+	// maxLocalOffset largest offset of an attributes write, which encodes
+	// it on a byte
+	maxLocalOffset = 0xff
+)
+
+// SetLocalValue write the whole value of a local attribute, segmenting it
+// across attributes writes at increasing offsets, and wait for each to be
+// acknowledged. Values are limited to segments starting at offsets that fit
+// a byte, longer values fail before anything is written.
+func (api *API) SetLocalValue(handle uint16, value []byte) error {
+	if len(value) > 0 && (len(value)-1)/localWriteChunk*localWriteChunk > maxLocalOffset {
+		return fmt.Errorf("value of %d bytes needs offsets beyond %d", len(value), maxLocalOffset)
+	}
+
+	// an empty value is still written, to truncate the attribute
+	for offset := 0; offset < len(value) || offset == 0; offset += localWriteChunk {
+		end := offset + localWriteChunk
+		if end > len(value) {
+			end = len(value)
+		}
+		if err := api.writeLocal(handle, byte(offset), value[offset:end]); err != nil {
+			return fmt.Errorf("writing attribute 0x%04x at offset %d: %w", handle, offset, err)
+		}
+	}
+	return nil
+}
+
+// writeLocal write a segment of a local attribute and check the result
+func (api *API) writeLocal(handle uint16, offset byte, segment []byte) error {
+	data := (&encoder{}).write(handle).write(offset).uint8array(segment).bytes()
+	buf, err := api.call(2, 0, data)
+	if err != nil {
+		return err
+	}
+	d := newDecoder(buf)
+	result := d.u16()
+	if d.err != nil {
+		return d.err
+	}
+	if result != 0 {
+		return &ProcedureError{Result: result}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package bgapi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// memoryReadChunkMax largest block fetched by a single SystemMemoryRead
+const memoryReadChunkMax = 32
+
+// SystemMemoryReadRange read an arbitrary memory range by issuing as many
+// bounded SystemMemoryRead requests as needed. The completion receives the
+// reassembled data, or the first error encountered.
+func (api *API) SystemMemoryReadRange(addr uint32, length int, completion func([]byte, error)) error {
+	data := make([]byte, 0, length)
+
+	var next func() error
+	next = func() error {
+		remaining := length - len(data)
+		if remaining <= 0 {
+			completion(data, nil)
+			return nil
+		}
+
+		size := remaining
+		if size > memoryReadChunkMax {
+			size = memoryReadChunkMax
+		}
+		cur := addr + uint32(len(data))
+
+		buf := new(bytes.Buffer)
+		binary.Write(buf, binary.LittleEndian, cur)
+		binary.Write(buf, binary.LittleEndian, uint8(size))
+		return api.submit(0, 7, buf.Bytes(), defaultTimeoutMs, func(buf *bytes.Buffer, err error) {
+			if err == nil {
+				d := newDecoder(buf)
+				rxAddr := d.u32()
+				chunk := d.uint8array()
+				if err = d.err; err == nil && (rxAddr != cur || len(chunk) == 0) {
+					err = fmt.Errorf("memory read at 0x%x returned %d bytes at 0x%x", cur, len(chunk), rxAddr)
+				}
+				if err == nil {
+					if len(chunk) > size {
+						chunk = chunk[:size]
+					}
+					data = append(data, chunk...)
+					err = next()
+				}
+			}
+
+			if err != nil {
+				completion(nil, err)
+			}
+		})
+	}
+
+	return next()
+}
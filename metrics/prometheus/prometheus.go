@@ -0,0 +1,199 @@
+// Package prometheus exports the bgapi API counters to Prometheus
+package prometheus
+
+import (
+	"strconv"
+	"time"
+
+	bgapi "github.com/jsakwa/go_bgapi"
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "bgapi"
+
+func desc(name string, help string, labels []string, constLabels prom.Labels) *prom.Desc {
+	return prom.NewDesc(prom.BuildFQName(namespace, "", name), help, labels, constLabels)
+}
+
+// Collector a prometheus.Collector reading the API state at scrape time
+type Collector struct {
+	api *bgapi.API
+
+	commands            *prom.Desc
+	responses           *prom.Desc
+	timeouts            *prom.Desc
+	events              *prom.Desc
+	malformedEvents     *prom.Desc
+	unexpectedResponses *prom.Desc
+	staleResponses      *prom.Desc
+	resyncs             *prom.Desc
+	bytesReceived       *prom.Desc
+	reads               *prom.Desc
+	readBufferSize      *prom.Desc
+	queueDepth          *prom.Desc
+	openConnections     *prom.Desc
+	rssi                *prom.Desc
+	systemCounters      *prom.Desc
+	commandLatency      *prom.Desc
+	queueWait           *prom.Desc
+	throttled           *prom.Desc
+	throttleWait        *prom.Desc
+	deviceInfo          *prom.Desc
+	gattOps             *prom.Desc
+	notifications       *prom.Desc
+	attBytes            *prom.Desc
+	rawBytes            *prom.Desc
+}
+
+// NewCollector returns a collector for the API, constLabels (e.g. the
+// serial port) are attached to every metric and may be nil
+func NewCollector(api *bgapi.API, constLabels prom.Labels) *Collector {
+	return &Collector{
+		api:                 api,
+		commands:            desc("commands_sent_total", "Commands written to the device.", nil, constLabels),
+		responses:           desc("responses_total", "Command responses received.", nil, constLabels),
+		timeouts:            desc("command_timeouts_total", "Commands that never received a response.", nil, constLabels),
+		events:              desc("events_total", "Events received from the device.", nil, constLabels),
+		malformedEvents:     desc("malformed_events_total", "Events dropped because they did not decode.", nil, constLabels),
+		unexpectedResponses: desc("unexpected_responses_total", "Responses received while no command was pending.", nil, constLabels),
+		staleResponses:      desc("stale_responses_total", "Unexpected responses to a command that had timed out.", nil, constLabels),
+		resyncs:             desc("resyncs_total", "Receive buffer flushes after a spurious response.", nil, constLabels),
+		bytesReceived:       desc("received_bytes_total", "Bytes read from the device.", nil, constLabels),
+		reads:               desc("reads_total", "Successful reads from the device.", nil, constLabels),
+		readBufferSize:      desc("read_buffer_bytes", "Current size of the read buffer.", nil, constLabels),
+		queueDepth:          desc("queue_depth", "Commands waiting to be transmitted.", nil, constLabels),
+		openConnections:     desc("open_connections", "Connections reported open by the device.", nil, constLabels),
+		rssi:                desc("connection_rssi_dbm", "Last RSSI read for a connection.", []string{"connection"}, constLabels),
+		systemCounters:      desc("system_counter", "Last radio counters read from the device.", []string{"counter"}, constLabels),
+		commandLatency:      desc("command_latency_seconds", "Round-trip time of commands.", []string{"command"}, constLabels),
+		queueWait:           desc("queue_wait_seconds_total", "Time commands waited to be transmitted.", nil, constLabels),
+		throttled:           desc("throttled_commands_total", "Commands delayed by the rate limits.", nil, constLabels),
+		throttleWait:        desc("throttle_wait_seconds_total", "Delay imposed by the rate limits.", nil, constLabels),
+		gattOps:             desc("connection_gatt_ops_total", "Attribute client commands issued on a connection.", []string{"connection"}, constLabels),
+		notifications:       desc("connection_notifications_total", "Values notified or indicated on a connection.", []string{"connection"}, constLabels),
+		attBytes:            desc("connection_att_bytes_total", "Payload bytes of the attribute client traffic of a connection.", []string{"connection", "direction"}, constLabels),
+		rawBytes:            desc("connection_raw_bytes_total", "Raw link layer bytes of a connection.", []string{"connection", "direction"}, constLabels),
+		deviceInfo:          desc("device_info", "Identity of the device, once read with API.Fingerprint.", []string{"device", "address", "hardware", "firmware"}, constLabels),
+	}
+}
+
+// Register registers a collector for the API with reg
+func Register(reg prom.Registerer, api *bgapi.API, constLabels prom.Labels) (*Collector, error) {
+	c := NewCollector(api, constLabels)
+	return c, reg.Register(c)
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prom.Desc) {
+	ch <- c.commands
+	ch <- c.responses
+	ch <- c.timeouts
+	ch <- c.events
+	ch <- c.malformedEvents
+	ch <- c.unexpectedResponses
+	ch <- c.staleResponses
+	ch <- c.resyncs
+	ch <- c.bytesReceived
+	ch <- c.reads
+	ch <- c.readBufferSize
+	ch <- c.queueDepth
+	ch <- c.openConnections
+	ch <- c.rssi
+	ch <- c.systemCounters
+	ch <- c.commandLatency
+	ch <- c.queueWait
+	ch <- c.throttled
+	ch <- c.throttleWait
+	ch <- c.deviceInfo
+	ch <- c.gattOps
+	ch <- c.notifications
+	ch <- c.attBytes
+	ch <- c.rawBytes
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prom.Metric) {
+	state := c.api.State()
+
+	counter := func(d *prom.Desc, v uint64) {
+		ch <- prom.MustNewConstMetric(d, prom.CounterValue, float64(v))
+	}
+	counter(c.commands, state.Stats.CommandsSent)
+	counter(c.responses, state.Stats.Responses)
+	counter(c.timeouts, state.Stats.Timeouts)
+	counter(c.events, state.Stats.Events)
+	counter(c.malformedEvents, state.Stats.MalformedEvents)
+	counter(c.unexpectedResponses, state.Stats.UnexpectedResponses)
+	counter(c.staleResponses, state.Stats.StaleResponses)
+	counter(c.resyncs, state.Stats.Resyncs)
+	counter(c.bytesReceived, state.Stats.BytesReceived)
+	counter(c.reads, state.Stats.Reads)
+	counter(c.throttled, state.Stats.Throttled)
+	ch <- prom.MustNewConstMetric(c.queueWait, prom.CounterValue, state.Stats.QueueWait.Seconds())
+	ch <- prom.MustNewConstMetric(c.throttleWait, prom.CounterValue, state.Stats.ThrottleWait.Seconds())
+
+	ch <- prom.MustNewConstMetric(c.readBufferSize, prom.GaugeValue, float64(state.ReadBufferSize))
+	ch <- prom.MustNewConstMetric(c.queueDepth, prom.GaugeValue, float64(state.QueueDepth))
+	ch <- prom.MustNewConstMetric(c.openConnections, prom.GaugeValue, float64(len(state.OpenConnections)))
+
+	if f := c.api.CachedFingerprint(); f != nil {
+		labels := f.Labels()
+		ch <- prom.MustNewConstMetric(c.deviceInfo, prom.GaugeValue, 1, labels["device"], labels["address"], labels["hardware"], labels["firmware"])
+	}
+
+	for handle, link := range c.api.LinkStats() {
+		connection := strconv.Itoa(int(handle))
+		ch <- prom.MustNewConstMetric(c.gattOps, prom.CounterValue, float64(link.GattOps), connection)
+		ch <- prom.MustNewConstMetric(c.notifications, prom.CounterValue, float64(link.Notifications), connection)
+		ch <- prom.MustNewConstMetric(c.attBytes, prom.CounterValue, float64(link.ATTBytesOut), connection, "out")
+		ch <- prom.MustNewConstMetric(c.attBytes, prom.CounterValue, float64(link.ATTBytesIn), connection, "in")
+		ch <- prom.MustNewConstMetric(c.rawBytes, prom.CounterValue, float64(link.RawBytesOut), connection, "out")
+		ch <- prom.MustNewConstMetric(c.rawBytes, prom.CounterValue, float64(link.RawBytesIn), connection, "in")
+	}
+
+	for handle, rssi := range state.RSSI {
+		ch <- prom.MustNewConstMetric(c.rssi, prom.GaugeValue, float64(rssi), strconv.Itoa(int(handle)))
+	}
+
+	if sc := state.SystemCounters; sc != nil {
+		for name, v := range map[string]byte{
+			"txok": sc.Txok, "txretry": sc.Txretry, "rxok": sc.Rxok, "rxfail": sc.Rxfail, "mbuf": sc.Mbuf,
+		} {
+			ch <- prom.MustNewConstMetric(c.systemCounters, prom.GaugeValue, float64(v), name)
+		}
+	}
+
+	for _, l := range c.api.Latencies() {
+		buckets := make(map[float64]uint64, len(l.Buckets))
+		var cumulative uint64
+		for i, bound := range l.Buckets {
+			cumulative += l.Counts[i]
+			buckets[bound.Seconds()] = cumulative
+		}
+		ch <- prom.MustNewConstHistogram(c.commandLatency, l.Count, l.Sum.Seconds(), buckets, l.Name())
+	}
+}
+
+// Poll periodically refreshes the values the device only reports on request
+// (system counters and the RSSI of open connections). Call the returned
+// function to stop polling.
+func (c *Collector) Poll(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.api.SystemCountersGet(func(*bgapi.SystemCounters) {})
+				for _, status := range c.api.State().OpenConnections {
+					c.api.ConnectionGetRssi(status.Connection)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
@@ -0,0 +1,121 @@
+package bgapi
+
+import (
+	"fmt"
+)
+
+var classNames = []string{"system", "flash", "attributes", "connection", "attclient", "sm", "gap", "hardware", "test"}
+
+// command names indexed by class then command
+var commandNames = [][]string{
+	{"reset", "hello", "address_get", "reg_write", "reg_read", "get_counters", "get_connections",
+		"read_memory", "get_info", "endpoint_tx", "whitelist_append", "whitelist_remove",
+		"whitelist_clear", "endpoint_rx", "endpoint_set_watermarks"},
+	{"ps_defrag", "ps_dump", "ps_erase_all", "ps_save", "ps_load", "ps_erase", "erase_page", "write_words"},
+	{"write", "read", "read_type", "user_read_response", "user_write_response"},
+	{"disconnect", "get_rssi", "update", "version_update", "channel_map_get", "channel_map_set",
+		"features_get", "get_status", "raw_tx"},
+	{"find_by_type_value", "read_by_group_type", "read_by_type", "find_information", "read_by_handle",
+		"attribute_write", "write_command", "indicate_confirm", "read_long", "prepare_write",
+		"execute_write", "read_multiple"},
+	{"encrypt_start", "set_bondable_mode", "delete_bonding", "set_parameters", "passkey_entry",
+		"get_bonds", "set_oob_data"},
+	{"set_privacy_flags", "set_mode", "discover", "connect_direct", "end_procedure",
+		"connect_selective", "set_filtering", "set_scan_parameters", "set_adv_parameters",
+		"set_adv_data", "set_directed_connectable_mode"},
+	{"io_port_config_irq", "set_soft_timer", "adc_read", "io_port_config_direction",
+		"io_port_config_function", "io_port_config_pull", "io_port_write", "io_port_read",
+		"spi_config", "spi_transfer", "i2c_read", "i2c_write", "set_txpower", "timer_comparator",
+		"io_port_irq_enable", "io_port_irq_direction", "analog_comparator_enable",
+		"analog_comparator_read", "analog_comparator_config_irq", "set_rxgain", "usb_enable",
+		"sleep_enable"},
+	{"phy_tx", "phy_rx", "phy_end", "phy_reset", "get_channel_map", "debug"},
+}
+
+// event names indexed by class then event
+var eventNames = [][]string{
+	{"boot", "debug", "endpoint_watermark_rx", "endpoint_watermark_tx", "script_failure", "no_license_key", "protocol_error"},
+	{"ps_key"},
+	{"value", "user_read_request", "status"},
+	{"status", "version_ind", "feature_ind", "raw_rx", "disconnected"},
+	{"indicated", "procedure_completed", "group_found", "attribute_found", "find_information_found",
+		"attribute_value", "read_multiple_response"},
+	{"smp_data", "bonding_fail", "passkey_display", "passkey_request", "bond_status"},
+	{"scan_response", "mode_changed"},
+	{"io_port_status", "soft_timer", "adc_result", "analog_comparator_status"},
+}
+
+func lookupName(table [][]string, class byte, cmd byte) string {
+	if int(class) < len(table) && int(cmd) < len(table[class]) {
+		return classNames[class] + "_" + table[class][cmd]
+	}
+	return fmt.Sprintf("unknown_%d_%d", class, cmd)
+}
+
+// CommandName returns the BGAPI name of a command (or its response),
+// e.g. "system_hello"
+func CommandName(class byte, cmd byte) string {
+	return lookupName(commandNames, class, cmd)
+}
+
+// EventName returns the BGAPI name of an event, e.g. "gap_scan_response"
+func EventName(class byte, cmd byte) string {
+	return lookupName(eventNames, class, cmd)
+}
+
+// result names of the BGAPI, Bluetooth and security manager errors, the
+// ATT errors (0x04xx) are named by AttError
+var resultNames = map[uint16]string{
+	0x0000: "success",
+	0x0180: "invalid parameter",
+	0x0181: "device in wrong state",
+	0x0182: "out of memory",
+	0x0183: "feature not implemented",
+	0x0184: "command not recognized",
+	0x0185: "timeout",
+	0x0186: "not connected",
+	0x0187: "flow",
+	0x0188: "user attribute",
+	0x0189: "invalid license key",
+	0x018a: "command too long",
+	0x018b: "out of bonds",
+	0x018c: "script overflow",
+	0x0205: "authentication failure",
+	0x0206: "pin or key missing",
+	0x0207: "memory capacity exceeded",
+	0x0208: "connection timeout",
+	0x0209: "connection limit exceeded",
+	0x020c: "command disallowed",
+	0x0212: "invalid command parameters",
+	0x0213: "remote user terminated connection",
+	0x0216: "connection terminated by local host",
+	0x0222: "link layer response timeout",
+	0x0228: "link layer instant passed",
+	0x023a: "controller busy",
+	0x023b: "unacceptable connection interval",
+	0x023c: "directed advertising timeout",
+	0x023d: "MIC failure",
+	0x023e: "connection failed to be established",
+	0x0301: "passkey entry failed",
+	0x0302: "OOB data not available",
+	0x0303: "authentication requirements",
+	0x0304: "confirm value failed",
+	0x0305: "pairing not supported",
+	0x0306: "encryption key size",
+	0x0307: "command not supported",
+	0x0308: "unspecified reason",
+	0x0309: "repeated attempts",
+	0x030a: "invalid parameters",
+}
+
+// ResultName returns a description of a BGAPI result code, e.g. "device in
+// wrong state" for 0x0181
+func ResultName(result uint16) string {
+	if name, ok := resultNames[result]; ok {
+		return name
+	}
+	if result&0xff00 == attResultBase {
+		return AttError(result & 0xff).Error()
+	}
+	return fmt.Sprintf("result 0x%04x", result)
+}
@@ -0,0 +1,250 @@
+package bgapi
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// defaultNotifyQueueDepth values a NotifyQueue handle holds by default
+const defaultNotifyQueueDepth = 8
+
+// ErrSchedulerClosed the notification scheduler was closed
+var ErrSchedulerClosed = errors.New("notification scheduler closed")
+
+// NotifyPolicy what a NotificationScheduler does with the values of a
+// handle updated faster than they are written
+type NotifyPolicy int
+
+const (
+	// NotifyCoalesce write only the latest value, replacing the one
+	// waiting (the default): clients see the current state, not every step
+	NotifyCoalesce NotifyPolicy = iota
+	// NotifyQueue write every value in order, dropping the oldest once the
+	// queue of the handle is full
+	NotifyQueue
+)
+
+// NotifyStats the values of a handle a NotificationScheduler handled
+type NotifyStats struct {
+	// Written values written, each notified or indicated to the clients
+	// subscribed
+	Written uint64
+	// Coalesced values replaced by a later one before being written
+	Coalesced uint64
+	// Dropped values dropped from a full queue
+	Dropped uint64
+	// Failed writes the module refused
+	Failed uint64
+}
+
+// notifyHandle the values waiting to be written to a handle
+type notifyHandle struct {
+	policy NotifyPolicy
+	depth  int
+	values [][]byte
+	ready  bool // listed in the scheduler's ready handles
+	stats  NotifyStats
+}
+
+// NotificationScheduler writes the values of local attributes, which notify
+// or indicate them to the subscribed clients, one write at a time: updates
+// arriving faster are coalesced or queued per handle rather than piling up
+// in the command queue. Handles take turns so a busy one does not starve
+// the others.
+type NotificationScheduler struct {
+	api *API
+	// Interval least time between two writes, e.g. a connection interval
+	// to let the radio send each notification; none by default. Set it
+	// before the first Update.
+	Interval time.Duration
+	// OnError notified of the writes that failed, may be nil
+	OnError func(handle uint16, err error)
+
+	mutex   sync.Mutex
+	handles map[uint16]*notifyHandle
+	ready   []uint16 // handles with values waiting, in turn order
+	wake    chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+	closed  bool
+}
+
+// NewNotificationScheduler returns a scheduler writing local attributes
+// through api
+func NewNotificationScheduler(api *API) *NotificationScheduler {
+	s := &NotificationScheduler{
+		api:     api,
+		handles: make(map[uint16]*notifyHandle),
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// handle returns the state of handle, the mutex must be held
+func (s *NotificationScheduler) handle(handle uint16) *notifyHandle {
+	h := s.handles[handle]
+	if h == nil {
+		h = &notifyHandle{policy: NotifyCoalesce, depth: defaultNotifyQueueDepth}
+		s.handles[handle] = h
+	}
+	return h
+}
+
+// SetPolicy set the policy of handle; depth is the queue length of
+// NotifyQueue, 8 when zero
+func (s *NotificationScheduler) SetPolicy(handle uint16, policy NotifyPolicy, depth int) {
+	if depth <= 0 {
+		depth = defaultNotifyQueueDepth
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	h := s.handle(handle)
+	h.policy = policy
+	h.depth = depth
+}
+
+// Update schedule writing value to handle, without waiting
+func (s *NotificationScheduler) Update(handle uint16, value []byte) error {
+	value = append([]byte(nil), value...)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return ErrSchedulerClosed
+	}
+	h := s.handle(handle)
+	switch {
+	case h.policy == NotifyCoalesce && len(h.values) > 0:
+		h.values[0] = value
+		h.stats.Coalesced++
+	case h.policy == NotifyQueue && len(h.values) >= h.depth:
+		h.values = append(h.values[1:], value)
+		h.stats.Dropped++
+	default:
+		h.values = append(h.values, value)
+	}
+	if !h.ready {
+		h.ready = true
+		s.ready = append(s.ready, handle)
+	}
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Stats returns the statistics of handle
+func (s *NotificationScheduler) Stats(handle uint16) NotifyStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if h := s.handles[handle]; h != nil {
+		return h.stats
+	}
+	return NotifyStats{}
+}
+
+// Pending returns the number of values waiting to be written
+func (s *NotificationScheduler) Pending() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	n := 0
+	for _, h := range s.handles {
+		n += len(h.values)
+	}
+	return n
+}
+
+// Close stop writing, the values waiting are dropped
+func (s *NotificationScheduler) Close() {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.done)
+	s.mutex.Unlock()
+
+	<-s.stopped
+}
+
+// next returns the next value to write, false when none waits
+func (s *NotificationScheduler) next() (uint16, []byte, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.ready) == 0 {
+		return 0, nil, false
+	}
+	handle := s.ready[0]
+	s.ready = s.ready[1:]
+	h := s.handles[handle]
+	value := h.values[0]
+	h.values = h.values[1:]
+	if len(h.values) > 0 {
+		s.ready = append(s.ready, handle)
+	} else {
+		h.values = nil
+		h.ready = false
+	}
+	return handle, value, true
+}
+
+// written account a write
+func (s *NotificationScheduler) written(handle uint16, err error) {
+	s.mutex.Lock()
+	h := s.handles[handle]
+	if err != nil {
+		h.stats.Failed++
+	} else {
+		h.stats.Written++
+	}
+	s.mutex.Unlock()
+
+	if err != nil && s.OnError != nil {
+		s.OnError(handle, err)
+	}
+}
+
+// run write the values as they are scheduled
+func (s *NotificationScheduler) run() {
+	defer close(s.stopped)
+
+	for {
+		handle, value, ok := s.next()
+		if !ok {
+			select {
+			case <-s.wake:
+				continue
+			case <-s.done:
+				return
+			}
+		}
+
+		s.written(handle, s.api.SetLocalValue(handle, value))
+
+		if s.Interval > 0 {
+			select {
+			case <-s.api.clock.After(s.Interval):
+			case <-s.done:
+				return
+			}
+		}
+		select {
+		case <-s.done:
+			return
+		default:
+		}
+	}
+}
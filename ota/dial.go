@@ -0,0 +1,99 @@
+package ota
+
+import (
+	"errors"
+	"time"
+
+	bgapi "github.com/jsakwa/go_bgapi"
+)
+
+const (
+	// rebootTimeout bound on the peripheral dropping the link once asked
+	// to reboot into OTA mode
+	rebootTimeout = 5 * time.Second
+	// reconnectAttempts connection attempts while the bootloader starts
+	reconnectAttempts = 5
+	// reconnectDelay pause before each attempt
+	reconnectDelay = 500 * time.Millisecond
+)
+
+// disconnectWatcher a connection delegate signalling the disconnection
+type disconnectWatcher chan struct{}
+
+// OnDisconnected implements bgapi.ConnectionDelegate
+func (w disconnectWatcher) OnDisconnected(reason uint16) {
+	select {
+	case w <- struct{}{}:
+	default:
+	}
+}
+
+// RebootIntoOTA ask a peripheral running its application to reboot into
+// its OTA bootloader and wait for it to drop the connection. The
+// connection's delegate is replaced, and cleared on return.
+func RebootIntoOTA(conn *bgapi.Connection) error {
+	control := conn.CharacteristicForUUID(ControlUUID)
+	if control == nil {
+		return ErrNoOTAService
+	}
+
+	disconnected := make(disconnectWatcher, 1)
+	conn.SetDelegate(disconnected)
+	defer conn.SetDelegate(nil)
+
+	// the peripheral may reboot before acknowledging the write
+	conn.Write(control, []byte{controlStart}, false)
+
+	select {
+	case <-disconnected:
+		return nil
+	case <-time.After(rebootTimeout):
+		conn.Close()
+		return errors.New("peripheral did not reboot into OTA mode")
+	}
+}
+
+// Dial connect to the peripheral at address and return a client for it.
+// A peripheral running its application is rebooted into its OTA
+// bootloader, then reconnected.
+func Dial(central *bgapi.Central, address bgapi.QualifiedMac, opts *Options) (*Client, error) {
+	conn := central.NewConnection(&bgapi.GapScanRespone{Address: address}, bgapi.DefaultConnectionParameters())
+	if err := conn.Open(); err != nil {
+		return nil, err
+	}
+
+	c, err := NewClient(conn, opts)
+	if err != ErrApplicationMode {
+		return c, err
+	}
+
+	reportOpts(opts, "reboot")
+	if err := RebootIntoOTA(conn); err != nil {
+		return nil, err
+	}
+
+	for attempt := 1; ; attempt++ {
+		time.Sleep(reconnectDelay)
+		reportOpts(opts, "reconnect")
+		if err = conn.Open(); err == nil {
+			break
+		}
+		if attempt == reconnectAttempts {
+			return nil, err
+		}
+	}
+
+	c, err = NewClient(conn, opts)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// reportOpts report a phase to the progress reporter of opts, if any
+func reportOpts(opts *Options, phase string) {
+	if opts != nil && opts.Progress != nil {
+		opts.Progress.Progress(bgapi.Progress{Phase: phase})
+	}
+}
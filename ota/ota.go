@@ -0,0 +1,144 @@
+// Package ota pushes firmware images to remote peripherals implementing the
+// Silicon Labs OTA service (the Gecko bootloader AppLoader).
+package ota
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	bgapi "github.com/jsakwa/go_bgapi"
+)
+
+var (
+	// ServiceUUID the Silicon Labs OTA service
+	ServiceUUID = bgapi.MustParseUUID("1d14d6ee-fd63-4fa1-bfa4-8f47b42119f0")
+	// ControlUUID the OTA control characteristic
+	ControlUUID = bgapi.MustParseUUID("f7bf3564-fb6d-4e53-88a4-5e37e0326063")
+	// DataUUID the OTA data characteristic
+	DataUUID = bgapi.MustParseUUID("984227f3-34fc-4045-a5d0-2c581f81a153")
+)
+
+// control point commands
+const (
+	controlStart byte = 0x00
+	controlEnd   byte = 0x03
+	controlClose byte = 0x04
+)
+
+// gblHeaderTag the tag starting every GBL image
+const gblHeaderTag = 0x03a617eb
+
+const (
+	// default data chunk, the largest write that fits the default ATT MTU
+	defaultChunkSize = 20
+)
+
+var (
+	// ErrNoOTAService the peripheral does not expose the OTA characteristics
+	ErrNoOTAService = errors.New("peripheral does not implement the OTA service")
+	// ErrInvalidImage the image is not a GBL file
+	ErrInvalidImage = errors.New("image is not a GBL file")
+	// ErrVerification the peripheral rejected the image once transferred
+	ErrVerification = errors.New("peripheral rejected the image")
+	// ErrApplicationMode the peripheral runs its application, which only
+	// exposes the control characteristic; see RebootIntoOTA
+	ErrApplicationMode = errors.New("peripheral runs its application, reboot it into OTA mode first")
+)
+
+// Options tune an update
+type Options struct {
+	// ChunkSize bytes written per data write, defaults to 20
+	ChunkSize int
+	// WithoutResponse pipeline data writes without waiting for each to be
+	// acknowledged; faster, but relies on the peripheral keeping up
+	WithoutResponse bool
+	// Progress notified as the update moves through its phases and after
+	// every chunk written
+	Progress bgapi.ProgressReporter
+}
+
+// Client updates the firmware of a connected peripheral
+type Client struct {
+	conn    *bgapi.Connection
+	control *bgapi.Characteristic
+	data    *bgapi.Characteristic
+	opts    Options
+}
+
+// NewClient returns a client for an open connection, opts may be nil
+func NewClient(conn *bgapi.Connection, opts *Options) (*Client, error) {
+	c := &Client{
+		conn:    conn,
+		control: conn.CharacteristicForUUID(ControlUUID),
+		data:    conn.CharacteristicForUUID(DataUUID),
+	}
+	if c.control == nil {
+		return nil, ErrNoOTAService
+	}
+	if c.data == nil {
+		return nil, ErrApplicationMode
+	}
+
+	if opts != nil {
+		c.opts = *opts
+	}
+	if c.opts.ChunkSize <= 0 {
+		c.opts.ChunkSize = defaultChunkSize
+	}
+	return c, nil
+}
+
+// VerifyImage check that image is a GBL file
+func VerifyImage(image []byte) error {
+	if len(image) < 4 || binary.LittleEndian.Uint32(image) != gblHeaderTag {
+		return ErrInvalidImage
+	}
+	return nil
+}
+
+// Update transfer image to the peripheral, which verifies it once the
+// transfer ends
+func (c *Client) Update(image []byte) error {
+	if err := VerifyImage(image); err != nil {
+		return err
+	}
+
+	c.report("start", 0, 0)
+	if err := c.conn.Write(c.control, []byte{controlStart}, false); err != nil {
+		return fmt.Errorf("starting OTA: %v", err)
+	}
+
+	for sent := 0; sent < len(image); {
+		end := sent + c.opts.ChunkSize
+		if end > len(image) {
+			end = len(image)
+		}
+		if err := c.conn.Write(c.data, image[sent:end], c.opts.WithoutResponse); err != nil {
+			return fmt.Errorf("writing image at offset %d: %v", sent, err)
+		}
+		sent = end
+		c.report("transfer", sent, len(image))
+	}
+
+	c.report("verify", 0, 0)
+	if err := c.conn.Write(c.control, []byte{controlEnd}, false); err != nil {
+		if _, ok := err.(*bgapi.ProcedureError); ok {
+			return ErrVerification
+		}
+		return fmt.Errorf("ending OTA: %v", err)
+	}
+	return nil
+}
+
+// report notify the progress reporter, if any
+func (c *Client) report(phase string, done int, total int) {
+	if c.opts.Progress != nil {
+		c.opts.Progress.Progress(bgapi.Progress{Phase: phase, Done: done, Total: total})
+	}
+}
+
+// Close ask the peripheral to leave OTA mode and boot the new firmware
+func (c *Client) Close() error {
+	return c.conn.Write(c.control, []byte{controlClose}, false)
+}
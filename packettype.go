@@ -0,0 +1,270 @@
+package bgapi
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// AdvPacketType the type of a packet received while scanning, the link
+// layer advertising PDU type reported in GapScanRespone.PacketType
+type AdvPacketType byte
+
+// packet types of GapScanRespone
+const (
+	// AdvConnectableUndirected ADV_IND, connectable and scannable
+	AdvConnectableUndirected AdvPacketType = 0
+	// AdvConnectableDirected ADV_DIRECT_IND, connectable by the addressed
+	// central only
+	AdvConnectableDirected AdvPacketType = 1
+	// AdvNonConnectable ADV_NONCONN_IND, e.g. a beacon
+	AdvNonConnectable AdvPacketType = 2
+	// AdvScanResponse SCAN_RSP, the answer to a scan request
+	AdvScanResponse AdvPacketType = 4
+	// AdvScannable ADV_SCAN_IND, scannable but not connectable
+	AdvScannable AdvPacketType = 6
+)
+
+var advPacketTypeNames = map[AdvPacketType]string{
+	AdvConnectableUndirected: "connectable undirected",
+	AdvConnectableDirected:   "connectable directed",
+	AdvNonConnectable:        "non-connectable",
+	AdvScanResponse:          "scan response",
+	AdvScannable:             "scannable",
+}
+
+func (t AdvPacketType) String() string {
+	if name, ok := advPacketTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("packet type %d", byte(t))
+}
+
+// Connectable returns true for the advertisements accepting connections
+func (t AdvPacketType) Connectable() bool {
+	return t == AdvConnectableUndirected || t == AdvConnectableDirected
+}
+
+// Scannable returns true for the advertisements answering scan requests
+// with a scan response
+func (t AdvPacketType) Scannable() bool {
+	return t == AdvConnectableUndirected || t == AdvScannable
+}
+
+// IsScanResponse returns true for a scan response
+func (t AdvPacketType) IsScanResponse() bool {
+	return t == AdvScanResponse
+}
+
+// Type returns the decoded packet type
+func (resp *GapScanRespone) Type() AdvPacketType {
+	return AdvPacketType(resp.PacketType)
+}
+
+// MergedAdvertisement an advertisement and the scan response answering it
+type MergedAdvertisement struct {
+	Address QualifiedMac
+	Bond    byte
+	// Type of the advertisement, AdvScanResponse for a scan response
+	// received alone
+	Type AdvPacketType
+	// RSSI of the last packet
+	RSSI int8
+	// Advertisement and ScanResponse the data of the packets, nil when
+	// not received
+	Advertisement []byte
+	ScanResponse  []byte
+}
+
+// Parse decode the advertisement and scan response data as one, a field
+// of the advertisement taking precedence over the same in the scan
+// response
+func (m *MergedAdvertisement) Parse() *AdvertisementData {
+	adv := *ParseGapScanResponse(&GapScanRespone{Data: m.Advertisement})
+	for t, v := range *ParseGapScanResponse(&GapScanRespone{Data: m.ScanResponse}) {
+		if _, ok := adv[t]; !ok {
+			adv[t] = v
+		}
+	}
+	return &adv
+}
+
+// pendingAdvertisement an advertisement waiting for its scan response
+type pendingAdvertisement struct {
+	merged   MergedAdvertisement
+	deadline time.Time
+}
+
+// ScanMerger merges the scannable advertisements with their scan response:
+// fed every scan response received while scanning actively, it emits a
+// scannable advertisement once its scan response arrives, or alone after
+// waiting for it. Other packets are emitted as they are added.
+type ScanMerger struct {
+	clock Clock
+	wait  time.Duration
+	emit  func(m *MergedAdvertisement)
+
+	emitMutex sync.Mutex // serializes emit
+	mutex     sync.Mutex
+	pending   map[string]*pendingAdvertisement // keyed by address, see Hashable
+	done      chan struct{}
+	stopped   chan struct{}
+	closed    bool
+	adding    sync.WaitGroup // Add calls in progress, awaited by Close
+}
+
+// NewScanMerger returns a merger waiting up to wait for the scan response
+// of an advertisement, emit receives the merged records one at a time
+func NewScanMerger(clock Clock, wait time.Duration, emit func(m *MergedAdvertisement)) *ScanMerger {
+	m := &ScanMerger{
+		clock:   clock,
+		wait:    wait,
+		emit:    emit,
+		pending: make(map[string]*pendingAdvertisement),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go m.run()
+	return m
+}
+
+// Add merge a packet received while scanning, e.g. from
+// Central.OnScanResponse; nothing is emitted once closed
+func (m *ScanMerger) Add(resp *GapScanRespone) {
+	key := resp.Address.Hashable()
+	data := append([]byte(nil), resp.Data...)
+	var ready []*MergedAdvertisement
+
+	m.mutex.Lock()
+	if m.closed {
+		m.mutex.Unlock()
+		return
+	}
+	m.adding.Add(1)
+	defer m.adding.Done()
+	pending := m.pending[key]
+	switch {
+	case resp.Type().IsScanResponse() && pending != nil:
+		delete(m.pending, key)
+		pending.merged.ScanResponse = data
+		pending.merged.RSSI = resp.RSSI
+		ready = append(ready, &pending.merged)
+	case resp.Type().IsScanResponse():
+		ready = append(ready, &MergedAdvertisement{Address: resp.Address, Bond: resp.Bond, Type: AdvScanResponse, RSSI: resp.RSSI, ScanResponse: data})
+	default:
+		if pending != nil {
+			// advertised again without answering the scan request
+			delete(m.pending, key)
+			ready = append(ready, &pending.merged)
+		}
+		merged := MergedAdvertisement{Address: resp.Address, Bond: resp.Bond, Type: resp.Type(), RSSI: resp.RSSI, Advertisement: data}
+		if resp.Type().Scannable() && m.wait > 0 {
+			m.pending[key] = &pendingAdvertisement{merged: merged, deadline: m.clock.Now().Add(m.wait)}
+		} else {
+			ready = append(ready, &merged)
+		}
+	}
+	m.mutex.Unlock()
+
+	m.deliver(ready)
+}
+
+// Flush emit the advertisements waiting for a scan response
+func (m *ScanMerger) Flush() {
+	m.deliver(m.expired(time.Time{}))
+}
+
+// Close stop the merger and flush it, once the packets being added were
+// emitted
+func (m *ScanMerger) Close() {
+	m.mutex.Lock()
+	if m.closed {
+		m.mutex.Unlock()
+		return
+	}
+	m.closed = true
+	close(m.done)
+	m.mutex.Unlock()
+
+	<-m.stopped
+	m.adding.Wait()
+	m.Flush()
+}
+
+// expired remove the advertisements waiting past now, every one for a zero
+// now, oldest first
+func (m *ScanMerger) expired(now time.Time) []*MergedAdvertisement {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var expired []*pendingAdvertisement
+	for key, p := range m.pending {
+		if now.IsZero() || !now.Before(p.deadline) {
+			expired = append(expired, p)
+			delete(m.pending, key)
+		}
+	}
+	ready := make([]*MergedAdvertisement, 0, len(expired))
+	for len(expired) > 0 {
+		oldest := 0
+		for i, p := range expired {
+			if p.deadline.Before(expired[oldest].deadline) {
+				oldest = i
+			}
+		}
+		ready = append(ready, &expired[oldest].merged)
+		expired = append(expired[:oldest], expired[oldest+1:]...)
+	}
+	return ready
+}
+
+// deliver emit records in order
+func (m *ScanMerger) deliver(ready []*MergedAdvertisement) {
+	if len(ready) == 0 {
+		return
+	}
+	m.emitMutex.Lock()
+	defer m.emitMutex.Unlock()
+
+	for _, merged := range ready {
+		m.emit(merged)
+	}
+}
+
+// run emit the advertisements whose scan response did not arrive in time,
+// checking every half wait
+func (m *ScanMerger) run() {
+	defer close(m.stopped)
+
+	period := m.wait / 2
+	if period <= 0 {
+		period = time.Second
+	}
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-m.clock.After(period):
+			m.deliver(m.expired(m.clock.Now()))
+		}
+	}
+}
+
+// ScanMerged scan actively like Scan, sending scan requests, and emit every
+// advertisement merged with its scan response, waiting up to wait for it.
+// A handler already set in OnScanResponse keeps being called. Returns nil
+// once ctx is done.
+func (c *Central) ScanMerged(ctx context.Context, mode byte, wait time.Duration, emit func(m *MergedAdvertisement)) error {
+	merger := NewScanMerger(c.api.clock, wait, emit)
+	remove := c.addScanHook(merger.Add)
+
+	c.ScanRequestEnable()
+	err := c.Scan(ctx, mode)
+	remove()
+	merger.Close()
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,238 @@
+package bgapi
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SmIOCapabilities the input and output capabilities announced when pairing,
+// which select the pairing method
+type SmIOCapabilities byte
+
+const (
+	// SmIODisplayOnly can display a passkey
+	SmIODisplayOnly SmIOCapabilities = iota
+	// SmIODisplayYesNo can display a passkey and confirm it
+	SmIODisplayYesNo
+	// SmIOKeyboardOnly can enter a passkey
+	SmIOKeyboardOnly
+	// SmIONoInputNoOutput neither, pairing is unauthenticated (just works)
+	SmIONoInputNoOutput
+	// SmIOKeyboardDisplay can display or enter a passkey
+	SmIOKeyboardDisplay
+)
+
+// SmKeys a set of the keys exchanged when bonding, as reported by the bond
+// status event
+type SmKeys byte
+
+// keys exchanged when bonding
+const (
+	SmKeyLTK        SmKeys = 0x01
+	SmKeyAddrPublic SmKeys = 0x02
+	SmKeyAddrStatic SmKeys = 0x04
+	SmKeyIRK        SmKeys = 0x08
+	SmKeyEDIVRand   SmKeys = 0x10
+	SmKeyCSRK       SmKeys = 0x20
+	SmKeyMasterID   SmKeys = 0x40
+)
+
+var smKeyNames = []string{"ltk", "addr_public", "addr_static", "irk", "edivrand", "csrk", "masterid"}
+
+func (k SmKeys) String() string {
+	var names []string
+	for i, name := range smKeyNames {
+		if k&(1<<uint(i)) != 0 {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ",")
+}
+
+// Has returns true when every key of o is in k
+func (k SmKeys) Has(o SmKeys) bool {
+	return k&o == o
+}
+
+// MissingKeysError the bond lacks keys required by PairingOptions
+type MissingKeysError struct {
+	Exchanged SmKeys
+	Missing   SmKeys
+}
+
+func (e *MissingKeysError) Error() string {
+	return fmt.Sprintf("pairing did not exchange %s (exchanged %s)", e.Missing, e.Exchanged)
+}
+
+// PairingUI interacts with the user during passkey entry pairing. The
+// methods run on their own goroutine and may block on the user.
+type PairingUI interface {
+	// DisplayPasskey show the passkey to be entered on the peer
+	DisplayPasskey(passkey uint32)
+	// RequestPasskey ask for the passkey displayed by the peer
+	RequestPasskey() (uint32, error)
+}
+
+// PairingOptions security requirements of Connection.Pair
+type PairingOptions struct {
+	IO SmIOCapabilities
+	// MITM require man-in-the-middle protection, i.e. passkey entry
+	MITM bool
+	// MinKeySize smallest encryption key size accepted (7-16), defaults to 16
+	MinKeySize byte
+	// UI handles the passkey, required unless IO is SmIONoInputNoOutput
+	UI PairingUI
+	// RequiredKeys the keys the bond must hold, e.g. SmKeyIRK to resolve
+	// the private addresses of the peer. The firmware negotiates the keys
+	// distributed on its own, the BGAPI has no parameter requesting them;
+	// a bond lacking one of these is deleted and Pair fails with a
+	// MissingKeysError.
+	RequiredKeys SmKeys
+}
+
+// BondInfo result of a successful pairing
+type BondInfo struct {
+	// Bond handle of the stored bond, 0xff when the bond was not stored
+	Bond    byte
+	KeySize byte
+	MITM    bool
+	// Keys the keys exchanged
+	Keys SmKeys
+}
+
+// ErrNoPairingUI a passkey was requested but no PairingUI was given
+var ErrNoPairingUI = errors.New("pairing requires a passkey but no PairingUI was given")
+
+// ErrMITMImpossible MITM protection was requested with IO capabilities
+// that cannot enter nor display a passkey
+var ErrMITMImpossible = errors.New("MITM protection requires a display or a keyboard")
+
+// Validate check the options before pairing: the SMP exchange fails late
+// and with an obscure result when the local IO capabilities cannot provide
+// the protection requested. MITM protection also needs capabilities on the
+// peer, which are only known once pairing starts.
+func (o *PairingOptions) Validate() error {
+	if o.IO > SmIOKeyboardDisplay {
+		return fmt.Errorf("unknown IO capabilities %d", o.IO)
+	}
+	if o.MinKeySize != 0 && (o.MinKeySize < 7 || o.MinKeySize > 16) {
+		return fmt.Errorf("minimum key size %d out of range (7-16)", o.MinKeySize)
+	}
+	if o.MITM && o.IO == SmIONoInputNoOutput {
+		return ErrMITMImpossible
+	}
+	if o.IO != SmIONoInputNoOutput && o.UI == nil {
+		return ErrNoPairingUI
+	}
+	return nil
+}
+
+// pairingTimeout leaves the user time to enter the passkey
+const pairingTimeout time.Duration = 60000
+
+// Pair pair and bond with the peer, opts may be nil for unauthenticated
+// pairing and are validated first, see PairingOptions.Validate. Only one
+// connection can pair at a time.
+func (c *Connection) Pair(opts *PairingOptions) (*BondInfo, error) {
+	if opts == nil {
+		opts = &PairingOptions{IO: SmIONoInputNoOutput}
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	keySize := opts.MinKeySize
+	if keySize == 0 {
+		keySize = 16
+	}
+
+	api := c.central.api
+	if err := api.SmSetBondableMode(1); err != nil {
+		return nil, err
+	}
+	if err := api.SmSetParameters(boolCast(opts.MITM), keySize, byte(opts.IO)); err != nil {
+		return nil, err
+	}
+
+	// the bond status event carries no connection handle
+	c.central.mutex.Lock()
+	c.central.pairing = c
+	c.central.mutex.Unlock()
+	defer func() {
+		c.central.mutex.Lock()
+		c.central.pairing = nil
+		c.central.mutex.Unlock()
+	}()
+
+	c.pairingUI = opts.UI
+	c.bond = nil
+	c.pairingErr = nil
+	c.audit(SecurityPairingStarted, nil, nil)
+	err := c.procMgr.perform(pairingTimeout, procedureBond, func() {
+		c.procResult = 0
+		api.SmEncryptStart(c.status.Connection, 1, func(result uint16) {
+			if result != 0 {
+				c.procResult = result
+				c.procMgr.complete(procedureBond)
+			}
+		})
+	})
+	if err == nil {
+		err = c.pairingErr
+	}
+	if err == nil && c.procResult != 0 {
+		err = &ProcedureError{Result: c.procResult}
+	}
+	if err != nil {
+		c.audit(SecurityBondingFailed, nil, err)
+		return nil, err
+	}
+	if missing := opts.RequiredKeys &^ c.bond.Keys; missing != 0 {
+		err = &MissingKeysError{Exchanged: c.bond.Keys, Missing: missing}
+		c.audit(SecurityBondingFailed, c.bond, err)
+		if c.bond.Bond != 0xff && api.deleteBonding(c.bond.Bond) == nil {
+			c.audit(SecurityBondDeleted, c.bond, nil)
+		}
+		return nil, err
+	}
+	c.audit(SecurityBondingSucceeded, c.bond, nil)
+	if store := c.central.Store; store != nil && c.bond.Bond != 0xff {
+		if err := SaveBond(store, c.resp.Address, c.bond); err != nil {
+			return c.bond, fmt.Errorf("saving the bond: %w", err)
+		}
+	}
+	return c.bond, nil
+}
+
+// passkeyRequested ask the user for the passkey displayed by the peer
+func (c *Connection) passkeyRequested() {
+	c.audit(SecurityPasskeyRequested, nil, nil)
+	ui := c.pairingUI
+	if ui == nil {
+		c.pairingErr = ErrNoPairingUI
+		c.procMgr.complete(procedureBond)
+		return
+	}
+
+	go func() {
+		passkey, err := ui.RequestPasskey()
+		if err != nil {
+			c.pairingErr = err
+			c.procMgr.complete(procedureBond)
+			return
+		}
+		c.central.api.SmPasskeyEntry(c.status.Connection, passkey)
+	}()
+}
+
+// passkeyDisplayed show the passkey to be entered on the peer
+func (c *Connection) passkeyDisplayed(passkey uint32) {
+	c.audit(SecurityPasskeyDisplayed, nil, nil)
+	if ui := c.pairingUI; ui != nil {
+		go ui.DisplayPasskey(passkey)
+	}
+}
@@ -0,0 +1,111 @@
+package bgapi
+
+import "fmt"
+
+// payload sizes of the BLED112 firmwares, which neither negotiate the ATT
+// MTU nor support data length extension
+const (
+	// DefaultATTMTU the ATT MTU, the only one the firmwares support
+	DefaultATTMTU = 23
+	// MaxAttributePayload longest value of a write request or command, a
+	// notification or an indication: the ATT MTU less the opcode and handle
+	MaxAttributePayload = DefaultATTMTU - 3
+	// MaxPrepareWritePayload value bytes per prepare write request, which
+	// also carry the offset
+	MaxPrepareWritePayload = DefaultATTMTU - 5
+	// MaxAdvPayload longest advertisement or scan response data
+	MaxAdvPayload = 31
+	// MaxLocalValue longest value of a local attribute
+	MaxLocalValue = 255
+)
+
+// PayloadLimits the largest payloads a firmware accepts, to validate sizes
+// before sending rather than have the module refuse them
+type PayloadLimits struct {
+	// ATTMTU the ATT MTU of every connection
+	ATTMTU int
+	// Attribute value of a write, notification or indication
+	Attribute int
+	// Read value returned by a single read request, longer values are read
+	// in parts
+	Read int
+	// PrepareWrite value of each part of a long or reliable write
+	PrepareWrite int
+	// Adv advertisement or scan response data
+	Adv int
+	// LocalValue value of a local attribute
+	LocalValue int
+	// RawPacket payload of a raw link layer packet
+	RawPacket int
+}
+
+// firmwarePayloadLimits limits by firmware, newest first; no firmware
+// released so far changed them
+var firmwarePayloadLimits = []struct {
+	since  FirmwareVersion
+	limits PayloadLimits
+}{
+	{FirmwareVersion{Major: 1}, PayloadLimits{
+		ATTMTU:       DefaultATTMTU,
+		Attribute:    MaxAttributePayload,
+		Read:         maxReadLength,
+		PrepareWrite: MaxPrepareWritePayload,
+		Adv:          MaxAdvPayload,
+		LocalValue:   MaxLocalValue,
+		RawPacket:    rawPacketMax,
+	}},
+}
+
+// PayloadLimitsOf returns the limits of a firmware version
+func PayloadLimitsOf(version FirmwareVersion) PayloadLimits {
+	for _, l := range firmwarePayloadLimits {
+		if version.AtLeast(l.since) {
+			return l.limits
+		}
+	}
+	return firmwarePayloadLimits[len(firmwarePayloadLimits)-1].limits
+}
+
+// PayloadLimits returns the limits of the module's firmware, those of the
+// oldest firmware until its version is known
+func (api *API) PayloadLimits() PayloadLimits {
+	if version, known := api.FirmwareVersion(); known {
+		return PayloadLimitsOf(version)
+	}
+	return firmwarePayloadLimits[len(firmwarePayloadLimits)-1].limits
+}
+
+// PayloadSizeError a payload longer than the firmware accepts
+type PayloadSizeError struct {
+	// What the payload, e.g. "advertisement data"
+	What  string
+	Size  int
+	Limit int
+}
+
+func (e *PayloadSizeError) Error() string {
+	return fmt.Sprintf("%s of %d bytes exceeds the %d bytes limit", e.What, e.Size, e.Limit)
+}
+
+// checkPayload returns a PayloadSizeError when size exceeds limit
+func checkPayload(what string, size int, limit int) error {
+	if size > limit {
+		return &PayloadSizeError{What: what, Size: size, Limit: limit}
+	}
+	return nil
+}
+
+// CheckAttribute check the value of a write, notification or indication
+func (l PayloadLimits) CheckAttribute(value []byte) error {
+	return checkPayload("attribute value", len(value), l.Attribute)
+}
+
+// CheckAdv check advertisement or scan response data
+func (l PayloadLimits) CheckAdv(data []byte) error {
+	return checkPayload("advertisement data", len(data), l.Adv)
+}
+
+// CheckLocalValue check the value of a local attribute
+func (l PayloadLimits) CheckLocalValue(value []byte) error {
+	return checkPayload("local attribute value", len(value), l.LocalValue)
+}
@@ -0,0 +1,36 @@
+package bgapi
+
+// defaultHighPriority commands transmitted ahead of the queue by default:
+// disconnecting, ending the GAP procedure and answering user reads and
+// writes, which the peer or the caller waits for
+var defaultHighPriority = []uint16{
+	3<<8 | 0, // connection disconnect
+	6<<8 | 4, // gap end_procedure
+	2<<8 | 3, // attributes user_read_response
+	2<<8 | 4, // attributes user_write_response
+}
+
+// SetCommandPriority mark a command high priority, or back to normal. High
+// priority commands are transmitted before the queued normal ones and are
+// not rate limited, so a disconnect or a user read response is not held
+// behind a long write stream. Disconnecting, ending the GAP procedure and
+// the user read and write responses are high priority by default.
+func (api *API) SetCommandPriority(class byte, cmd byte, high bool) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	key := uint16(class)<<8 | uint16(cmd)
+	if high {
+		api.highPriority[key] = true
+	} else {
+		delete(api.highPriority, key)
+	}
+}
+
+// CommandPriority returns whether a command is high priority
+func (api *API) CommandPriority(class byte, cmd byte) bool {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	return api.highPriority[uint16(class)<<8|uint16(cmd)]
+}
@@ -0,0 +1,169 @@
+package bgapi
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultPrivacyInterval address rotation interval recommended by the
+	// specification
+	DefaultPrivacyInterval = 15 * time.Minute
+
+	// privacyRetryInterval delay before retrying a rotation deferred by a
+	// directed procedure
+	privacyRetryInterval = time.Second
+)
+
+// ErrPrivacyBusy the address cannot rotate while connections are open or
+// being established, the peer would no longer recognize the module
+var ErrPrivacyBusy = errors.New("address rotation deferred by an active connection")
+
+// PrivacyManager enables privacy on the module and rotates its random
+// address periodically, deferring rotations while connections are open or
+// being established
+type PrivacyManager struct {
+	central *Central
+
+	// Interval between rotations, defaults to DefaultPrivacyInterval
+	Interval time.Duration
+	// OnRotated invoked after every rotation
+	OnRotated func()
+
+	mutex          sync.Mutex
+	peripheral     bool
+	centralPrivacy bool
+	lastRotation   time.Time
+	deferred       int
+	stop           chan struct{}
+}
+
+// NewPrivacyManager returns a privacy manager for the central's module
+func NewPrivacyManager(c *Central) *PrivacyManager {
+	return &PrivacyManager{central: c, Interval: DefaultPrivacyInterval}
+}
+
+// Enable turn on privacy in the peripheral and/or central roles and start
+// rotating the address
+func (pm *PrivacyManager) Enable(peripheral bool, central bool) error {
+	pm.mutex.Lock()
+	pm.peripheral = peripheral
+	pm.centralPrivacy = central
+	pm.mutex.Unlock()
+
+	if err := pm.central.api.GapSetPrivacyFlags(boolCast(peripheral), boolCast(central)); err != nil {
+		return err
+	}
+
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	pm.lastRotation = pm.central.api.clock.Now()
+	if pm.stop == nil && (peripheral || central) {
+		pm.stop = make(chan struct{})
+		go pm.run(pm.stop, pm.Interval)
+	}
+	return nil
+}
+
+// Disable turn off privacy and stop rotating the address
+func (pm *PrivacyManager) Disable() error {
+	pm.mutex.Lock()
+	if pm.stop != nil {
+		close(pm.stop)
+		pm.stop = nil
+	}
+	pm.peripheral = false
+	pm.centralPrivacy = false
+	pm.mutex.Unlock()
+
+	return pm.central.api.GapSetPrivacyFlags(0, 0)
+}
+
+// Rotate generate a new address now, returns ErrPrivacyBusy while a
+// connection is open or being established
+func (pm *PrivacyManager) Rotate() error {
+	if pm.central.connectionsActive() {
+		pm.mutex.Lock()
+		pm.deferred++
+		pm.mutex.Unlock()
+		return ErrPrivacyBusy
+	}
+
+	pm.mutex.Lock()
+	peripheral, central := boolCast(pm.peripheral), boolCast(pm.centralPrivacy)
+	pm.mutex.Unlock()
+
+	// the module draws a new address whenever privacy is turned on
+	if err := pm.central.api.GapSetPrivacyFlags(0, 0); err != nil {
+		return err
+	}
+	if err := pm.central.api.GapSetPrivacyFlags(peripheral, central); err != nil {
+		return err
+	}
+
+	pm.mutex.Lock()
+	pm.lastRotation = pm.central.api.clock.Now()
+	pm.mutex.Unlock()
+
+	if pm.OnRotated != nil {
+		pm.OnRotated()
+	}
+	return nil
+}
+
+// LastRotation returns when the address last changed
+func (pm *PrivacyManager) LastRotation() time.Time {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	return pm.lastRotation
+}
+
+// Deferred returns the number of rotations postponed by active connections
+func (pm *PrivacyManager) Deferred() int {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	return pm.deferred
+}
+
+// run rotate the address until stopped
+func (pm *PrivacyManager) run(stop chan struct{}, interval time.Duration) {
+	timer := pm.central.api.clock.NewTimer(interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C():
+			if pm.Rotate() == ErrPrivacyBusy {
+				timer.Reset(privacyRetryInterval)
+			} else {
+				timer.Reset(interval)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// connectionsActive returns true while a connection is open or being
+// established
+func (c *Central) connectionsActive() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if len(c.openConnections) > 0 {
+		return true
+	}
+	for _, conn := range c.connections {
+		conn.procMgr.mutex.Lock()
+		connecting := conn.procMgr.procPending == procedureConnect
+		conn.procMgr.mutex.Unlock()
+		if connecting {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,40 @@
+package bgapi
+
+// Progress reports the advance of a long-running procedure
+type Progress struct {
+	// Phase the step currently performed, e.g. "discover services"
+	Phase string
+	// Done bytes or items completed so far in the phase
+	Done int
+	// Total bytes or items expected in the phase, zero when unknown
+	Total int
+}
+
+// Percent returns the completion of the phase, or -1 when the total is unknown
+func (p Progress) Percent() int {
+	if p.Total <= 0 {
+		return -1
+	}
+	return p.Done * 100 / p.Total
+}
+
+// ProgressReporter receives progress updates of long-running procedures
+// such as discovery, long reads and firmware updates
+type ProgressReporter interface {
+	Progress(p Progress)
+}
+
+// ProgressFunc adapts a function to a ProgressReporter
+type ProgressFunc func(p Progress)
+
+// Progress implements ProgressReporter
+func (f ProgressFunc) Progress(p Progress) {
+	f(p)
+}
+
+// reportProgress notify the reporter, if any
+func reportProgress(r ProgressReporter, phase string, done int, total int) {
+	if r != nil {
+		r.Progress(Progress{Phase: phase, Done: done, Total: total})
+	}
+}
@@ -0,0 +1,292 @@
+// Package provision runs manufacturing provisioning: find a device by
+// name or service, connect, verify its Device Information Service, write
+// its configuration, reboot it and record the outcome to a report.
+package provision
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	bgapi "github.com/jsakwa/go_bgapi"
+)
+
+// Device Information Service characteristics
+var (
+	manufacturerUUID     = []byte{0x29, 0x2a}
+	modelUUID            = []byte{0x24, 0x2a}
+	serialUUID           = []byte{0x25, 0x2a}
+	firmwareRevisionUUID = []byte{0x26, 0x2a}
+	hardwareRevisionUUID = []byte{0x27, 0x2a}
+	softwareRevisionUUID = []byte{0x28, 0x2a}
+)
+
+const (
+	// defaultScanTimeout bound on finding the target
+	defaultScanTimeout = 30 * time.Second
+	// rebootTimeout bound on the device dropping the link once rebooted
+	rebootTimeout = 5 * time.Second
+)
+
+// ErrNotFound no advertiser matched the target before the scan timed out
+var ErrNotFound = errors.New("target device not found")
+
+// Target selects the device to provision, by advertised name, service or
+// both; the first match is provisioned
+type Target struct {
+	// Name complete or shortened local name
+	Name string
+	// Service UUID of an advertised service, as returned by ParseUUID
+	Service []byte
+}
+
+// matches returns true when the advertisement matches every criteria
+func (t *Target) matches(adv bgapi.AdvertisementData) bool {
+	if t.Name != "" && adv.LocalName() != t.Name {
+		return false
+	}
+	if t.Service != nil {
+		for _, uuid := range adv.ServiceUUIDs() {
+			if bytes.Equal(uuid, t.Service) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// DeviceInfo values of the Device Information Service
+type DeviceInfo struct {
+	Manufacturer     string `json:"manufacturer,omitempty"`
+	Model            string `json:"model,omitempty"`
+	Serial           string `json:"serial,omitempty"`
+	FirmwareRevision string `json:"firmware_revision,omitempty"`
+	HardwareRevision string `json:"hardware_revision,omitempty"`
+	SoftwareRevision string `json:"software_revision,omitempty"`
+}
+
+// disField a Device Information Service characteristic
+type disField struct {
+	uuid  []byte
+	name  string
+	value func(info *DeviceInfo) *string
+}
+
+var disFields = []disField{
+	{manufacturerUUID, "manufacturer", func(info *DeviceInfo) *string { return &info.Manufacturer }},
+	{modelUUID, "model", func(info *DeviceInfo) *string { return &info.Model }},
+	{serialUUID, "serial", func(info *DeviceInfo) *string { return &info.Serial }},
+	{firmwareRevisionUUID, "firmware revision", func(info *DeviceInfo) *string { return &info.FirmwareRevision }},
+	{hardwareRevisionUUID, "hardware revision", func(info *DeviceInfo) *string { return &info.HardwareRevision }},
+	{softwareRevisionUUID, "software revision", func(info *DeviceInfo) *string { return &info.SoftwareRevision }},
+}
+
+// Write a value written to a characteristic
+type Write struct {
+	// UUID of the characteristic, as returned by ParseUUID
+	UUID  []byte
+	Value []byte
+}
+
+// Plan the provisioning steps of a device
+type Plan struct {
+	Target Target
+	// ScanTimeout bound on finding the target, defaults to 30 seconds
+	ScanTimeout time.Duration
+	// Params connection parameters, DefaultConnectionParameters when nil
+	Params *bgapi.ConnectionParameters
+	// Expect values the Device Information Service must report, empty
+	// fields are not checked
+	Expect DeviceInfo
+	// Writes configuration written in order
+	Writes []Write
+	// Reboot write triggering the reboot of the device, which is expected
+	// to drop the connection; nil disconnects instead
+	Reboot *Write
+}
+
+// Result outcome of provisioning a device
+type Result struct {
+	Time    time.Time     `json:"time"`
+	Address string        `json:"address,omitempty"`
+	Name    string        `json:"name,omitempty"`
+	Info    DeviceInfo    `json:"info"`
+	Elapsed time.Duration `json:"elapsed_ns"`
+	// Stage where provisioning failed, empty on success
+	Stage string `json:"stage,omitempty"`
+	Err   error  `json:"-"`
+}
+
+// Passed returns true when the device was provisioned
+func (r *Result) Passed() bool {
+	return r.Err == nil
+}
+
+// fail record the failure of a stage
+func (r *Result) fail(stage string, err error) *Result {
+	r.Stage = stage
+	r.Err = fmt.Errorf("%s: %w", stage, err)
+	return r
+}
+
+// Run provision the first device matching the plan's target. The central
+// must not be scanning or connecting. The result is returned even on
+// failure, with the failing stage.
+func Run(ctx context.Context, central *bgapi.Central, plan *Plan) *Result {
+	start := time.Now()
+	r := run(ctx, central, plan, &Result{Time: start.UTC()})
+	r.Elapsed = time.Since(start)
+	return r
+}
+
+func run(ctx context.Context, central *bgapi.Central, plan *Plan, r *Result) *Result {
+	resp, err := find(ctx, central, plan)
+	if err != nil {
+		return r.fail("scan", err)
+	}
+	r.Address = resp.Address.Address.String()
+	r.Name = bgapi.ParseGapScanResponse(resp).LocalName()
+
+	params := plan.Params
+	if params == nil {
+		params = bgapi.DefaultConnectionParameters()
+	}
+	conn := central.NewConnection(resp, params)
+	if err := conn.OpenContext(ctx); err != nil {
+		return r.fail("connect", err)
+	}
+
+	if err := verify(conn, &plan.Expect, &r.Info); err != nil {
+		conn.Close()
+		return r.fail("verify", err)
+	}
+	for _, w := range plan.Writes {
+		if err := write(conn, &w); err != nil {
+			conn.Close()
+			return r.fail("configure", err)
+		}
+	}
+
+	if plan.Reboot == nil {
+		if err := conn.Close(); err != nil {
+			return r.fail("disconnect", err)
+		}
+		return r
+	}
+	if err := reboot(conn, plan.Reboot); err != nil {
+		return r.fail("reboot", err)
+	}
+	return r
+}
+
+// find scan until an advertiser matches the target
+func find(ctx context.Context, central *bgapi.Central, plan *Plan) (*bgapi.GapScanRespone, error) {
+	timeout := plan.ScanTimeout
+	if timeout == 0 {
+		timeout = defaultScanTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var mutex sync.Mutex
+	var found *bgapi.GapScanRespone
+	central.OnScanResponse = func(resp *bgapi.GapScanRespone) {
+		if !plan.Target.matches(*bgapi.ParseGapScanResponse(resp)) {
+			return
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		if found == nil {
+			found = resp
+			cancel()
+		}
+	}
+	defer func() { central.OnScanResponse = nil }()
+
+	err := central.Scan(ctx, bgapi.GapDiscoverGeneric)
+	mutex.Lock()
+	defer mutex.Unlock()
+	if found != nil {
+		return found, nil
+	}
+	if err == context.DeadlineExceeded {
+		return nil, ErrNotFound
+	}
+	return nil, err
+}
+
+// verify read the Device Information Service and check it against expect
+func verify(conn *bgapi.Connection, expect *DeviceInfo, info *DeviceInfo) error {
+	for _, field := range disFields {
+		want := *field.value(expect)
+		char := conn.CharacteristicForUUID(field.uuid)
+		if char == nil {
+			if want != "" {
+				return fmt.Errorf("device does not report its %s", field.name)
+			}
+			continue
+		}
+		value, err := conn.Read(char)
+		if err != nil {
+			return fmt.Errorf("reading the %s: %w", field.name, err)
+		}
+		got := strings.TrimRight(string(value), "\x00")
+		*field.value(info) = got
+		if want != "" && got != want {
+			return fmt.Errorf("%s is %q, expected %q", field.name, got, want)
+		}
+	}
+	return nil
+}
+
+// write write a configuration value
+func write(conn *bgapi.Connection, w *Write) error {
+	char := conn.CharacteristicForUUID(w.UUID)
+	if char == nil {
+		return fmt.Errorf("device has no characteristic %s", bgapi.UUIDString(w.UUID))
+	}
+	if err := conn.Write(char, w.Value, false); err != nil {
+		return fmt.Errorf("writing %s: %w", bgapi.UUIDString(w.UUID), err)
+	}
+	return nil
+}
+
+// disconnectWatcher a connection delegate signalling the disconnection
+type disconnectWatcher chan struct{}
+
+// OnDisconnected implements bgapi.ConnectionDelegate
+func (w disconnectWatcher) OnDisconnected(reason uint16) {
+	select {
+	case w <- struct{}{}:
+	default:
+	}
+}
+
+// reboot trigger the reboot and wait for the device to drop the link
+func reboot(conn *bgapi.Connection, w *Write) error {
+	char := conn.CharacteristicForUUID(w.UUID)
+	if char == nil {
+		conn.Close()
+		return fmt.Errorf("device has no characteristic %s", bgapi.UUIDString(w.UUID))
+	}
+
+	disconnected := make(disconnectWatcher, 1)
+	conn.SetDelegate(disconnected)
+	defer conn.SetDelegate(nil)
+
+	// the device may reboot before acknowledging the write
+	conn.Write(char, w.Value, false)
+
+	select {
+	case <-disconnected:
+		return nil
+	case <-time.After(rebootTimeout):
+		conn.Close()
+		return errors.New("device did not reboot")
+	}
+}
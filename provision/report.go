@@ -0,0 +1,52 @@
+package provision
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// Report appends results to a file, one JSON object per line, so that a
+// rig can be stopped and restarted without losing its history
+type Report struct {
+	mutex sync.Mutex
+	file  *os.File
+	enc   *json.Encoder
+}
+
+// reportRecord a result as written to the report
+type reportRecord struct {
+	*Result
+	Passed bool   `json:"passed"`
+	Error  string `json:"error,omitempty"`
+}
+
+// OpenReport open, or create, the report at path
+func OpenReport(path string) (*Report, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Report{file: file, enc: json.NewEncoder(file)}, nil
+}
+
+// Record append a result, flushed to disk before returning so that a
+// power loss does not lose provisioned devices
+func (r *Report) Record(result *Result) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	rec := reportRecord{Result: result, Passed: result.Passed()}
+	if result.Err != nil {
+		rec.Error = result.Err.Error()
+	}
+	if err := r.enc.Encode(&rec); err != nil {
+		return err
+	}
+	return r.file.Sync()
+}
+
+// Close close the report file
+func (r *Report) Close() error {
+	return r.file.Close()
+}
@@ -0,0 +1,156 @@
+package bgapi
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// PS keys the application may use, the firmware stores its own data (such
+// as bonds) below
+const (
+	PSUserKeyFirst uint16 = 0x8000
+	PSUserKeyLast  uint16 = 0x807f
+)
+
+// PSValueMax the largest value of a PS key
+const PSValueMax = 32
+
+// psDumpEnd the key of the flash_ps_key event ending a dump
+const psDumpEnd = 0xffff
+
+// psDumpTimeout bound on a whole dump, the store holds at most a few
+// hundred keys
+const psDumpTimeout = 5 * time.Second
+
+var psKeyNames = map[uint16]string{
+	DefaultAddressPSKey: "address",
+}
+
+// PSEntry a key of the persistent store and its value
+type PSEntry struct {
+	Key   uint16
+	Value []byte
+}
+
+// IsUserPSKey returns true for the keys the application may use
+func IsUserPSKey(key uint16) bool {
+	return key >= PSUserKeyFirst && key <= PSUserKeyLast
+}
+
+// PSKeyName returns the name of a key known to this package, or a
+// description of its range
+func PSKeyName(key uint16) string {
+	if name, ok := psKeyNames[key]; ok {
+		return name
+	}
+	if IsUserPSKey(key) {
+		return fmt.Sprintf("user %d", key-PSUserKeyFirst)
+	}
+	return "firmware"
+}
+
+// PSError a persistent store command failed
+type PSError struct {
+	Op     string
+	Key    uint16
+	Result uint16
+}
+
+func (e *PSError) Error() string {
+	return fmt.Sprintf("PS %s 0x%04x failed with result 0x%04x", e.Op, e.Key, e.Result)
+}
+
+// PSDump returns every key of the persistent store
+func (api *API) PSDump() ([]PSEntry, error) {
+	var entries []PSEntry
+	var ended bool
+	done := make(chan struct{})
+
+	api.mutex.Lock()
+	if api.psDump != nil {
+		api.mutex.Unlock()
+		return nil, errors.New("a PS dump is already running")
+	}
+	api.psDump = func(key uint16, value []byte) {
+		if ended {
+			return
+		}
+		if key == psDumpEnd {
+			ended = true
+			close(done)
+			return
+		}
+		entries = append(entries, PSEntry{Key: key, Value: append([]byte(nil), value...)})
+	}
+	api.mutex.Unlock()
+	defer func() {
+		api.mutex.Lock()
+		api.psDump = nil
+		api.mutex.Unlock()
+	}()
+
+	if _, err := api.call(1, 1, nil); err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-done:
+		return entries, nil
+	case <-api.clock.After(psDumpTimeout):
+		return nil, errors.New("PS dump did not complete")
+	}
+}
+
+// PSLoad returns the value of key
+func (api *API) PSLoad(key uint16) ([]byte, error) {
+	buf, err := api.call(1, 4, []byte{byte(key), byte(key >> 8)})
+	if err != nil {
+		return nil, err
+	}
+	d := newDecoder(buf)
+	result := d.u16()
+	value := d.uint8array()
+	if d.err != nil {
+		return nil, d.err
+	}
+	if result != 0 {
+		return nil, &PSError{Op: "load", Key: key, Result: result}
+	}
+	return value, nil
+}
+
+// PSSave store value in key
+func (api *API) PSSave(key uint16, value []byte) error {
+	if len(value) > PSValueMax {
+		return fmt.Errorf("PS value of %d bytes exceeds %d", len(value), PSValueMax)
+	}
+	buf, err := api.call(1, 3, (&encoder{}).write(key).uint8array(value).bytes())
+	if err != nil {
+		return err
+	}
+	d := newDecoder(buf)
+	if result := d.u16(); d.err != nil {
+		return d.err
+	} else if result != 0 {
+		return &PSError{Op: "save", Key: key, Result: result}
+	}
+	return nil
+}
+
+// PSErase erase key
+func (api *API) PSErase(key uint16) error {
+	_, err := api.call(1, 5, []byte{byte(key), byte(key >> 8)})
+	return err
+}
+
+// psKey hand a flash_ps_key event to a running dump
+func (api *API) psKey(key uint16, value []byte) {
+	api.mutex.Lock()
+	dump := api.psDump
+	api.mutex.Unlock()
+
+	if dump != nil {
+		dump(key, value)
+	}
+}
@@ -0,0 +1,148 @@
+package bgapi
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// RateLimit a token bucket: commands are submitted at Rate per second on
+// average, up to Burst at once
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// Validate check that the limit lets commands through
+func (l *RateLimit) Validate() error {
+	if l.Rate <= 0 {
+		return errors.New("rate limit must be positive")
+	}
+	if l.Burst < 1 {
+		return errors.New("rate limit burst must be at least 1")
+	}
+	return nil
+}
+
+// tokenBucket the state of a rate limit
+type tokenBucket struct {
+	limit  RateLimit
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(limit RateLimit, now time.Time) *tokenBucket {
+	return &tokenBucket{limit: limit, tokens: float64(limit.Burst), last: now}
+}
+
+// reserve take a token, returns how long to wait for it. Tokens go
+// negative while commands wait so that they are let through in order.
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * b.limit.Rate
+		if b.tokens > float64(b.limit.Burst) {
+			b.tokens = float64(b.limit.Burst)
+		}
+		b.last = now
+	}
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.limit.Rate * float64(time.Second))
+}
+
+// rateLimiter the rate limits of an API
+type rateLimiter struct {
+	mutex         sync.Mutex
+	global        *tokenBucket
+	perConnection *RateLimit
+	connections   map[byte]*tokenBucket
+}
+
+// SetRateLimit limit the rate at which commands are submitted, to keep the
+// latency of each goroutine predictable when several share the module.
+// global applies to every command, perConnection to the commands of each
+// connection (the connection and attribute client classes) separately; nil
+// removes a limit. Submitting a command over the limit blocks until it is
+// let through, the wait is accounted in Stats; commands submitted on the
+// receive goroutine, e.g. indication confirmations, are let through at
+// once.
+func (api *API) SetRateLimit(global *RateLimit, perConnection *RateLimit) error {
+	for _, l := range []*RateLimit{global, perConnection} {
+		if l != nil {
+			if err := l.Validate(); err != nil {
+				return err
+			}
+		}
+	}
+
+	limiter := &api.limiter
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	limiter.global = nil
+	if global != nil {
+		limiter.global = newTokenBucket(*global, api.clock.Now())
+	}
+	limiter.perConnection = nil
+	limiter.connections = nil
+	if perConnection != nil {
+		limit := *perConnection
+		limiter.perConnection = &limit
+		limiter.connections = make(map[byte]*tokenBucket)
+	}
+	return nil
+}
+
+// operationConnection returns the connection a command addresses, false
+// for commands outside the connection and attribute client classes
+func operationConnection(op *operation) (byte, bool) {
+	if (op.class == 3 || op.class == 4) && len(op.txData) > 4 {
+		return op.txData[4], true
+	}
+	return 0, false
+}
+
+// throttle wait until the rate limits let op through
+func (api *API) throttle(op *operation) {
+	limiter := &api.limiter
+	now := api.clock.Now()
+
+	limiter.mutex.Lock()
+	var wait time.Duration
+	if limiter.global != nil {
+		wait = limiter.global.reserve(now)
+	}
+	if connection, ok := operationConnection(op); ok && limiter.perConnection != nil {
+		bucket := limiter.connections[connection]
+		if bucket == nil {
+			bucket = newTokenBucket(*limiter.perConnection, now)
+			limiter.connections[connection] = bucket
+		}
+		if w := bucket.reserve(now); w > wait {
+			wait = w
+		}
+	}
+	limiter.mutex.Unlock()
+
+	if wait <= 0 {
+		return
+	}
+	api.enqueueMutex.RLock()
+	rxGoroutine := api.rxGoroutine
+	api.enqueueMutex.RUnlock()
+	if rxGoroutine != 0 && rxGoroutine == goroutineID() {
+		// waiting would stall the events, the token is taken all the same
+		return
+	}
+	api.mutex.Lock()
+	api.stats.Throttled++
+	api.stats.ThrottleWait += wait
+	api.mutex.Unlock()
+
+	select {
+	case <-api.clock.After(wait):
+	case <-api.done:
+	}
+}
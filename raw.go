@@ -0,0 +1,60 @@
+package bgapi
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+)
+
+// maxPayload largest payload the 11-bit length of the header can describe
+const maxPayload = 0x7ff
+
+// maxPacketFrame largest frame, header included, the length byte preceding
+// it in packet mode can describe
+const maxPacketFrame = 0xff
+
+// SendRaw send a command not wrapped by this package and return the payload
+// of its response, e.g. to exercise vendor or newer firmware commands. The
+// command times out at the deadline of ctx, or after the default timeout of
+// a second; events it triggers are delivered to the delegate as usual.
+// Cancelling ctx abandons the wait but not a command already queued.
+func (api *API) SendRaw(ctx context.Context, class byte, cmd byte, payload []byte) ([]byte, error) {
+	if len(payload) > maxPayload {
+		return nil, fmt.Errorf("payload of %d bytes exceeds the %d byte maximum", len(payload), maxPayload)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	timeout := time.Duration(defaultTimeoutMs)
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline) / time.Millisecond
+		if timeout < 1 {
+			timeout = 1
+		}
+	}
+
+	type reply struct {
+		data []byte
+		err  error
+	}
+	replyC := make(chan reply, 1)
+	err := api.submit(class, cmd, payload, timeout, func(buf *bytes.Buffer, err error) {
+		if err != nil {
+			replyC <- reply{err: err}
+			return
+		}
+		replyC <- reply{data: append([]byte{}, buf.Bytes()...)}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case r := <-replyC:
+		return r.data, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,164 @@
+package bgapi
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// rawReaderBuffer bytes received and not yet read a raw reader holds
+// before dropping packets
+const rawReaderBuffer = 64 * 1024
+
+// ErrRawOverflow raw packets were dropped as the reader fell behind
+var ErrRawOverflow = errors.New("raw receive buffer overflow, packets dropped")
+
+// rawReader buffers the raw packets received for reading as a stream
+type rawReader struct {
+	mutex    sync.Mutex
+	readable *sync.Cond
+	buf      []byte
+	err      error // returned once the buffer is drained
+	overflow bool
+}
+
+// RawReader returns the raw packets received on the connection as a byte
+// stream, e.g. to run a protocol over bufio or gob. Packets are buffered
+// until read; when the reader falls behind by more than 64 KiB the packets
+// are dropped and the next Read fails with ErrRawOverflow. Reads return
+// io.EOF once the connection is lost and the buffer drained, Close stops
+// the buffering. Calling RawReader again returns the same reader until it
+// is closed. The data channel, if open, keeps receiving the packets too.
+func (c *Connection) RawReader() io.ReadCloser {
+	c.central.mutex.Lock()
+	defer c.central.mutex.Unlock()
+
+	if c.rawReader == nil {
+		r := &rawReader{}
+		r.readable = sync.NewCond(&r.mutex)
+		c.rawReader = r
+	}
+	return &rawReadCloser{conn: c, reader: c.rawReader}
+}
+
+// rawReadCloser the reader handed to the application
+type rawReadCloser struct {
+	conn   *Connection
+	reader *rawReader
+}
+
+// Read implements io.Reader
+func (r *rawReadCloser) Read(p []byte) (int, error) {
+	return r.reader.read(p)
+}
+
+// Close implements io.Closer, pending reads return io.ErrClosedPipe
+func (r *rawReadCloser) Close() error {
+	r.conn.central.mutex.Lock()
+	if r.conn.rawReader == r.reader {
+		r.conn.rawReader = nil
+	}
+	r.conn.central.mutex.Unlock()
+
+	r.reader.close(io.ErrClosedPipe)
+	return nil
+}
+
+// rawReceiver returns the raw reader of the connection, if any
+func (c *Connection) rawReceiver() *rawReader {
+	c.central.mutex.Lock()
+	defer c.central.mutex.Unlock()
+
+	return c.rawReader
+}
+
+// endRawReader end the stream of the raw reader, reads return io.EOF once
+// it is drained
+func (c *Connection) endRawReader() {
+	c.central.mutex.Lock()
+	r := c.rawReader
+	c.rawReader = nil
+	c.central.mutex.Unlock()
+
+	if r != nil {
+		r.close(io.EOF)
+	}
+}
+
+// receive buffer a raw packet
+func (r *rawReader) receive(data []byte) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.err != nil {
+		return
+	}
+	if len(r.buf)+len(data) > rawReaderBuffer {
+		r.overflow = true
+		r.readable.Broadcast()
+		return
+	}
+	r.buf = append(r.buf, data...)
+	r.readable.Broadcast()
+}
+
+// close end the stream with err once the buffer is drained
+func (r *rawReader) close(err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.err == nil {
+		r.err = err
+	}
+	r.readable.Broadcast()
+}
+
+// read wait for data
+func (r *rawReader) read(p []byte) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for len(r.buf) == 0 && r.err == nil && !r.overflow {
+		r.readable.Wait()
+	}
+	if r.overflow {
+		r.overflow = false
+		return 0, ErrRawOverflow
+	}
+	if len(r.buf) == 0 {
+		return 0, r.err
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	if len(r.buf) == 0 {
+		r.buf = nil
+	}
+	return n, nil
+}
+
+// rawWriter sends a byte stream as raw packets
+type rawWriter struct {
+	conn *Connection
+}
+
+// RawWriter returns a writer sending the bytes written as raw packets of
+// at most 27 bytes; each Write is queued before it returns
+func (c *Connection) RawWriter() io.Writer {
+	return &rawWriter{conn: c}
+}
+
+// Write implements io.Writer
+func (w *rawWriter) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		n := len(p) - written
+		if n > rawPacketMax {
+			n = rawPacketMax
+		}
+		if err := w.conn.central.api.ConnectionRawTx(w.conn.status.Connection, p[written:written+n]); err != nil {
+			return written, err
+		}
+		written += n
+	}
+	return written, nil
+}
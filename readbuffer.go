@@ -0,0 +1,55 @@
+package bgapi
+
+const (
+	defaultReadBufferSize    = 128
+	defaultMaxReadBufferSize = 4096
+	// readBufferGrowAfter consecutive reads filling the buffer before it
+	// doubles, a single full read is usually a burst rather than a trend
+	readBufferGrowAfter = 4
+)
+
+// readBuffer the buffer handed to Transport.Read. Under heavy traffic (scan
+// responses from many advertisers) reads keep filling a small buffer and the
+// receive loop spends its time in syscalls, so the buffer doubles whenever
+// readBufferGrowAfter reads in a row fill it, up to max.
+type readBuffer struct {
+	data []byte
+	max  int
+	full int
+}
+
+func newReadBuffer(opts *TransportOptions) readBuffer {
+	size, max := defaultReadBufferSize, defaultMaxReadBufferSize
+	if opts != nil {
+		if opts.ReadBufferSize > 0 {
+			size = opts.ReadBufferSize
+		}
+		if opts.MaxReadBufferSize > 0 {
+			max = opts.MaxReadBufferSize
+		}
+	}
+	if max < size {
+		max = size
+	}
+	return readBuffer{data: make([]byte, size), max: max}
+}
+
+// observe account for a read of n bytes, growing the buffer if needed
+func (rb *readBuffer) observe(n int) {
+	if n < len(rb.data) {
+		rb.full = 0
+		return
+	}
+
+	rb.full++
+	if rb.full < readBufferGrowAfter || len(rb.data) >= rb.max {
+		return
+	}
+
+	size := 2 * len(rb.data)
+	if size > rb.max {
+		size = rb.max
+	}
+	rb.data = make([]byte, size)
+	rb.full = 0
+}
@@ -0,0 +1,195 @@
+package bgapi
+
+import (
+	"fmt"
+)
+
+// RegisterField describes a bitfield within a register
+type RegisterField struct {
+	Name        string
+	Shift       uint
+	Width       uint
+	Description string
+}
+
+// Mask returns the bits occupied by the field
+func (f *RegisterField) Mask() byte {
+	return byte(((1 << f.Width) - 1) << f.Shift)
+}
+
+// Get extract the field from a register value
+func (f *RegisterField) Get(value byte) byte {
+	return (value & f.Mask()) >> f.Shift
+}
+
+// Set returns the register value with the field replaced
+func (f *RegisterField) Set(value byte, field byte) byte {
+	return (value &^ f.Mask()) | ((field << f.Shift) & f.Mask())
+}
+
+// Register describes a CC2540 register reachable through SystemRegRead and
+// SystemRegWrite. Addresses are in the XDATA memory map, where the SFRs are
+// mirrored at 0x7080-0x70ff.
+type Register struct {
+	Name        string
+	Addr        uint16
+	Description string
+	Fields      []RegisterField
+}
+
+// Field returns the named field or nil
+func (r *Register) Field(name string) *RegisterField {
+	for i := range r.Fields {
+		if r.Fields[i].Name == name {
+			return &r.Fields[i]
+		}
+	}
+	return nil
+}
+
+// sfr returns the XDATA address of a special function register
+func sfr(addr byte) uint16 {
+	return 0x7000 | uint16(addr)
+}
+
+func pinsField(description string) []RegisterField {
+	return []RegisterField{{Name: "PINS", Shift: 0, Width: 8, Description: description}}
+}
+
+// commonly used CC2540 registers
+var (
+	// RegP0 port 0 data
+	RegP0 = &Register{Name: "P0", Addr: sfr(0x80), Description: "port 0", Fields: pinsField("pin levels P0_7..P0_0")}
+	// RegP1 port 1 data
+	RegP1 = &Register{Name: "P1", Addr: sfr(0x90), Description: "port 1", Fields: pinsField("pin levels P1_7..P1_0")}
+	// RegP2 port 2 data
+	RegP2 = &Register{Name: "P2", Addr: sfr(0xa0), Description: "port 2", Fields: pinsField("pin levels P2_4..P2_0")}
+	// RegP0Dir port 0 direction
+	RegP0Dir = &Register{Name: "P0DIR", Addr: sfr(0xfd), Description: "port 0 direction", Fields: pinsField("1 = output")}
+	// RegP1Dir port 1 direction
+	RegP1Dir = &Register{Name: "P1DIR", Addr: sfr(0xfe), Description: "port 1 direction", Fields: pinsField("1 = output")}
+	// RegP2Dir port 2 direction and port 0 peripheral priority
+	RegP2Dir = &Register{Name: "P2DIR", Addr: sfr(0xff), Description: "port 2 direction", Fields: []RegisterField{
+		{Name: "PRIP0", Shift: 6, Width: 2, Description: "port 0 peripheral priority"},
+		{Name: "DIRP2", Shift: 0, Width: 5, Description: "P2_4..P2_0 direction, 1 = output"},
+	}}
+	// RegP0Sel port 0 function select
+	RegP0Sel = &Register{Name: "P0SEL", Addr: sfr(0xf3), Description: "port 0 function select", Fields: pinsField("1 = peripheral function")}
+	// RegP1Sel port 1 function select
+	RegP1Sel = &Register{Name: "P1SEL", Addr: sfr(0xf4), Description: "port 1 function select", Fields: pinsField("1 = peripheral function")}
+	// RegP2Sel port 2 function select and peripheral priority
+	RegP2Sel = &Register{Name: "P2SEL", Addr: sfr(0xf5), Description: "port 2 function select", Fields: []RegisterField{
+		{Name: "PRI3P1", Shift: 6, Width: 1, Description: "port 1 priority, USART1 over timer 3"},
+		{Name: "PRI2P1", Shift: 5, Width: 1, Description: "port 1 priority, timer 3 over USART1"},
+		{Name: "PRI1P1", Shift: 4, Width: 1, Description: "port 1 priority, timer 4 over timer 1"},
+		{Name: "PRI0P1", Shift: 3, Width: 1, Description: "port 1 priority, USART1 over USART0"},
+		{Name: "SELP2_4", Shift: 2, Width: 1, Description: "P2_4 peripheral function"},
+		{Name: "SELP2_3", Shift: 1, Width: 1, Description: "P2_3 peripheral function"},
+		{Name: "SELP2_0", Shift: 0, Width: 1, Description: "P2_0 peripheral function"},
+	}}
+	// RegP0Inp port 0 input mode
+	RegP0Inp = &Register{Name: "P0INP", Addr: sfr(0x8f), Description: "port 0 input mode", Fields: pinsField("1 = tristate, 0 = pull-up/down")}
+	// RegP1Inp port 1 input mode
+	RegP1Inp = &Register{Name: "P1INP", Addr: sfr(0xf6), Description: "port 1 input mode", Fields: pinsField("1 = tristate, 0 = pull-up/down")}
+	// RegP2Inp port 2 input mode and port pull direction
+	RegP2Inp = &Register{Name: "P2INP", Addr: sfr(0xf7), Description: "port 2 input mode", Fields: []RegisterField{
+		{Name: "PDUP2", Shift: 7, Width: 1, Description: "port 2 pull, 1 = pull-down"},
+		{Name: "PDUP1", Shift: 6, Width: 1, Description: "port 1 pull, 1 = pull-down"},
+		{Name: "PDUP0", Shift: 5, Width: 1, Description: "port 0 pull, 1 = pull-down"},
+		{Name: "MDP2", Shift: 0, Width: 5, Description: "P2_4..P2_0 input mode, 1 = tristate"},
+	}}
+	// RegPerCfg peripheral I/O location
+	RegPerCfg = &Register{Name: "PERCFG", Addr: sfr(0xf1), Description: "peripheral I/O location", Fields: []RegisterField{
+		{Name: "T1CFG", Shift: 6, Width: 1, Description: "timer 1 I/O location"},
+		{Name: "T3CFG", Shift: 5, Width: 1, Description: "timer 3 I/O location"},
+		{Name: "T4CFG", Shift: 4, Width: 1, Description: "timer 4 I/O location"},
+		{Name: "U1CFG", Shift: 1, Width: 1, Description: "USART1 I/O location"},
+		{Name: "U0CFG", Shift: 0, Width: 1, Description: "USART0 I/O location"},
+	}}
+	// RegAPCfg analog peripheral I/O configuration
+	RegAPCfg = &Register{Name: "APCFG", Addr: sfr(0xf2), Description: "analog I/O configuration", Fields: pinsField("1 = P0 pin used as analog input")}
+	// RegClkConCmd clock control command
+	RegClkConCmd = &Register{Name: "CLKCONCMD", Addr: sfr(0xc6), Description: "clock control command", Fields: []RegisterField{
+		{Name: "OSC32K", Shift: 7, Width: 1, Description: "32 kHz clock source, 1 = RC oscillator"},
+		{Name: "OSC", Shift: 6, Width: 1, Description: "system clock source, 1 = 16 MHz RC oscillator"},
+		{Name: "TICKSPD", Shift: 3, Width: 3, Description: "timer tick speed, 32 MHz >> n"},
+		{Name: "CLKSPD", Shift: 0, Width: 3, Description: "clock speed, 32 MHz >> n"},
+	}}
+	// RegClkConSta clock control status
+	RegClkConSta = &Register{Name: "CLKCONSTA", Addr: sfr(0x9e), Description: "clock control status", Fields: []RegisterField{
+		{Name: "OSC32K", Shift: 7, Width: 1, Description: "current 32 kHz clock source"},
+		{Name: "OSC", Shift: 6, Width: 1, Description: "current system clock source"},
+		{Name: "TICKSPD", Shift: 3, Width: 3, Description: "current timer tick speed"},
+		{Name: "CLKSPD", Shift: 0, Width: 3, Description: "current clock speed"},
+	}}
+	// RegSleepCmd sleep mode control
+	RegSleepCmd = &Register{Name: "SLEEPCMD", Addr: sfr(0xbe), Description: "sleep mode control", Fields: []RegisterField{
+		{Name: "OSC32K_CALDIS", Shift: 7, Width: 1, Description: "disable 32 kHz RC calibration"},
+		{Name: "MODE", Shift: 0, Width: 2, Description: "power mode entered on PCON.IDLE"},
+	}}
+	// RegSleepSta sleep mode status
+	RegSleepSta = &Register{Name: "SLEEPSTA", Addr: sfr(0x9d), Description: "sleep mode status", Fields: []RegisterField{
+		{Name: "OSC32K_CALDIS", Shift: 7, Width: 1, Description: "32 kHz RC calibration disabled"},
+		{Name: "RST", Shift: 3, Width: 2, Description: "cause of last reset"},
+		{Name: "CLK32K", Shift: 0, Width: 1, Description: "32 kHz clock level"},
+	}}
+	// RegWdCtl watchdog timer control
+	RegWdCtl = &Register{Name: "WDCTL", Addr: sfr(0xc9), Description: "watchdog timer control", Fields: []RegisterField{
+		{Name: "CLR", Shift: 4, Width: 4, Description: "clear sequence (0xa then 0x5)"},
+		{Name: "MODE", Shift: 2, Width: 2, Description: "timer mode"},
+		{Name: "INT", Shift: 0, Width: 2, Description: "timer interval"},
+	}}
+	// RegTxPower radio output power
+	RegTxPower = &Register{Name: "TXPOWER", Addr: 0x61a1, Description: "radio output power", Fields: pinsField("PA power setting")}
+	// RegChVer chip version
+	RegChVer = &Register{Name: "CHVER", Addr: 0x6249, Description: "chip version", Fields: pinsField("chip revision")}
+	// RegChipID chip identification
+	RegChipID = &Register{Name: "CHIPID", Addr: 0x624a, Description: "chip identification", Fields: pinsField("0x8d for CC2540")}
+)
+
+// CC2540Registers the named register map
+var CC2540Registers = []*Register{
+	RegP0, RegP1, RegP2,
+	RegP0Dir, RegP1Dir, RegP2Dir,
+	RegP0Sel, RegP1Sel, RegP2Sel,
+	RegP0Inp, RegP1Inp, RegP2Inp,
+	RegPerCfg, RegAPCfg,
+	RegClkConCmd, RegClkConSta,
+	RegSleepCmd, RegSleepSta,
+	RegWdCtl,
+	RegTxPower, RegChVer, RegChipID,
+}
+
+// RegisterByName look up a register in the map
+func RegisterByName(name string) *Register {
+	for _, r := range CC2540Registers {
+		if r.Name == name {
+			return r
+		}
+	}
+	return nil
+}
+
+// RegisterRead read a named register
+func (api *API) RegisterRead(reg *Register, completion func(byte)) error {
+	return api.SystemRegRead(reg.Addr, func(_ uint16, value uint8) {
+		completion(value)
+	})
+}
+
+// RegisterWrite write a named register, the completion receives the result code
+func (api *API) RegisterWrite(reg *Register, value byte, completion func(uint16)) error {
+	return api.SystemRegWrite(reg.Addr, value, completion)
+}
+
+// RegisterFieldWrite read-modify-write a single bitfield of a register
+func (api *API) RegisterFieldWrite(reg *Register, name string, value byte, completion func(uint16)) error {
+	field := reg.Field(name)
+	if field == nil {
+		return fmt.Errorf("register %s has no field %s", reg.Name, name)
+	}
+
+	return api.RegisterRead(reg, func(current byte) {
+		api.RegisterWrite(reg, field.Set(current, value), completion)
+	})
+}
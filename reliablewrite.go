@@ -0,0 +1,88 @@
+package bgapi
+
+import (
+	"bytes"
+	"errors"
+	"time"
+)
+
+// prepareWriteChunk value bytes per prepare write request with the default
+// ATT MTU of 23 bytes
+const prepareWriteChunk = 18
+
+// maxReadLength longest value returned by a read request with the default
+// ATT MTU
+const maxReadLength = 22
+
+var (
+	// ErrReliableWriteUnsupported the extended properties of the
+	// characteristic do not allow reliable writes
+	ErrReliableWriteUnsupported = errors.New("characteristic does not support reliable writes")
+	// ErrReliableWriteMismatch the value read back differs from the value
+	// written
+	ErrReliableWriteMismatch = errors.New("value read back differs from the value written")
+)
+
+// ReliableWrite write the value of the characteristic with the reliable
+// write procedure: the value is queued by the peer in parts with prepare
+// write requests, then committed at once with an execute write request, or
+// cancelled if any part fails. BGAPI does not report the parts echoed by
+// the peer, so readable characteristics are read back and compared instead.
+func (c *Characteristic) ReliableWrite(data []byte) error {
+	if c.value == nil {
+		return errors.New("characteristic has no value attribute")
+	}
+	if ext, known := c.ExtendedProperties(); known && ext&ExtPropReliableWrite == 0 {
+		return ErrReliableWriteUnsupported
+	}
+
+	conn := c.conn
+	for offset := 0; offset < len(data) || offset == 0; offset += prepareWriteChunk {
+		end := offset + prepareWriteChunk
+		if end > len(data) {
+			end = len(data)
+		}
+		if err := conn.prepareWrite(c.value.handle, uint16(offset), data[offset:end]); err != nil {
+			conn.executeWrite(false)
+			return err
+		}
+		reportProgress(conn.progress, "reliable write", end, len(data))
+	}
+	if err := conn.executeWrite(true); err != nil {
+		return err
+	}
+
+	if c.properties&CharPropRead == 0 {
+		return nil
+	}
+	read := conn.Read
+	if len(data) > maxReadLength {
+		read = conn.ReadLong
+	}
+	value, err := read(c)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(value, data) {
+		return ErrReliableWriteMismatch
+	}
+	return nil
+}
+
+// prepareWrite queue part of a value on the peer
+func (c *Connection) prepareWrite(handle uint16, offset uint16, part []byte) error {
+	var timeout time.Duration = 5000
+	return c.withRetry(func() error {
+		return c.performGatt(timeout, func() {
+			c.attclientCommand(9, new(encoder).write(c.status.Connection).write(handle).write(offset).uint8array(part))
+		})
+	})
+}
+
+// executeWrite commit, or cancel, the parts queued on the peer
+func (c *Connection) executeWrite(commit bool) error {
+	var timeout time.Duration = 5000
+	return c.performGatt(timeout, func() {
+		c.attclientCommand(10, new(encoder).write(c.status.Connection).write(boolCast(commit)))
+	})
+}
@@ -0,0 +1,89 @@
+package bgapi
+
+import (
+	"bytes"
+	"errors"
+	"time"
+)
+
+// transientResults results reporting a temporary shortage rather than an
+// invalid request, the operation may succeed if retried
+var transientResults = map[uint16]bool{
+	0x0181: true, // device in wrong state, e.g. another procedure is active
+	0x0182: true, // out of memory
+	0x020c: true, // command disallowed by the link layer
+	0x023a: true, // controller busy
+	0x0409: true, // ATT prepare queue full
+	0x0411: true, // ATT insufficient resources
+}
+
+// IsTransient returns true when err reports a temporary condition, e.g. the
+// peer running out of resources, as opposed to a permanent one such as an
+// invalid handle
+func IsTransient(err error) bool {
+	var procErr *ProcedureError
+	return errors.As(err, &procErr) && transientResults[procErr.Result]
+}
+
+// RetryPolicy how operations failing with transient results are retried
+type RetryPolicy struct {
+	// Attempts retries after the first failure, zero disables retrying
+	Attempts int
+	// Delay before the first retry, doubled after each failure
+	Delay time.Duration
+	// MaxDelay bound on the delay, unbounded when zero
+	MaxDelay time.Duration
+}
+
+// Backoff returns the delay before retry attempt (counted from 0), and
+// false once the policy gives up
+func (p *RetryPolicy) Backoff(attempt int) (time.Duration, bool) {
+	if attempt >= p.Attempts {
+		return 0, false
+	}
+	return backoff(p.Delay, p.MaxDelay, attempt), true
+}
+
+// backoff returns delay doubled attempt times, bounded by maxDelay when
+// not zero
+func backoff(delay time.Duration, maxDelay time.Duration, attempt int) time.Duration {
+	for i := 0; i < attempt && delay > 0; i++ {
+		if maxDelay > 0 && delay >= maxDelay {
+			break
+		}
+		delay *= 2
+	}
+	if maxDelay > 0 && delay > maxDelay {
+		delay = maxDelay
+	}
+	return delay
+}
+
+// withRetry run op, retrying it on transient failures as set by the
+// central's GattRetry
+func (c *Connection) withRetry(op func() error) error {
+	policy := c.central.GattRetry
+	for attempt := 0; ; attempt++ {
+		err := op()
+		if !IsTransient(err) {
+			return err
+		}
+		delay, retry := policy.Backoff(attempt)
+		if !retry {
+			return err
+		}
+		c.central.api.sleep(delay)
+	}
+}
+
+// attclientCommand send the attclient command starting the pending
+// procedure, which fails at once when the module refuses the command
+func (c *Connection) attclientCommand(cmd byte, enc *encoder) error {
+	return c.central.api.send(4, cmd, enc.bytes(), func(buf *bytes.Buffer) {
+		d := newDecoder(buf)
+		d.u8() // connection handle
+		if result := d.u16(); d.err == nil && result != 0 {
+			c.procMgr.refuse(result)
+		}
+	})
+}
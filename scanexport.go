@@ -0,0 +1,233 @@
+package bgapi
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ScanRecord the fields of a scan response decoded for export, optional
+// fields are zero when not advertised
+type ScanRecord struct {
+	Time       time.Time
+	Address    QualifiedMac
+	RSSI       int8
+	PacketType byte
+	Name       string
+	TxPower    *int8
+	// Company the company identifier of the manufacturer data
+	Company  *uint16
+	Services []string
+	IBeacon  *IBeacon
+	// Eddystone the Eddystone frame type, empty when none
+	Eddystone string
+	Data      []byte
+}
+
+// NewScanRecord decode a scan response received at t
+func NewScanRecord(t time.Time, resp *GapScanRespone) *ScanRecord {
+	r := &ScanRecord{
+		Time:       t,
+		Address:    resp.Address,
+		RSSI:       resp.RSSI,
+		PacketType: resp.PacketType,
+		Data:       append([]byte(nil), resp.Data...),
+	}
+
+	adv := *ParseGapScanResponse(resp)
+	r.Name = adv.LocalName()
+	if power, ok := adv.TxPower(); ok {
+		r.TxPower = &power
+	}
+	if company, _, ok := adv.ManufacturerData(); ok {
+		r.Company = &company
+	}
+	for _, uuid := range adv.ServiceUUIDs() {
+		r.Services = append(r.Services, UUIDString(uuid))
+	}
+	sort.Strings(r.Services)
+	r.IBeacon, _ = adv.IBeacon()
+	if e, ok := adv.Eddystone(); ok {
+		r.Eddystone = e.Frame.String()
+	}
+	return r
+}
+
+// addressType the address type as written by the exporters
+func (r *ScanRecord) addressType() string {
+	if r.Address.AddrType == AddrTypeRandom {
+		return "random"
+	}
+	return "public"
+}
+
+// ScanExporter writes scan records to a file or socket, e.g. for RF
+// surveys fed to a time-series database
+type ScanExporter interface {
+	Export(record *ScanRecord) error
+	// Flush write buffered records
+	Flush() error
+}
+
+// csvColumns the header of the CSV export
+var csvColumns = []string{
+	"time", "address", "address_type", "rssi", "packet_type", "name", "tx_power", "company",
+	"services", "ibeacon_uuid", "ibeacon_major", "ibeacon_minor", "eddystone", "data",
+}
+
+// CSVExporter writes scan records as CSV, starting with a header line.
+// Services are separated by spaces and absent fields left empty.
+type CSVExporter struct {
+	writer *csv.Writer
+	header bool
+}
+
+// NewCSVExporter returns an exporter writing to w
+func NewCSVExporter(w io.Writer) *CSVExporter {
+	return &CSVExporter{writer: csv.NewWriter(w)}
+}
+
+// Export implements ScanExporter
+func (e *CSVExporter) Export(r *ScanRecord) error {
+	if !e.header {
+		if err := e.writer.Write(csvColumns); err != nil {
+			return err
+		}
+		e.header = true
+	}
+
+	row := []string{
+		r.Time.UTC().Format(time.RFC3339Nano),
+		r.Address.Address.String(),
+		r.addressType(),
+		strconv.Itoa(int(r.RSSI)),
+		strconv.Itoa(int(r.PacketType)),
+		r.Name,
+		"", "",
+		strings.Join(r.Services, " "),
+		"", "", "",
+		r.Eddystone,
+		hex.EncodeToString(r.Data),
+	}
+	if r.TxPower != nil {
+		row[6] = strconv.Itoa(int(*r.TxPower))
+	}
+	if r.Company != nil {
+		row[7] = strconv.Itoa(int(*r.Company))
+	}
+	if r.IBeacon != nil {
+		row[9] = UUIDString(r.IBeacon.UUID)
+		row[10] = strconv.Itoa(int(r.IBeacon.Major))
+		row[11] = strconv.Itoa(int(r.IBeacon.Minor))
+	}
+	return e.writer.Write(row)
+}
+
+// Flush implements ScanExporter
+func (e *CSVExporter) Flush() error {
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+// InfluxExporter writes scan records in the InfluxDB line protocol, one
+// point per record tagged with the address and its type, with nanosecond
+// timestamps
+type InfluxExporter struct {
+	writer      io.Writer
+	measurement string
+}
+
+// NewInfluxExporter returns an exporter writing points of measurement to
+// w, "ble_scan" when empty. Buffer w if needed, writes are not batched.
+func NewInfluxExporter(w io.Writer, measurement string) *InfluxExporter {
+	if measurement == "" {
+		measurement = "ble_scan"
+	}
+	return &InfluxExporter{writer: w, measurement: measurement}
+}
+
+// influx escapers of the line protocol
+var (
+	influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	influxTagEscaper         = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+	influxStringEscaper      = strings.NewReplacer(`"`, `\"`, `\`, `\\`)
+)
+
+// Export implements ScanExporter
+func (e *InfluxExporter) Export(r *ScanRecord) error {
+	var b strings.Builder
+	b.WriteString(influxMeasurementEscaper.Replace(e.measurement))
+	fmt.Fprintf(&b, ",address=%s,address_type=%s", r.Address.Address, r.addressType())
+
+	fmt.Fprintf(&b, " rssi=%di,packet_type=%di", r.RSSI, r.PacketType)
+	if r.Name != "" {
+		fmt.Fprintf(&b, `,name="%s"`, influxStringEscaper.Replace(r.Name))
+	}
+	if r.TxPower != nil {
+		fmt.Fprintf(&b, ",tx_power=%di", *r.TxPower)
+	}
+	if r.Company != nil {
+		fmt.Fprintf(&b, ",company=%di", *r.Company)
+	}
+	if len(r.Services) > 0 {
+		fmt.Fprintf(&b, `,services="%s"`, strings.Join(r.Services, " "))
+	}
+	if r.IBeacon != nil {
+		fmt.Fprintf(&b, `,ibeacon_uuid="%s",ibeacon_major=%di,ibeacon_minor=%di`, UUIDString(r.IBeacon.UUID), r.IBeacon.Major, r.IBeacon.Minor)
+	}
+	if r.Eddystone != "" {
+		fmt.Fprintf(&b, `,eddystone="%s"`, r.Eddystone)
+	}
+	fmt.Fprintf(&b, `,data="%s" %d`+"\n", hex.EncodeToString(r.Data), r.Time.UnixNano())
+
+	_, err := io.WriteString(e.writer, b.String())
+	return err
+}
+
+// Flush implements ScanExporter
+func (e *InfluxExporter) Flush() error {
+	return nil
+}
+
+// ExportScan scan like Scan, exporting every scan response received until
+// ctx is done or a write fails. A handler already set in OnScanResponse
+// keeps being called. Returns nil once ctx is done.
+func (c *Central) ExportScan(ctx context.Context, mode byte, exporter ScanExporter) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var mutex sync.Mutex
+	var exportErr error
+	stopped := false
+	defer c.addScanHook(func(resp *GapScanRespone) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if !stopped && exportErr == nil {
+			if exportErr = exporter.Export(NewScanRecord(c.api.clock.Now(), resp)); exportErr != nil {
+				cancel()
+			}
+		}
+	})()
+
+	err := c.Scan(ctx, mode)
+	mutex.Lock()
+	defer mutex.Unlock()
+	stopped = true
+	if flushErr := exporter.Flush(); exportErr == nil {
+		exportErr = flushErr
+	}
+	if exportErr != nil {
+		return exportErr
+	}
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		return nil
+	}
+	return err
+}
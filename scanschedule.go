@@ -0,0 +1,149 @@
+package bgapi
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// ErrScanPreempted a scan window was ended by another GAP function, e.g. a
+// connection with ConflictEndActive
+var ErrScanPreempted = errors.New("scan window preempted")
+
+// ScanSchedule a duty cycle scanning for Window every Period, for gateways
+// saving power and air time. Windows start on a grid anchored at the first
+// one so late windows do not drift the schedule, each delayed by a random
+// jitter so gateways sharing a schedule do not scan in step.
+type ScanSchedule struct {
+	// Mode GAP discover mode, see GapDiscoverObservation
+	Mode byte
+	// Window time scanning, Period time between the start of two windows
+	Window time.Duration
+	Period time.Duration
+	// Jitter most a window is delayed after its slot, at most Period minus
+	// Window
+	Jitter time.Duration
+	// Backoff delay before retrying a window that failed, doubled on each
+	// consecutive failure up to Period; Window when zero
+	Backoff time.Duration
+	// OnWindow invoked after each window with the time it started and why
+	// it failed, may be nil
+	OnWindow func(start time.Time, err error)
+}
+
+// validate check the durations of the schedule
+func (s *ScanSchedule) validate() error {
+	switch {
+	case s.Window <= 0:
+		return errors.New("scan window must be positive")
+	case s.Period < s.Window:
+		return errors.New("scan period shorter than the window")
+	case s.Jitter < 0 || s.Jitter > s.Period-s.Window:
+		return errors.New("scan jitter must be between 0 and the period minus the window")
+	case s.Backoff < 0:
+		return errors.New("scan backoff must not be negative")
+	}
+	return nil
+}
+
+// ScanDutyCycle scan following schedule until ctx is done. A window that
+// fails to start or is preempted is retried after a backoff instead of
+// waiting for the next slot; the error of the context is returned.
+func (c *Central) ScanDutyCycle(ctx context.Context, schedule ScanSchedule) error {
+	if err := schedule.validate(); err != nil {
+		return err
+	}
+	clock := c.api.clock
+	initialBackoff := schedule.Backoff
+	if initialBackoff == 0 {
+		initialBackoff = schedule.Window
+	}
+
+	anchor := clock.Now()
+	start := anchor.Add(scanJitter(schedule.Jitter))
+	var backoff time.Duration
+	for {
+		if wait := start.Sub(clock.Now()); wait > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-clock.After(wait):
+			}
+		}
+
+		started := clock.Now()
+		err := c.scanWindow(ctx, schedule.Mode, schedule.Window)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if schedule.OnWindow != nil {
+			schedule.OnWindow(started, err)
+		}
+
+		now := clock.Now()
+		if err != nil {
+			if backoff == 0 {
+				backoff = initialBackoff
+			} else if backoff *= 2; backoff > schedule.Period {
+				backoff = schedule.Period
+			}
+			start = now.Add(backoff)
+			continue
+		}
+		backoff = 0
+
+		// the next slot of the grid, skipping those the window overran
+		slots := now.Sub(anchor)/schedule.Period + 1
+		start = anchor.Add(slots * schedule.Period).Add(scanJitter(schedule.Jitter))
+	}
+}
+
+// scanWindow scan for window, or until ctx is done
+func (c *Central) scanWindow(ctx context.Context, mode byte, window time.Duration) error {
+	if err := c.gapTake(gapFuncScanning); err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	idle := c.gapIdle
+	c.mutex.Unlock()
+
+	if err := c.discover(mode); err != nil {
+		c.gapGive(gapFuncScanning)
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-c.api.clock.After(window):
+	case <-idle:
+		return ErrScanPreempted
+	}
+	if err := c.gapGive(gapFuncScanning); err != nil {
+		return ErrScanPreempted
+	}
+	return c.endProcedure()
+}
+
+// discover start discovering and check the module accepted
+func (c *Central) discover(mode byte) error {
+	buf, err := c.api.call(6, 2, []byte{mode})
+	if err != nil {
+		return err
+	}
+	d := newDecoder(buf)
+	if result := d.u16(); d.err != nil {
+		return d.err
+	} else if result != 0 {
+		return &ProcedureError{Result: result}
+	}
+	return nil
+}
+
+// scanJitter returns a random delay up to jitter
+func scanJitter(jitter time.Duration) time.Duration {
+	if jitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(jitter) + 1))
+}
@@ -0,0 +1,142 @@
+package bgapi
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ScriptFailure a BGScript failure reported by the module
+type ScriptFailure struct {
+	Time time.Time
+	// Address of the script instruction that failed
+	Address uint16
+	// Reason the BGAPI result the instruction failed with, see ResultName
+	Reason uint16
+}
+
+func (f *ScriptFailure) Error() string {
+	return fmt.Sprintf("script failed at 0x%04x: %s", f.Address, ResultName(f.Reason))
+}
+
+// ScriptFailureAction what to do about a script failure, actions combine
+type ScriptFailureAction int
+
+const (
+	// ScriptDegrade mark the device degraded, see API.Degraded
+	ScriptDegrade ScriptFailureAction = 1 << iota
+	// ScriptDisable write the disable key of the policy, which the script
+	// must check at boot as the firmware has no way to skip it
+	ScriptDisable
+	// ScriptReset reset the module, after the disable key is written when
+	// both are requested
+	ScriptReset
+)
+
+// ScriptFailurePolicy recovery from script failures
+type ScriptFailurePolicy struct {
+	// Actions applied to every failure, unless Decide is set
+	Actions ScriptFailureAction
+	// Decide chooses the actions for a failure, may be nil
+	Decide func(f *ScriptFailure) ScriptFailureAction
+	// DisableKey user PS key written by ScriptDisable
+	DisableKey uint16
+	// DisableValue value written to DisableKey, defaults to a single 1
+	DisableValue []byte
+	// OnHandled invoked once the actions ran, with the first error; may be
+	// nil
+	OnHandled func(f *ScriptFailure, actions ScriptFailureAction, err error)
+}
+
+// SetScriptFailurePolicy apply policy to the script failures reported from
+// now on, nil only reports them to the delegate. The actions run on a
+// goroutine of their own, after the delegate's OnSystemScriptFailure.
+func (api *API) SetScriptFailurePolicy(policy *ScriptFailurePolicy) error {
+	if policy != nil && (policy.DisableKey != 0 || policy.Actions&ScriptDisable != 0) && !IsUserPSKey(policy.DisableKey) {
+		return fmt.Errorf("script disable key 0x%04x is not a user PS key", policy.DisableKey)
+	}
+
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	api.scriptPolicy = policy
+	return nil
+}
+
+// Degraded returns the script failure that marked the device degraded, nil
+// while it is healthy
+func (api *API) Degraded() *ScriptFailure {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	return api.degraded
+}
+
+// ClearDegraded mark the device healthy again, e.g. after its firmware was
+// updated
+func (api *API) ClearDegraded() {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	api.degraded = nil
+}
+
+// scriptFailed report a script failure to the delegate and apply the policy
+func (api *API) scriptFailed(addr uint16, reason uint16) {
+	api.delegate.OnSystemScriptFailure(addr, reason)
+
+	f := &ScriptFailure{Time: api.clock.Now(), Address: addr, Reason: reason}
+	api.historyError(f.Error())
+
+	api.mutex.Lock()
+	policy := api.scriptPolicy
+	api.mutex.Unlock()
+	if policy == nil {
+		return
+	}
+
+	actions := policy.Actions
+	if policy.Decide != nil {
+		actions = policy.Decide(f)
+	}
+	// the commands wait for responses handled by the receive loop
+	go api.recoverScript(policy, f, actions)
+}
+
+// recoverScript run the actions chosen for a script failure
+func (api *API) recoverScript(policy *ScriptFailurePolicy, f *ScriptFailure, actions ScriptFailureAction) {
+	var err error
+	if actions&ScriptDegrade != 0 {
+		api.mutex.Lock()
+		api.degraded = f
+		api.mutex.Unlock()
+	}
+	if actions&ScriptDisable != 0 {
+		value := policy.DisableValue
+		if value == nil {
+			value = []byte{1}
+		}
+		if !IsUserPSKey(policy.DisableKey) {
+			err = errors.New("script disable key is not a user PS key")
+		} else if perr := api.PSSave(policy.DisableKey, value); perr != nil {
+			err = fmt.Errorf("disabling the script: %w", perr)
+		}
+	}
+	if actions&ScriptReset != 0 {
+		written := make(chan struct{})
+		if rerr := api.SystemReset(false, func() { close(written) }); rerr != nil {
+			if err == nil {
+				err = fmt.Errorf("resetting: %w", rerr)
+			}
+		} else {
+			<-written
+		}
+	}
+
+	if err != nil {
+		api.historyError(err.Error())
+	}
+	if policy.OnHandled != nil {
+		policy.OnHandled(f, actions, err)
+	}
+}
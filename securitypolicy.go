@@ -0,0 +1,94 @@
+package bgapi
+
+import (
+	"errors"
+	"fmt"
+)
+
+// SecurityPolicy the security every connection must reach before it is
+// used, see Central.SecurityPolicy. Open secures the link before discovering
+// the peer: a bonded peer is encrypted with its bond, any other one is
+// paired. Links failing the policy are dropped.
+type SecurityPolicy struct {
+	// RequireBond the pairing must store a bond, encryption alone is not
+	// enough
+	RequireBond bool
+	// Pairing options pairing new peers, nil for unauthenticated pairing;
+	// with MITM set the keys must be authenticated
+	Pairing *PairingOptions
+	// OnRejected invoked with the connection dropped and the reason, may be
+	// nil
+	OnRejected func(conn *Connection, err error)
+}
+
+// ErrSecurityPolicy a connection failed the security policy
+var ErrSecurityPolicy = errors.New("connection rejected by the security policy")
+
+// secure bring the link to the security required by policy, dropping it on
+// failure
+func (c *Connection) secure(policy *SecurityPolicy) error {
+	err := c.establishSecurity(policy)
+	if err != nil {
+		err = fmt.Errorf("%w: %v", ErrSecurityPolicy, err)
+		c.audit(SecurityConnectionRefused, nil, err)
+		c.Close()
+		if policy.OnRejected != nil {
+			policy.OnRejected(c, err)
+		}
+		return err
+	}
+
+	c.central.mutex.Lock()
+	c.secured = true
+	c.central.mutex.Unlock()
+	return nil
+}
+
+// establishSecurity encrypt with the bond of the peer, or pair
+func (c *Connection) establishSecurity(policy *SecurityPolicy) error {
+	if c.status.Bonding != 0xff {
+		return c.encrypt()
+	}
+
+	bond, err := c.Pair(policy.Pairing)
+	if err != nil {
+		return err
+	}
+	if policy.RequireBond && bond.Bond == 0xff {
+		return errors.New("the bond was not stored")
+	}
+	if policy.Pairing != nil && policy.Pairing.MITM && !bond.MITM {
+		return errors.New("the keys are not authenticated")
+	}
+	return nil
+}
+
+// encrypt start encryption with the stored bond of the peer
+func (c *Connection) encrypt() error {
+	err := c.procMgr.perform(pairingTimeout, procedureEncrypt, func() {
+		c.procResult = 0
+		c.central.api.SmEncryptStart(c.status.Connection, 0, func(result uint16) {
+			if result != 0 {
+				c.procMgr.refuse(result)
+			}
+		})
+	})
+	if err == nil && c.procResult != 0 {
+		err = &ProcedureError{Result: c.procResult}
+	}
+	if err != nil {
+		c.audit(SecurityEncryptionFailed, nil, err)
+	} else {
+		c.audit(SecurityEncrypted, nil, nil)
+	}
+	return err
+}
+
+// delivers returns true when values received on the connection may reach
+// the application: no policy applies or the link satisfies it
+func (c *Connection) delivers() bool {
+	c.central.mutex.Lock()
+	defer c.central.mutex.Unlock()
+
+	return c.security == nil || c.secured
+}
@@ -0,0 +1,130 @@
+package bgapi
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// selfTestTimerHandle soft timer handle used by the self-test
+	selfTestTimerHandle = 0xfe
+	// selfTestTimerTicks 100ms of the 32.768kHz soft timer clock
+	selfTestTimerTicks = 3277
+	selfTestTimerDelay = 100 * time.Millisecond
+)
+
+// SelfTestResult outcome of a self-test check
+type SelfTestResult struct {
+	Name    string
+	Err     error
+	Elapsed time.Duration
+}
+
+// Passed returns true when the check succeeded
+func (r *SelfTestResult) Passed() bool {
+	return r.Err == nil
+}
+
+// SelfTest run quick checks of a device, e.g. to validate incoming
+// hardware: hello, address get, whitelist clear and a soft timer round
+// trip. Every check runs, even after a failure.
+func (api *API) SelfTest() []SelfTestResult {
+	checks := []struct {
+		name string
+		run  func() error
+	}{
+		{"hello", api.selfTestHello},
+		{"address get", api.selfTestAddress},
+		{"whitelist clear", api.selfTestWhitelist},
+		{"soft timer", api.selfTestTimer},
+	}
+
+	results := make([]SelfTestResult, 0, len(checks))
+	for _, check := range checks {
+		start := time.Now()
+		err := check.run()
+		results = append(results, SelfTestResult{Name: check.name, Err: err, Elapsed: time.Since(start)})
+	}
+	return results
+}
+
+func (api *API) selfTestHello() error {
+	_, err := api.call(0, 1, nil)
+	return err
+}
+
+func (api *API) selfTestAddress() error {
+	buf, err := api.call(0, 2, nil)
+	if err != nil {
+		return err
+	}
+	d := newDecoder(buf)
+	mac := d.mac()
+	if d.err != nil {
+		return d.err
+	}
+	if mac == (Mac{}) || mac == (Mac{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}) {
+		return fmt.Errorf("device reports address %s", mac)
+	}
+	return nil
+}
+
+func (api *API) selfTestWhitelist() error {
+	_, err := api.call(0, 12, nil)
+	return err
+}
+
+// selfTestTimer start a single shot soft timer and wait for it to fire
+func (api *API) selfTestTimer() error {
+	firedC := make(chan struct{}, 1)
+	api.mutex.Lock()
+	api.softTimerC = firedC
+	api.mutex.Unlock()
+	defer func() {
+		api.mutex.Lock()
+		api.softTimerC = nil
+		api.mutex.Unlock()
+	}()
+
+	start := time.Now()
+	data := (&encoder{}).write(uint32(selfTestTimerTicks)).write(byte(selfTestTimerHandle)).write(byte(1)).bytes()
+	buf, err := api.call(7, 1, data)
+	if err != nil {
+		return err
+	}
+	d := newDecoder(buf)
+	if result := d.u16(); d.err != nil {
+		return d.err
+	} else if result != 0 {
+		return fmt.Errorf("setting the timer failed with result 0x%04x", result)
+	}
+
+	select {
+	case <-firedC:
+	case <-time.After(10 * selfTestTimerDelay):
+		return errors.New("the soft timer did not fire")
+	}
+	if elapsed := time.Since(start); elapsed < selfTestTimerDelay/2 {
+		return fmt.Errorf("the soft timer fired after %v, expected %v", elapsed, selfTestTimerDelay)
+	}
+	return nil
+}
+
+// softTimer signal the self-test that its timer fired
+func (api *API) softTimer(handle byte) {
+	if handle != selfTestTimerHandle {
+		return
+	}
+
+	api.mutex.Lock()
+	firedC := api.softTimerC
+	api.mutex.Unlock()
+
+	if firedC != nil {
+		select {
+		case firedC <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package bgapi
+
+import (
+	"time"
+
+	"github.com/tarm/serial"
+)
+
+const (
+	// default BLED112 serial settings
+	defaultBaud     = 115200
+	defaultDataBits = 8
+)
+
+// Parity serial parity setting
+type Parity byte
+
+const (
+	// ParityNone no parity bit
+	ParityNone Parity = 'N'
+	// ParityOdd odd parity
+	ParityOdd Parity = 'O'
+	// ParityEven even parity
+	ParityEven Parity = 'E'
+)
+
+// StopBits serial stop bit setting
+type StopBits byte
+
+const (
+	// StopBits1 one stop bit
+	StopBits1 StopBits = 1
+	// StopBits2 two stop bits
+	StopBits2 StopBits = 2
+)
+
+// SerialOptions serial port settings. The zero value of each field selects
+// the BLED112 default of 115200-8-N-1 without flow control.
+type SerialOptions struct {
+	TransportOptions
+
+	// Baud baud rate
+	Baud int
+	// DataBits number of data bits
+	DataBits byte
+	// Parity parity setting
+	Parity Parity
+	// StopBits number of stop bits
+	StopBits StopBits
+	// HardwareFlowControl enable RTS/CTS flow control, needed by most
+	// modules wired over a UART
+	HardwareFlowControl bool
+	// ReadTimeout bound on each read from the port, zero blocks
+	ReadTimeout time.Duration
+}
+
+func (opts *SerialOptions) config(port string) *serial.Config {
+	cfg := serial.Config{Name: port, Baud: defaultBaud, Size: defaultDataBits}
+	if opts == nil {
+		return &cfg
+	}
+
+	if opts.Baud != 0 {
+		cfg.Baud = opts.Baud
+	}
+	if opts.DataBits != 0 {
+		cfg.Size = opts.DataBits
+	}
+	if opts.Parity != 0 {
+		cfg.Parity = serial.Parity(opts.Parity)
+	}
+	if opts.StopBits != 0 {
+		cfg.StopBits = serial.StopBits(opts.StopBits)
+	}
+	cfg.ReadTimeout = opts.ReadTimeout
+
+	return &cfg
+}
+
+// OpenSerial open the connection to a BGAPI device on a serial port,
+// opts may be nil to use the BLED112 defaults
+func (api *API) OpenSerial(port string, opts *SerialOptions) error {
+	ser, err := serial.OpenPort(opts.config(port))
+	if err != nil {
+		return err
+	}
+
+	if opts != nil && opts.HardwareFlowControl {
+		if err = setHardwareFlowControl(port); err != nil {
+			ser.Close()
+			return err
+		}
+	}
+
+	var topts *TransportOptions
+	if opts != nil {
+		topts = &opts.TransportOptions
+		// the serial package reports an expired read timeout as io.EOF
+		api.eofIsTimeout = opts.ReadTimeout > 0
+	}
+	return api.OpenTransport(ser, topts)
+}
@@ -0,0 +1,58 @@
+//go:build linux
+
+package bgapi
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// setHardwareFlowControl enable RTS/CTS on an open port. The serial package
+// does not expose flow control, but termios settings belong to the device
+// rather than the file descriptor so they can be applied through a second
+// descriptor.
+func setHardwareFlowControl(port string) error {
+	f, err := os.OpenFile(port, unix.O_RDWR|unix.O_NOCTTY|unix.O_NONBLOCK, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fd := int(f.Fd())
+	t, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return err
+	}
+	t.Cflag |= unix.CRTSCTS
+	return unix.IoctlSetTermios(fd, unix.TCSETS, t)
+}
+
+// usbID returns the USB vendor and product of the device behind a tty
+func usbID(port string) (uint16, uint16, error) {
+	dev, err := filepath.EvalSymlinks(port)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// the tty links to its USB interface, the device attributes are one up
+	dir := filepath.Join("/sys/class/tty", filepath.Base(dev), "device", "..")
+	vid, err := readHexAttr(filepath.Join(dir, "idVendor"))
+	if err != nil {
+		return 0, 0, err
+	}
+	pid, err := readHexAttr(filepath.Join(dir, "idProduct"))
+	return vid, pid, err
+}
+
+func readHexAttr(path string) (uint16, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseUint(strings.TrimSpace(string(b)), 16, 16)
+	return uint16(v), err
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package bgapi
+
+import (
+	"errors"
+)
+
+func setHardwareFlowControl(port string) error {
+	return errors.New("hardware flow control is only supported on linux")
+}
+
+func usbID(port string) (uint16, uint16, error) {
+	return 0, 0, errors.New("USB identification is only supported on linux")
+}
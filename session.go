@@ -0,0 +1,262 @@
+package bgapi
+
+import (
+	"encoding/json"
+	"html/template"
+	"io"
+	"sync"
+	"time"
+)
+
+// SessionEntryKind the type of an entry of a recorded session
+type SessionEntryKind string
+
+// session entry kinds
+const (
+	SessionCommand    SessionEntryKind = "command"
+	SessionResponse   SessionEntryKind = "response"
+	SessionEvent      SessionEntryKind = "event"
+	SessionTimeout    SessionEntryKind = "timeout"
+	SessionAnnotation SessionEntryKind = "annotation"
+)
+
+// SessionEntry a frame or an annotation of a recorded session, tagged for
+// JSON
+type SessionEntry struct {
+	Time time.Time        `json:"time"`
+	Kind SessionEntryKind `json:"kind"`
+	// Name the BGAPI name of a frame
+	Name    string   `json:"name,omitempty"`
+	Class   byte     `json:"class"`
+	Command byte     `json:"command"`
+	Payload HexBytes `json:"payload,omitempty"`
+	// Elapsed for responses and timeouts, the time since the command was
+	// written
+	Elapsed Duration `json:"elapsed,omitempty"`
+	// Text of an annotation
+	Text string `json:"text,omitempty"`
+}
+
+// Session a recorded debugging session: the traffic with the device
+// interleaved with the annotations of the application, to share with
+// other developers or the hardware vendor
+type Session struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// Firmware the firmware version of the module, when known
+	Firmware string `json:"firmware,omitempty"`
+	// Device the fingerprint ID of the module, when read, see
+	// API.Fingerprint
+	Device  string         `json:"device,omitempty"`
+	Entries []SessionEntry `json:"entries"`
+	// Truncated entries dropped beyond the limit of the recorder
+	Truncated int `json:"truncated,omitempty"`
+}
+
+// SessionRecorder records the traffic of an API and its annotations until
+// stopped. It is attached as the trace hook of the API, the hook attached
+// before keeps being called.
+type SessionRecorder struct {
+	api   *API
+	next  TraceHook
+	limit int
+
+	mutex   sync.Mutex
+	session Session
+	stopped bool
+}
+
+// StartRecording record the session from now on, up to limit entries (no
+// limit when zero); see Annotate
+func (api *API) StartRecording(limit int) *SessionRecorder {
+	r := &SessionRecorder{api: api, limit: limit}
+	r.session.Start = api.clock.Now()
+
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	r.next = api.traceHook
+	api.traceHook = r
+	api.recorder = r
+	return r
+}
+
+// Annotate add a note to the session being recorded, e.g. "pressed the
+// button" or "peripheral rebooted", so the traffic can be read against what
+// happened; ignored when no session is recorded
+func (api *API) Annotate(text string) {
+	api.mutex.Lock()
+	r := api.recorder
+	api.mutex.Unlock()
+
+	if r != nil {
+		r.record(SessionEntry{Time: api.clock.Now(), Kind: SessionAnnotation, Text: text})
+	}
+}
+
+// Stop stop recording, restoring the trace hook attached before, and
+// returns the session
+func (r *SessionRecorder) Stop() *Session {
+	api := r.api
+	api.mutex.Lock()
+	if api.traceHook == r {
+		api.traceHook = r.next
+	}
+	if api.recorder == r {
+		api.recorder = nil
+	}
+	api.mutex.Unlock()
+
+	firmware, known := api.FirmwareVersion()
+	fingerprint := api.CachedFingerprint()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.stopped = true
+	session := r.session
+	session.End = api.clock.Now()
+	if known {
+		session.Firmware = firmware.String()
+	}
+	if fingerprint != nil {
+		session.Device = fingerprint.ID
+	}
+	session.Entries = append([]SessionEntry(nil), r.session.Entries...)
+	return &session
+}
+
+// record append an entry unless stopped or full
+func (r *SessionRecorder) record(e SessionEntry) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.stopped {
+		return
+	}
+	if r.limit > 0 && len(r.session.Entries) >= r.limit {
+		r.session.Truncated++
+		return
+	}
+	r.session.Entries = append(r.session.Entries, e)
+}
+
+// recordFrame append a traced frame
+func (r *SessionRecorder) recordFrame(kind SessionEntryKind, f *TraceFrame) {
+	e := SessionEntry{
+		Time:    f.Time,
+		Kind:    kind,
+		Name:    f.Name(),
+		Class:   f.Class,
+		Command: f.Command,
+		Payload: append(HexBytes(nil), f.Payload...),
+	}
+	if kind != SessionCommand {
+		e.Elapsed = Duration(f.Elapsed)
+	}
+	r.record(e)
+}
+
+// OnSubmit implements TraceHook, commands are recorded when transmitted
+func (r *SessionRecorder) OnSubmit(f *TraceFrame) {
+	if r.next != nil {
+		r.next.OnSubmit(f)
+	}
+}
+
+// OnTransmit implements TraceHook
+func (r *SessionRecorder) OnTransmit(f *TraceFrame) {
+	r.recordFrame(SessionCommand, f)
+	if r.next != nil {
+		r.next.OnTransmit(f)
+	}
+}
+
+// OnResponse implements TraceHook
+func (r *SessionRecorder) OnResponse(f *TraceFrame) {
+	r.recordFrame(SessionResponse, f)
+	if r.next != nil {
+		r.next.OnResponse(f)
+	}
+}
+
+// OnTimeout implements TraceHook
+func (r *SessionRecorder) OnTimeout(f *TraceFrame) {
+	r.recordFrame(SessionTimeout, f)
+	if r.next != nil {
+		r.next.OnTimeout(f)
+	}
+}
+
+// OnEvent implements TraceHook
+func (r *SessionRecorder) OnEvent(f *TraceFrame) {
+	r.recordFrame(SessionEvent, f)
+	if r.next != nil {
+		r.next.OnEvent(f)
+	}
+}
+
+// WriteJSON write the session as indented JSON
+func (s *Session) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
+
+// ReadSession read a session written by WriteJSON
+func ReadSession(r io.Reader) (*Session, error) {
+	var s Session
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// sessionTemplate a self-contained page, readable without network access
+var sessionTemplate = template.Must(template.New("session").Funcs(template.FuncMap{
+	"offset": func(start time.Time, t time.Time) string {
+		return t.Sub(start).Truncate(time.Microsecond).String()
+	},
+	"elapsed": func(d Duration) string {
+		if d == 0 {
+			return ""
+		}
+		return time.Duration(d).Truncate(time.Microsecond).String()
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>BGAPI session {{.Start.Format "2006-01-02 15:04:05"}}</title>
+<style>
+body { font-family: sans-serif; font-size: 13px; }
+table { border-collapse: collapse; }
+td, th { padding: 2px 8px; text-align: left; vertical-align: top; }
+td.payload { font-family: monospace; word-break: break-all; }
+tr.command { color: #0645ad; }
+tr.response { color: #333; }
+tr.event { color: #067d17; }
+tr.timeout { color: #b00; font-weight: bold; }
+tr.annotation { background: #fff3bf; font-style: italic; }
+</style>
+</head>
+<body>
+<h1>BGAPI session</h1>
+<p>{{.Start.Format "2006-01-02 15:04:05.000 MST"}} to {{.End.Format "2006-01-02 15:04:05.000 MST"}}{{if .Firmware}}, firmware {{.Firmware}}{{end}}{{if .Device}}, device {{.Device}}{{end}}, {{len .Entries}} entries{{if .Truncated}} ({{.Truncated}} more dropped){{end}}</p>
+<table>
+<tr><th>time</th><th>kind</th><th>name</th><th>payload</th><th>elapsed</th></tr>
+{{- $start := .Start}}
+{{- range .Entries}}
+<tr class="{{.Kind}}"><td>+{{offset $start .Time}}</td><td>{{.Kind}}</td>
+{{- if eq .Kind "annotation"}}<td colspan="3">{{.Text}}</td>
+{{- else}}<td>{{.Name}}</td><td class="payload">{{printf "%x" .Payload}}</td><td>{{elapsed .Elapsed}}</td>{{end}}</tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+// WriteHTML write the session as a standalone HTML page
+func (s *Session) WriteHTML(w io.Writer) error {
+	return sessionTemplate.Execute(w, s)
+}
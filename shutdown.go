@@ -0,0 +1,182 @@
+package bgapi
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"runtime"
+	"strconv"
+)
+
+// ErrClosed the API was shut down before the command could be sent
+var ErrClosed = errors.New("API closed")
+
+// ErrReceiveGoroutine Shutdown was called on the receive goroutine, e.g.
+// from a Delegate method, where it would wait for responses only that
+// goroutine can deliver
+var ErrReceiveGoroutine = errors.New("API shut down on the receive goroutine")
+
+// ShutdownOptions tune Shutdown
+type ShutdownOptions struct {
+	// CancelInFlight complete the command awaiting its response with
+	// ErrClosed rather than waiting for it (at most its timeout)
+	CancelInFlight bool
+	// Disconnect close the connections reported open by the device
+	Disconnect bool
+}
+
+// Close shut the API down, waiting for the command in flight; see Shutdown
+// for the goroutines it cannot be called on
+func (api *API) Close() error {
+	return api.Shutdown(nil)
+}
+
+// Done returns a channel closed once the API shut down, by Close or after a
+// fatal transport error (see Err)
+func (api *API) Done() <-chan struct{} {
+	return api.done
+}
+
+// Shutdown stop scanning and advertising, optionally disconnect, then stop
+// accepting commands, complete the queued ones with ErrClosed and close the
+// transport. opts may be nil.
+//
+// Shutdown waits for the device to answer, so it returns
+// ErrReceiveGoroutine when called on the receive goroutine: from the
+// Delegate methods with DispatchInline, TransportOptions.OnError or the
+// handlers documented to run there. Call it from another goroutine
+// instead, e.g. go api.Close().
+func (api *API) Shutdown(opts *ShutdownOptions) error {
+	if opts == nil {
+		opts = &ShutdownOptions{}
+	}
+
+	api.enqueueMutex.RLock()
+	closing, rxGoroutine := api.closing, api.rxGoroutine
+	api.enqueueMutex.RUnlock()
+	if closing {
+		return ErrClosed
+	}
+	if rxGoroutine != 0 && rxGoroutine == goroutineID() {
+		return ErrReceiveGoroutine
+	}
+
+	if api.transport() != nil {
+		// leave the radio idle, failures are not worth aborting the shutdown
+		api.call(6, 4, []byte{})     // gap_end_procedure
+		api.call(6, 1, []byte{0, 0}) // gap_set_mode, non-discoverable and non-connectable
+		if opts.Disconnect {
+			for _, status := range api.State().OpenConnections {
+				api.call(3, 0, []byte{status.Connection}) // connection_disconnect
+			}
+		}
+	}
+
+	return api.stop(opts.CancelInFlight)
+}
+
+// stop stop accepting commands, drain the queue and close the transport
+func (api *API) stop(cancelInFlight bool) error {
+	// wait for the commands being enqueued, no more are accepted afterwards
+	api.enqueueMutex.Lock()
+	if api.closing {
+		api.enqueueMutex.Unlock()
+		return ErrClosed
+	}
+	api.closing = true
+	api.enqueueMutex.Unlock()
+
+	if cancelInFlight {
+		api.mutex.Lock()
+		op := api.pendingOp
+		api.pendingOp = nil
+		api.mutex.Unlock()
+
+		api.failPendingOp(op, ErrClosed)
+	}
+
+	close(api.done)
+	if api.transport() == nil {
+		api.drainQueue()
+		return nil
+	}
+
+	<-api.txDone
+	return api.closeTransport()
+}
+
+// failPendingOp complete op, taken from pendingOp, with err as its
+// response will not come; op may be nil
+func (api *API) failPendingOp(op *operation, err error) {
+	if op == nil {
+		return
+	}
+	op.completion(nil, err)
+	// release the transmit loop waiting for the response
+	select {
+	case api.rxReplyC <- nil:
+	default:
+	}
+}
+
+// enqueue queue a command for transmission
+func (api *API) enqueue(op *operation) error {
+	if api.framer.packetMode && len(op.txData) > maxPacketFrame {
+		return fmt.Errorf("%s of %d bytes exceeds the %d byte frame of packet mode", CommandName(op.class, op.cmd), len(op.txData), maxPacketFrame)
+	}
+
+	// trace first, the hook must see the command before it is transmitted
+	// and may issue commands itself
+	api.mutex.Lock()
+	op.queued = api.clock.Now()
+	op.priority = api.highPriority[uint16(op.class)<<8|uint16(op.cmd)]
+	api.mutex.Unlock()
+
+	if hook := api.tracer(); hook != nil {
+		hook.OnSubmit(traceCommand(op, op.queued))
+	}
+	if !op.priority {
+		api.throttle(op)
+	}
+
+	api.enqueueMutex.RLock()
+	defer api.enqueueMutex.RUnlock()
+
+	if api.closing {
+		return ErrClosed
+	}
+	if op.priority {
+		api.txHighC <- op
+	} else {
+		api.txC <- op
+	}
+	return nil
+}
+
+// drainQueue complete the queued commands with ErrClosed
+func (api *API) drainQueue() {
+	for {
+		select {
+		case op := <-api.txHighC:
+			op.completion(nil, ErrClosed)
+		case op := <-api.txC:
+			op.completion(nil, ErrClosed)
+		default:
+			return
+		}
+	}
+}
+
+// goroutineID returns the identifier of the calling goroutine, as printed
+// at the top of its stack trace
+func goroutineID() uint64 {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	// goroutine 42 [running]:
+	buf = bytes.TrimPrefix(buf, []byte("goroutine "))
+	if i := bytes.IndexByte(buf, ' '); i > 0 {
+		buf = buf[:i]
+	}
+	id, _ := strconv.ParseUint(string(buf), 10, 64)
+	return id
+}
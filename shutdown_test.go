@@ -0,0 +1,51 @@
+package bgapi_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	bgapi "github.com/jsakwa/go_bgapi"
+	"github.com/jsakwa/go_bgapi/bgapitest"
+)
+
+// closingDelegate closes the API when the device boots
+type closingDelegate struct {
+	bgapi.LoggingDelegate
+	api  *bgapi.API
+	errC chan error
+}
+
+func (d *closingDelegate) OnSystemBoot(info *bgapi.SystemInfo) {
+	d.errC <- d.api.Close()
+}
+
+func TestShutdownFromDelegate(t *testing.T) {
+	delegate := &closingDelegate{errC: make(chan error, 1)}
+	api := bgapi.NewAPI(delegate)
+	delegate.api = api
+	module := bgapitest.NewModule()
+	api.OpenTransport(module, nil)
+
+	boot := bgapitest.SystemBoot(bgapi.SystemInfo{Major: 1, Minor: 3})
+	module.Event(boot.Class, boot.Event, boot.Payload)
+	select {
+	case err := <-delegate.errC:
+		if !errors.Is(err, bgapi.ErrReceiveGoroutine) {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close blocked the receive goroutine")
+	}
+
+	closed := make(chan error, 1)
+	go func() { closed <- api.Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close blocked")
+	}
+}
@@ -0,0 +1,43 @@
+// Package kafka writes the device events of a central to a Kafka topic
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+
+	bgapi "github.com/jsakwa/go_bgapi"
+	kafkago "github.com/segmentio/kafka-go"
+)
+
+// Sink a bgapi.EventSink writing each event as JSON, keyed by the address
+// of the peripheral so the events of a device stay in order within their
+// partition
+type Sink struct {
+	writer *kafkago.Writer
+}
+
+// NewSink returns a sink writing through writer, which sets the brokers
+// and topic. Set writer.Async as Publish otherwise waits for the brokers
+// while the API's receive goroutine is blocked; errors are then reported
+// to writer.Completion.
+func NewSink(writer *kafkago.Writer) *Sink {
+	return &Sink{writer: writer}
+}
+
+// Publish implements bgapi.EventSink
+func (s *Sink) Publish(event *bgapi.DeviceEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return s.writer.WriteMessages(context.Background(), kafkago.Message{
+		Key:   []byte(event.Address),
+		Value: data,
+		Time:  event.Time,
+	})
+}
+
+// Close flush the pending messages and close the writer
+func (s *Sink) Close() error {
+	return s.writer.Close()
+}
@@ -0,0 +1,475 @@
+// Package mqtt publishes the device events of a central to an MQTT broker.
+// It speaks just enough of MQTT 3.1.1 to publish at QoS 0, so gateways do
+// not need a full client library.
+package mqtt
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	bgapi "github.com/jsakwa/go_bgapi"
+)
+
+// defaults of Options
+const (
+	defaultPrefix       = "bgapi"
+	defaultKeepAlive    = 30 * time.Second
+	defaultQueueSize    = 1024
+	defaultDialTimeout  = 10 * time.Second
+	defaultRetryDelay   = time.Second
+	defaultMaxRetryWait = time.Minute
+)
+
+// MQTT control packet types, shifted into the first byte of the header
+const (
+	packetConnect    = 0x10
+	packetConnAck    = 0x20
+	packetPublish    = 0x30
+	packetPingReq    = 0xc0
+	packetPingResp   = 0xd0
+	packetDisconnect = 0xe0
+)
+
+var (
+	// ErrClosed the sink was closed or gave up reconnecting
+	ErrClosed = errors.New("mqtt sink closed")
+	// ErrQueueFull too many messages wait to be sent
+	ErrQueueFull = errors.New("mqtt queue full")
+)
+
+// connAckReasons the return codes of a refused connection
+var connAckReasons = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "identifier rejected",
+	3: "server unavailable",
+	4: "bad user name or password",
+	5: "not authorized",
+}
+
+// Options configure a Sink, zero fields take the defaults
+type Options struct {
+	// Broker address of the broker, e.g. "localhost:1883"
+	Broker string
+	// Dial opens the connection to the broker instead of a plain TCP
+	// connection to Broker, e.g. for TLS
+	Dial func() (net.Conn, error)
+	// ClientID identifier of the client, the broker assigns one when empty
+	ClientID string
+	// Username and Password sent when Username is set
+	Username string
+	Password string
+	// Prefix of the topics, defaults to "bgapi"
+	Prefix string
+	// Retain ask the broker to keep the last message of each topic for new
+	// subscribers
+	Retain bool
+	// KeepAlive longest the connection stays silent, defaults to 30s
+	KeepAlive time.Duration
+	// QueueSize messages waiting to be sent before new ones are dropped,
+	// defaults to 1024
+	QueueSize int
+	// Reconnect how the connection to the broker is retried, the zero
+	// policy retries forever a second apart, backing off up to a minute
+	Reconnect bgapi.ReconnectPolicy
+	// OnError notified of the failures of the connection, may be nil
+	OnError func(err error)
+}
+
+// message a message waiting to be published
+type message struct {
+	topic   string
+	payload []byte
+}
+
+// Sink a bgapi.EventSink publishing each event as JSON to the topic made of
+// the prefix, the address of the peripheral and the event type, e.g.
+// "bgapi/00:07:80:aa:bb:cc/value_changed". Messages are queued and sent by
+// a goroutine keeping the connection to the broker, reconnecting as the
+// policy allows; messages queued while disconnected wait for the next
+// connection.
+type Sink struct {
+	opts   Options
+	queue  chan message
+	done   chan struct{}
+	closed chan struct{}
+
+	mutex   sync.Mutex
+	stopped bool
+	dropped int
+	err     error // why closing lost messages or failed to disconnect
+}
+
+// NewSink returns a sink connecting to the broker of opts in the
+// background
+func NewSink(opts Options) (*Sink, error) {
+	if opts.Broker == "" && opts.Dial == nil {
+		return nil, errors.New("mqtt sink without broker")
+	}
+	if opts.Prefix == "" {
+		opts.Prefix = defaultPrefix
+	}
+	if opts.KeepAlive <= 0 {
+		opts.KeepAlive = defaultKeepAlive
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = defaultQueueSize
+	}
+	if opts.Reconnect == (bgapi.ReconnectPolicy{}) {
+		opts.Reconnect = bgapi.ReconnectPolicy{
+			Attempts: -1,
+			Delay:    bgapi.Duration(defaultRetryDelay),
+			MaxDelay: bgapi.Duration(defaultMaxRetryWait),
+		}
+	}
+
+	s := &Sink{
+		opts:   opts,
+		queue:  make(chan message, opts.QueueSize),
+		done:   make(chan struct{}),
+		closed: make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+// Publish implements bgapi.EventSink, queueing an event
+func (s *Sink) Publish(event *bgapi.DeviceEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return s.PublishMessage(s.Topic(event), data)
+}
+
+// PublishMessage queue a message for an arbitrary topic, e.g. decoded
+// sensor readings; it is dropped when the queue is full or the sink closed
+func (s *Sink) PublishMessage(topic string, payload []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.stopped {
+		s.dropped++
+		return ErrClosed
+	}
+	select {
+	case s.queue <- message{topic: topic, payload: payload}:
+		return nil
+	default:
+		s.dropped++
+		return ErrQueueFull
+	}
+}
+
+// Topic returns the topic of an event
+func (s *Sink) Topic(event *bgapi.DeviceEvent) string {
+	return s.opts.Prefix + "/" + event.Address + "/" + string(event.Type)
+}
+
+// Prefix returns the prefix of the topics
+func (s *Sink) Prefix() string {
+	return s.opts.Prefix
+}
+
+// Dropped returns the number of messages dropped so far
+func (s *Sink) Dropped() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.dropped
+}
+
+// Close send the queued messages, over a last connection when the sink
+// is waiting to reconnect, disconnect and stop. Returns why messages could
+// not be sent, those being counted as dropped, or why disconnecting
+// failed; or the error of ctx if it is done first, the sink then closing
+// in the background.
+func (s *Sink) Close(ctx context.Context) error {
+	if !s.stop() {
+		return ErrClosed
+	}
+	close(s.done)
+	select {
+	case <-s.closed:
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		return s.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// stop stop queueing messages, returns false when already stopped
+func (s *Sink) stop() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.stopped {
+		return false
+	}
+	s.stopped = true
+	return true
+}
+
+// run keep a connection to the broker and send the queued messages over it
+func (s *Sink) run() {
+	defer close(s.closed)
+
+	attempt := 0
+	for {
+		connected, err := s.session()
+		if err == nil {
+			return
+		}
+		s.fail(err)
+		if connected {
+			attempt = 0
+		}
+
+		select {
+		case <-s.done:
+			s.flush(err)
+			return
+		default:
+		}
+		delay, ok := s.opts.Reconnect.Backoff(attempt)
+		attempt++
+		if !ok {
+			s.fail(fmt.Errorf("giving up reconnecting: %w", err))
+			s.stop()
+			s.discard(err)
+			return
+		}
+		select {
+		case <-s.done:
+			s.flush(err)
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// session connect to the broker and serve the connection, connected
+// reports whether the broker accepted it
+func (s *Sink) session() (connected bool, err error) {
+	conn, err := s.connect()
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	return true, s.serve(conn)
+}
+
+// flush send the messages queued while disconnected over a last
+// connection as the sink closes, err being the last failure
+func (s *Sink) flush(err error) {
+	if len(s.queue) > 0 {
+		if _, err = s.session(); err != nil {
+			s.fail(err)
+		}
+	}
+	s.discard(err)
+}
+
+// discard drop the messages left unsent, err being why
+func (s *Sink) discard(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.queue) == 0 {
+		return
+	}
+	for len(s.queue) > 0 {
+		<-s.queue
+		s.dropped++
+	}
+	s.err = err
+}
+
+// connect open a connection to the broker and wait for it to be accepted
+func (s *Sink) connect() (net.Conn, error) {
+	var conn net.Conn
+	var err error
+	if s.opts.Dial != nil {
+		conn, err = s.opts.Dial()
+	} else {
+		conn, err = net.DialTimeout("tcp", s.opts.Broker, defaultDialTimeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Now().Add(defaultDialTimeout))
+	if _, err := conn.Write(s.connectPacket()); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	var ack [4]byte
+	if _, err := io.ReadFull(conn, ack[:]); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if ack[0] != packetConnAck || ack[1] != 2 {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected packet 0x%02x connecting", ack[0])
+	}
+	if code := ack[3]; code != 0 {
+		conn.Close()
+		if reason, ok := connAckReasons[code]; ok {
+			return nil, fmt.Errorf("connection refused: %s", reason)
+		}
+		return nil, fmt.Errorf("connection refused: code %d", code)
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// serve send the queued messages and keep the connection alive, returns
+// nil once the sink is closed and the queue drained
+func (s *Sink) serve(conn net.Conn) error {
+	readErr := make(chan error, 1)
+	go func() { readErr <- s.readLoop(conn) }()
+
+	ticker := time.NewTicker(s.opts.KeepAlive / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case m := <-s.queue:
+			if _, err := s.write(conn, s.publishPacket(m)); err != nil {
+				return err
+			}
+		case <-ticker.C:
+			if _, err := s.write(conn, []byte{packetPingReq, 0}); err != nil {
+				return err
+			}
+		case err := <-readErr:
+			return err
+		case <-s.done:
+			// Publish no longer queues, send what is left
+			for len(s.queue) > 0 {
+				if _, err := s.write(conn, s.publishPacket(<-s.queue)); err != nil {
+					return err
+				}
+			}
+			if _, err := s.write(conn, []byte{packetDisconnect, 0}); err != nil {
+				s.mutex.Lock()
+				s.err = fmt.Errorf("disconnecting: %w", err)
+				s.mutex.Unlock()
+			}
+			return nil
+		}
+	}
+}
+
+// write send a packet, failing when the broker does not take it within
+// the keep alive period
+func (s *Sink) write(conn net.Conn, p []byte) (int, error) {
+	conn.SetWriteDeadline(time.Now().Add(s.opts.KeepAlive))
+	return conn.Write(p)
+}
+
+// readLoop read the packets of the broker, only ping responses are
+// expected; fails once the broker is silent for more than the keep alive
+// period, as a ping is sent every half period
+func (s *Sink) readLoop(conn net.Conn) error {
+	var header [1]byte
+	for {
+		conn.SetReadDeadline(time.Now().Add(s.opts.KeepAlive * 3 / 2))
+		if _, err := io.ReadFull(conn, header[:]); err != nil {
+			return err
+		}
+		length, err := readLength(conn)
+		if err != nil {
+			return err
+		}
+		if _, err := io.CopyN(io.Discard, conn, int64(length)); err != nil {
+			return err
+		}
+		if header[0]&0xf0 != packetPingResp {
+			return fmt.Errorf("unexpected packet 0x%02x", header[0])
+		}
+	}
+}
+
+// fail report a failure of the connection
+func (s *Sink) fail(err error) {
+	if s.opts.OnError != nil {
+		s.opts.OnError(err)
+	}
+}
+
+// connectPacket returns the CONNECT packet, starting a clean session
+func (s *Sink) connectPacket() []byte {
+	flags := byte(0x02) // clean session
+	body := appendString(nil, "MQTT")
+	body = append(body, 4) // protocol level 3.1.1
+	if s.opts.Username != "" {
+		flags |= 0xc0 // user name and password
+	}
+	body = append(body, flags)
+	body = binary.BigEndian.AppendUint16(body, uint16(s.opts.KeepAlive/time.Second))
+	body = appendString(body, s.opts.ClientID)
+	if s.opts.Username != "" {
+		body = appendString(body, s.opts.Username)
+		body = appendString(body, s.opts.Password)
+	}
+	return packet(packetConnect, body)
+}
+
+// publishPacket returns the PUBLISH packet of a message, at QoS 0
+func (s *Sink) publishPacket(m message) []byte {
+	header := byte(packetPublish)
+	if s.opts.Retain {
+		header |= 0x01
+	}
+	return packet(header, append(appendString(nil, m.topic), m.payload...))
+}
+
+// packet returns a packet made of its first header byte and body
+func packet(header byte, body []byte) []byte {
+	p := []byte{header}
+	length := len(body)
+	for {
+		b := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			b |= 0x80
+		}
+		p = append(p, b)
+		if length == 0 {
+			break
+		}
+	}
+	return append(p, body...)
+}
+
+// appendString append a length prefixed string
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// readLength read the remaining length of a packet
+func readLength(r io.Reader) (int, error) {
+	var b [1]byte
+	length, shift := 0, 0
+	for i := 0; i < 4; i++ {
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return 0, err
+		}
+		length |= int(b[0]&0x7f) << shift
+		if b[0]&0x80 == 0 {
+			return length, nil
+		}
+		shift += 7
+	}
+	return 0, errors.New("malformed remaining length")
+}
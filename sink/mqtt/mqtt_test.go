@@ -0,0 +1,343 @@
+package mqtt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	bgapi "github.com/jsakwa/go_bgapi"
+)
+
+// broker an in-process broker, each connection dialed by a sink is handed
+// to the test
+type broker struct {
+	t     *testing.T
+	conns chan net.Conn
+}
+
+func newBroker(t *testing.T) *broker {
+	return &broker{t: t, conns: make(chan net.Conn, 4)}
+}
+
+// dial implements Options.Dial
+func (b *broker) dial() (net.Conn, error) {
+	client, server := net.Pipe()
+	b.conns <- server
+	return client, nil
+}
+
+// accept returns the next connection, once its CONNECT packet was read
+func (b *broker) accept() (net.Conn, []byte) {
+	b.t.Helper()
+	select {
+	case conn := <-b.conns:
+		b.t.Cleanup(func() { conn.Close() })
+		header, body := b.read(conn)
+		if header != packetConnect {
+			b.t.Fatalf("packet 0x%02x, want CONNECT", header)
+		}
+		return conn, body
+	case <-time.After(time.Second):
+		b.t.Fatal("no connection")
+		return nil, nil
+	}
+}
+
+// connAck answer a CONNECT with a return code
+func (b *broker) connAck(conn net.Conn, code byte) {
+	b.t.Helper()
+	if _, err := conn.Write([]byte{packetConnAck, 2, 0, code}); err != nil {
+		b.t.Fatal(err)
+	}
+}
+
+// read returns the first header byte and the body of the next packet
+func (b *broker) read(conn net.Conn) (byte, []byte) {
+	b.t.Helper()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	var header [1]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		b.t.Fatal(err)
+	}
+	length, err := readLength(conn)
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(conn, body); err != nil {
+		b.t.Fatal(err)
+	}
+	return header[0], body
+}
+
+// publish returns the topic and payload of the next packet, a PUBLISH
+func (b *broker) publish(conn net.Conn) (string, []byte) {
+	b.t.Helper()
+	header, body := b.read(conn)
+	if header&0xf0 != packetPublish {
+		b.t.Fatalf("packet 0x%02x, want PUBLISH", header)
+	}
+	n := int(body[0])<<8 | int(body[1])
+	return string(body[2 : 2+n]), body[2+n:]
+}
+
+// disconnected wait for the DISCONNECT packet
+func (b *broker) disconnected(conn net.Conn) {
+	b.t.Helper()
+	if header, _ := b.read(conn); header != packetDisconnect {
+		b.t.Fatalf("packet 0x%02x, want DISCONNECT", header)
+	}
+}
+
+// closeSink close s in the background, Close waits for the broker
+func closeSink(s *Sink) <-chan error {
+	closed := make(chan error, 1)
+	go func() { closed <- s.Close(context.Background()) }()
+	return closed
+}
+
+// closeErr returns the result of a Close
+func closeErr(t *testing.T, closed <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-closed:
+		return err
+	case <-time.After(time.Second):
+		t.Fatal("Close blocked")
+		return nil
+	}
+}
+
+func TestConnect(t *testing.T) {
+	b := newBroker(t)
+	s, err := NewSink(Options{Dial: b.dial, ClientID: "gw", Username: "u", Password: "p"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, body := b.accept()
+	want := []byte{
+		0, 4, 'M', 'Q', 'T', 'T', 4,
+		0xc2,  // user name, password, clean session
+		0, 30, // keep alive
+		0, 2, 'g', 'w',
+		0, 1, 'u',
+		0, 1, 'p',
+	}
+	if !bytes.Equal(body, want) {
+		t.Fatalf("CONNECT % x, want % x", body, want)
+	}
+	b.connAck(conn, 0)
+
+	event := &bgapi.DeviceEvent{Type: bgapi.DeviceAppeared, Address: "00:07:80:aa:bb:cc", RSSI: -60}
+	if err := s.Publish(event); err != nil {
+		t.Fatal(err)
+	}
+	topic, payload := b.publish(conn)
+	if topic != "bgapi/00:07:80:aa:bb:cc/device_appeared" {
+		t.Fatal(topic)
+	}
+	var got bgapi.DeviceEvent
+	if err := json.Unmarshal(payload, &got); err != nil || got.RSSI != -60 {
+		t.Fatal(string(payload), err)
+	}
+
+	closed := closeSink(s)
+	b.disconnected(conn)
+	if err := closeErr(t, closed); err != nil {
+		t.Fatal(err)
+	}
+	if s.Publish(event) != ErrClosed {
+		t.Fatal("published once closed")
+	}
+}
+
+func TestConnectRefused(t *testing.T) {
+	b := newBroker(t)
+	errs := make(chan error, 4)
+	s, _ := NewSink(Options{
+		Dial:      b.dial,
+		Reconnect: bgapi.ReconnectPolicy{Attempts: 1, Delay: bgapi.Duration(time.Millisecond)},
+		OnError:   func(err error) { errs <- err },
+	})
+	conn, _ := b.accept()
+	b.connAck(conn, 5)
+	if err := <-errs; err.Error() != "connection refused: not authorized" {
+		t.Fatal(err)
+	}
+
+	// the retry allowed by the policy
+	conn, _ = b.accept()
+	b.connAck(conn, 5)
+	<-s.closed
+	if s.Publish(&bgapi.DeviceEvent{}) != ErrClosed {
+		t.Fatal("sink open after giving up")
+	}
+}
+
+func TestRemainingLength(t *testing.T) {
+	for _, tc := range []struct {
+		length  int
+		encoded []byte
+	}{
+		{0, []byte{0x00}},
+		{127, []byte{0x7f}},
+		{128, []byte{0x80, 0x01}},
+		{16383, []byte{0xff, 0x7f}},
+		{16384, []byte{0x80, 0x80, 0x01}},
+		{2097151, []byte{0xff, 0xff, 0x7f}},
+		{2097152, []byte{0x80, 0x80, 0x80, 0x01}},
+	} {
+		p := packet(packetPublish, make([]byte, tc.length))
+		if encoded := p[1 : len(p)-tc.length]; !bytes.Equal(encoded, tc.encoded) {
+			t.Errorf("%d encoded as % x, want % x", tc.length, encoded, tc.encoded)
+		}
+		if length, err := readLength(bytes.NewReader(tc.encoded)); err != nil || length != tc.length {
+			t.Errorf("% x decoded as %d, %v", tc.encoded, length, err)
+		}
+	}
+	if _, err := readLength(bytes.NewReader([]byte{0x80, 0x80, 0x80, 0x80, 0x01})); err == nil {
+		t.Error("5 byte length accepted")
+	}
+
+	// messages whose length spans 2 and 3 bytes reach the broker whole
+	b := newBroker(t)
+	s, _ := NewSink(Options{Dial: b.dial})
+	conn, _ := b.accept()
+	b.connAck(conn, 0)
+	for _, size := range []int{200, 20000} {
+		payload := bytes.Repeat([]byte{0x5a}, size)
+		s.PublishMessage("large", payload)
+		if topic, got := b.publish(conn); topic != "large" || !bytes.Equal(got, payload) {
+			t.Fatalf("%s: %d bytes, want %d", topic, len(got), size)
+		}
+	}
+	closed := closeSink(s)
+	b.disconnected(conn)
+	closeErr(t, closed)
+}
+
+func TestPingTimeout(t *testing.T) {
+	b := newBroker(t)
+	errs := make(chan error, 4)
+	s, _ := NewSink(Options{
+		Dial:      b.dial,
+		KeepAlive: 100 * time.Millisecond,
+		Reconnect: bgapi.ReconnectPolicy{Attempts: -1, Delay: bgapi.Duration(time.Millisecond)},
+		OnError:   func(err error) { errs <- err },
+	})
+	defer s.Close(context.Background())
+	conn, _ := b.accept()
+	b.connAck(conn, 0)
+
+	// read the pings without answering
+	go io.Copy(io.Discard, conn)
+	select {
+	case err := <-errs:
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("silent broker not detected")
+	}
+	// the sink reconnects
+	conn, _ = b.accept()
+	b.connAck(conn, 0)
+}
+
+func TestCloseDrainsQueue(t *testing.T) {
+	b := newBroker(t)
+	s, _ := NewSink(Options{Dial: b.dial})
+	conn, _ := b.accept()
+
+	// queued before the connection is accepted
+	for _, topic := range []string{"a", "b", "c"} {
+		s.PublishMessage(topic, []byte(topic))
+	}
+	closed := closeSink(s)
+	b.connAck(conn, 0)
+	for _, want := range []string{"a", "b", "c"} {
+		if topic, _ := b.publish(conn); topic != want {
+			t.Fatalf("topic %s, want %s", topic, want)
+		}
+	}
+	b.disconnected(conn)
+	if err := closeErr(t, closed); err != nil {
+		t.Fatal(err)
+	}
+	if n := s.Dropped(); n != 0 {
+		t.Fatalf("%d dropped", n)
+	}
+}
+
+func TestCloseWhileReconnecting(t *testing.T) {
+	b := newBroker(t)
+	s, _ := NewSink(Options{
+		Dial:      b.dial,
+		Reconnect: bgapi.ReconnectPolicy{Attempts: -1, Delay: bgapi.Duration(time.Hour)},
+	})
+	conn, _ := b.accept()
+	b.connAck(conn, 3)
+
+	// waiting an hour to reconnect
+	s.PublishMessage("a", []byte("a"))
+	closed := closeSink(s)
+	conn, _ = b.accept()
+	b.connAck(conn, 0)
+	if topic, _ := b.publish(conn); topic != "a" {
+		t.Fatal(topic)
+	}
+	b.disconnected(conn)
+	if err := closeErr(t, closed); err != nil {
+		t.Fatal(err)
+	}
+
+	// the broker is still down, the message is dropped
+	b = newBroker(t)
+	s, _ = NewSink(Options{
+		Dial:      b.dial,
+		Reconnect: bgapi.ReconnectPolicy{Attempts: -1, Delay: bgapi.Duration(time.Hour)},
+	})
+	conn, _ = b.accept()
+	b.connAck(conn, 3)
+	s.PublishMessage("a", []byte("a"))
+	closed = closeSink(s)
+	conn, _ = b.accept()
+	b.connAck(conn, 3)
+	if err := closeErr(t, closed); err == nil || err.Error() != "connection refused: server unavailable" {
+		t.Fatal(err)
+	}
+	if n := s.Dropped(); n != 1 {
+		t.Fatalf("%d dropped", n)
+	}
+}
+
+// failingDisconnect a connection failing to write DISCONNECT
+type failingDisconnect struct {
+	net.Conn
+}
+
+func (c *failingDisconnect) Write(p []byte) (int, error) {
+	if p[0] == packetDisconnect {
+		return 0, io.ErrClosedPipe
+	}
+	return c.Conn.Write(p)
+}
+
+func TestCloseDisconnectError(t *testing.T) {
+	b := newBroker(t)
+	s, _ := NewSink(Options{Dial: func() (net.Conn, error) {
+		conn, err := b.dial()
+		return &failingDisconnect{conn}, err
+	}})
+	conn, _ := b.accept()
+	b.connAck(conn, 0)
+	if err := closeErr(t, closeSink(s)); !errors.Is(err, io.ErrClosedPipe) {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,47 @@
+// Package nats publishes the device events of a central to NATS subjects
+package nats
+
+import (
+	"encoding/json"
+
+	bgapi "github.com/jsakwa/go_bgapi"
+	natsgo "github.com/nats-io/nats.go"
+)
+
+// defaultPrefix the subject prefix when none is given
+const defaultPrefix = "bgapi.events"
+
+// Sink a bgapi.EventSink publishing each event as JSON to the subject
+// made of the prefix and the event type, e.g. "bgapi.events.value_changed"
+type Sink struct {
+	conn   *natsgo.Conn
+	prefix string
+}
+
+// NewSink returns a sink publishing on conn under prefix, "bgapi.events"
+// when empty
+func NewSink(conn *natsgo.Conn, prefix string) *Sink {
+	if prefix == "" {
+		prefix = defaultPrefix
+	}
+	return &Sink{conn: conn, prefix: prefix}
+}
+
+// Publish implements bgapi.EventSink, the client buffers the message
+func (s *Sink) Publish(event *bgapi.DeviceEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return s.conn.Publish(s.Subject(event), data)
+}
+
+// Subject returns the subject of an event
+func (s *Sink) Subject(event *bgapi.DeviceEvent) string {
+	return s.prefix + "." + string(event.Type)
+}
+
+// Flush wait for the server to acknowledge the events published
+func (s *Sink) Flush() error {
+	return s.conn.Flush()
+}
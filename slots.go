@@ -0,0 +1,60 @@
+package bgapi
+
+import "errors"
+
+// ErrNoFreeConnections every connection slot of the module is in use
+var ErrNoFreeConnections = errors.New("no free connection slot on the module")
+
+// queryMaxConnections learn the number of connection slots of the module,
+// which also reports the status of the open connections
+func (api *API) queryMaxConnections() error {
+	buf, err := api.call(0, 6, []byte{})
+	if err != nil {
+		return err
+	}
+	d := newDecoder(buf)
+	max := d.u8()
+	if d.err != nil {
+		return d.err
+	}
+
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	api.maxConnections = int(max)
+	return nil
+}
+
+// MaxConnections returns the number of connection slots of the module,
+// false until learned by Ready
+func (api *API) MaxConnections() (int, bool) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	return api.maxConnections, api.maxConnections != 0
+}
+
+// FreeConnections returns the number of connection slots not in use, false
+// while the number of slots is unknown
+func (api *API) FreeConnections() (int, bool) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	if api.maxConnections == 0 {
+		return 0, false
+	}
+	free := api.maxConnections - len(api.connections)
+	if free < 0 {
+		free = 0
+	}
+	return free, true
+}
+
+// checkFreeConnection returns ErrNoFreeConnections when every slot is known
+// to be in use
+func (api *API) checkFreeConnection() error {
+	if free, known := api.FreeConnections(); known && free == 0 {
+		return ErrNoFreeConnections
+	}
+	return nil
+}
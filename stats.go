@@ -0,0 +1,150 @@
+package bgapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Stats API and parser counters
+type Stats struct {
+	// CommandsSent commands written to the device
+	CommandsSent uint64
+	// Responses command responses matched to a pending command
+	Responses uint64
+	// Timeouts commands that never received a response
+	Timeouts uint64
+	// Events events received from the device
+	Events uint64
+	// BytesReceived raw bytes read from the device
+	BytesReceived uint64
+	// Reads successful reads from the transport
+	Reads uint64
+	// Frames frames extracted by the parser
+	Frames uint64
+	// MalformedEvents events dropped because their payload did not decode
+	MalformedEvents uint64
+	// UnexpectedResponses responses received while no command was pending
+	UnexpectedResponses uint64
+	// StaleResponses unexpected responses to the last command that timed
+	// out, received too late
+	StaleResponses uint64
+	// Resyncs receive buffer flushes after a spurious response, see
+	// TransportOptions.ResyncOnSpuriousResponse
+	Resyncs uint64
+	// QueueWait total time commands waited between their submission and
+	// their transmission, rate limiting included
+	QueueWait time.Duration
+	// Throttled commands delayed by the rate limits, see SetRateLimit
+	Throttled uint64
+	// ThrottleWait total delay imposed by the rate limits
+	ThrottleWait time.Duration
+}
+
+// BytesPerRead returns the average number of bytes returned by a read from
+// the transport
+func (s *Stats) BytesPerRead() float64 {
+	if s.Reads == 0 {
+		return 0
+	}
+	return float64(s.BytesReceived) / float64(s.Reads)
+}
+
+// CommandInfo describes a command in flight
+type CommandInfo struct {
+	Class   byte
+	Command byte
+	Elapsed time.Duration
+}
+
+// State snapshot of the API
+type State struct {
+	// QueueDepth commands waiting to be transmitted
+	QueueDepth int
+	// InFlight the command awaiting its response, nil when idle
+	InFlight *CommandInfo
+	// OpenConnections status of the connections reported open by the device
+	OpenConnections []ConnectionStatus
+	// MaxConnections connection slots of the device, 0 until learned by Ready
+	MaxConnections int
+	// RSSI last RSSI read by ConnectionGetRssi, keyed by connection handle
+	RSSI map[byte]int8
+	// Subscriptions notifications and indications enabled with
+	// Connection.Subscribe, keyed by connection handle
+	Subscriptions map[byte]int
+	// SystemCounters last result of SystemCountersGet, nil until queried
+	SystemCounters *SystemCounters
+	// ParserBuffered bytes held by the parser waiting for a complete frame
+	ParserBuffered int
+	// ReadBufferSize current size of the read buffer, see
+	// TransportOptions.MaxReadBufferSize
+	ReadBufferSize int
+	// GapMode the mode tracked by CurrentGapMode
+	GapMode GapMode
+	// NoLicenseKey the module reported a missing license key since it
+	// booted
+	NoLicenseKey bool
+	// Degraded the script failure that marked the device degraded, nil
+	// while healthy
+	Degraded *ScriptFailure
+	Stats    Stats
+}
+
+// Stats returns a copy of the API counters
+func (api *API) Stats() Stats {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	return api.stats
+}
+
+// State returns a snapshot of the API state
+func (api *API) State() State {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	state := State{
+		QueueDepth:     len(api.txC) + len(api.txHighC),
+		MaxConnections: api.maxConnections,
+		GapMode:        api.gapMode,
+		NoLicenseKey:   api.noLicense,
+		Degraded:       api.degraded,
+		Stats:          api.stats,
+	}
+	if op := api.pendingOp; op != nil {
+		state.InFlight = &CommandInfo{Class: op.class, Command: op.cmd, Elapsed: api.clock.Now().Sub(op.sent)}
+	}
+	for _, status := range api.connections {
+		state.OpenConnections = append(state.OpenConnections, status)
+	}
+	sort.Slice(state.OpenConnections, func(i, j int) bool {
+		return state.OpenConnections[i].Connection < state.OpenConnections[j].Connection
+	})
+	state.RSSI = make(map[byte]int8, len(api.rssi))
+	for handle, rssi := range api.rssi {
+		state.RSSI[handle] = rssi
+	}
+	state.Subscriptions = make(map[byte]int, len(api.subscriptions))
+	for handle, enabled := range api.subscriptions {
+		state.Subscriptions[handle] = len(enabled)
+	}
+	if api.counters != nil {
+		counters := *api.counters
+		state.SystemCounters = &counters
+	}
+	state.ParserBuffered = api.parserBuffered
+	state.ReadBufferSize = api.readBufferSize
+
+	return state
+}
+
+// DebugHandler returns an http.Handler rendering the API state as JSON
+func (api *API) DebugHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(api.State())
+	})
+}
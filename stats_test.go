@@ -0,0 +1,79 @@
+package bgapi_test
+
+import (
+	"testing"
+
+	bgapi "github.com/jsakwa/go_bgapi"
+	"github.com/jsakwa/go_bgapi/bgapitest"
+)
+
+// heartRate a peripheral notifying its heart rate measurement
+var heartRate = &bgapi.GattDatabase{
+	Services: []bgapi.GattService{{
+		Handle: 1,
+		End:    4,
+		UUID:   "180d",
+		Characteristics: []bgapi.GattCharacteristic{{
+			Handle:      2,
+			UUID:        "2a37",
+			Properties:  bgapi.CharPropNotify,
+			ValueHandle: 3,
+			Descriptors: []bgapi.GattDescriptor{{Handle: 4, UUID: "2902", Value: bgapi.HexBytes{0, 0}}},
+		}},
+	}},
+}
+
+// connectPeripheral returns a connection to a peripheral serving db on
+// module
+func connectPeripheral(t *testing.T, module *bgapitest.Module, db *bgapi.GattDatabase) (*bgapi.Central, *bgapi.Connection, *bgapitest.Peripheral) {
+	t.Helper()
+	peripheral, err := bgapitest.NewPeripheral(module, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	central := bgapi.NewCentral()
+	central.API().OpenTransport(module, nil)
+	t.Cleanup(func() { central.API().Close() })
+
+	address := bgapi.QualifiedMac{Address: bgapi.Mac{1, 2, 3, 4, 5, 6}}
+	conn := central.NewConnection(&bgapi.GapScanRespone{Address: address}, bgapi.DefaultConnectionParameters())
+	if err := conn.Open(); err != nil {
+		t.Fatal(err)
+	}
+	return central, conn, peripheral
+}
+
+func TestStateSubscriptions(t *testing.T) {
+	central, conn, _ := connectPeripheral(t, bgapitest.NewModule(), heartRate)
+	api := central.API()
+	if n := api.State().Subscriptions[0]; n != 0 {
+		t.Fatalf("%d subscriptions before subscribing", n)
+	}
+
+	char := conn.CharacteristicForUUID(bgapi.MustParseUUID("2a37"))
+	if err := conn.Subscribe(char, true); err != nil {
+		t.Fatal(err)
+	}
+	// subscribing twice enables the same client configuration
+	if err := conn.Subscribe(char, true); err != nil {
+		t.Fatal(err)
+	}
+	if n := api.State().Subscriptions[0]; n != 1 {
+		t.Fatalf("%d subscriptions, want 1", n)
+	}
+
+	if err := conn.Subscribe(char, false); err != nil {
+		t.Fatal(err)
+	}
+	if n := api.State().Subscriptions[0]; n != 0 {
+		t.Fatalf("%d subscriptions after unsubscribing", n)
+	}
+
+	conn.Subscribe(char, true)
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := api.State().Subscriptions[0]; ok {
+		t.Fatal("subscriptions kept after disconnecting")
+	}
+}
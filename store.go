@@ -0,0 +1,469 @@
+package bgapi
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrKeyNotFound the store holds no value under the key
+var ErrKeyNotFound = errors.New("key not found")
+
+// Store persists the bonds, GATT databases, devices and whitelist an
+// application keeps across restarts, see Central.Store. NewFileStore and
+// NewMemoryStore are provided; implement it to keep them in the
+// application's own database. Keys are "bond/", "gatt/" or "device/"
+// followed by an address, e.g. "gatt/00:07:80:12:34:56", "alias/" followed
+// by a name of the AddressBook, and "whitelist"; values are JSON.
+type Store interface {
+	// Get returns the value of key, ErrKeyNotFound when there is none
+	Get(key string) ([]byte, error)
+	Put(key string, value []byte) error
+	// Delete remove key, deleting a missing key is not an error
+	Delete(key string) error
+	// List returns the keys starting with prefix, sorted
+	List(prefix string) ([]string, error)
+}
+
+// store key prefixes
+const (
+	storeBondPrefix   = "bond/"
+	storeGattPrefix   = "gatt/"
+	storeDevicePrefix = "device/"
+	storeWhitelist    = "whitelist"
+)
+
+// MemoryStore a Store keeping values in memory, e.g. for tests
+type MemoryStore struct {
+	mutex  sync.Mutex
+	values map[string][]byte
+}
+
+// NewMemoryStore returns an empty store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{values: make(map[string][]byte)}
+}
+
+// Get implements Store
+func (s *MemoryStore) Get(key string) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	value, ok := s.values[key]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return append([]byte(nil), value...), nil
+}
+
+// Put implements Store
+func (s *MemoryStore) Put(key string, value []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.values[key] = append([]byte(nil), value...)
+	return nil
+}
+
+// Delete implements Store
+func (s *MemoryStore) Delete(key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.values, key)
+	return nil
+}
+
+// List implements Store
+func (s *MemoryStore) List(prefix string) ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var keys []string
+	for key := range s.values {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// FileStore a Store keeping each value in a file of a directory. File
+// names are the keys with the characters not portable across file systems
+// escaped; values are replaced atomically.
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore returns a store in dir, created if needed
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// fileStoreSuffix distinguishes values from the temporary files of Put
+const fileStoreSuffix = ".json"
+
+// escapeKey returns the file name of key
+func escapeKey(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02x", c)
+		}
+	}
+	return b.String() + fileStoreSuffix
+}
+
+// unescapeKey returns the key of a file name, false for foreign files
+func unescapeKey(name string) (string, bool) {
+	if !strings.HasSuffix(name, fileStoreSuffix) {
+		return "", false
+	}
+	name = strings.TrimSuffix(name, fileStoreSuffix)
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] != '%' {
+			b.WriteByte(name[i])
+			continue
+		}
+		if i+2 >= len(name) {
+			return "", false
+		}
+		c, err := hex.DecodeString(name[i+1 : i+3])
+		if err != nil {
+			return "", false
+		}
+		b.Write(c)
+		i += 2
+	}
+	return b.String(), true
+}
+
+// Get implements Store
+func (s *FileStore) Get(key string) ([]byte, error) {
+	value, err := os.ReadFile(filepath.Join(s.dir, escapeKey(key)))
+	if os.IsNotExist(err) {
+		return nil, ErrKeyNotFound
+	}
+	return value, err
+}
+
+// Put implements Store
+func (s *FileStore) Put(key string, value []byte) error {
+	f, err := os.CreateTemp(s.dir, "put-*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(value); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), filepath.Join(s.dir, escapeKey(key))); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// Delete implements Store
+func (s *FileStore) Delete(key string) error {
+	err := os.Remove(filepath.Join(s.dir, escapeKey(key)))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// List implements Store
+func (s *FileStore) List(prefix string) ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, entry := range entries {
+		key, ok := unescapeKey(entry.Name())
+		if ok && !entry.IsDir() && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// storePut write v as JSON under key
+func storePut(store Store, key string, v interface{}) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return store.Put(key, value)
+}
+
+// storeGet read the JSON value of key into v
+func storeGet(store Store, key string, v interface{}) error {
+	value, err := store.Get(key)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(value, v); err != nil {
+		return fmt.Errorf("stored %s: %v", key, err)
+	}
+	return nil
+}
+
+// StoredBond a bond kept in a Store
+type StoredBond struct {
+	Address string `json:"address"`
+	Public  bool   `json:"public,omitempty"`
+	// Bond handle of the bond on the module
+	Bond    byte `json:"bond"`
+	KeySize byte `json:"key_size"`
+	MITM    bool `json:"mitm,omitempty"`
+	Keys    byte `json:"keys"`
+}
+
+// SaveBond keep the bond obtained by pairing with address
+func SaveBond(store Store, address QualifiedMac, bond *BondInfo) error {
+	return storePut(store, storeBondPrefix+address.Address.String(), &StoredBond{
+		Address: address.Address.String(),
+		Public:  address.AddrType == AddrTypePublic,
+		Bond:    bond.Bond,
+		KeySize: bond.KeySize,
+		MITM:    bond.MITM,
+		Keys:    byte(bond.Keys),
+	})
+}
+
+// LoadBond returns the bond kept for address, ErrKeyNotFound when none
+func LoadBond(store Store, address Mac) (*BondInfo, error) {
+	var stored StoredBond
+	if err := storeGet(store, storeBondPrefix+address.String(), &stored); err != nil {
+		return nil, err
+	}
+	return &BondInfo{Bond: stored.Bond, KeySize: stored.KeySize, MITM: stored.MITM, Keys: SmKeys(stored.Keys)}, nil
+}
+
+// LoadBonds returns every bond kept
+func LoadBonds(store Store) ([]StoredBond, error) {
+	keys, err := store.List(storeBondPrefix)
+	if err != nil {
+		return nil, err
+	}
+	bonds := make([]StoredBond, 0, len(keys))
+	for _, key := range keys {
+		var stored StoredBond
+		if err := storeGet(store, key, &stored); err != nil {
+			return nil, err
+		}
+		bonds = append(bonds, stored)
+	}
+	return bonds, nil
+}
+
+// DeleteBond forget the bond kept for address
+func DeleteBond(store Store, address Mac) error {
+	return store.Delete(storeBondPrefix + address.String())
+}
+
+// deleteStoredBonds forget the bonds kept with handle bond, 0xff forgets
+// them all
+func deleteStoredBonds(store Store, bond byte) error {
+	bonds, err := LoadBonds(store)
+	if err != nil {
+		return err
+	}
+	for _, stored := range bonds {
+		if bond == 0xff || stored.Bond == bond {
+			if err := store.Delete(storeBondPrefix + stored.Address); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// SaveGattDatabase keep a snapshot under the address it was taken from,
+// e.g. to skip discovery on the next connection
+func SaveGattDatabase(store Store, db *GattDatabase) error {
+	if db.Address == "" {
+		return errors.New("GATT database without address")
+	}
+	return storePut(store, storeGattPrefix+db.Address, db)
+}
+
+// LoadCachedGattDatabase returns the snapshot kept for address,
+// ErrKeyNotFound when none
+func LoadCachedGattDatabase(store Store, address Mac) (*GattDatabase, error) {
+	var db GattDatabase
+	if err := storeGet(store, storeGattPrefix+address.String(), &db); err != nil {
+		return nil, err
+	}
+	if err := db.Validate(); err != nil {
+		return nil, err
+	}
+	return &db, nil
+}
+
+// DeleteGattDatabase forget the snapshot kept for address, e.g. once the
+// peripheral changed its database
+func DeleteGattDatabase(store Store, address Mac) error {
+	return store.Delete(storeGattPrefix + address.String())
+}
+
+// StoredDevice a peripheral the central connected to, kept in a Store
+type StoredDevice struct {
+	Address string `json:"address"`
+	Public  bool   `json:"public,omitempty"`
+	// Name the local name last advertised, if any
+	Name           string    `json:"name,omitempty"`
+	FirstConnected time.Time `json:"first_connected"`
+	LastConnected  time.Time `json:"last_connected"`
+	Connections    uint64    `json:"connections"`
+}
+
+// saveDevice record a connection to address at now, name replaces the
+// name kept unless empty
+func saveDevice(store Store, address QualifiedMac, name string, now time.Time) error {
+	key := storeDevicePrefix + address.Address.String()
+	var stored StoredDevice
+	if err := storeGet(store, key, &stored); err == ErrKeyNotFound {
+		stored = StoredDevice{Address: address.Address.String(), FirstConnected: now}
+	} else if err != nil {
+		return err
+	}
+	stored.Public = address.AddrType == AddrTypePublic
+	if name != "" {
+		stored.Name = name
+	}
+	stored.LastConnected = now
+	stored.Connections++
+	return storePut(store, key, &stored)
+}
+
+// LoadDevice returns the device kept for address, ErrKeyNotFound when the
+// central never connected to it
+func LoadDevice(store Store, address Mac) (*StoredDevice, error) {
+	var stored StoredDevice
+	if err := storeGet(store, storeDevicePrefix+address.String(), &stored); err != nil {
+		return nil, err
+	}
+	return &stored, nil
+}
+
+// LoadDevices returns every device kept
+func LoadDevices(store Store) ([]StoredDevice, error) {
+	keys, err := store.List(storeDevicePrefix)
+	if err != nil {
+		return nil, err
+	}
+	devices := make([]StoredDevice, 0, len(keys))
+	for _, key := range keys {
+		var stored StoredDevice
+		if err := storeGet(store, key, &stored); err != nil {
+			return nil, err
+		}
+		devices = append(devices, stored)
+	}
+	return devices, nil
+}
+
+// DeleteDevice forget the device kept for address
+func DeleteDevice(store Store, address Mac) error {
+	return store.Delete(storeDevicePrefix + address.String())
+}
+
+// SaveWhitelist keep the addresses of the whitelist
+func SaveWhitelist(store Store, addresses []QualifiedMac) error {
+	entries := make([]WhitelistEntry, 0, len(addresses))
+	for _, address := range addresses {
+		entries = append(entries, WhitelistEntry{Address: address.Address.String(), Public: address.AddrType == AddrTypePublic})
+	}
+	return storePut(store, storeWhitelist, entries)
+}
+
+// LoadWhitelist returns the addresses kept by SaveWhitelist, none when the
+// whitelist was never saved
+func LoadWhitelist(store Store) ([]QualifiedMac, error) {
+	var entries []WhitelistEntry
+	if err := storeGet(store, storeWhitelist, &entries); err == ErrKeyNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	cfg := Config{Filter: FilterConfig{Whitelist: entries}}
+	return cfg.Whitelist()
+}
+
+// RestoreWhitelist replace the whitelist of the module with the addresses
+// kept by SaveWhitelist, leaving it alone when none were saved
+func RestoreWhitelist(api *API, store Store) error {
+	addresses, err := LoadWhitelist(store)
+	if err != nil || addresses == nil {
+		return err
+	}
+	if err := api.SystemWhitelistClear(); err != nil {
+		return err
+	}
+	for _, address := range addresses {
+		if err := api.SystemWhitelistAppend(address, func(uint16) {}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetWhitelist replace the whitelist of the module with addresses, kept in
+// the central's Store, if any, to be restored whenever the module boots
+func (c *Central) SetWhitelist(addresses []QualifiedMac) error {
+	if c.Store != nil {
+		if err := SaveWhitelist(c.Store, addresses); err != nil {
+			return err
+		}
+	}
+	if err := c.api.SystemWhitelistClear(); err != nil {
+		return err
+	}
+	for _, address := range addresses {
+		if err := c.api.SystemWhitelistAppend(address, func(uint16) {}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// registerDevice record the connection in the central's Store, if any
+func (c *Connection) registerDevice() error {
+	store := c.central.Store
+	if store == nil {
+		return nil
+	}
+	resp := c.central.KnownPeripheral(c.resp.Address)
+	if resp == nil {
+		resp = &c.resp
+	}
+	name := ParseGapScanResponse(resp).LocalName()
+	if err := saveDevice(store, c.resp.Address, name, c.central.api.clock.Now()); err != nil {
+		return fmt.Errorf("recording the device: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package bgapi_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	bgapi "github.com/jsakwa/go_bgapi"
+	"github.com/jsakwa/go_bgapi/bgapitest"
+)
+
+// TestStoreList lists the keys of a prefix sorted, whatever their
+// characters, with either store
+func TestStoreList(t *testing.T) {
+	fileStore, err := bgapi.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, store := range map[string]bgapi.Store{"file": fileStore, "memory": bgapi.NewMemoryStore()} {
+		for _, key := range []string{"gatt/b", "bond/a", "gatt/a b%", "gatt/a", "gatt"} {
+			if err := store.Put(key, []byte(key)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		keys, err := store.List("gatt/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"gatt/a", "gatt/a b%", "gatt/b"}; !reflect.DeepEqual(keys, want) {
+			t.Fatalf("%s store lists %q, want %q", name, keys, want)
+		}
+		if value, err := store.Get("gatt/a b%"); err != nil || string(value) != "gatt/a b%" {
+			t.Fatalf("%s store gets %q, %v", name, value, err)
+		}
+		if err := store.Delete("gatt/a"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.Get("gatt/a"); err != bgapi.ErrKeyNotFound {
+			t.Fatalf("%s store gets a deleted key: %v", name, err)
+		}
+		if err := store.Delete("gatt/a"); err != nil {
+			t.Fatalf("%s store fails deleting a missing key: %v", name, err)
+		}
+	}
+}
+
+// TestFileStoreEscaping keeps keys in portable file names, files the
+// store did not write are not listed
+func TestFileStoreEscaping(t *testing.T) {
+	dir := t.TempDir()
+	store, err := bgapi.NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put("alias/kitchen sensor:1", []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a%4.json", "b%4g.json", "c%.json", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.ContainsAny(entry.Name(), "/: ") {
+			t.Fatalf("file name %q not escaped", entry.Name())
+		}
+	}
+	keys, err := store.List("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"alias/kitchen sensor:1"}; !reflect.DeepEqual(keys, want) {
+		t.Fatalf("listed %q, want %q", keys, want)
+	}
+}
+
+// TestFileStorePutAtomic replaces a value while it is read, readers see
+// the old or the new value but never a part, and no temporary file remains
+func TestFileStorePutAtomic(t *testing.T) {
+	dir := t.TempDir()
+	store, err := bgapi.NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := [][]byte{bytes.Repeat([]byte("a"), 64<<10), bytes.Repeat([]byte("b"), 64<<10)}
+	if err := store.Put("whitelist", values[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			if err := store.Put("whitelist", values[i%2]); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		value, err := store.Get("whitelist")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(value, values[0]) && !bytes.Equal(value, values[1]) {
+			t.Fatalf("read %d bytes of a partial value", len(value))
+		}
+	}
+	wg.Wait()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("%d files after replacing a value", len(entries))
+	}
+}
+
+// TestCentralStore keeps the database discovered, the device connected and
+// the whitelist set; a central sharing the store skips discovery and
+// restores the whitelist when the module boots
+func TestCentralStore(t *testing.T) {
+	store := bgapi.NewMemoryStore()
+	address := bgapi.QualifiedMac{Address: bgapi.Mac{1, 2, 3, 4, 5, 6}}
+	open := func(module *bgapitest.Module) *bgapi.Central {
+		if _, err := bgapitest.NewPeripheral(module, heartRate); err != nil {
+			t.Fatal(err)
+		}
+		central := bgapi.NewCentral()
+		central.Store = store
+		central.API().OpenTransport(module, nil)
+		t.Cleanup(func() { central.API().Close() })
+		conn := central.NewConnection(&bgapi.GapScanRespone{Address: address}, bgapi.DefaultConnectionParameters())
+		if err := conn.Open(); err != nil {
+			t.Fatal(err)
+		}
+		if conn.CharacteristicForUUID(bgapi.MustParseUUID("2a37")) == nil {
+			t.Fatal("heart rate measurement not found")
+		}
+		return central
+	}
+
+	central := open(bgapitest.NewModule())
+	if _, err := bgapi.LoadCachedGattDatabase(store, address.Address); err != nil {
+		t.Fatal(err)
+	}
+	whitelist := []bgapi.QualifiedMac{address}
+	if err := central.SetWhitelist(whitelist); err != nil {
+		t.Fatal(err)
+	}
+	if saved, err := bgapi.LoadWhitelist(store); err != nil || !reflect.DeepEqual(saved, whitelist) {
+		t.Fatalf("whitelist kept %v, %v", saved, err)
+	}
+
+	module := bgapitest.NewModule()
+	open(module)
+	device, err := bgapi.LoadDevice(store, address.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if device.Connections != 2 {
+		t.Fatalf("%d connections recorded", device.Connections)
+	}
+
+	boot := bgapitest.SystemBoot(bgapi.SystemInfo{Major: 1, Minor: 3})
+	module.Event(boot.Class, boot.Event, boot.Payload)
+	restored := false
+	for deadline := time.Now().Add(time.Second); !restored && time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		for _, cmd := range module.Commands() {
+			switch {
+			case cmd.Class == 4 && cmd.Command == 1:
+				t.Fatal("discovered the database kept")
+			case cmd.Class == 0 && cmd.Command == 10:
+				restored = true
+			}
+		}
+	}
+	if !restored {
+		t.Fatal("whitelist not restored on boot")
+	}
+}
@@ -0,0 +1,92 @@
+package bgapi
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+const (
+	defaultStreamChunkSize = 20
+	defaultStreamWindow    = 8
+)
+
+// StreamWriter an io.Writer transferring bulk data to a characteristic. Data
+// is pipelined as write commands; every Window chunks, and for the last chunk
+// of each Write, an acknowledged write is sent instead and its procedure
+// completion awaited, so the module's transmit buffers never overrun. The
+// characteristic must therefore support both kinds of write.
+type StreamWriter struct {
+	conn *Connection
+	char *Characteristic
+
+	// ChunkSize bytes per write, defaults to 20
+	ChunkSize int
+	// Window chunks sent before waiting for an acknowledged write, defaults to 8
+	Window int
+
+	pending int // write commands sent since the last acknowledged write
+
+	mutex sync.Mutex
+	err   error // first write command rejected by the module
+}
+
+// NewStreamWriter returns a writer streaming to char
+func NewStreamWriter(conn *Connection, char *Characteristic) (*StreamWriter, error) {
+	if char.value == nil {
+		return nil, errors.New("characteristic has no value attribute")
+	}
+	return &StreamWriter{
+		conn:      conn,
+		char:      char,
+		ChunkSize: defaultStreamChunkSize,
+		Window:    defaultStreamWindow,
+	}, nil
+}
+
+// Write implements io.Writer, it returns once the peripheral acknowledged p
+func (w *StreamWriter) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		if err := w.failure(); err != nil {
+			return written, err
+		}
+
+		end := written + w.ChunkSize
+		if end > len(p) {
+			end = len(p)
+		}
+
+		var err error
+		if w.pending+1 >= w.Window || end == len(p) {
+			// acknowledged writes complete after the commands queued before them
+			err = w.conn.Write(w.char, p[written:end], false)
+			w.pending = 0
+		} else {
+			err = w.conn.central.api.AttclientWriteCommand(w.conn.status.Connection, w.char.value.handle, p[written:end], w.commandDone)
+			w.pending++
+		}
+		if err != nil {
+			return written, err
+		}
+		written = end
+	}
+	return written, w.failure()
+}
+
+// commandDone record a write command rejected by the module
+func (w *StreamWriter) commandDone(result uint16) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if result != 0 && w.err == nil {
+		w.err = fmt.Errorf("write command rejected with result 0x%04x", result)
+	}
+}
+
+func (w *StreamWriter) failure() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.err
+}
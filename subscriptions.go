@@ -0,0 +1,122 @@
+package bgapi
+
+import (
+	"errors"
+	"sort"
+)
+
+// SubscriptionStatus outcome of restoring a subscription once reconnected
+type SubscriptionStatus struct {
+	// UUID of the characteristic
+	UUID []byte
+	// Persisted the peer kept the subscription, as bonded peers should, so
+	// it was not written again
+	Persisted bool
+	Err       error
+}
+
+// SubscriptionDelegate optionally implemented by a ConnectionDelegate to
+// learn how the subscriptions were restored after reconnecting
+type SubscriptionDelegate interface {
+	OnSubscriptionsRestored(status []SubscriptionStatus)
+}
+
+// Subscriptions returns the UUIDs of the characteristics subscribed with
+// Subscribe, which are restored whenever the connection is opened again
+func (c *Connection) Subscriptions() [][]byte {
+	uuids := make([][]byte, 0, len(c.subscriptions))
+	for uuid := range c.subscriptions {
+		uuids = append(uuids, []byte(uuid))
+	}
+	sort.Slice(uuids, func(i, j int) bool { return string(uuids[i]) < string(uuids[j]) })
+	return uuids
+}
+
+// ForgetSubscriptions stop restoring the subscriptions on reconnection,
+// the peer is left untouched
+func (c *Connection) ForgetSubscriptions() {
+	c.subscriptions = nil
+}
+
+// rememberSubscription record a subscription along with the value handler
+// to install again once reconnected, discovery recreating the attributes
+func (c *Connection) rememberSubscription(char *Characteristic, enable bool) {
+	uuid := string(char.UUID())
+	if !enable {
+		delete(c.subscriptions, uuid)
+		return
+	}
+	if c.subscriptions == nil {
+		c.subscriptions = make(map[string]func([]byte))
+	}
+	var onValueChanged func([]byte)
+	if char.value != nil {
+		onValueChanged = char.value.OnValueChanged
+	}
+	c.subscriptions[uuid] = onValueChanged
+}
+
+// restoreSubscriptions subscribe again once the connection is reopened,
+// unless the peer persisted the client configuration, and report the
+// outcome to the delegate
+func (c *Connection) restoreSubscriptions() {
+	if len(c.subscriptions) == 0 {
+		return
+	}
+
+	status := make([]SubscriptionStatus, 0, len(c.subscriptions))
+	for _, uuid := range c.Subscriptions() {
+		s := SubscriptionStatus{UUID: uuid}
+		char := c.CharacteristicForUUID(uuid)
+		if char == nil {
+			s.Err = errors.New("characteristic no longer exists")
+			status = append(status, s)
+			continue
+		}
+
+		if char.value != nil && char.value.OnValueChanged == nil {
+			char.value.OnValueChanged = c.subscriptions[string(uuid)]
+		}
+		if subscribed(char) {
+			s.Persisted = true
+			c.central.api.trackSubscription(c.status.Connection, char.Descriptor(ClientCharacteristicConfigUUID).handle, true)
+		} else {
+			s.Err = c.Subscribe(char, true)
+		}
+		status = append(status, s)
+	}
+
+	if delegate, ok := c.delegate.(SubscriptionDelegate); ok {
+		delegate.OnSubscriptionsRestored(status)
+	}
+}
+
+// trackSubscription record the client configuration of a connection, as
+// counted by State
+func (api *API) trackSubscription(connection byte, handle uint16, enable bool) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	if !enable {
+		delete(api.subscriptions[connection], handle)
+		return
+	}
+	if api.subscriptions == nil {
+		api.subscriptions = make(map[byte]map[uint16]bool)
+	}
+	if api.subscriptions[connection] == nil {
+		api.subscriptions[connection] = make(map[uint16]bool)
+	}
+	api.subscriptions[connection][handle] = true
+}
+
+// subscribed returns true when the client configuration read during
+// discovery enables notifications or indications
+func subscribed(char *Characteristic) bool {
+	cccd := char.Descriptor(ClientCharacteristicConfigUUID)
+	if cccd == nil {
+		return false
+	}
+	value := cccd.Value()
+	return len(value) > 0 && value[0]&3 != 0
+}
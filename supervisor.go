@@ -0,0 +1,128 @@
+package bgapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// SupervisorState a transition of the lifecycle of a Supervisor
+type SupervisorState string
+
+// supervisor states
+const (
+	// SupervisorOpened the device was opened, a session starts
+	SupervisorOpened SupervisorState = "opened"
+	// SupervisorOpenFailed the device could not be opened
+	SupervisorOpenFailed SupervisorState = "open_failed"
+	// SupervisorLost the session failed or the device went away, it is
+	// opened again unless the reconnect policy gives up
+	SupervisorLost SupervisorState = "lost"
+	// SupervisorStopped Run returns
+	SupervisorStopped SupervisorState = "stopped"
+)
+
+// Supervisor keeps a central running over a device that may come and go,
+// e.g. a dongle of a gateway being unplugged: Run opens the device, hands a
+// fresh central to the session and, once the session fails or the device
+// is lost, opens it again after the back-off of the reconnect policy. What
+// the session wires to the central (watchers, sinks, collectors) must be
+// wired again to each new one.
+type Supervisor struct {
+	// Open returns a central over a ready device, e.g. with
+	// OpenSerialReady and ApplyConfig; it closes what it opened when it
+	// fails
+	Open func() (*Central, error)
+	// Reconnect how opening the device is retried, the attempts count from
+	// the last successful open; the zero policy gives up at the first
+	// failure
+	Reconnect ReconnectPolicy
+	// OnState notified of the transitions, with the error causing them,
+	// may be nil
+	OnState func(state SupervisorState, err error)
+	// Clock source of time for the back-off, defaults to SystemClock
+	Clock Clock
+}
+
+// Run open the device and run session until ctx is done, then close the
+// central. The context of the session is cancelled as well when the device
+// is lost. A session returning nil ends Run; an error, like the loss of
+// the device, reopens it. Returns nil once ctx is done or the session
+// returned nil, the last error once the reconnect policy gives up.
+func (s *Supervisor) Run(ctx context.Context, session func(ctx context.Context, c *Central) error) error {
+	if s.Open == nil {
+		return errors.New("supervisor without Open")
+	}
+	clock := s.Clock
+	if clock == nil {
+		clock = SystemClock
+	}
+
+	attempt := 0
+	for {
+		central, err := s.Open()
+		if err != nil {
+			s.notify(SupervisorOpenFailed, err)
+		} else {
+			s.notify(SupervisorOpened, nil)
+			attempt = 0
+			if err = s.serve(ctx, central, session); err == nil {
+				s.notify(SupervisorStopped, nil)
+				return nil
+			}
+			s.notify(SupervisorLost, err)
+		}
+
+		delay, ok := s.Reconnect.Backoff(attempt)
+		attempt++
+		if !ok {
+			err = fmt.Errorf("giving up reopening the device: %w", err)
+			s.notify(SupervisorStopped, err)
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			s.notify(SupervisorStopped, nil)
+			return nil
+		case <-clock.After(delay):
+		}
+	}
+}
+
+// serve run a session over central and close it, returns nil when the
+// session ended normally, the reason it failed otherwise
+func (s *Supervisor) serve(ctx context.Context, central *Central, session func(ctx context.Context, c *Central) error) error {
+	api := central.API()
+	sessionCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-api.Done():
+			cancel()
+		case <-sessionCtx.Done():
+		}
+	}()
+
+	err := session(sessionCtx, central)
+	select {
+	case <-api.Done():
+		if lost := api.Err(); lost != nil && ctx.Err() == nil {
+			return lost
+		}
+		// closed by the session
+		return nil
+	default:
+	}
+	api.Close()
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// notify report a transition
+func (s *Supervisor) notify(state SupervisorState, err error) {
+	if s.OnState != nil {
+		s.OnState(state, err)
+	}
+}
@@ -0,0 +1,153 @@
+package bgapi
+
+import (
+	"archive/zip"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// supportManifest the summary of a support bundle, its manifest.json
+type supportManifest struct {
+	Created  time.Time         `json:"created"`
+	Firmware string            `json:"firmware,omitempty"`
+	Files    []string          `json:"files"`
+	Errors   map[string]string `json:"errors,omitempty"`
+}
+
+// supportPSEntry a PS key as written to a support bundle, the value of a
+// redacted key is left out
+type supportPSEntry struct {
+	Key      uint16 `json:"key"`
+	Name     string `json:"name"`
+	Length   int    `json:"length"`
+	Value    string `json:"value,omitempty"`
+	Redacted bool   `json:"redacted,omitempty"`
+}
+
+// supportSystem the identity of the module in a support bundle
+type supportSystem struct {
+	Fingerprint  *Fingerprint        `json:"fingerprint,omitempty"`
+	Counters     *SystemCounters     `json:"counters,omitempty"`
+	Capabilities map[Capability]bool `json:"capabilities,omitempty"`
+}
+
+// SupportBundle write a zip archive describing the module and the API to
+// w, for attaching to bug reports: system information and counters, the
+// persistent store, the frame history (see EnableHistory), the API state,
+// statistics and latencies, and the configuration last applied with
+// ApplyConfig. The firmware keys of the store are redacted, as they hold
+// bonding keys and the license, except the ones PSKeyName names. The
+// bundle is written even when the module does not answer; manifest.json
+// lists what could not be collected. Only a failure to write w is
+// returned.
+func (api *API) SupportBundle(w io.Writer) error {
+	manifest := supportManifest{Created: api.clock.Now(), Errors: make(map[string]string)}
+	if version, ok := api.FirmwareVersion(); ok {
+		manifest.Firmware = version.String()
+	}
+
+	archive := zip.NewWriter(w)
+	add := func(name string, write func(w io.Writer) error) error {
+		f, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: manifest.Created})
+		if err == nil {
+			manifest.Files = append(manifest.Files, name)
+			err = write(f)
+		}
+		if err != nil {
+			return fmt.Errorf("writing %s: %w", name, err)
+		}
+		return nil
+	}
+	addJSON := func(name string, v interface{}) error {
+		return add(name, func(w io.Writer) error {
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			return enc.Encode(v)
+		})
+	}
+
+	system := supportSystem{}
+	if fingerprint, err := api.Fingerprint(); err != nil {
+		manifest.Errors["fingerprint"] = err.Error()
+	} else {
+		system.Fingerprint = fingerprint
+	}
+	if counters, err := api.supportCounters(); err != nil {
+		manifest.Errors["counters"] = err.Error()
+	} else {
+		system.Counters = counters
+	}
+	api.mutex.Lock()
+	if api.capabilities != nil {
+		system.Capabilities = make(map[Capability]bool, len(api.capabilities))
+		for c, supported := range api.capabilities {
+			system.Capabilities[c] = supported
+		}
+	}
+	config := api.config
+	api.mutex.Unlock()
+	if err := addJSON("system.json", &system); err != nil {
+		return err
+	}
+
+	if entries, err := api.PSDump(); err != nil {
+		manifest.Errors["ps"] = err.Error()
+	} else if err := addJSON("ps.json", redactPS(entries)); err != nil {
+		return err
+	}
+	if err := addJSON("state.json", api.State()); err != nil {
+		return err
+	}
+	if err := addJSON("latencies.json", api.Latencies()); err != nil {
+		return err
+	}
+	if err := add("history.txt", api.DumpHistory); err != nil {
+		return err
+	}
+	if config != nil {
+		if err := addJSON("config.json", config); err != nil {
+			return err
+		}
+	}
+
+	if len(manifest.Errors) == 0 {
+		manifest.Errors = nil
+	}
+	// the manifest lists itself
+	if err := addJSON("manifest.json", &manifest); err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+// supportCounters query the system counters
+func (api *API) supportCounters() (*SystemCounters, error) {
+	countersC := make(chan *SystemCounters, 1)
+	if err := api.SystemCountersGet(func(counters *SystemCounters) { countersC <- counters }); err != nil {
+		return nil, err
+	}
+	select {
+	case counters := <-countersC:
+		return counters, nil
+	case <-api.clock.After(defaultTimeoutMs * time.Millisecond):
+		return nil, errOperationTimedOut
+	}
+}
+
+// redactPS returns the entries of a PS dump as written to a support bundle
+func redactPS(entries []PSEntry) []supportPSEntry {
+	redacted := make([]supportPSEntry, len(entries))
+	for i, e := range entries {
+		name := PSKeyName(e.Key)
+		redacted[i] = supportPSEntry{Key: e.Key, Name: name, Length: len(e.Value)}
+		if _, named := psKeyNames[e.Key]; named || IsUserPSKey(e.Key) {
+			redacted[i].Value = hex.EncodeToString(e.Value)
+		} else {
+			redacted[i].Redacted = true
+		}
+	}
+	return redacted
+}
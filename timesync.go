@@ -0,0 +1,234 @@
+package bgapi
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// timeSyncTimerHandle soft timer handle used by the time sync
+	timeSyncTimerHandle = 0xfd
+	// deviceTickRate frequency of the sleep clock timing the soft timer and
+	// the IO port status timestamps
+	deviceTickRate = 32768
+	// timeSyncSamples timer expiries the tick period is estimated over
+	timeSyncSamples = 64
+)
+
+// timeSyncSample the host time of a timer expiry, count numbering the
+// expiries including those missed
+type timeSyncSample struct {
+	count int64
+	host  time.Time
+}
+
+// TimeSync translates the timestamps of the device, in ticks of its
+// 32.768kHz sleep clock, to host time. A repeating soft timer measures the
+// length of a tick on the host's monotonic clock, which gives the skew of
+// the device clock; the IO port status events place the tick counter on
+// the host timeline, the event arriving the soonest after its timestamp
+// anchoring it. While it runs IoPortStatus.Time holds the host time of
+// IoPortStatus.Timestamp.
+type TimeSync struct {
+	api   *API
+	ticks uint32 // timer period
+
+	mutex    sync.Mutex
+	samples  []timeSyncSample // the latest expiries, oldest first
+	anchored bool
+	anchor   timeSyncAnchor
+	seen     bool
+	last     uint32 // latest timestamp received
+	unwrap   int64  // latest timestamp, counting wraps of the counter
+}
+
+// timeSyncAnchor a timestamp, unwrapped, and its host time
+type timeSyncAnchor struct {
+	ticks int64
+	host  time.Time
+}
+
+// StartTimeSync start estimating the device clock, with a soft timer
+// firing every interval; a longer interval loads the link less but takes
+// longer to converge. Only one time sync runs at a time, the timer is
+// restarted when the module boots.
+func (api *API) StartTimeSync(interval time.Duration) (*TimeSync, error) {
+	ticks := int64(interval) * deviceTickRate / int64(time.Second)
+	if ticks < 1 || ticks > math.MaxUint32 {
+		return nil, fmt.Errorf("time sync interval %v out of range", interval)
+	}
+	s := &TimeSync{api: api, ticks: uint32(ticks)}
+
+	api.mutex.Lock()
+	if api.timeSync != nil {
+		api.mutex.Unlock()
+		return nil, errors.New("time sync already running")
+	}
+	api.timeSync = s
+	api.mutex.Unlock()
+
+	if err := s.setTimer(s.ticks); err != nil {
+		api.mutex.Lock()
+		api.timeSync = nil
+		api.mutex.Unlock()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Stop stop the timer, timestamps are no longer translated
+func (s *TimeSync) Stop() error {
+	s.api.mutex.Lock()
+	if s.api.timeSync == s {
+		s.api.timeSync = nil
+	}
+	s.api.mutex.Unlock()
+
+	// a zero time stops the timer
+	return s.setTimer(0)
+}
+
+// setTimer start the repeating soft timer, ticks 0 stops it
+func (s *TimeSync) setTimer(ticks uint32) error {
+	data := (&encoder{}).write(ticks).write(byte(timeSyncTimerHandle)).write(byte(0)).bytes()
+	buf, err := s.api.call(7, 1, data)
+	if err != nil {
+		return err
+	}
+	d := newDecoder(buf)
+	if result := d.u16(); d.err != nil {
+		return d.err
+	} else if result != 0 {
+		return &ProcedureError{Result: result}
+	}
+	return nil
+}
+
+// tickNanos returns the length of a device tick in host nanoseconds, the
+// nominal one until the timer fired twice
+func (s *TimeSync) tickNanos() float64 {
+	if len(s.samples) < 2 {
+		return float64(time.Second) / deviceTickRate
+	}
+	first, last := s.samples[0], s.samples[len(s.samples)-1]
+	return float64(last.host.Sub(first.host)) / float64((last.count-first.count)*int64(s.ticks))
+}
+
+// Skew returns how much slower the device clock runs than the host's, in
+// parts per million; false until the timer fired twice
+func (s *TimeSync) Skew() (float64, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.samples) < 2 {
+		return 0, false
+	}
+	return (s.tickNanos()*deviceTickRate/float64(time.Second) - 1) * 1e6, true
+}
+
+// DeviceTime returns the host time of a device timestamp close to the
+// latest received, false until an IO port status event anchored the clock
+func (s *TimeSync) DeviceTime(ticks uint32) (time.Time, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.anchored {
+		return time.Time{}, false
+	}
+	return s.hostTime(s.unwrap + int64(int32(ticks-s.last))), true
+}
+
+// hostTime returns the host time of an unwrapped timestamp, the mutex must
+// be held
+func (s *TimeSync) hostTime(ticks int64) time.Time {
+	elapsed := float64(ticks-s.anchor.ticks) * s.tickNanos()
+	return s.anchor.host.Add(time.Duration(elapsed))
+}
+
+// expired record a timer expiry received at now
+func (s *TimeSync) expired(now time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var count int64
+	if n := len(s.samples); n > 0 {
+		last := s.samples[n-1]
+		period := s.tickNanos() * float64(s.ticks)
+		missed := int64(math.Round(float64(now.Sub(last.host)) / period))
+		if missed < 1 {
+			missed = 1
+		}
+		count = last.count + missed
+	}
+	s.samples = append(s.samples, timeSyncSample{count: count, host: now})
+	if len(s.samples) > timeSyncSamples {
+		s.samples = s.samples[len(s.samples)-timeSyncSamples:]
+	}
+}
+
+// observe place a timestamp received at now on the host timeline and
+// return its host time: an event arriving sooner than the anchor predicts
+// becomes the anchor
+func (s *TimeSync) observe(ticks uint32, now time.Time) time.Time {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.seen {
+		s.unwrap += int64(ticks - s.last)
+	} else {
+		s.unwrap = int64(ticks)
+		s.seen = true
+	}
+	s.last = ticks
+
+	if s.anchored {
+		if predicted := s.hostTime(s.unwrap); !now.Before(predicted) {
+			return predicted
+		}
+	}
+	s.anchor = timeSyncAnchor{ticks: s.unwrap, host: now}
+	s.anchored = true
+	return now
+}
+
+// reset forget the estimate, the device clock restarted
+func (s *TimeSync) reset() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.samples = nil
+	s.anchored = false
+	s.seen = false
+}
+
+// currentTimeSync returns the running time sync, nil if none
+func (api *API) currentTimeSync() *TimeSync {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	return api.timeSync
+}
+
+// timeSyncExpired record an expiry of the time sync timer
+func (api *API) timeSyncExpired(handle byte) {
+	if handle != timeSyncTimerHandle {
+		return
+	}
+	if s := api.currentTimeSync(); s != nil {
+		s.expired(api.clock.Now())
+	}
+}
+
+// restartTimeSync restart the timer of the time sync after a boot, without
+// waiting for the response
+func (api *API) restartTimeSync() {
+	s := api.currentTimeSync()
+	if s == nil {
+		return
+	}
+	s.reset()
+	api.HardwareSetSoftTimer(s.ticks, timeSyncTimerHandle, 0)
+}
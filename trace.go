@@ -0,0 +1,108 @@
+package bgapi
+
+import (
+	"time"
+)
+
+// TraceFrame metadata of a command, response or event passed to a TraceHook.
+// The frame and its payload are only valid until the hook returns.
+type TraceFrame struct {
+	Time    time.Time
+	Class   byte
+	Command byte
+	// Event the frame is an event, otherwise a command or its response
+	Event bool
+	// Payload the frame without its header
+	Payload []byte
+	// Elapsed for responses and timeouts, the time since the command was
+	// written; zero for a response no command was waiting for
+	Elapsed time.Duration
+	// Err for OnTimeout, the error writing the command when it could not
+	// be sent; nil when it went unanswered
+	Err error
+}
+
+// Name returns the BGAPI name of the frame
+func (f *TraceFrame) Name() string {
+	if f.Event {
+		return EventName(f.Class, f.Command)
+	}
+	return CommandName(f.Class, f.Command)
+}
+
+// TraceHook observes the traffic between the API and the device, for
+// profilers, loggers or recorders. The hooks run on the API's transmit and
+// receive loops and must not block; they may issue commands.
+type TraceHook interface {
+	// OnSubmit a command is being queued
+	OnSubmit(f *TraceFrame)
+	// OnTransmit a command is being written to the device
+	OnTransmit(f *TraceFrame)
+	// OnResponse a response was received
+	OnResponse(f *TraceFrame)
+	// OnTimeout a command received no response in time, or none will come
+	// as writing it failed (see TraceFrame.Err)
+	OnTimeout(f *TraceFrame)
+	// OnEvent an event was received, before it is dispatched to the delegate
+	OnEvent(f *TraceFrame)
+}
+
+// NopTraceHook a TraceHook doing nothing, to embed in hooks interested in
+// only some of the trace points
+type NopTraceHook struct{}
+
+// OnSubmit implements TraceHook
+func (NopTraceHook) OnSubmit(*TraceFrame) {}
+
+// OnTransmit implements TraceHook
+func (NopTraceHook) OnTransmit(*TraceFrame) {}
+
+// OnResponse implements TraceHook
+func (NopTraceHook) OnResponse(*TraceFrame) {}
+
+// OnTimeout implements TraceHook
+func (NopTraceHook) OnTimeout(*TraceFrame) {}
+
+// OnEvent implements TraceHook
+func (NopTraceHook) OnEvent(*TraceFrame) {}
+
+// SetTraceHook attach a trace hook, nil detaches it
+func (api *API) SetTraceHook(hook TraceHook) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	api.traceHook = hook
+}
+
+// tracer returns the trace hook, nil when none is attached
+func (api *API) tracer() TraceHook {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	return api.traceHook
+}
+
+// traceCommand build the trace frame of a command
+func traceCommand(op *operation, now time.Time) *TraceFrame {
+	f := &TraceFrame{Time: now, Class: op.class, Command: op.cmd, Payload: op.txData[4:]}
+	if !op.sent.IsZero() {
+		f.Elapsed = f.Time.Sub(op.sent)
+	}
+	return f
+}
+
+// traceReceived pass a response or event to the hook, op is the command
+// that was pending when the frame arrived
+func traceReceived(hook TraceHook, hdr *bgFrameHeader, op *operation, frame []byte, now time.Time) {
+	f := &TraceFrame{Time: now, Class: hdr.packetClass, Command: hdr.packetCommand, Payload: frame}
+	if hdr.messageTypeGet() == 1 {
+		f.Event = true
+		hook.OnEvent(f)
+		return
+	}
+
+	if op != nil {
+		f.Elapsed = f.Time.Sub(op.sent)
+	}
+	hook.OnResponse(f)
+}
@@ -0,0 +1,80 @@
+package bgapi
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+)
+
+var errUnplugged = errors.New("device unplugged")
+
+// failingTransport fails every write, reads wait for it to be closed
+type failingTransport struct {
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (f *failingTransport) Read(p []byte) (int, error) {
+	<-f.closed
+	return 0, io.EOF
+}
+
+func (f *failingTransport) Write(p []byte) (int, error) {
+	return 0, errUnplugged
+}
+
+func (f *failingTransport) Close() error {
+	f.once.Do(func() { close(f.closed) })
+	return nil
+}
+
+// traceRecorder records the commands transmitted and those that failed
+type traceRecorder struct {
+	NopTraceHook
+	transmitted []string
+	failed      []error
+}
+
+func (r *traceRecorder) OnTransmit(f *TraceFrame) {
+	r.transmitted = append(r.transmitted, f.Name())
+}
+
+func (r *traceRecorder) OnTimeout(f *TraceFrame) {
+	r.failed = append(r.failed, f.Err)
+}
+
+func TestTraceFailedWrite(t *testing.T) {
+	api := NewAPI(&LoggingDelegate{})
+	api.OpenTransport(&failingTransport{closed: make(chan struct{})}, nil)
+	defer api.Close()
+	hook := &traceRecorder{}
+	api.SetTraceHook(hook)
+
+	if _, err := api.call(0, 2, nil); !errors.Is(err, errUnplugged) {
+		t.Fatal(err)
+	}
+	api.SetTraceHook(nil)
+	if len(hook.transmitted) != 1 || hook.transmitted[0] != CommandName(0, 2) {
+		t.Fatal(hook.transmitted)
+	}
+	if len(hook.failed) != 1 || hook.failed[0] != errUnplugged {
+		t.Fatal(hook.failed)
+	}
+}
+
+func TestPacketModeFrameLimit(t *testing.T) {
+	api := NewAPI(&LoggingDelegate{})
+	api.OpenTransport(&failingTransport{closed: make(chan struct{})}, &TransportOptions{PacketMode: true})
+	defer api.Close()
+
+	// the length byte cannot describe a 256 byte frame
+	if _, err := api.SendRaw(context.Background(), 0x10, 1, make([]byte, 252)); err == nil || errors.Is(err, errUnplugged) {
+		t.Fatal(err)
+	}
+	// a 255 byte frame is written
+	if _, err := api.SendRaw(context.Background(), 0x10, 1, make([]byte, 251)); !errors.Is(err, errUnplugged) {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,228 @@
+package bgapi
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+	"time"
+)
+
+// Transport the byte stream between the API and the device, usually a serial
+// port
+type Transport interface {
+	io.ReadWriteCloser
+}
+
+// TransportOptions framing settings of a transport
+type TransportOptions struct {
+	// PacketMode precede every frame with a length byte, as used by modules
+	// wired to a host UART without flow control
+	PacketMode bool
+
+	// Wake drives the wake-up line of modules in sleep mode, may be nil
+	Wake WakeController
+
+	// OnError invoked for every failed read, may be nil
+	OnError func(err *TransportError)
+
+	// Reopen invoked after a fatal read error to obtain a new transport, for
+	// instance reopening a USB dongle that was unplugged; when nil (or when
+	// it fails) the API shuts down
+	Reopen func() (Transport, error)
+
+	// ReadBufferSize initial size of the read buffer, defaults to 128 bytes
+	ReadBufferSize int
+	// MaxReadBufferSize the read buffer doubles, up to this size, while
+	// reads keep filling it; defaults to 4096 bytes, set it to
+	// ReadBufferSize to disable growth
+	MaxReadBufferSize int
+
+	// CopyPayloads hand the delegate and completions payloads they own,
+	// rather than slices of the receive buffer that are only valid until
+	// the callback returns; costs an allocation per frame
+	CopyPayloads bool
+
+	// Clock source of time for timeouts, delays and timestamps, defaults to
+	// SystemClock
+	Clock Clock
+
+	// Dispatch how events are delivered to the delegate, inline on the
+	// receive goroutine by default
+	Dispatch DispatchMode
+
+	// ResyncOnSpuriousResponse flush the receive buffer when a response
+	// arrives that matches no command, assuming the stream is misaligned
+	// (e.g. after line noise) and that the next read starts a frame
+	ResyncOnSpuriousResponse bool
+}
+
+// TransportError a read from the transport failed
+type TransportError struct {
+	Err error
+	// Fatal the transport is unusable, the API reopens it or shuts down;
+	// otherwise reading resumes
+	Fatal bool
+}
+
+func (e *TransportError) Error() string {
+	if e.Fatal {
+		return "fatal transport error: " + e.Err.Error()
+	}
+	return "transport error: " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+const (
+	// maxReadErrors consecutive recoverable errors before the transport is
+	// deemed broken
+	maxReadErrors = 10
+	// readRetryDelay back-off between reads after an error, per consecutive
+	// error
+	readRetryDelay = 10 * time.Millisecond
+)
+
+// classifyReadError decide whether a read error leaves the transport usable,
+// errors other than the end of the stream, a closed transport or a vanished
+// device are deemed transient until maxReadErrors occur in a row
+func classifyReadError(err error, consecutive int) *TransportError {
+	fatal := consecutive >= maxReadErrors ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrClosedPipe) || errors.Is(err, os.ErrClosed) ||
+		errors.Is(err, syscall.ENODEV) || errors.Is(err, syscall.ENXIO) || errors.Is(err, syscall.EIO)
+	return &TransportError{Err: err, Fatal: fatal}
+}
+
+// OpenTransport start the API over an open transport, opts may be nil
+func (api *API) OpenTransport(t Transport, opts *TransportOptions) error {
+	if opts != nil {
+		api.framer.packetMode = opts.PacketMode
+		api.wake = opts.Wake
+		api.onTransportError = opts.OnError
+		api.reopen = opts.Reopen
+		api.copyPayloads = opts.CopyPayloads
+		api.resync = opts.ResyncOnSpuriousResponse
+		if opts.Clock != nil {
+			api.clock = opts.Clock
+		}
+		if _, dispatched := api.delegate.(*dispatcher); opts.Dispatch == DispatchPerConnection && !dispatched {
+			// the delegate runs after the receive buffer is reused
+			api.copyPayloads = true
+			api.delegate = newDispatcher(api.delegate, api.done)
+		}
+	}
+	api.readBuffer = newReadBuffer(opts)
+
+	api.ser = t
+	api.start()
+	return nil
+}
+
+// Err returns the fatal transport error that shut the API down, if any
+func (api *API) Err() error {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	return api.err
+}
+
+// transport returns the current transport
+func (api *API) transport() Transport {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	return api.ser
+}
+
+// readLoop hand the data read from the transport to the parser, recovering
+// from transient errors and reopening or shutting down on fatal ones
+func (api *API) readLoop() {
+	api.enqueueMutex.Lock()
+	api.rxGoroutine = goroutineID()
+	api.enqueueMutex.Unlock()
+
+	consecutive := 0
+	for {
+		n, err := api.transport().Read(api.readBuffer.data)
+		if err == nil {
+			consecutive = 0
+			api.onSerialPortData(api.readBuffer.data[:n])
+			api.readBuffer.observe(n)
+			api.mutex.Lock()
+			api.stats.Reads++
+			api.readBufferSize = len(api.readBuffer.data)
+			api.mutex.Unlock()
+			continue
+		}
+		if api.isClosed() {
+			return
+		}
+		if api.eofIsTimeout && errors.Is(err, io.EOF) {
+			// the read timeout expired
+			continue
+		}
+
+		terr := classifyReadError(err, consecutive)
+		if api.onTransportError != nil {
+			api.onTransportError(terr)
+		}
+
+		if !terr.Fatal {
+			consecutive++
+			api.sleep(time.Duration(consecutive) * readRetryDelay)
+			continue
+		}
+
+		if api.reopen != nil {
+			if t, rerr := api.reopen(); rerr == nil {
+				// a frame cut short by the failure must not swallow the
+				// first ones read from the new transport
+				api.rxMutex.Lock()
+				api.mutex.Lock()
+				old := api.ser
+				api.ser = t
+				api.framer.reset()
+				api.parserBuffered = 0
+				// the device lost the command in flight with the transport
+				op := api.pendingOp
+				api.pendingOp = nil
+				api.mutex.Unlock()
+				api.rxMutex.Unlock()
+				old.Close()
+				api.failPendingOp(op, terr)
+
+				consecutive = 0
+				continue
+			} else if api.onTransportError != nil {
+				api.onTransportError(&TransportError{Err: rerr, Fatal: true})
+			}
+		}
+
+		api.mutex.Lock()
+		api.err = terr
+		api.mutex.Unlock()
+		api.stop(true)
+		return
+	}
+}
+
+// closeTransport close the transport and stop the receive loop
+func (api *API) closeTransport() error {
+	api.mutex.Lock()
+	api.closed = true
+	t := api.ser
+	api.mutex.Unlock()
+
+	return t.Close()
+}
+
+// isClosed returns true once the transport was closed
+func (api *API) isClosed() bool {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	return api.closed
+}
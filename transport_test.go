@@ -0,0 +1,66 @@
+package bgapi
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+// brokenTransport delivers the start of a frame once a command is written,
+// then fails
+type brokenTransport struct {
+	written chan struct{}
+	reads   int
+}
+
+func (b *brokenTransport) Read(p []byte) (int, error) {
+	<-b.written
+	b.reads++
+	if b.reads == 1 {
+		// connection_disconnected, 2 of its 3 bytes of payload missing
+		return copy(p, []byte{0x80, 0x03, 0x03, 0x04, 0x00}), nil
+	}
+	return 0, io.EOF
+}
+
+func (b *brokenTransport) Write(p []byte) (int, error) {
+	select {
+	case <-b.written:
+	default:
+		close(b.written)
+	}
+	return len(p), nil
+}
+
+func (b *brokenTransport) Close() error {
+	return nil
+}
+
+func TestReopenTransport(t *testing.T) {
+	a, b := net.Pipe()
+	commands := make(chan []byte, 1)
+	go device(b, frame(t, "00 06 00 02 9f 3c 4b 80 07 00"), commands)
+
+	api := NewAPI(&LoggingDelegate{})
+	api.OpenTransport(&brokenTransport{written: make(chan struct{})}, &TransportOptions{
+		Reopen: func() (Transport, error) { return a, nil },
+	})
+	defer api.Close()
+
+	// system_address_get is lost with the transport, not timed out
+	var terr *TransportError
+	if _, err := api.call(0, 2, nil); !errors.As(err, &terr) || !terr.Fatal {
+		t.Fatal(err)
+	}
+
+	// the response from the new transport is not taken for the rest of
+	// the frame cut short
+	buf, err := api.call(0, 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if address := newDecoder(buf).mac(); address != (Mac{0x9f, 0x3c, 0x4b, 0x80, 0x07, 0x00}) {
+		t.Fatal(address)
+	}
+}
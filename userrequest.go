@@ -0,0 +1,212 @@
+package bgapi
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// attTransactionTimeout the ATT transaction timeout: a client whose
+	// request is not answered within it considers the link lost
+	attTransactionTimeout = 30 * time.Second
+	// defaultUserRequestDeadline leaves the error response time to reach
+	// the client
+	defaultUserRequestDeadline = 25 * time.Second
+	// attributeChangeUserWrite the attribute value reason of a write the
+	// application must answer with AttributesUserWriteResponse
+	attributeChangeUserWrite byte = 2
+)
+
+// UserRequestKind the kind of a user request
+type UserRequestKind int
+
+const (
+	// UserRead a read of a user attribute, see OnAttributeUserReadRequest
+	UserRead UserRequestKind = iota
+	// UserWrite a write of a user attribute, reported by OnAttributeValue
+	// with the write_request_user reason
+	UserWrite
+)
+
+func (k UserRequestKind) String() string {
+	if k == UserWrite {
+		return "user write"
+	}
+	return "user read"
+}
+
+// UserRequest a user read or write waiting for the application's response
+type UserRequest struct {
+	Kind       UserRequestKind
+	Connection byte
+	Handle     uint16
+	Received   time.Time
+}
+
+// UserRequestPolicy enforcement of the ATT transaction deadline on user
+// reads and writes: the client drops the connection when a request is not
+// answered within 30 seconds, so one left unanswered is answered with an
+// error before then
+type UserRequestPolicy struct {
+	// Deadline time after which an unanswered request is answered with
+	// Error, defaults to 25 seconds; must leave room for the response
+	// within the 30 seconds of the transaction
+	Deadline time.Duration
+	// Error the ATT error answering expired requests, defaults to
+	// AttErrUnlikely
+	Error AttError
+	// Warn time after which OnWarn is notified of a request still
+	// unanswered, defaults to four fifths of Deadline
+	Warn time.Duration
+	// OnWarn notified with the time left before the request expires, may
+	// be nil
+	OnWarn func(req *UserRequest, left time.Duration)
+	// OnExpired notified once an expired request was answered, with the
+	// error sending the response; may be nil
+	OnExpired func(req *UserRequest, err error)
+}
+
+// pendingUserRequest a request being watched, answered is closed once the
+// application or the policy answers it or the connection is lost
+type pendingUserRequest struct {
+	req      UserRequest
+	answered chan struct{}
+}
+
+// SetUserRequestPolicy enforce policy on the user reads and writes
+// received from now on, nil stops the enforcement. The callbacks run on a
+// goroutine of their own.
+func (api *API) SetUserRequestPolicy(policy *UserRequestPolicy) error {
+	if policy != nil {
+		p := *policy
+		if p.Deadline == 0 {
+			p.Deadline = defaultUserRequestDeadline
+		}
+		if p.Deadline <= 0 || p.Deadline >= attTransactionTimeout {
+			return fmt.Errorf("user request deadline %v not within the %v ATT transaction timeout", p.Deadline, attTransactionTimeout)
+		}
+		if p.Error == AttErrNone {
+			p.Error = AttErrUnlikely
+		}
+		if err := p.Error.Validate(); err != nil {
+			return err
+		}
+		if p.Warn == 0 {
+			p.Warn = p.Deadline * 4 / 5
+		}
+		if p.Warn < 0 || p.Warn >= p.Deadline {
+			return errors.New("user request warning must come before the deadline")
+		}
+		policy = &p
+	}
+
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	api.userPolicy = policy
+	return nil
+}
+
+// PendingUserRequests returns the user requests waiting for a response,
+// tracked while a policy is set
+func (api *API) PendingUserRequests() []UserRequest {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	requests := make([]UserRequest, 0, len(api.userRequests))
+	for _, p := range api.userRequests {
+		requests = append(requests, p.req)
+	}
+	return requests
+}
+
+// userRequested start watching a user request. The ATT protocol allows a
+// single transaction per connection, a new request replaces the previous.
+func (api *API) userRequested(kind UserRequestKind, connection byte, handle uint16) {
+	api.mutex.Lock()
+	policy := api.userPolicy
+	if policy == nil {
+		api.mutex.Unlock()
+		return
+	}
+	if api.userRequests == nil {
+		api.userRequests = make(map[byte]*pendingUserRequest)
+	}
+	if previous := api.userRequests[connection]; previous != nil {
+		close(previous.answered)
+	}
+	p := &pendingUserRequest{
+		req:      UserRequest{Kind: kind, Connection: connection, Handle: handle, Received: api.clock.Now()},
+		answered: make(chan struct{}),
+	}
+	api.userRequests[connection] = p
+	api.mutex.Unlock()
+
+	go api.enforceDeadline(policy, p)
+}
+
+// userAnswered stop watching the request of connection, answered or lost
+func (api *API) userAnswered(connection byte) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	if p := api.userRequests[connection]; p != nil {
+		delete(api.userRequests, connection)
+		close(p.answered)
+	}
+}
+
+// takeUserRequest stop watching p, false when it was answered meanwhile
+func (api *API) takeUserRequest(p *pendingUserRequest) bool {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	if api.userRequests[p.req.Connection] != p {
+		return false
+	}
+	delete(api.userRequests, p.req.Connection)
+	close(p.answered)
+	return true
+}
+
+// enforceDeadline warn about a request left unanswered and answer it with
+// an error once the deadline passed
+func (api *API) enforceDeadline(policy *UserRequestPolicy, p *pendingUserRequest) {
+	timer := api.clock.NewTimer(policy.Warn)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C():
+	case <-p.answered:
+		return
+	case <-api.done:
+		return
+	}
+	if policy.OnWarn != nil {
+		policy.OnWarn(&p.req, policy.Deadline-policy.Warn)
+	}
+
+	timer.Reset(policy.Deadline - policy.Warn)
+	select {
+	case <-timer.C():
+	case <-p.answered:
+		return
+	case <-api.done:
+		return
+	}
+	if !api.takeUserRequest(p) {
+		return
+	}
+
+	var err error
+	if p.req.Kind == UserRead {
+		err = api.AttributesUserReadResponse(p.req.Connection, policy.Error, nil)
+	} else {
+		err = api.AttributesUserWriteResponse(p.req.Connection, policy.Error)
+	}
+	api.historyError(fmt.Sprintf("%s of 0x%04x on connection %d unanswered after %v", p.req.Kind, p.req.Handle, p.req.Connection, policy.Deadline))
+	if policy.OnExpired != nil {
+		policy.OnExpired(&p.req, err)
+	}
+}
@@ -0,0 +1,66 @@
+package bgapi
+
+import (
+	"fmt"
+)
+
+// link layer versions indexed by the version byte of a version indication
+var llVersions = []string{"1.0b", "1.1", "1.2", "2.0", "2.1", "3.0", "4.0", "4.1", "4.2", "5.0", "5.1", "5.2", "5.3", "5.4"}
+
+// Bluetooth SIG assigned company identifiers of common controller vendors
+var companyNames = map[uint16]string{
+	0x0000: "Ericsson Technology Licensing",
+	0x0001: "Nokia Mobile Phones",
+	0x0002: "Intel Corp.",
+	0x0003: "IBM Corp.",
+	0x0004: "Toshiba Corp.",
+	0x0006: "Microsoft",
+	0x0008: "Motorola",
+	0x000a: "Qualcomm Technologies International, Ltd. (QTIL)",
+	0x000d: "Texas Instruments Inc.",
+	0x000f: "Broadcom Corporation",
+	0x001d: "Qualcomm",
+	0x0025: "NXP Semiconductors",
+	0x0030: "ST Microelectronics",
+	0x0046: "MediaTek, Inc.",
+	0x0047: "Bluegiga",
+	0x004c: "Apple, Inc.",
+	0x0059: "Nordic Semiconductor ASA",
+	0x005d: "Realtek Semiconductor Corporation",
+	0x0075: "Samsung Electronics Co. Ltd.",
+	0x0087: "Garmin International, Inc.",
+	0x00e0: "Google",
+	0x0131: "Cypress Semiconductor",
+	0x02ff: "Silicon Laboratories",
+}
+
+// LLVersionString returns the Bluetooth specification version of a link
+// layer version number, e.g. "4.2"
+func LLVersionString(version byte) string {
+	if int(version) < len(llVersions) {
+		return llVersions[version]
+	}
+	return fmt.Sprintf("unknown (%d)", version)
+}
+
+// CompanyName returns the name of a Bluetooth SIG company identifier
+func CompanyName(id uint16) string {
+	if name, ok := companyNames[id]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown (0x%04x)", id)
+}
+
+// LLVersion returns the link layer version of the peer, e.g. "4.2"
+func (ind *ConnectionVersionIndication) LLVersion() string {
+	return LLVersionString(ind.Version)
+}
+
+// Company returns the name of the peer's controller vendor
+func (ind *ConnectionVersionIndication) Company() string {
+	return CompanyName(ind.CompID)
+}
+
+func (ind *ConnectionVersionIndication) String() string {
+	return fmt.Sprintf("Bluetooth %s, %s, subversion 0x%04x", ind.LLVersion(), ind.Company(), ind.SubVersion)
+}
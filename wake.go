@@ -0,0 +1,11 @@
+package bgapi
+
+// WakeController drives the wake-up line of a module that uses sleep mode.
+// The line is asserted before each command is transmitted and released once
+// its response arrived or it timed out.
+type WakeController interface {
+	// Assert wake the module, returning once it is ready to receive
+	Assert() error
+	// Release allow the module to go back to sleep
+	Release() error
+}
@@ -0,0 +1,44 @@
+//go:build linux
+
+package bgapi
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// rtsWake drives the wake-up line from the RTS output of the serial port
+type rtsWake struct {
+	f *os.File
+}
+
+// NewRTSWake returns a WakeController toggling the RTS line of port, which
+// must not also use hardware flow control. Close the returned controller
+// once the API is closed, it implements io.Closer.
+func NewRTSWake(port string) (WakeController, error) {
+	f, err := os.OpenFile(port, unix.O_RDWR|unix.O_NOCTTY|unix.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &rtsWake{f: f}, nil
+}
+
+func (w *rtsWake) set(req uint) error {
+	return unix.IoctlSetPointerInt(int(w.f.Fd()), req, unix.TIOCM_RTS)
+}
+
+// Assert raise RTS
+func (w *rtsWake) Assert() error {
+	return w.set(unix.TIOCMBIS)
+}
+
+// Release lower RTS
+func (w *rtsWake) Release() error {
+	return w.set(unix.TIOCMBIC)
+}
+
+// Close release the port
+func (w *rtsWake) Close() error {
+	return w.f.Close()
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package bgapi
+
+import (
+	"errors"
+)
+
+// NewRTSWake returns a WakeController toggling the RTS line of port
+func NewRTSWake(port string) (WakeController, error) {
+	return nil, errors.New("RTS wake-up is only supported on linux")
+}
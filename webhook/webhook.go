@@ -0,0 +1,291 @@
+// Package webhook posts the device events of a central as JSON to HTTP
+// endpoints, for integrations that cannot embed Go.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	bgapi "github.com/jsakwa/go_bgapi"
+)
+
+// defaults of Options
+const (
+	defaultBatchSize     = 50
+	defaultFlushInterval = time.Second
+	defaultRetries       = 3
+	defaultRetryDelay    = 500 * time.Millisecond
+	defaultQueueSize     = 1024
+)
+
+var (
+	// ErrClosed the publisher was closed
+	ErrClosed = errors.New("webhook publisher closed")
+	// ErrQueueFull too many events wait to be posted
+	ErrQueueFull = errors.New("webhook queue full")
+)
+
+// Options configure a Publisher, zero fields take the defaults
+type Options struct {
+	// URLs endpoints every batch is posted to
+	URLs []string
+	// Events types published, all when empty
+	Events []bgapi.DeviceEventType
+	// BatchSize events posted at most per request, defaults to 50
+	BatchSize int
+	// FlushInterval longest an event waits for its batch to fill,
+	// defaults to a second
+	FlushInterval time.Duration
+	// Retries attempts after a failed post, defaults to 3; negative
+	// disables retrying
+	Retries int
+	// RetryDelay delay before the first retry, doubled after each failure;
+	// defaults to 500ms
+	RetryDelay time.Duration
+	// QueueSize events waiting to be posted before new ones are dropped,
+	// defaults to 1024
+	QueueSize int
+	// Header added to every request, e.g. for authentication
+	Header http.Header
+	// Client used for the requests, http.DefaultClient when nil
+	Client *http.Client
+	// OnError notified of batches an endpoint did not accept after the
+	// retries
+	OnError func(url string, err error)
+}
+
+// Publisher posts device events in batches: each request carries a JSON
+// array of bgapi.DeviceEvent. Requests failing or answered with 429 or a
+// 5xx status are retried.
+type Publisher struct {
+	opts   Options
+	events map[bgapi.DeviceEventType]bool
+	queue  chan *bgapi.DeviceEvent
+	done   chan struct{}
+	closed chan struct{}
+
+	mutex   sync.Mutex
+	stopped bool
+	dropped int
+	unwatch func()
+}
+
+// NewPublisher returns a publisher posting to the URLs of opts
+func NewPublisher(opts Options) (*Publisher, error) {
+	if len(opts.URLs) == 0 {
+		return nil, errors.New("webhook without URL")
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultBatchSize
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = defaultFlushInterval
+	}
+	if opts.Retries == 0 {
+		opts.Retries = defaultRetries
+	}
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = defaultRetryDelay
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = defaultQueueSize
+	}
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+
+	p := &Publisher{
+		opts:   opts,
+		queue:  make(chan *bgapi.DeviceEvent, opts.QueueSize),
+		done:   make(chan struct{}),
+		closed: make(chan struct{}),
+	}
+	if len(opts.Events) > 0 {
+		p.events = make(map[bgapi.DeviceEventType]bool)
+		for _, t := range opts.Events {
+			p.events[t] = true
+		}
+	}
+	go p.run()
+	return p, nil
+}
+
+// Watch publish the events of the central until the publisher is closed
+func (p *Publisher) Watch(central *bgapi.Central) {
+	stop := central.PublishTo(p, nil)
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.stopped {
+		stop()
+		return
+	}
+	previous := p.unwatch
+	p.unwatch = func() {
+		if previous != nil {
+			previous()
+		}
+		stop()
+	}
+}
+
+// Publish implements bgapi.EventSink, queueing an event; it is dropped
+// when the queue is full or the publisher closed. Events of types not
+// selected are ignored.
+func (p *Publisher) Publish(event *bgapi.DeviceEvent) error {
+	if p.events != nil && !p.events[event.Type] {
+		return nil
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.stopped {
+		p.dropped++
+		return ErrClosed
+	}
+	select {
+	case p.queue <- event:
+		return nil
+	default:
+		p.dropped++
+		return ErrQueueFull
+	}
+}
+
+// Dropped returns the number of events dropped so far
+func (p *Publisher) Dropped() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.dropped
+}
+
+// Close stop watching, post the queued events and stop. Returns the error
+// of ctx if it is done first, the posts left go on in the background.
+func (p *Publisher) Close(ctx context.Context) error {
+	p.mutex.Lock()
+	if p.stopped {
+		p.mutex.Unlock()
+		return ErrClosed
+	}
+	p.stopped = true
+	stop := p.unwatch
+	p.mutex.Unlock()
+
+	if stop != nil {
+		stop()
+	}
+	close(p.done)
+	select {
+	case <-p.closed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run batch the queued events and post them
+func (p *Publisher) run() {
+	defer close(p.closed)
+
+	ticker := time.NewTicker(p.opts.FlushInterval)
+	defer ticker.Stop()
+
+	var batch []*bgapi.DeviceEvent
+	for {
+		select {
+		case event := <-p.queue:
+			batch = append(batch, event)
+			if len(batch) < p.opts.BatchSize {
+				continue
+			}
+		case <-ticker.C:
+		case <-p.done:
+			// Publish no longer queues, drain what is left
+			for len(p.queue) > 0 {
+				batch = append(batch, <-p.queue)
+				if len(batch) == p.opts.BatchSize {
+					p.post(batch)
+					batch = nil
+				}
+			}
+			if len(batch) > 0 {
+				p.post(batch)
+			}
+			return
+		}
+
+		if len(batch) > 0 {
+			p.post(batch)
+			batch = nil
+		}
+	}
+}
+
+// post send a batch to every endpoint
+func (p *Publisher) post(batch []*bgapi.DeviceEvent) {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		p.fail("", err)
+		return
+	}
+	for _, url := range p.opts.URLs {
+		if err := p.postTo(url, body); err != nil {
+			p.fail(url, err)
+		}
+	}
+}
+
+// postTo post a batch to an endpoint, retrying transient failures
+func (p *Publisher) postTo(url string, body []byte) error {
+	delay := p.opts.RetryDelay
+	for attempt := 0; ; attempt++ {
+		retry, err := p.send(url, body)
+		if err == nil || !retry || attempt >= p.opts.Retries {
+			return err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// send post once, returns whether a failure is worth retrying
+func (p *Publisher) send(url string, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	for name, values := range p.opts.Header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.opts.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return false, fmt.Errorf("webhook answered %s", resp.Status)
+}
+
+// fail notify the error handler, if any
+func (p *Publisher) fail(url string, err error) {
+	if p.opts.OnError != nil {
+		p.opts.OnError(url, err)
+	}
+}
@@ -0,0 +1,98 @@
+package bgapi
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Workflow the GATT operations run against a connected and discovered
+// peripheral, e.g. reading its serial number and writing its configuration
+type Workflow func(ctx context.Context, conn *Connection) error
+
+// WorkflowOptions tune RunWorkflow, the zero value runs one device per free
+// connection slot with the default connection parameters
+type WorkflowOptions struct {
+	// Concurrency devices handled at once, bounded by the free connection
+	// slots of the module
+	Concurrency int
+	// Params connection parameters, DefaultConnectionParameters when nil
+	Params *ConnectionParameters
+}
+
+// WorkflowResult outcome of a workflow for one device
+type WorkflowResult struct {
+	Address QualifiedMac
+	// Err nil when the device connected, ran the workflow and disconnected
+	Err     error
+	Elapsed time.Duration
+}
+
+// RunWorkflow connect to every address, run the workflow and disconnect,
+// handling several devices at once. Connection attempts are serialized as
+// the module runs one GAP procedure at a time, the workflows run in
+// parallel. Results are returned in the order of addresses; devices not
+// started when ctx is done fail with its error.
+func (c *Central) RunWorkflow(ctx context.Context, addresses []QualifiedMac, opts *WorkflowOptions, workflow Workflow) []WorkflowResult {
+	if opts == nil {
+		opts = &WorkflowOptions{}
+	}
+	params := opts.Params
+	if params == nil {
+		params = DefaultConnectionParameters()
+	}
+
+	concurrency := opts.Concurrency
+	if free, known := c.api.FreeConnections(); known && (concurrency <= 0 || concurrency > free) {
+		concurrency = free
+	}
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	results := make([]WorkflowResult, len(addresses))
+	slots := make(chan struct{}, concurrency)
+	var connecting sync.Mutex
+	var wg sync.WaitGroup
+	for i, address := range addresses {
+		results[i].Address = address
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			results[i].Err = ctx.Err()
+			continue
+		}
+
+		wg.Add(1)
+		go func(result *WorkflowResult) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			start := c.api.clock.Now()
+			result.Err = c.runWorkflow(ctx, result.Address, params, &connecting, workflow)
+			result.Elapsed = c.api.clock.Now().Sub(start)
+		}(&results[i])
+	}
+	wg.Wait()
+
+	return results
+}
+
+// runWorkflow run the workflow against a single device
+func (c *Central) runWorkflow(ctx context.Context, address QualifiedMac, params *ConnectionParameters, connecting *sync.Mutex, workflow Workflow) error {
+	conn := c.NewConnection(&GapScanRespone{Address: address}, params)
+
+	connecting.Lock()
+	err := conn.OpenContext(ctx)
+	connecting.Unlock()
+	if err != nil {
+		return fmt.Errorf("connecting: %w", err)
+	}
+
+	err = workflow(ctx, conn)
+	if closeErr := conn.Close(); closeErr != nil && err == nil {
+		err = fmt.Errorf("disconnecting: %w", closeErr)
+	}
+	return err
+}
@@ -0,0 +1,94 @@
+package bgapi
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNotWritable the characteristic supports neither kind of write
+var ErrNotWritable = errors.New("characteristic is not writable")
+
+// WritePath the kind of write used by Characteristic.Write
+type WritePath int
+
+const (
+	// WriteRequest a write acknowledged by the peripheral
+	WriteRequest WritePath = iota
+	// WriteCommand a write without response, only acknowledged by the
+	// module
+	WriteCommand
+)
+
+func (p WritePath) String() string {
+	if p == WriteCommand {
+		return "write command"
+	}
+	return "write request"
+}
+
+// WriteOptions tune Characteristic.Write
+type WriteOptions struct {
+	// Reliable prefer writes acknowledged by the peripheral, otherwise
+	// write commands are preferred when the characteristic supports them
+	Reliable bool
+	// NoFallback fail rather than use the other kind of write when the
+	// preferred one is not supported or, for write commands, is refused by
+	// the module (e.g. its transmit buffers are full)
+	NoFallback bool
+}
+
+// Write write the value of the characteristic with a write request or a
+// write command, chosen from its properties and opts (which may be nil),
+// and returns the kind of write used
+func (c *Characteristic) Write(data []byte, opts *WriteOptions) (WritePath, error) {
+	if opts == nil {
+		opts = &WriteOptions{}
+	}
+	canRequest := c.properties&CharPropWrite != 0
+	canCommand := c.properties&CharPropWriteNoResp != 0
+	if !canRequest && !canCommand {
+		return WriteRequest, ErrNotWritable
+	}
+
+	preferred := WriteCommand
+	if opts.Reliable || !canCommand {
+		preferred = WriteRequest
+	}
+	if opts.NoFallback && ((preferred == WriteRequest && !canRequest) || (preferred == WriteCommand && !canCommand)) {
+		return preferred, fmt.Errorf("characteristic does not support %s", preferred)
+	}
+	if preferred == WriteRequest && !canRequest {
+		preferred = WriteCommand
+	}
+
+	if preferred == WriteRequest {
+		return WriteRequest, c.conn.Write(c, data, false)
+	}
+
+	err := c.conn.writeCommand(c, data)
+	if _, refused := err.(*ProcedureError); refused && canRequest && !opts.NoFallback {
+		return WriteRequest, c.conn.Write(c, data, false)
+	}
+	return WriteCommand, err
+}
+
+// writeCommand send a write command, waiting for the module to accept it
+func (c *Connection) writeCommand(char *Characteristic, data []byte) error {
+	if char.value == nil {
+		return errors.New("characteristic has no value attribute")
+	}
+
+	enc := new(encoder).write(c.status.Connection).write(char.value.handle).uint8array(data)
+	buf, err := c.central.api.call(4, 6, enc.bytes())
+	if err != nil {
+		return err
+	}
+	d := newDecoder(buf)
+	d.u8() // connection handle
+	if result := d.u16(); d.err != nil {
+		return d.err
+	} else if result != 0 {
+		return &ProcedureError{Result: result}
+	}
+	return nil
+}